@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	accessLogOut io.Writer
+	accessLogMu  sync.Mutex
+)
+
+// AccessLogEntry 是每个请求结束时输出的一行结构化访问日志
+type AccessLogEntry struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"req_id"`
+	ClientIP  string    `json:"client_ip"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Model     string    `json:"model,omitempty"`
+	Backend   string    `json:"backend,omitempty"`
+	Protocol  string    `json:"protocol,omitempty"`
+	Status    int       `json:"status"`
+	Attempts  int       `json:"attempts"`
+	BytesIn   int64     `json:"bytes_in"`
+	BytesOut  int64     `json:"bytes_out"`
+	LatencyMs int64     `json:"latency_ms"`
+	Stream    bool      `json:"stream"`
+	Cost      float64   `json:"cost_usd"`
+}
+
+type accessEntryKey struct{}
+
+// withAccessEntry 将访问日志条目放入请求上下文，转发过程中填写后端与尝试次数
+func withAccessEntry(ctx context.Context, entry *AccessLogEntry) context.Context {
+	return context.WithValue(ctx, accessEntryKey{}, entry)
+}
+
+// accessEntryFrom 返回请求上下文中的访问日志条目；未启用访问日志时返回一个丢弃用的条目
+func accessEntryFrom(ctx context.Context) *AccessLogEntry {
+	if entry, ok := ctx.Value(accessEntryKey{}).(*AccessLogEntry); ok {
+		return entry
+	}
+	return &AccessLogEntry{}
+}
+
+// InitAccessLog 打开访问日志输出：配置了 access_file 时写入该文件，否则写到标准输出
+func InitAccessLog(cfg *Logging) error {
+	if !cfg.AccessLog || cfg.AccessFile == "" {
+		return nil
+	}
+	f, err := os.OpenFile(cfg.AccessFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	accessLogMu.Lock()
+	accessLogOut = f
+	accessLogMu.Unlock()
+	return nil
+}
+
+func writeAccessLog(entry *AccessLogEntry) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	accessLogMu.Lock()
+	defer accessLogMu.Unlock()
+	out := accessLogOut
+	if out == nil {
+		if testMode {
+			return
+		}
+		out = os.Stdout
+	}
+	out.Write(append(line, '\n'))
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// accessLogWriter 记录写给客户端的状态码与字节数，并保留 Flush 以支持流式响应；TPM 预占也据此判断请求是否成功
+type accessLogWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *accessLogWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessLogWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *accessLogWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *accessLogWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func captureAccessLog(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	accessLogMu.Lock()
+	prev := accessLogOut
+	accessLogOut = &buf
+	accessLogMu.Unlock()
+	t.Cleanup(func() {
+		accessLogMu.Lock()
+		accessLogOut = prev
+		accessLogMu.Unlock()
+	})
+	return &buf
+}
+
+func TestProxy_AccessLog(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error":"boom"}`))
+	}))
+	defer failing.Close()
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"choices":[]}`))
+	}))
+	defer ok.Close()
+
+	tests := []struct {
+		name         string
+		routes       []ModelRoute
+		wantStatus   int
+		wantBackend  string
+		wantAttempts int
+	}{
+		{
+			name: "success after fallback",
+			routes: []ModelRoute{
+				{Backend: "failing", Model: "m1", Priority: 1},
+				{Backend: "ok", Model: "m1", Priority: 2},
+			},
+			wantStatus:   http.StatusOK,
+			wantBackend:  "ok",
+			wantAttempts: 2,
+		},
+		{
+			name:         "failure",
+			routes:       []ModelRoute{{Backend: "failing", Model: "m1", Priority: 1}},
+			wantStatus:   http.StatusInternalServerError,
+			wantBackend:  "failing",
+			wantAttempts: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := captureAccessLog(t)
+			proxy := newTestProxy(&Config{
+				Backends: []Backend{
+					{Name: "failing", URL: failing.URL},
+					{Name: "ok", URL: ok.URL, Protocol: ProtocolOpenAI},
+				},
+				Models:    map[string]*ModelAlias{"model-a": {Routes: tt.routes}},
+				Fallback:  Fallback{CooldownSeconds: 60},
+				Detection: Detection{ErrorCodes: []string{"5xx"}},
+				Logging:   Logging{AccessLog: true},
+			})
+
+			reqBody := `{"model":"model-a","stream":false}`
+			req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(reqBody))
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+
+			lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+			if len(lines) != 1 {
+				t.Fatalf("expected exactly one access log line, got %q", buf.String())
+			}
+			var entry AccessLogEntry
+			if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+				t.Fatalf("access log line is not JSON: %v", err)
+			}
+
+			if entry.RequestID == "" || entry.ClientIP != "192.0.2.1" || entry.Model != "model-a" {
+				t.Errorf("unexpected request fields: %+v", entry)
+			}
+			if entry.Status != tt.wantStatus || entry.Status != w.Code {
+				t.Errorf("status = %d, want %d (client got %d)", entry.Status, tt.wantStatus, w.Code)
+			}
+			if entry.Backend != tt.wantBackend || entry.Protocol != ProtocolOpenAI {
+				t.Errorf("backend/protocol = %s/%s, want %s/openai", entry.Backend, entry.Protocol, tt.wantBackend)
+			}
+			if entry.Attempts != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", entry.Attempts, tt.wantAttempts)
+			}
+			if entry.BytesIn != int64(len(reqBody)) || entry.BytesOut != int64(w.Body.Len()) {
+				t.Errorf("bytes in/out = %d/%d, want %d/%d", entry.BytesIn, entry.BytesOut, len(reqBody), w.Body.Len())
+			}
+			if entry.Stream {
+				t.Error("stream flag should be false")
+			}
+		})
+	}
+}
+
+func TestProxy_AccessLogDisabled(t *testing.T) {
+	buf := captureAccessLog(t)
+	proxy := newTestProxy(&Config{})
+
+	req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{}`))
+	proxy.ServeHTTP(httptest.NewRecorder(), req)
+
+	if buf.Len() != 0 {
+		t.Errorf("access log written while disabled: %q", buf.String())
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AdminHandler 提供运维管理接口（/admin/...）。配置了 admin_api_key 时使用该密钥认证，
+// 否则使用代理 API Key；两者都未配置时管理接口不可用
+type AdminHandler struct {
+	configMgr *ConfigManager
+	cooldown  *CooldownManager
+	breaker   *CircuitBreaker
+}
+
+func NewAdminHandler(cfg *ConfigManager, cd *CooldownManager, breaker *CircuitBreaker) *AdminHandler {
+	return &AdminHandler{configMgr: cfg, cooldown: cd, breaker: breaker}
+}
+
+func (h *AdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cfg := h.configMgr.Get()
+	if cfg.AdminAPIKey == "" && cfg.ProxyAPIKey == "" {
+		httpError(w, "管理接口未启用", http.StatusForbidden)
+		return
+	}
+	if !adminAuthorized(cfg, r) {
+		LogGeneral("WARN", "管理接口认证失败，客户端: %s", r.RemoteAddr)
+		httpError(w, "无效的 API Key", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/admin/cooldown":
+		if r.Method != http.MethodGet {
+			httpError(w, "仅支持 GET", http.StatusMethodNotAllowed)
+			return
+		}
+		h.listCooldowns(w)
+	case "/admin/cooldown/reset":
+		if r.Method != http.MethodPost {
+			httpError(w, "仅支持 POST", http.StatusMethodNotAllowed)
+			return
+		}
+		h.resetCooldown(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func adminAuthorized(cfg *Config, r *http.Request) bool {
+	key := cfg.AdminAPIKey
+	if key == "" {
+		key = cfg.ProxyAPIKey
+	}
+	return r.Header.Get("Authorization") == "Bearer "+key
+}
+
+// debugAuthorized 判断数据面请求是否携带 admin_api_key，未配置 admin_api_key 时不向任何调用方返回调试信息
+func debugAuthorized(cfg *Config, r *http.Request) bool {
+	return cfg.AdminAPIKey != "" && r.Header.Get(adminKeyHeader) == cfg.AdminAPIKey
+}
+
+type cooldownResetRequest struct {
+	Backend string `json:"backend"`
+	Model   string `json:"model"`
+}
+
+// resetCooldown 按请求体清除冷却：同时指定 backend 与 model 时清除单个键，
+// 只指定 backend 时清除该后端的所有键，请求体为空时清除全部
+func (h *AdminHandler) resetCooldown(w http.ResponseWriter, r *http.Request) {
+	var req cooldownResetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		httpError(w, "无效的请求体", http.StatusBadRequest)
+		return
+	}
+	req.Backend = strings.TrimSpace(req.Backend)
+	req.Model = strings.TrimSpace(req.Model)
+
+	var cleared int
+	switch {
+	case req.Backend == "" && req.Model != "":
+		httpError(w, "指定 model 时必须同时指定 backend", http.StatusBadRequest)
+		return
+	case req.Backend != "" && req.Model != "":
+		if h.cooldown.Reset(h.cooldown.Key(req.Backend, req.Model)) {
+			cleared = 1
+		}
+	case req.Backend != "":
+		cleared = h.cooldown.ClearBackend(req.Backend)
+	default:
+		cleared = h.cooldown.ResetAll()
+	}
+	LogGeneral("INFO", "手动清除冷却: 后端=%s 模型=%s 数量=%d", req.Backend, req.Model, cleared)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"cleared": cleared})
+}
+
+type cooldownEntry struct {
+	Key              CooldownKey `json:"key"`
+	Until            time.Time   `json:"until"`
+	RemainingSeconds float64     `json:"remaining_seconds"`
+}
+
+type circuitEntry struct {
+	Key   CooldownKey  `json:"key"`
+	State CircuitState `json:"state"`
+}
+
+// listCooldowns 返回冷却中的键及剩余时间，以及非 closed 的熔断状态，均按键排序
+func (h *AdminHandler) listCooldowns(w http.ResponseWriter) {
+	now := h.cooldown.now()
+	cooldowns := make([]cooldownEntry, 0)
+	for key, until := range h.cooldown.Snapshot() {
+		cooldowns = append(cooldowns, cooldownEntry{Key: key, Until: until, RemainingSeconds: until.Sub(now).Seconds()})
+	}
+	sort.Slice(cooldowns, func(i, j int) bool { return cooldowns[i].Key < cooldowns[j].Key })
+
+	circuits := make([]circuitEntry, 0)
+	for key, state := range h.breaker.Snapshot() {
+		circuits = append(circuits, circuitEntry{Key: key, State: state})
+	}
+	sort.Slice(circuits, func(i, j int) bool { return circuits[i].Key < circuits[j].Key })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"cooldowns": cooldowns,
+		"circuits":  circuits,
+	})
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdminHandler_CooldownReset(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		wantCleared int
+		wantCooling []CooldownKey
+	}{
+		{"single key", `{"backend":"b1","model":"m1"}`, 1, []CooldownKey{"b1/m2", "b2/m1"}},
+		{"whole backend", `{"backend":"b1"}`, 2, []CooldownKey{"b2/m1"}},
+		{"all keys", ``, 3, nil},
+		{"unknown key", `{"backend":"b9","model":"m1"}`, 0, []CooldownKey{"b1/m1", "b1/m2", "b2/m1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := newTestProxy(&Config{ProxyAPIKey: "sk-test-key"})
+			for _, key := range []CooldownKey{"b1/m1", "b1/m2", "b2/m1"} {
+				proxy.cooldown.SetCooldown(key, time.Minute)
+			}
+
+			req := httptest.NewRequest("POST", "/admin/cooldown/reset", strings.NewReader(tt.body))
+			req.Header.Set("Authorization", "Bearer sk-test-key")
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+			}
+			var resp struct {
+				Cleared int `json:"cleared"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("invalid JSON: %v", err)
+			}
+			if resp.Cleared != tt.wantCleared {
+				t.Errorf("cleared = %d, want %d", resp.Cleared, tt.wantCleared)
+			}
+
+			want := make(map[CooldownKey]bool)
+			for _, key := range tt.wantCooling {
+				want[key] = true
+			}
+			for _, key := range []CooldownKey{"b1/m1", "b1/m2", "b2/m1"} {
+				if got := proxy.cooldown.IsCoolingDown(key); got != want[key] {
+					t.Errorf("%s cooling down = %v, want %v", key, got, want[key])
+				}
+			}
+		})
+	}
+}
+
+func TestAdminHandler_Auth(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      *Config
+		method   string
+		auth     string
+		wantCode int
+	}{
+		{"no key configured", &Config{}, "POST", "", http.StatusForbidden},
+		{"missing key", &Config{ProxyAPIKey: "sk-proxy"}, "POST", "", http.StatusUnauthorized},
+		{"wrong key", &Config{ProxyAPIKey: "sk-proxy"}, "POST", "Bearer wrong", http.StatusUnauthorized},
+		{"proxy key", &Config{ProxyAPIKey: "sk-proxy"}, "POST", "Bearer sk-proxy", http.StatusOK},
+		{"admin key", &Config{ProxyAPIKey: "sk-proxy", AdminAPIKey: "sk-admin"}, "POST", "Bearer sk-admin", http.StatusOK},
+		{"proxy key rejected when admin key set", &Config{ProxyAPIKey: "sk-proxy", AdminAPIKey: "sk-admin"}, "POST", "Bearer sk-proxy", http.StatusUnauthorized},
+		{"wrong method", &Config{ProxyAPIKey: "sk-proxy"}, "GET", "Bearer sk-proxy", http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := newTestProxy(tt.cfg)
+			proxy.cooldown.SetCooldown("b1/m1", time.Minute)
+
+			req := httptest.NewRequest(tt.method, "/admin/cooldown/reset", nil)
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantCode)
+			}
+			if w.Code != http.StatusOK && !proxy.cooldown.IsCoolingDown("b1/m1") {
+				t.Error("rejected request must not clear cooldowns")
+			}
+		})
+	}
+}
+
+func TestAdminHandler_ListCooldowns(t *testing.T) {
+	proxy := newTestProxy(&Config{
+		ProxyAPIKey:    "sk-test-key",
+		CircuitBreaker: CircuitBreakerConfig{Enabled: true, FailureThreshold: 1},
+	})
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	proxy.cooldown.now = func() time.Time { return now }
+	proxy.cooldown.SetCooldown("b2/m1", time.Minute)
+	proxy.cooldown.SetCooldown("b1/m1", 30*time.Second)
+	proxy.router.breaker.RecordFailure("b3/m1")
+
+	list := func() (resp struct {
+		Cooldowns []cooldownEntry `json:"cooldowns"`
+		Circuits  []circuitEntry  `json:"circuits"`
+	}) {
+		req := httptest.NewRequest("GET", "/admin/cooldown", nil)
+		req.Header.Set("Authorization", "Bearer sk-test-key")
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("invalid JSON: %v", err)
+		}
+		return resp
+	}
+
+	first := list()
+	if len(first.Cooldowns) != 2 || first.Cooldowns[0].Key != "b1/m1" || first.Cooldowns[1].Key != "b2/m1" {
+		t.Fatalf("cooldowns = %+v, want b1/m1 and b2/m1 sorted", first.Cooldowns)
+	}
+	if first.Cooldowns[0].RemainingSeconds != 30 {
+		t.Errorf("b1/m1 remaining = %v, want 30", first.Cooldowns[0].RemainingSeconds)
+	}
+	if len(first.Circuits) != 1 || first.Circuits[0].Key != "b3/m1" || first.Circuits[0].State != CircuitOpen {
+		t.Errorf("circuits = %+v, want b3/m1 open", first.Circuits)
+	}
+
+	now = now.Add(45 * time.Second)
+	second := list()
+	if len(second.Cooldowns) != 1 || second.Cooldowns[0].Key != "b2/m1" {
+		t.Fatalf("cooldowns after 45s = %+v, want only b2/m1", second.Cooldowns)
+	}
+	if second.Cooldowns[0].RemainingSeconds >= first.Cooldowns[1].RemainingSeconds {
+		t.Errorf("remaining time should decrease: %v -> %v", first.Cooldowns[1].RemainingSeconds, second.Cooldowns[0].RemainingSeconds)
+	}
+}
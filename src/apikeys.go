@@ -0,0 +1,73 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// KeyRotator 在后端的多个 API Key 之间轮询，并对触发 429 的单个 Key 做短暂冷却
+type KeyRotator struct {
+	cursors   map[string]uint64
+	cooldowns map[string]time.Time
+	now       func() time.Time
+	mu        sync.Mutex
+}
+
+func NewKeyRotator() *KeyRotator {
+	return &KeyRotator{
+		cursors:   make(map[string]uint64),
+		cooldowns: make(map[string]time.Time),
+		now:       time.Now,
+	}
+}
+
+func keyCooldownKey(backend, key string) string {
+	return backend + "\x00" + key
+}
+
+// NextAPIKey 按轮询顺序返回下一个未冷却的 Key 及其序号；全部冷却时仍按轮询返回，
+// 后端没有配置 Key 时返回空字符串
+func (kr *KeyRotator) NextAPIKey(b *Backend) (string, int) {
+	if b == nil {
+		return "", -1
+	}
+	keys := b.Keys()
+	if len(keys) == 0 {
+		return "", -1
+	}
+	if len(keys) == 1 {
+		return keys[0], 0
+	}
+
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	start := kr.cursors[b.Name]
+	now := kr.now()
+	for i := 0; i < len(keys); i++ {
+		idx := int((start + uint64(i)) % uint64(len(keys)))
+		if until, cooling := kr.cooldowns[keyCooldownKey(b.Name, keys[idx])]; cooling && now.Before(until) {
+			continue
+		}
+		kr.cursors[b.Name] = start + uint64(i) + 1
+		return keys[idx], idx
+	}
+	idx := int(start % uint64(len(keys)))
+	kr.cursors[b.Name] = start + 1
+	return keys[idx], idx
+}
+
+// Cooldown 将单个 Key 冷却 d，返回该后端是否仍有可用的 Key
+func (kr *KeyRotator) Cooldown(b *Backend, key string, d time.Duration) bool {
+	keys := b.Keys()
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	now := kr.now()
+	kr.cooldowns[keyCooldownKey(b.Name, key)] = now.Add(d)
+
+	for _, k := range keys {
+		if until, cooling := kr.cooldowns[keyCooldownKey(b.Name, k)]; !cooling || !now.Before(until) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBackend_Keys(t *testing.T) {
+	tests := []struct {
+		name    string
+		backend Backend
+		want    string
+	}{
+		{"none", Backend{}, ""},
+		{"single", Backend{APIKey: "k1"}, "k1"},
+		{"list", Backend{APIKeys: []string{"k1", "k2"}}, "k1,k2"},
+		{"merged dedup", Backend{APIKey: "k1", APIKeys: []string{"k2", "k1", ""}}, "k1,k2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := strings.Join(tt.backend.Keys(), ","); got != tt.want {
+				t.Errorf("Keys() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyRotator_NextAPIKey_RoundRobin(t *testing.T) {
+	kr := NewKeyRotator()
+	b := &Backend{Name: "b1", APIKeys: []string{"k1", "k2", "k3"}}
+
+	var got []string
+	for i := 0; i < 6; i++ {
+		key, _ := kr.NextAPIKey(b)
+		got = append(got, key)
+	}
+	if want := "k1,k2,k3,k1,k2,k3"; strings.Join(got, ",") != want {
+		t.Errorf("rotation = %v, want %s", got, want)
+	}
+
+	if key, idx := kr.NextAPIKey(&Backend{Name: "b2"}); key != "" || idx != -1 {
+		t.Errorf("backend without keys: got %q/%d", key, idx)
+	}
+}
+
+func TestKeyRotator_Cooldown(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	kr := NewKeyRotator()
+	kr.now = func() time.Time { return now }
+	b := &Backend{Name: "b1", APIKeys: []string{"k1", "k2", "k3"}, KeyCooldownSeconds: 30}
+
+	if !kr.Cooldown(b, "k2", b.GetKeyCooldown()) {
+		t.Fatal("other keys should still be available")
+	}
+	var got []string
+	for i := 0; i < 4; i++ {
+		key, _ := kr.NextAPIKey(b)
+		got = append(got, key)
+	}
+	if want := "k1,k3,k1,k3"; strings.Join(got, ",") != want {
+		t.Errorf("rotation with k2 cooling = %v, want %s", got, want)
+	}
+
+	kr.Cooldown(b, "k1", b.GetKeyCooldown())
+	if kr.Cooldown(b, "k3", b.GetKeyCooldown()) {
+		t.Error("all keys cooling should report none available")
+	}
+
+	now = now.Add(31 * time.Second)
+	if key, _ := kr.NextAPIKey(b); key == "" {
+		t.Error("keys should be usable after cooldown expires")
+	}
+}
+
+func TestKeyRotator_Concurrent(t *testing.T) {
+	kr := NewKeyRotator()
+	b := &Backend{Name: "b1", APIKeys: []string{"k1", "k2"}}
+
+	counts := make(map[string]int)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			key, _ := kr.NextAPIKey(b)
+			mu.Lock()
+			counts[key]++
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if counts["k1"] != 50 || counts["k2"] != 50 {
+		t.Errorf("uneven rotation: %v", counts)
+	}
+}
+
+func TestProxy_PerKeyCooldownOn429(t *testing.T) {
+	var mu sync.Mutex
+	var seen []string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		mu.Lock()
+		seen = append(seen, strings.TrimPrefix(auth, "Bearer "))
+		mu.Unlock()
+		if auth == "Bearer k1" {
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"error":"rate limited"}`))
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer backend.Close()
+
+	proxy := newTestProxy(&Config{
+		Backends: []Backend{{Name: "b1", URL: backend.URL, APIKeys: []string{"k1", "k2"}}},
+		Models: map[string]*ModelAlias{
+			"model-a": {Routes: []ModelRoute{{Backend: "b1", Model: "m1", Priority: 1}}},
+		},
+		Fallback: Fallback{CooldownSeconds: 300},
+	})
+
+	codes := make([]int, 3)
+	for i := range codes {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"model-a"}`))
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+		codes[i] = w.Code
+	}
+
+	// 第一个请求在 k1 被限流后立即换用 k2 重试同一后端，之后的请求跳过冷却中的 k1
+	if want := "k1,k2,k2,k2"; strings.Join(seen, ",") != want {
+		t.Errorf("keys used = %v, want %s", seen, want)
+	}
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("request %d: status = %d, want 200 via the healthy key", i, code)
+		}
+	}
+	if proxy.cooldown.IsCoolingDown(proxy.cooldown.Key("b1", "m1")) {
+		t.Error("a single key 429 should not cool down the whole backend")
+	}
+}
+
+func TestProxy_KeyRetriesBoundedByKeyCount(t *testing.T) {
+	var calls atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"error":"rate limited"}`))
+	}))
+	defer backend.Close()
+
+	proxy := newTestProxy(&Config{
+		Backends: []Backend{{Name: "b1", URL: backend.URL, APIKeys: []string{"k1", "k2", "k3"}}},
+		Models: map[string]*ModelAlias{
+			"model-a": {Routes: []ModelRoute{{Backend: "b1", Model: "m1", Priority: 1}}},
+		},
+	})
+
+	req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"model-a"}`))
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+
+	if got := calls.Load(); got != 3 {
+		t.Errorf("upstream calls = %d, want one per key", got)
+	}
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("status = %d, want 429 once every key is limited", w.Code)
+	}
+}
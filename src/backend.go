@@ -1,20 +1,35 @@
 package main
 
 import (
+	"math/rand"
+	"strings"
 	"sync"
 	"time"
 )
 
 type CooldownKey string
 
+type CooldownEvent struct {
+	Key     CooldownKey
+	Backend string
+	Until   time.Time
+}
+
 type CooldownManager struct {
 	cooldowns map[CooldownKey]time.Time
+	failures  map[CooldownKey]int
+	listeners []func(CooldownEvent)
+	now       func() time.Time
+	random    func() float64
 	mu        sync.RWMutex
 }
 
 func NewCooldownManager() *CooldownManager {
 	return &CooldownManager{
 		cooldowns: make(map[CooldownKey]time.Time),
+		failures:  make(map[CooldownKey]int),
+		now:       time.Now,
+		random:    rand.Float64,
 	}
 }
 
@@ -26,20 +41,105 @@ func (cm *CooldownManager) IsCoolingDown(key CooldownKey) bool {
 	cm.mu.RLock()
 	defer cm.mu.RUnlock()
 	until, exists := cm.cooldowns[key]
-	return exists && time.Now().Before(until)
+	return exists && cm.now().Before(until)
+}
+
+// Subscribe 注册冷却事件监听，每次进入冷却时同步回调
+func (cm *CooldownManager) Subscribe(fn func(CooldownEvent)) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.listeners = append(cm.listeners, fn)
 }
 
 func (cm *CooldownManager) SetCooldown(key CooldownKey, duration time.Duration) {
+	cm.mu.Lock()
+	until := cm.now().Add(duration)
+	cm.cooldowns[key] = until
+	listeners := cm.listeners
+	cm.mu.Unlock()
+	LogGeneral("INFO", "设置冷却: %s 直到 %v", key, until.Format(time.RFC3339))
+
+	backend, _, _ := strings.Cut(string(key), "/")
+	event := CooldownEvent{Key: key, Backend: backend, Until: until}
+	for _, fn := range listeners {
+		fn(event)
+	}
+}
+
+// Escalate 记录路由的一次连续失败，返回按 fallback.cooldown_backoff 递增后的冷却时长
+func (cm *CooldownManager) Escalate(key CooldownKey, f *Fallback) time.Duration {
+	cm.mu.Lock()
+	cm.failures[key]++
+	failures := cm.failures[key]
+	r := cm.random()
+	cm.mu.Unlock()
+	return f.EscalatedCooldown(failures, r)
+}
+
+// RecordSuccess 清零路由的连续失败次数，下次失败重新从 cooldown_seconds 开始冷却
+func (cm *CooldownManager) RecordSuccess(key CooldownKey) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	delete(cm.failures, key)
+}
+
+// ClearBackend 清除后端所有路由的冷却，返回清除的数量，用于健康检查确认后端恢复
+func (cm *CooldownManager) ClearBackend(backend string) int {
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
-	cm.cooldowns[key] = time.Now().Add(duration)
-	LogGeneral("INFO", "设置冷却: %s 直到 %v", key, cm.cooldowns[key].Format(time.RFC3339))
+	for key := range cm.failures {
+		if name, _, _ := strings.Cut(string(key), "/"); name == backend {
+			delete(cm.failures, key)
+		}
+	}
+	cleared := 0
+	for key := range cm.cooldowns {
+		if name, _, _ := strings.Cut(string(key), "/"); name == backend {
+			delete(cm.cooldowns, key)
+			cleared++
+		}
+	}
+	return cleared
+}
+
+// Reset 立即清除单个键的冷却，返回该键此前是否处于冷却中
+func (cm *CooldownManager) Reset(key CooldownKey) bool {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	until, exists := cm.cooldowns[key]
+	delete(cm.cooldowns, key)
+	delete(cm.failures, key)
+	return exists && cm.now().Before(until)
+}
+
+// ResetAll 清除全部冷却，返回清除的数量
+func (cm *CooldownManager) ResetAll() int {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cleared := len(cm.cooldowns)
+	cm.cooldowns = make(map[CooldownKey]time.Time)
+	cm.failures = make(map[CooldownKey]int)
+	return cleared
+}
+
+// Snapshot 返回当前仍在冷却中的键及其结束时间（内部状态的副本）
+func (cm *CooldownManager) Snapshot() map[CooldownKey]time.Time {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	now := cm.now()
+	snapshot := make(map[CooldownKey]time.Time, len(cm.cooldowns))
+	for key, until := range cm.cooldowns {
+		if now.Before(until) {
+			snapshot[key] = until
+		}
+	}
+	return snapshot
 }
 
 func (cm *CooldownManager) ClearExpired() {
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
-	now := time.Now()
+	now := cm.now()
 	for key, until := range cm.cooldowns {
 		if now.After(until) {
 			delete(cm.cooldowns, key)
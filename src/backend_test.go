@@ -84,3 +84,75 @@ func TestCooldownManager_Concurrent(t *testing.T) {
 		<-done
 	}
 }
+
+func TestCooldownManager_Subscribe(t *testing.T) {
+	cm := NewCooldownManager()
+	var events []CooldownEvent
+	cm.Subscribe(func(e CooldownEvent) {
+		events = append(events, e)
+	})
+
+	cm.SetCooldown(cm.Key("backend1", "org/model"), time.Minute)
+
+	if len(events) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(events))
+	}
+	if events[0].Backend != "backend1" || events[0].Key != "backend1/org/model" {
+		t.Errorf("unexpected event: %+v", events[0])
+	}
+}
+
+func TestCooldownManager_Snapshot(t *testing.T) {
+	cm := NewCooldownManager()
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	cm.now = func() time.Time { return now }
+
+	cm.SetCooldown("b1/m1", time.Minute)
+	cm.SetCooldown("b2/m1", 10*time.Second)
+
+	first := cm.Snapshot()
+	if len(first) != 2 {
+		t.Fatalf("snapshot = %v, want 2 keys", first)
+	}
+	remaining := first["b1/m1"].Sub(now)
+
+	now = now.Add(20 * time.Second)
+	second := cm.Snapshot()
+	if _, exists := second["b2/m1"]; exists {
+		t.Error("expired cooldown should not appear in snapshot")
+	}
+	if got := second["b1/m1"].Sub(now); got >= remaining || got != 40*time.Second {
+		t.Errorf("remaining after 20s = %v, want 40s (was %v)", got, remaining)
+	}
+
+	delete(second, "b1/m1")
+	if !cm.IsCoolingDown("b1/m1") {
+		t.Error("modifying the snapshot must not affect the manager")
+	}
+}
+
+func TestCooldownManager_EscalateAndReset(t *testing.T) {
+	cm := NewCooldownManager()
+	f := &Fallback{CooldownSeconds: 10, CooldownBackoff: CooldownBackoff{Multiplier: 2}}
+	key := cm.Key("backend", "model")
+	other := cm.Key("backend", "other")
+
+	var got []time.Duration
+	for i := 0; i < 3; i++ {
+		got = append(got, cm.Escalate(key, f))
+	}
+	want := []time.Duration{10 * time.Second, 20 * time.Second, 40 * time.Second}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("failure %d: cooldown = %v, want %v", i+1, got[i], want[i])
+		}
+	}
+	if d := cm.Escalate(other, f); d != 10*time.Second {
+		t.Errorf("other route cooldown = %v, want its own streak to start at 10s", d)
+	}
+
+	cm.RecordSuccess(key)
+	if d := cm.Escalate(key, f); d != 10*time.Second {
+		t.Errorf("after success cooldown = %v, want reset to 10s", d)
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// bedrockAnthropicVersion 是 Bedrock 上 Anthropic 模型要求的请求体版本字段
+const bedrockAnthropicVersion = "bedrock-2023-05-31"
+
+// bedrockTargetURL 返回 Bedrock InvokeModel 地址；后端未配置 url 时使用区域默认端点
+func bedrockTargetURL(backend *Backend, model string, stream bool) (*url.URL, error) {
+	endpoint := backend.URL
+	if endpoint == "" {
+		if backend.AWS == nil || backend.AWS.Region == "" {
+			return nil, errors.New("bedrock 后端缺少 url 或 aws.region")
+		}
+		endpoint = "https://bedrock-runtime." + backend.AWS.Region + ".amazonaws.com"
+	}
+	targetURL, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	action := "invoke"
+	if stream {
+		action = "invoke-with-response-stream"
+	}
+	targetURL.Path = strings.TrimSuffix(targetURL.Path, "/") + "/model/" + model + "/" + action
+	return targetURL, nil
+}
+
+// bedrockBody 将 Anthropic Messages 请求体包装为 Bedrock 格式：模型与流式由地址决定，
+// 请求体改用 anthropic_version 字段，anthropic-beta 请求头转为 anthropic_beta 字段
+func bedrockBody(body map[string]interface{}, header http.Header) map[string]interface{} {
+	wrapped := cloneBody(body)
+	delete(wrapped, "model")
+	delete(wrapped, "stream")
+	if _, ok := wrapped["anthropic_version"]; !ok {
+		wrapped["anthropic_version"] = bedrockAnthropicVersion
+	}
+	var betas []interface{}
+	for _, v := range header.Values("anthropic-beta") {
+		for _, beta := range strings.Split(v, ",") {
+			if beta = strings.TrimSpace(beta); beta != "" {
+				betas = append(betas, beta)
+			}
+		}
+	}
+	if len(betas) > 0 {
+		wrapped["anthropic_beta"] = betas
+	}
+	return wrapped
+}
+
+// signBedrockRequest 去掉客户端的认证与 Anthropic 版本请求头，并用后端的 AWS 凭据签名
+func signBedrockRequest(req *http.Request, body []byte, creds *AWSCredentials, now time.Time) error {
+	if creds == nil || creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return errors.New("bedrock 后端缺少 AWS 凭据")
+	}
+	for _, h := range []string{"Authorization", "X-Api-Key", "Anthropic-Version", "Anthropic-Beta"} {
+		req.Header.Del(h)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if strings.HasSuffix(req.URL.Path, "invoke-with-response-stream") {
+		req.Header.Set("Accept", "application/vnd.amazon.eventstream")
+	} else {
+		req.Header.Set("Accept", "application/json")
+	}
+	signSigV4(req, body, creds, "bedrock", now)
+	return nil
+}
+
+// signSigV4 按 AWS Signature Version 4 为请求添加 X-Amz-Date 与 Authorization 头，签名覆盖 host 与 x-amz-* 头
+func signSigV4(req *http.Request, body []byte, creds *AWSCredentials, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		if lk := strings.ToLower(k); strings.HasPrefix(lk, "x-amz-") {
+			headers[lk] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	payloadHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURI(req.URL),
+		canonicalQuery(req.URL),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := date + "/" + creds.Region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), date)
+	for _, part := range []string{creds.Region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// canonicalURI 对实际发送的路径逐段再做一次 URI 编码（非 S3 服务的规则）
+func canonicalURI(u *url.URL) string {
+	path := u.EscapedPath()
+	if path == "" {
+		return "/"
+	}
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		segments[i] = awsURIEncode(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+func canonicalQuery(u *url.URL) string {
+	query := u.Query()
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			pairs = append(pairs, awsURIEncode(k)+"="+awsURIEncode(v))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// awsURIEncode 只保留 RFC 3986 非保留字符，其余字节编码为大写 %XX
+func awsURIEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// bedrockEventStream 将 Bedrock 的 application/vnd.amazon.eventstream 响应解码为 Anthropic SSE 流，
+// 每个 chunk 的 bytes 字段即一条 Anthropic 流式事件；异常帧转换为 Anthropic 的 error 事件
+func bedrockEventStream(body io.ReadCloser) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		defer body.Close()
+		for {
+			headers, payload, err := readEventStreamFrame(body)
+			if err != nil {
+				if err == io.EOF {
+					err = nil
+				}
+				pw.CloseWithError(err)
+				return
+			}
+			if _, err := pw.Write(bedrockFrameToSSE(headers, payload)); err != nil {
+				return
+			}
+		}
+	}()
+	return &pipeBody{PipeReader: pr, upstream: body}
+}
+
+// pipeBody 关闭时同时关闭上游响应体，让解码协程及时退出
+type pipeBody struct {
+	*io.PipeReader
+	upstream io.Closer
+}
+
+func (p *pipeBody) Close() error {
+	p.upstream.Close()
+	return p.PipeReader.Close()
+}
+
+func bedrockFrameToSSE(headers map[string]string, payload []byte) []byte {
+	if headers[":message-type"] == "exception" {
+		var exc struct {
+			Message string `json:"message"`
+		}
+		json.Unmarshal(payload, &exc)
+		data, _ := json.Marshal(map[string]interface{}{
+			"type":  "error",
+			"error": map[string]string{"type": headers[":exception-type"], "message": exc.Message},
+		})
+		return []byte("event: error\ndata: " + string(data) + "\n\n")
+	}
+
+	var chunk struct {
+		Bytes string `json:"bytes"`
+	}
+	if err := json.Unmarshal(payload, &chunk); err != nil || chunk.Bytes == "" {
+		return nil
+	}
+	event, err := base64.StdEncoding.DecodeString(chunk.Bytes)
+	if err != nil {
+		return nil
+	}
+	var typed struct {
+		Type string `json:"type"`
+	}
+	json.Unmarshal(event, &typed)
+	var out bytes.Buffer
+	if typed.Type != "" {
+		out.WriteString("event: " + typed.Type + "\n")
+	}
+	out.WriteString("data: ")
+	out.Write(event)
+	out.WriteString("\n\n")
+	return out.Bytes()
+}
+
+// readEventStreamFrame 读取一帧 AWS event stream 消息：
+// 总长度(4) 头部长度(4) 前导 CRC(4) 头部 负载 消息 CRC(4)
+func readEventStreamFrame(r io.Reader) (map[string]string, []byte, error) {
+	var prelude [12]byte
+	if _, err := io.ReadFull(r, prelude[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, nil, fmt.Errorf("event stream 帧不完整: %w", err)
+		}
+		return nil, nil, err
+	}
+	total := binary.BigEndian.Uint32(prelude[0:4])
+	headersLen := binary.BigEndian.Uint32(prelude[4:8])
+	if crc32.ChecksumIEEE(prelude[:8]) != binary.BigEndian.Uint32(prelude[8:12]) {
+		return nil, nil, errors.New("event stream 前导 CRC 校验失败")
+	}
+	if total < 16 || headersLen > total-16 || total > 16<<20 {
+		return nil, nil, fmt.Errorf("event stream 帧长度无效: %d", total)
+	}
+
+	rest := make([]byte, total-12)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return nil, nil, fmt.Errorf("event stream 帧不完整: %w", err)
+	}
+	msgCRC := binary.BigEndian.Uint32(rest[len(rest)-4:])
+	crc := crc32.Update(crc32.ChecksumIEEE(prelude[:]), crc32.IEEETable, rest[:len(rest)-4])
+	if crc != msgCRC {
+		return nil, nil, errors.New("event stream 消息 CRC 校验失败")
+	}
+
+	headers, err := parseEventStreamHeaders(rest[:headersLen])
+	if err != nil {
+		return nil, nil, err
+	}
+	return headers, rest[headersLen : len(rest)-4], nil
+}
+
+// parseEventStreamHeaders 解析帧头部，只保留字符串类型的值，其余类型跳过
+func parseEventStreamHeaders(data []byte) (map[string]string, error) {
+	headers := make(map[string]string)
+	for len(data) > 0 {
+		nameLen := int(data[0])
+		if len(data) < 1+nameLen+1 {
+			return nil, errors.New("event stream 头部格式错误")
+		}
+		name := string(data[1 : 1+nameLen])
+		valueType := data[1+nameLen]
+		data = data[2+nameLen:]
+
+		var size int
+		switch valueType {
+		case 0, 1:
+			size = 0
+		case 2:
+			size = 1
+		case 3:
+			size = 2
+		case 4:
+			size = 4
+		case 5, 8:
+			size = 8
+		case 9:
+			size = 16
+		case 6, 7:
+			if len(data) < 2 {
+				return nil, errors.New("event stream 头部格式错误")
+			}
+			n := int(binary.BigEndian.Uint16(data))
+			if len(data) < 2+n {
+				return nil, errors.New("event stream 头部格式错误")
+			}
+			if valueType == 7 {
+				headers[name] = string(data[2 : 2+n])
+			}
+			data = data[2+n:]
+			continue
+		default:
+			return nil, fmt.Errorf("event stream 头部类型未知: %d", valueType)
+		}
+		if len(data) < size {
+			return nil, errors.New("event stream 头部格式错误")
+		}
+		data = data[size:]
+	}
+	return headers, nil
+}
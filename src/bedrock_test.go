@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignSigV4_AWSTestVector(t *testing.T) {
+	// AWS SigV4 测试套件中的 get-vanilla 用例
+	req, _ := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	creds := &AWSCredentials{Region: "us-east-1", AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	signSigV4(req, nil, creds, "service", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("Authorization =\n%s\nwant\n%s", got, want)
+	}
+	if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+		t.Errorf("X-Amz-Date = %s", got)
+	}
+}
+
+func TestSignBedrockRequest_Headers(t *testing.T) {
+	tests := []struct {
+		name        string
+		creds       *AWSCredentials
+		wantErr     bool
+		wantSigned  string
+		wantToken   string
+		wantAccept  string
+		invokeRoute string
+	}{
+		{
+			name:        "static credentials",
+			creds:       &AWSCredentials{Region: "us-west-2", AccessKeyID: "AKID", SecretAccessKey: "secret"},
+			wantSigned:  "SignedHeaders=host;x-amz-date,",
+			wantAccept:  "application/json",
+			invokeRoute: "invoke",
+		},
+		{
+			name:        "session token is signed",
+			creds:       &AWSCredentials{Region: "us-west-2", AccessKeyID: "AKID", SecretAccessKey: "secret", SessionToken: "tok"},
+			wantSigned:  "SignedHeaders=host;x-amz-date;x-amz-security-token,",
+			wantToken:   "tok",
+			wantAccept:  "application/vnd.amazon.eventstream",
+			invokeRoute: "invoke-with-response-stream",
+		},
+		{
+			name:        "missing credentials",
+			creds:       &AWSCredentials{Region: "us-west-2"},
+			wantErr:     true,
+			invokeRoute: "invoke",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("POST", "https://bedrock-runtime.us-west-2.amazonaws.com/model/anthropic.claude-3-5-sonnet-20241022-v2:0/"+tt.invokeRoute, nil)
+			req.Header.Set("Authorization", "Bearer sk-proxy")
+			req.Header.Set("x-api-key", "sk-client")
+			req.Header.Set("anthropic-version", "2023-06-01")
+
+			err := signBedrockRequest(req, []byte(`{}`), tt.creds, time.Now())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			auth := req.Header.Get("Authorization")
+			if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/") || !strings.Contains(auth, "/us-west-2/bedrock/aws4_request") {
+				t.Errorf("Authorization = %s", auth)
+			}
+			if !strings.Contains(auth, tt.wantSigned) {
+				t.Errorf("Authorization = %s, want %s", auth, tt.wantSigned)
+			}
+			if got := req.Header.Get("X-Amz-Security-Token"); got != tt.wantToken {
+				t.Errorf("X-Amz-Security-Token = %q, want %q", got, tt.wantToken)
+			}
+			if got := req.Header.Get("Accept"); got != tt.wantAccept {
+				t.Errorf("Accept = %q, want %q", got, tt.wantAccept)
+			}
+			if req.Header.Get("x-api-key") != "" || req.Header.Get("anthropic-version") != "" {
+				t.Errorf("client credential headers not stripped: %v", req.Header)
+			}
+		})
+	}
+}
+
+func TestCanonicalURI_EncodesModelID(t *testing.T) {
+	u, _ := bedrockTargetURL(&Backend{AWS: &AWSCredentials{Region: "us-east-1"}}, "anthropic.claude-3-haiku-20240307-v1:0", false)
+	if got := u.String(); got != "https://bedrock-runtime.us-east-1.amazonaws.com/model/anthropic.claude-3-haiku-20240307-v1:0/invoke" {
+		t.Errorf("target URL = %s", got)
+	}
+	if got := canonicalURI(u); got != "/model/anthropic.claude-3-haiku-20240307-v1%3A0/invoke" {
+		t.Errorf("canonicalURI = %s", got)
+	}
+}
+
+func TestBedrockBody_Envelope(t *testing.T) {
+	header := http.Header{}
+	header.Add("anthropic-beta", "a-2024, b-2025")
+	body := map[string]interface{}{"model": "m", "stream": true, "max_tokens": 10.0}
+
+	got := bedrockBody(body, header)
+	if _, ok := got["model"]; ok {
+		t.Error("model should be removed")
+	}
+	if _, ok := got["stream"]; ok {
+		t.Error("stream should be removed")
+	}
+	if got["anthropic_version"] != bedrockAnthropicVersion {
+		t.Errorf("anthropic_version = %v", got["anthropic_version"])
+	}
+	if betas, _ := json.Marshal(got["anthropic_beta"]); string(betas) != `["a-2024","b-2025"]` {
+		t.Errorf("anthropic_beta = %s", betas)
+	}
+	if body["model"] != "m" {
+		t.Error("original body modified")
+	}
+}
+
+// encodeEventStreamFrame 按 AWS event stream 格式编码一帧，头部均为字符串类型
+func encodeEventStreamFrame(headers map[string]string, payload []byte) []byte {
+	var hdr bytes.Buffer
+	for k, v := range headers {
+		hdr.WriteByte(byte(len(k)))
+		hdr.WriteString(k)
+		hdr.WriteByte(7)
+		binary.Write(&hdr, binary.BigEndian, uint16(len(v)))
+		hdr.WriteString(v)
+	}
+	total := uint32(12 + hdr.Len() + len(payload) + 4)
+	var frame bytes.Buffer
+	binary.Write(&frame, binary.BigEndian, total)
+	binary.Write(&frame, binary.BigEndian, uint32(hdr.Len()))
+	binary.Write(&frame, binary.BigEndian, crc32.ChecksumIEEE(frame.Bytes()))
+	frame.Write(hdr.Bytes())
+	frame.Write(payload)
+	binary.Write(&frame, binary.BigEndian, crc32.ChecksumIEEE(frame.Bytes()))
+	return frame.Bytes()
+}
+
+func bedrockChunk(event string) []byte {
+	payload, _ := json.Marshal(map[string]string{"bytes": base64.StdEncoding.EncodeToString([]byte(event))})
+	return encodeEventStreamFrame(map[string]string{":message-type": "event", ":event-type": "chunk"}, payload)
+}
+
+func TestBedrockEventStream_DecodesToSSE(t *testing.T) {
+	tests := []struct {
+		name    string
+		stream  []byte
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "chunks",
+			stream: append(bedrockChunk(`{"type":"message_start"}`),
+				bedrockChunk(`{"type":"message_stop"}`)...),
+			want: "event: message_start\ndata: {\"type\":\"message_start\"}\n\nevent: message_stop\ndata: {\"type\":\"message_stop\"}\n\n",
+		},
+		{
+			name: "exception frame",
+			stream: encodeEventStreamFrame(map[string]string{":message-type": "exception", ":exception-type": "throttlingException"},
+				[]byte(`{"message":"Too many requests"}`)),
+			want: "event: error\ndata: {\"error\":{\"message\":\"Too many requests\",\"type\":\"throttlingException\"},\"type\":\"error\"}\n\n",
+		},
+		{
+			name:    "corrupted crc",
+			stream:  func() []byte { b := bedrockChunk(`{"type":"ping"}`); b[len(b)-1] ^= 0xff; return b }(),
+			wantErr: true,
+		},
+		{
+			name:    "truncated frame",
+			stream:  bedrockChunk(`{"type":"ping"}`)[:20],
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := io.ReadAll(bedrockEventStream(io.NopCloser(bytes.NewReader(tt.stream))))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && string(got) != tt.want {
+				t.Errorf("got %q\nwant %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxy_BedrockBackend(t *testing.T) {
+	var gotPath, gotAuth, gotAPIKey string
+	var gotBody map[string]interface{}
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotAuth = r.Header.Get("Authorization")
+		gotAPIKey = r.Header.Get("x-api-key")
+		gotBody = nil
+		json.NewDecoder(r.Body).Decode(&gotBody)
+		if strings.HasSuffix(r.URL.Path, "/invoke-with-response-stream") {
+			w.Header().Set("Content-Type", "application/vnd.amazon.eventstream")
+			w.Write(bedrockChunk(`{"type":"content_block_delta","delta":{"type":"text_delta","text":"hi"}}`))
+			w.Write(bedrockChunk(`{"type":"message_stop"}`))
+			return
+		}
+		w.Write([]byte(`{"type":"message","content":[{"type":"text","text":"hi"}]}`))
+	}))
+	defer backend.Close()
+
+	proxy := newTestProxy(&Config{
+		ProxyAPIKey: "sk-proxy",
+		Backends: []Backend{{
+			Name: "bedrock", URL: backend.URL, Protocol: ProtocolBedrock,
+			AWS: &AWSCredentials{Region: "us-east-1", AccessKeyID: "AKID", SecretAccessKey: "secret"},
+		}},
+		Models: map[string]*ModelAlias{
+			"claude": {Routes: []ModelRoute{{Backend: "bedrock", Model: "anthropic.claude-3-5-haiku-20241022-v1:0", Priority: 1}}},
+		},
+	})
+
+	tests := []struct {
+		name     string
+		path     string
+		body     string
+		wantCode int
+		wantPath string
+		wantBody string
+	}{
+		{
+			name:     "invoke",
+			path:     "/v1/messages",
+			body:     `{"model":"claude","max_tokens":10}`,
+			wantCode: http.StatusOK,
+			wantPath: "/model/anthropic.claude-3-5-haiku-20241022-v1:0/invoke",
+			wantBody: `"text":"hi"`,
+		},
+		{
+			name:     "stream",
+			path:     "/v1/messages",
+			body:     `{"model":"claude","max_tokens":10,"stream":true}`,
+			wantCode: http.StatusOK,
+			wantPath: "/model/anthropic.claude-3-5-haiku-20241022-v1:0/invoke-with-response-stream",
+			wantBody: "event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n",
+		},
+		{
+			name:     "chat completions unsupported",
+			path:     "/v1/chat/completions",
+			body:     `{"model":"claude"}`,
+			wantCode: http.StatusBadRequest,
+			wantBody: "不支持 /v1/chat/completions",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotPath, gotAuth, gotAPIKey = "", "", ""
+			req := httptest.NewRequest("POST", tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Authorization", "Bearer sk-proxy")
+			req.Header.Set("x-api-key", "sk-proxy")
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantCode, w.Body.String())
+			}
+			if !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("body = %q, want contains %q", w.Body.String(), tt.wantBody)
+			}
+			if tt.wantPath == "" {
+				return
+			}
+			if gotPath != tt.wantPath {
+				t.Errorf("upstream path = %s, want %s", gotPath, tt.wantPath)
+			}
+			if !strings.HasPrefix(gotAuth, "AWS4-HMAC-SHA256 ") || gotAPIKey != "" {
+				t.Errorf("upstream Authorization = %q, x-api-key = %q", gotAuth, gotAPIKey)
+			}
+			if _, ok := gotBody["model"]; ok || gotBody["anthropic_version"] != bedrockAnthropicVersion {
+				t.Errorf("upstream body = %v", gotBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ChurnMonitor 统计各后端在时间窗口内进入冷却的次数，超过阈值时告警
+type ChurnMonitor struct {
+	configMgr *ConfigManager
+	entries   map[string][]time.Time
+	lastAlert map[string]time.Time
+	now       func() time.Time
+	alert     func(ChurnAlert, string, int)
+	mu        sync.Mutex
+}
+
+func NewChurnMonitor(cfg *ConfigManager) *ChurnMonitor {
+	cm := &ChurnMonitor{
+		configMgr: cfg,
+		entries:   make(map[string][]time.Time),
+		lastAlert: make(map[string]time.Time),
+		now:       time.Now,
+	}
+	cm.alert = cm.sendAlert
+	return cm
+}
+
+func (cm *ChurnMonitor) Observe(event CooldownEvent) {
+	metricsRegistry.IncCounter("llm_proxy_cooldown_entries_total", "backend", event.Backend)
+
+	cfg := cm.configMgr.Get().Fallback.ChurnAlert
+	if !cfg.IsEnabled() {
+		return
+	}
+	window := cfg.GetWindow()
+	now := cm.now()
+
+	cm.mu.Lock()
+	kept := cm.entries[event.Backend][:0]
+	for _, t := range cm.entries[event.Backend] {
+		if now.Sub(t) < window {
+			kept = append(kept, t)
+		}
+	}
+	kept = append(kept, now)
+	cm.entries[event.Backend] = kept
+	count := len(kept)
+
+	// 同一窗口内每个后端只告警一次
+	shouldAlert := count >= cfg.Threshold && now.Sub(cm.lastAlert[event.Backend]) >= window
+	if shouldAlert {
+		cm.lastAlert[event.Backend] = now
+	}
+	cm.mu.Unlock()
+
+	if shouldAlert {
+		cm.alert(cfg, event.Backend, count)
+	}
+}
+
+// Count 返回后端在当前窗口内的冷却次数
+func (cm *ChurnMonitor) Count(backend string) int {
+	window := cm.configMgr.Get().Fallback.ChurnAlert.GetWindow()
+	now := cm.now()
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	count := 0
+	for _, t := range cm.entries[backend] {
+		if now.Sub(t) < window {
+			count++
+		}
+	}
+	return count
+}
+
+func (cm *ChurnMonitor) sendAlert(cfg ChurnAlert, backend string, count int) {
+	LogGeneral("WARN", "后端冷却抖动告警: %s 在 %v 内进入冷却 %d 次", backend, cfg.GetWindow(), count)
+	metricsRegistry.IncCounter("llm_proxy_cooldown_churn_alerts_total", "backend", backend)
+	if cfg.Webhook == "" {
+		return
+	}
+	payload, _ := json.Marshal(map[string]interface{}{
+		"event":          "cooldown_churn",
+		"backend":        backend,
+		"count":          count,
+		"window_seconds": int(cfg.GetWindow().Seconds()),
+		"time":           cm.now().Format(time.RFC3339),
+	})
+	go func() {
+		client := &http.Client{Timeout: 10 * time.Second}
+		resp, err := client.Post(cfg.Webhook, "application/json", bytes.NewReader(payload))
+		if err != nil {
+			LogGeneral("WARN", "发送冷却抖动告警失败: %v", err)
+			return
+		}
+		resp.Body.Close()
+	}()
+}
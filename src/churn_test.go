@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestChurnMonitor(alert ChurnAlert) (*ChurnMonitor, *time.Time, *[]string) {
+	cm := NewChurnMonitor(newTestConfigManager(&Config{Fallback: Fallback{ChurnAlert: alert}}))
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	cm.now = func() time.Time { return now }
+	var alerts []string
+	cm.alert = func(_ ChurnAlert, backend string, _ int) {
+		alerts = append(alerts, backend)
+	}
+	return cm, &now, &alerts
+}
+
+func TestChurnMonitor_FlapTriggersAlert(t *testing.T) {
+	cm, now, alerts := newTestChurnMonitor(ChurnAlert{WindowSeconds: 60, Threshold: 3})
+
+	for i := 0; i < 2; i++ {
+		cm.Observe(CooldownEvent{Backend: "flaky"})
+		*now = now.Add(10 * time.Second)
+	}
+	if len(*alerts) != 0 {
+		t.Fatalf("should not alert below threshold, got %v", *alerts)
+	}
+
+	cm.Observe(CooldownEvent{Backend: "flaky"})
+	if len(*alerts) != 1 || (*alerts)[0] != "flaky" {
+		t.Fatalf("expected one alert for flaky, got %v", *alerts)
+	}
+
+	cm.Observe(CooldownEvent{Backend: "flaky"})
+	if len(*alerts) != 1 {
+		t.Errorf("should alert at most once per window, got %v", *alerts)
+	}
+}
+
+func TestChurnMonitor_WindowExpiry(t *testing.T) {
+	cm, now, alerts := newTestChurnMonitor(ChurnAlert{WindowSeconds: 60, Threshold: 3})
+
+	for i := 0; i < 5; i++ {
+		cm.Observe(CooldownEvent{Backend: "slow"})
+		*now = now.Add(40 * time.Second)
+	}
+
+	if len(*alerts) != 0 {
+		t.Errorf("spread-out cooldowns should not alert, got %v", *alerts)
+	}
+	if got := cm.Count("slow"); got != 1 {
+		t.Errorf("Count() = %d, want 1", got)
+	}
+}
+
+func TestChurnMonitor_Disabled(t *testing.T) {
+	cm, _, alerts := newTestChurnMonitor(ChurnAlert{})
+	for i := 0; i < 10; i++ {
+		cm.Observe(CooldownEvent{Backend: "b"})
+	}
+	if len(*alerts) != 0 {
+		t.Errorf("disabled monitor should not alert, got %v", *alerts)
+	}
+}
+
+func TestChurnMonitor_Webhook(t *testing.T) {
+	received := make(chan map[string]interface{}, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		received <- payload
+	}))
+	defer hook.Close()
+
+	cm := NewChurnMonitor(newTestConfigManager(&Config{Fallback: Fallback{
+		ChurnAlert: ChurnAlert{WindowSeconds: 60, Threshold: 2, Webhook: hook.URL},
+	}}))
+	cd := NewCooldownManager()
+	cd.Subscribe(cm.Observe)
+
+	cd.SetCooldown(cd.Key("flaky", "m1"), time.Second)
+	cd.SetCooldown(cd.Key("flaky", "m2"), time.Second)
+
+	select {
+	case payload := <-received:
+		if payload["backend"] != "flaky" || payload["count"] != float64(2) {
+			t.Errorf("unexpected webhook payload: %v", payload)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("webhook was not called")
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+type CircuitState string
+
+const (
+	CircuitClosed   CircuitState = "closed"
+	CircuitOpen     CircuitState = "open"
+	CircuitHalfOpen CircuitState = "half-open"
+)
+
+type circuit struct {
+	state     CircuitState
+	failures  int
+	successes int
+	openedAt  time.Time
+	probing   bool
+	probeID   uint64
+}
+
+// CircuitBreaker 按 后端/模型 维护熔断状态：closed -> open -> half-open -> closed
+type CircuitBreaker struct {
+	configMgr *ConfigManager
+	circuits  map[CooldownKey]*circuit
+	now       func() time.Time
+	mu        sync.Mutex
+}
+
+func NewCircuitBreaker(cfg *ConfigManager) *CircuitBreaker {
+	return &CircuitBreaker{
+		configMgr: cfg,
+		circuits:  make(map[CooldownKey]*circuit),
+		now:       time.Now,
+	}
+}
+
+func (cb *CircuitBreaker) config() CircuitBreakerConfig {
+	return cb.configMgr.Get().CircuitBreaker
+}
+
+func (cb *CircuitBreaker) get(key CooldownKey) *circuit {
+	c, exists := cb.circuits[key]
+	if !exists {
+		c = &circuit{state: CircuitClosed}
+		cb.circuits[key] = c
+	}
+	return c
+}
+
+// State 返回当前状态；open 超时后视为 half-open
+func (cb *CircuitBreaker) State(key CooldownKey) CircuitState {
+	cfg := cb.config()
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	c, exists := cb.circuits[key]
+	if !exists {
+		return CircuitClosed
+	}
+	if c.state == CircuitOpen && cb.now().Sub(c.openedAt) >= cfg.GetOpenTimeout() {
+		return CircuitHalfOpen
+	}
+	return c.state
+}
+
+// IsOpen 判断路由选择时是否应跳过该键：熔断打开，或半开且探测请求正在进行
+func (cb *CircuitBreaker) IsOpen(key CooldownKey) bool {
+	cfg := cb.config()
+	if !cfg.Enabled {
+		return false
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	c, exists := cb.circuits[key]
+	if !exists {
+		return false
+	}
+	switch c.state {
+	case CircuitOpen:
+		return cb.now().Sub(c.openedAt) < cfg.GetOpenTimeout()
+	case CircuitHalfOpen:
+		return c.probing
+	}
+	return false
+}
+
+// Allow 在真正发起请求前调用；半开状态下只放行一个探测请求。
+// 放行时返回的释放函数用于没有记录成败就放弃本次尝试的情况（客户端断开、超时、换 Key 重试等），
+// 它只释放本次取得的探测名额，已记录结果或名额已被后续探测取得时不做任何事
+func (cb *CircuitBreaker) Allow(key CooldownKey) (func(), bool) {
+	cfg := cb.config()
+	if !cfg.Enabled {
+		return func() {}, true
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	c := cb.get(key)
+	switch c.state {
+	case CircuitOpen:
+		if cb.now().Sub(c.openedAt) < cfg.GetOpenTimeout() {
+			return nil, false
+		}
+		c.state = CircuitHalfOpen
+		c.successes = 0
+		LogGeneral("INFO", "熔断半开，放行探测请求: %s", key)
+		return cb.startProbe(key, c), true
+	case CircuitHalfOpen:
+		if c.probing {
+			return nil, false
+		}
+		return cb.startProbe(key, c), true
+	}
+	return func() {}, true
+}
+
+// startProbe 标记探测进行中并返回释放函数，调用方需持有 mu
+func (cb *CircuitBreaker) startProbe(key CooldownKey, c *circuit) func() {
+	c.probing = true
+	c.probeID++
+	id := c.probeID
+	return func() {
+		cb.mu.Lock()
+		defer cb.mu.Unlock()
+		if c.state == CircuitHalfOpen && c.probing && c.probeID == id {
+			c.probing = false
+			LogGeneral("DEBUG", "探测请求未产生结果，释放探测名额: %s", key)
+		}
+	}
+}
+
+// Snapshot 返回所有非 closed 状态的键及其当前状态（open 超时后报告为 half-open）
+func (cb *CircuitBreaker) Snapshot() map[CooldownKey]CircuitState {
+	cfg := cb.config()
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	snapshot := make(map[CooldownKey]CircuitState)
+	for key, c := range cb.circuits {
+		state := c.state
+		if state == CircuitOpen && cb.now().Sub(c.openedAt) >= cfg.GetOpenTimeout() {
+			state = CircuitHalfOpen
+		}
+		if state != CircuitClosed {
+			snapshot[key] = state
+		}
+	}
+	return snapshot
+}
+
+func (cb *CircuitBreaker) RecordSuccess(key CooldownKey) {
+	cfg := cb.config()
+	if !cfg.Enabled {
+		return
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	c := cb.get(key)
+	switch c.state {
+	case CircuitHalfOpen:
+		c.probing = false
+		c.successes++
+		if c.successes >= cfg.GetSuccessThreshold() {
+			c.state = CircuitClosed
+			c.failures = 0
+			LogGeneral("INFO", "熔断关闭: %s", key)
+		}
+	case CircuitClosed:
+		c.failures = 0
+	}
+}
+
+func (cb *CircuitBreaker) RecordFailure(key CooldownKey) {
+	cfg := cb.config()
+	if !cfg.Enabled {
+		return
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	c := cb.get(key)
+	switch c.state {
+	case CircuitHalfOpen:
+		c.state = CircuitOpen
+		c.openedAt = cb.now()
+		c.probing = false
+		LogGeneral("WARN", "探测失败，熔断重新打开: %s", key)
+	case CircuitClosed:
+		c.failures++
+		if c.failures >= cfg.GetFailureThreshold() {
+			c.state = CircuitOpen
+			c.openedAt = cb.now()
+			LogGeneral("WARN", "连续失败 %d 次，熔断打开: %s", c.failures, key)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestCircuitBreaker(cfg CircuitBreakerConfig) (*CircuitBreaker, *time.Time) {
+	cb := NewCircuitBreaker(newTestConfigManager(&Config{CircuitBreaker: cfg}))
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	cb.now = func() time.Time { return now }
+	return cb, &now
+}
+
+// probe 申请一次请求许可，只返回是否放行
+func probe(cb *CircuitBreaker, key CooldownKey) bool {
+	_, ok := cb.Allow(key)
+	return ok
+}
+
+func TestCircuitBreaker_Transitions(t *testing.T) {
+	cb, now := newTestCircuitBreaker(CircuitBreakerConfig{
+		Enabled: true, FailureThreshold: 3, SuccessThreshold: 2, OpenTimeoutSeconds: 30,
+	})
+	key := CooldownKey("b1/m1")
+
+	cb.RecordFailure(key)
+	cb.RecordFailure(key)
+	if got := cb.State(key); got != CircuitClosed {
+		t.Fatalf("below threshold: state = %s, want closed", got)
+	}
+
+	cb.RecordFailure(key)
+	if got := cb.State(key); got != CircuitOpen {
+		t.Fatalf("at threshold: state = %s, want open", got)
+	}
+	if probe(cb, key) || !cb.IsOpen(key) {
+		t.Fatal("open circuit should reject requests")
+	}
+
+	*now = now.Add(31 * time.Second)
+	if got := cb.State(key); got != CircuitHalfOpen {
+		t.Fatalf("after timeout: state = %s, want half-open", got)
+	}
+	if cb.IsOpen(key) {
+		t.Fatal("half-open circuit without probe should be selectable")
+	}
+	if !probe(cb, key) {
+		t.Fatal("half-open should allow a probe")
+	}
+	if probe(cb, key) || !cb.IsOpen(key) {
+		t.Fatal("half-open should allow only one probe at a time")
+	}
+
+	cb.RecordSuccess(key)
+	if got := cb.State(key); got != CircuitHalfOpen {
+		t.Fatalf("one success: state = %s, want half-open", got)
+	}
+	if !probe(cb, key) {
+		t.Fatal("next probe should be allowed after success")
+	}
+	cb.RecordSuccess(key)
+	if got := cb.State(key); got != CircuitClosed {
+		t.Fatalf("success threshold reached: state = %s, want closed", got)
+	}
+}
+
+func TestCircuitBreaker_HalfOpenFailureReopens(t *testing.T) {
+	cb, now := newTestCircuitBreaker(CircuitBreakerConfig{Enabled: true, FailureThreshold: 1, OpenTimeoutSeconds: 10})
+	key := CooldownKey("b1/m1")
+
+	cb.RecordFailure(key)
+	*now = now.Add(11 * time.Second)
+	if !probe(cb, key) {
+		t.Fatal("probe should be allowed")
+	}
+	cb.RecordFailure(key)
+
+	if got := cb.State(key); got != CircuitOpen {
+		t.Errorf("failed probe: state = %s, want open", got)
+	}
+	if probe(cb, key) {
+		t.Error("reopened circuit should reject until timeout")
+	}
+}
+
+func TestCircuitBreaker_SuccessResetsFailures(t *testing.T) {
+	cb, _ := newTestCircuitBreaker(CircuitBreakerConfig{Enabled: true, FailureThreshold: 2})
+	key := CooldownKey("b1/m1")
+
+	cb.RecordFailure(key)
+	cb.RecordSuccess(key)
+	cb.RecordFailure(key)
+
+	if got := cb.State(key); got != CircuitClosed {
+		t.Errorf("non-consecutive failures: state = %s, want closed", got)
+	}
+}
+
+func TestCircuitBreaker_Disabled(t *testing.T) {
+	cb, _ := newTestCircuitBreaker(CircuitBreakerConfig{FailureThreshold: 1})
+	key := CooldownKey("b1/m1")
+
+	cb.RecordFailure(key)
+	cb.RecordFailure(key)
+
+	if !probe(cb, key) || cb.IsOpen(key) {
+		t.Error("disabled breaker should never block")
+	}
+}
+
+func TestRouter_Resolve_SkipsOpenCircuit(t *testing.T) {
+	cfg := &Config{
+		Backends: []Backend{
+			{Name: "backend1", URL: "http://backend1.com"},
+			{Name: "backend2", URL: "http://backend2.com"},
+		},
+		Models: map[string]*ModelAlias{
+			"model-a": {
+				Routes: []ModelRoute{
+					{Backend: "backend1", Model: "m1", Priority: 1},
+					{Backend: "backend2", Model: "m2", Priority: 2},
+				},
+			},
+		},
+		CircuitBreaker: CircuitBreakerConfig{Enabled: true, FailureThreshold: 1, OpenTimeoutSeconds: 60},
+	}
+	cd := NewCooldownManager()
+	router := NewRouter(newTestConfigManager(cfg), cd)
+
+	router.breaker.RecordFailure(cd.Key("backend1", "m1"))
+
+	routes, _ := router.Resolve("model-a")
+	if len(routes) != 1 || routes[0].BackendName != "backend2" {
+		t.Fatalf("open circuit should be skipped, got %+v", routes)
+	}
+}
+
+func TestCircuitBreaker_ReleaseProbe(t *testing.T) {
+	cb, now := newTestCircuitBreaker(CircuitBreakerConfig{Enabled: true, FailureThreshold: 1, OpenTimeoutSeconds: 10})
+	key := CooldownKey("b1/m1")
+
+	cb.RecordFailure(key)
+	*now = now.Add(11 * time.Second)
+	release, ok := cb.Allow(key)
+	if !ok {
+		t.Fatal("probe should be allowed")
+	}
+	release()
+	if cb.IsOpen(key) || cb.State(key) != CircuitHalfOpen {
+		t.Fatalf("released probe: IsOpen = %v, state = %s, want selectable half-open", cb.IsOpen(key), cb.State(key))
+	}
+
+	// 已记录结果的探测再释放，不能清掉后续请求取得的探测名额
+	stale, _ := cb.Allow(key)
+	cb.RecordSuccess(key)
+	if !probe(cb, key) {
+		t.Fatal("next probe should be allowed after success")
+	}
+	stale()
+	if !cb.IsOpen(key) {
+		t.Error("stale release should not free another request's probe")
+	}
+}
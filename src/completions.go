@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// legacyCompletionKey 标记由 /v1/completions 转换而来的请求，流式响应需要转换回 legacy 格式
+type legacyCompletionKey struct{}
+
+func isLegacyCompletion(r *http.Request) bool {
+	v, _ := r.Context().Value(legacyCompletionKey{}).(bool)
+	return v
+}
+
+func isCompletionsPath(path string) bool {
+	return path == "/v1/completions" || path == "/completions"
+}
+
+// legacyOnlyFields 仅 legacy completions 支持、chat 接口不接受的字段
+var legacyOnlyFields = []string{"prompt", "echo", "best_of", "logprobs", "suffix"}
+
+// completionPrompts 解析 legacy prompt，支持字符串与字符串数组
+func completionPrompts(v interface{}) ([]string, bool) {
+	switch prompt := v.(type) {
+	case string:
+		return []string{prompt}, true
+	case []interface{}:
+		if len(prompt) == 0 {
+			return nil, false
+		}
+		prompts := make([]string, 0, len(prompt))
+		for _, p := range prompt {
+			s, ok := p.(string)
+			if !ok {
+				return nil, false
+			}
+			prompts = append(prompts, s)
+		}
+		return prompts, true
+	}
+	return nil, false
+}
+
+// completionToChat 将 legacy 请求体包装为单条 user 消息的 chat 请求体
+func completionToChat(reqBody map[string]interface{}, prompt string) map[string]interface{} {
+	chat := cloneBody(reqBody)
+	for _, field := range legacyOnlyFields {
+		delete(chat, field)
+	}
+	chat["messages"] = []interface{}{
+		map[string]interface{}{"role": "user", "content": prompt},
+	}
+	return chat
+}
+
+// chatToCompletion 将非流式 chat 响应转换为 legacy completion 响应，choice 的 index 加上 offset
+func chatToCompletion(body []byte, offset int) (map[string]interface{}, bool) {
+	var resp map[string]interface{}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, false
+	}
+	choices, ok := resp["choices"].([]interface{})
+	if !ok {
+		return nil, false
+	}
+	converted := make([]interface{}, 0, len(choices))
+	for i, c := range choices {
+		choice, _ := c.(map[string]interface{})
+		message, _ := choice["message"].(map[string]interface{})
+		text, _ := message["content"].(string)
+		converted = append(converted, map[string]interface{}{
+			"index":         choiceIndex(choice, i) + offset,
+			"text":          text,
+			"logprobs":      nil,
+			"finish_reason": choice["finish_reason"],
+		})
+	}
+	resp["object"] = "text_completion"
+	resp["choices"] = converted
+	return resp, true
+}
+
+// completionStreamConverter 将 chat.completion.chunk 转换为 legacy text_completion 流式分片
+func completionStreamConverter(line []byte) []byte {
+	payload, ok := sseData(line)
+	if !ok {
+		return line
+	}
+	var chunk map[string]interface{}
+	if err := json.Unmarshal(payload, &chunk); err != nil {
+		return line
+	}
+	choices, _ := chunk["choices"].([]interface{})
+	converted := make([]interface{}, 0, len(choices))
+	for i, c := range choices {
+		choice, _ := c.(map[string]interface{})
+		delta, _ := choice["delta"].(map[string]interface{})
+		text, _ := delta["content"].(string)
+		converted = append(converted, map[string]interface{}{
+			"index":         choiceIndex(choice, i),
+			"text":          text,
+			"logprobs":      nil,
+			"finish_reason": choice["finish_reason"],
+		})
+	}
+	chunk["object"] = "text_completion"
+	chunk["choices"] = converted
+	return rewriteSSEData(line, chunk)
+}
+
+// handleLegacyCompletion 将 /v1/completions 请求转换为 chat 请求转发，并将响应转换回 legacy 格式。
+// 数组 prompt 的每一项单独发送一次 chat 请求，结果按顺序合并为多个 choice（流式仅支持单个 prompt）
+func (p *Proxy) handleLegacyCompletion(w http.ResponseWriter, r *http.Request, reqID, modelAlias string, reqBody map[string]interface{}) (usage Usage, hasUsage bool) {
+	prompts, ok := completionPrompts(reqBody["prompt"])
+	if !ok {
+		httpError(w, "prompt 必须为字符串或字符串数组", http.StatusBadRequest)
+		return
+	}
+	stream, _ := reqBody["stream"].(bool)
+	if stream && len(prompts) > 1 {
+		httpError(w, "流式请求仅支持单个 prompt", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), legacyCompletionKey{}, true)
+	chatReq := r.Clone(ctx)
+	chatReq.URL.Path = strings.TrimSuffix(r.URL.Path, "/completions") + "/chat/completions"
+	LogGeneral("DEBUG", "[%s] legacy completions 请求转换为 chat: prompt 数=%d", reqID, len(prompts))
+
+	if stream {
+		chat := completionToChat(reqBody, prompts[0])
+		body, _ := json.Marshal(chat)
+		return p.forward(w, chatReq, reqID, modelAlias, chat, body)
+	}
+
+	var merged map[string]interface{}
+	var choices []interface{}
+	for i, prompt := range prompts {
+		chat := completionToChat(reqBody, prompt)
+		body, _ := json.Marshal(chat)
+		rec := newBufferedResponse()
+		u, ok := p.forward(rec, chatReq, fmt.Sprintf("%s-%d", reqID, i), modelAlias, chat, body)
+		if ok {
+			usage.PromptTokens += u.PromptTokens
+			usage.CompletionTokens += u.CompletionTokens
+			usage.TotalTokens += u.TotalTokens
+			hasUsage = true
+		}
+
+		resp, converted := chatToCompletion(rec.body.Bytes(), len(choices))
+		if rec.status < 200 || rec.status >= 300 || !converted {
+			// 任一 prompt 失败时原样返回该错误
+			for k, v := range rec.header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(rec.status)
+			w.Write(rec.body.Bytes())
+			return
+		}
+		if merged == nil {
+			merged = resp
+		}
+		choices = append(choices, resp["choices"].([]interface{})...)
+	}
+
+	merged["choices"] = choices
+	if hasUsage {
+		merged["usage"] = map[string]interface{}{
+			"prompt_tokens":     usage.PromptTokens,
+			"completion_tokens": usage.CompletionTokens,
+			"total_tokens":      usage.TotalTokens,
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(merged)
+	return
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCompletionPrompts(t *testing.T) {
+	tests := []struct {
+		name   string
+		prompt interface{}
+		want   []string
+		ok     bool
+	}{
+		{"string", "hello", []string{"hello"}, true},
+		{"array", []interface{}{"a", "b"}, []string{"a", "b"}, true},
+		{"empty array", []interface{}{}, nil, false},
+		{"token array", []interface{}{float64(1), float64(2)}, nil, false},
+		{"missing", nil, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := completionPrompts(tt.prompt)
+			if ok != tt.ok || strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("completionPrompts = %v/%v, want %v/%v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestCompletionToChat(t *testing.T) {
+	req := map[string]interface{}{
+		"model":      "model-a",
+		"prompt":     "Say hi",
+		"max_tokens": float64(16),
+		"echo":       false,
+		"logprobs":   float64(2),
+		"suffix":     "",
+		"best_of":    float64(1),
+	}
+
+	chat := completionToChat(req, "Say hi")
+
+	for _, field := range legacyOnlyFields {
+		if _, exists := chat[field]; exists {
+			t.Errorf("legacy field %s should be removed", field)
+		}
+	}
+	if chat["max_tokens"] != float64(16) || chat["model"] != "model-a" {
+		t.Errorf("shared fields should be kept: %v", chat)
+	}
+	messages, _ := chat["messages"].([]interface{})
+	if len(messages) != 1 {
+		t.Fatalf("expected a single message, got %v", chat["messages"])
+	}
+	msg := messages[0].(map[string]interface{})
+	if msg["role"] != "user" || msg["content"] != "Say hi" {
+		t.Errorf("unexpected message: %v", msg)
+	}
+	if _, exists := req["messages"]; exists {
+		t.Error("original request body must not be modified")
+	}
+}
+
+func TestChatToCompletion(t *testing.T) {
+	body := []byte(`{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"m1","choices":[{"index":0,"message":{"role":"assistant","content":"Hi!"},"finish_reason":"stop"}],"usage":{"prompt_tokens":3,"completion_tokens":2,"total_tokens":5}}`)
+
+	resp, ok := chatToCompletion(body, 2)
+	if !ok {
+		t.Fatal("conversion failed")
+	}
+	if resp["object"] != "text_completion" || resp["id"] != "chatcmpl-1" || resp["usage"] == nil {
+		t.Errorf("top-level fields not preserved: %v", resp)
+	}
+	choice := resp["choices"].([]interface{})[0].(map[string]interface{})
+	if choice["text"] != "Hi!" || choice["index"] != 2 || choice["finish_reason"] != "stop" {
+		t.Errorf("unexpected choice: %v", choice)
+	}
+	if _, ok := chatToCompletion([]byte(`{"error":"x"}`), 0); ok {
+		t.Error("response without choices should not convert")
+	}
+}
+
+func TestCompletionStreamConverter(t *testing.T) {
+	stream := `data: {"id":"c1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"role":"assistant"},"finish_reason":null}]}
+
+data: {"id":"c1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"content":"Hel"},"finish_reason":null}]}
+
+data: {"id":"c1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"content":"lo"},"finish_reason":null}]}
+
+data: {"id":"c1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}
+
+data: [DONE]
+
+`
+	out := runFilter(completionStreamConverter, stream)
+
+	var text strings.Builder
+	var finish string
+	for _, line := range strings.Split(out, "\n") {
+		payload, ok := sseData([]byte(line))
+		if !ok {
+			continue
+		}
+		var chunk struct {
+			Object  string `json:"object"`
+			Choices []struct {
+				Text         string  `json:"text"`
+				FinishReason *string `json:"finish_reason"`
+			} `json:"choices"`
+		}
+		json.Unmarshal(payload, &chunk)
+		if chunk.Object != "text_completion" {
+			t.Errorf("object = %q, want text_completion", chunk.Object)
+		}
+		for _, c := range chunk.Choices {
+			text.WriteString(c.Text)
+			if c.FinishReason != nil {
+				finish = *c.FinishReason
+			}
+		}
+	}
+	if text.String() != "Hello" || finish != "stop" {
+		t.Errorf("reassembled text=%q finish=%q", text.String(), finish)
+	}
+	if !strings.Contains(out, "data: [DONE]") {
+		t.Error("[DONE] should pass through")
+	}
+}
+
+func newCompletionsBackend(t *testing.T, paths *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*paths = append(*paths, r.URL.Path)
+		var req struct {
+			Stream   bool `json:"stream"`
+			Messages []struct {
+				Content string `json:"content"`
+			} `json:"messages"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if len(req.Messages) != 1 {
+			t.Errorf("expected a single wrapped message, got %+v", req.Messages)
+			return
+		}
+		prompt := req.Messages[0].Content
+		if req.Stream {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Write([]byte(`data: {"object":"chat.completion.chunk","choices":[{"index":0,"delta":{"content":"echo:` + prompt + `"},"finish_reason":null}]}` + "\n\n"))
+			w.Write([]byte("data: [DONE]\n\n"))
+			return
+		}
+		w.Write([]byte(`{"object":"chat.completion","choices":[{"index":0,"message":{"role":"assistant","content":"echo:` + prompt + `"},"finish_reason":"stop"}],"usage":{"prompt_tokens":1,"completion_tokens":2,"total_tokens":3}}`))
+	}))
+}
+
+func TestProxy_LegacyCompletions(t *testing.T) {
+	var paths []string
+	backend := newCompletionsBackend(t, &paths)
+	defer backend.Close()
+
+	proxy := newTestProxy(&Config{
+		Backends: []Backend{{Name: "b1", URL: backend.URL}},
+		Models: map[string]*ModelAlias{
+			"model-a": {Routes: []ModelRoute{{Backend: "b1", Model: "m1", Priority: 1}}},
+		},
+	})
+
+	tests := []struct {
+		name      string
+		body      string
+		wantCode  int
+		wantTexts []string
+		wantInOut string
+	}{
+		{"string prompt", `{"model":"model-a","prompt":"hi"}`, http.StatusOK, []string{"echo:hi"}, ""},
+		{"array prompt", `{"model":"model-a","prompt":["a","b"]}`, http.StatusOK, []string{"echo:a", "echo:b"}, ""},
+		{"streaming", `{"model":"model-a","prompt":"s","stream":true}`, http.StatusOK, nil, `"text":"echo:s"`},
+		{"streaming multiple prompts", `{"model":"model-a","prompt":["a","b"],"stream":true}`, http.StatusBadRequest, nil, ""},
+		{"invalid prompt", `{"model":"model-a","prompt":{"x":1}}`, http.StatusBadRequest, nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			paths = nil
+			req := httptest.NewRequest("POST", "/v1/completions", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("expected %d, got %d: %s", tt.wantCode, w.Code, w.Body.String())
+			}
+			for _, p := range paths {
+				if p != "/v1/chat/completions" {
+					t.Errorf("upstream path = %q, want /v1/chat/completions", p)
+				}
+			}
+			if tt.wantInOut != "" && !strings.Contains(w.Body.String(), tt.wantInOut) {
+				t.Errorf("stream output missing %s: %s", tt.wantInOut, w.Body.String())
+			}
+			if tt.wantTexts == nil {
+				return
+			}
+
+			var resp struct {
+				Object  string `json:"object"`
+				Choices []struct {
+					Index int    `json:"index"`
+					Text  string `json:"text"`
+				} `json:"choices"`
+				Usage Usage `json:"usage"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("invalid JSON: %v", err)
+			}
+			if resp.Object != "text_completion" || len(resp.Choices) != len(tt.wantTexts) {
+				t.Fatalf("unexpected response: %s", w.Body.String())
+			}
+			for i, want := range tt.wantTexts {
+				if resp.Choices[i].Text != want || resp.Choices[i].Index != i {
+					t.Errorf("choice %d = %+v, want text %q", i, resp.Choices[i], want)
+				}
+			}
+			if resp.Usage.TotalTokens != int64(3*len(tt.wantTexts)) {
+				t.Errorf("usage should be summed across prompts, got %+v", resp.Usage)
+			}
+		})
+	}
+}
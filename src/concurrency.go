@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ConcurrencyLimiter 限制代理同时处理的请求数。名额已满时请求按到达顺序排队，
+// 名额释放后直接交给队首的请求；排队超过超时时间或请求被取消时放弃。
+// 未配置上限时只统计处理中的请求数
+type ConcurrencyLimiter struct {
+	mu       sync.Mutex
+	max      int
+	inFlight int
+	waiters  []chan struct{}
+}
+
+func NewConcurrencyLimiter() *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{}
+}
+
+// Acquire 申请一个并发名额，成功时返回释放函数
+func (cl *ConcurrencyLimiter) Acquire(ctx context.Context, cfg *ConcurrencyConfig) (func(), bool) {
+	cl.mu.Lock()
+	cl.max = cfg.MaxInFlight
+	// 上限在重载中调大或取消时，先放行已在排队的请求
+	for len(cl.waiters) > 0 && cl.hasFreeSlot() {
+		cl.inFlight++
+		cl.popWaiter() <- struct{}{}
+	}
+	if cl.hasFreeSlot() {
+		cl.inFlight++
+		cl.reportGauges()
+		cl.mu.Unlock()
+		return cl.releaseFunc(), true
+	}
+	ready := make(chan struct{}, 1)
+	cl.waiters = append(cl.waiters, ready)
+	cl.reportGauges()
+	cl.mu.Unlock()
+
+	timer := time.NewTimer(cfg.GetQueueTimeout())
+	defer timer.Stop()
+	select {
+	case <-ready:
+		return cl.releaseFunc(), true
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+
+	cl.mu.Lock()
+	if i := slices.Index(cl.waiters, ready); i >= 0 {
+		cl.waiters = slices.Delete(cl.waiters, i, i+1)
+		cl.reportGauges()
+		cl.mu.Unlock()
+		return nil, false
+	}
+	cl.mu.Unlock()
+	// 放弃前名额已经交给了本请求，转交给下一个排队的请求
+	cl.release()
+	return nil, false
+}
+
+func (cl *ConcurrencyLimiter) hasFreeSlot() bool {
+	return cl.max <= 0 || cl.inFlight < cl.max
+}
+
+func (cl *ConcurrencyLimiter) releaseFunc() func() {
+	var once sync.Once
+	return func() { once.Do(cl.release) }
+}
+
+func (cl *ConcurrencyLimiter) release() {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	defer cl.reportGauges()
+	// 上限在重载中调小时，先让处理中的请求数降到新上限以内再唤醒排队的请求
+	if len(cl.waiters) > 0 && (cl.max <= 0 || cl.inFlight <= cl.max) {
+		cl.popWaiter() <- struct{}{}
+		return
+	}
+	cl.inFlight--
+}
+
+func (cl *ConcurrencyLimiter) popWaiter() chan struct{} {
+	ready := cl.waiters[0]
+	cl.waiters = cl.waiters[1:]
+	return ready
+}
+
+// reportGauges 更新处理中与排队中的请求数指标，调用方需持有 mu
+func (cl *ConcurrencyLimiter) reportGauges() {
+	metricsRegistry.SetGauge("llm_proxy_in_flight_requests", float64(cl.inFlight))
+	metricsRegistry.SetGauge("llm_proxy_queued_requests", float64(len(cl.waiters)))
+}
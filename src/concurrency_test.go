@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConcurrencyLimiter_AdmitsAfterSlotFrees(t *testing.T) {
+	cl := NewConcurrencyLimiter()
+	cfg := &ConcurrencyConfig{MaxInFlight: 1, QueueTimeoutMs: 2000}
+
+	release, ok := cl.Acquire(context.Background(), cfg)
+	if !ok {
+		t.Fatal("first request should be admitted")
+	}
+
+	admitted := make(chan bool, 1)
+	go func() {
+		r, ok := cl.Acquire(context.Background(), cfg)
+		if ok {
+			defer r()
+		}
+		admitted <- ok
+	}()
+
+	select {
+	case <-admitted:
+		t.Fatal("second request should queue while the slot is taken")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if got := metricsRegistry.CounterValue("llm_proxy_queued_requests"); got != 1 {
+		t.Errorf("queued gauge = %v, want 1", got)
+	}
+	if got := metricsRegistry.CounterValue("llm_proxy_in_flight_requests"); got != 1 {
+		t.Errorf("in-flight gauge = %v, want 1", got)
+	}
+	release()
+	select {
+	case ok := <-admitted:
+		if !ok {
+			t.Error("queued request should be admitted once the slot frees")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("queued request was not admitted after release")
+	}
+}
+
+func TestConcurrencyLimiter_RejectsOnTimeoutOrCancel(t *testing.T) {
+	cl := NewConcurrencyLimiter()
+	cfg := &ConcurrencyConfig{MaxInFlight: 1, QueueTimeoutMs: 30}
+	release, _ := cl.Acquire(context.Background(), cfg)
+
+	start := time.Now()
+	if _, ok := cl.Acquire(context.Background(), cfg); ok {
+		t.Error("request should time out while the slot is taken")
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("rejected after %v, want to wait for the queue timeout", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, ok := cl.Acquire(ctx, &ConcurrencyConfig{MaxInFlight: 1, QueueTimeoutMs: 5000}); ok {
+		t.Error("cancelled request should not be admitted")
+	}
+
+	// 放弃排队的请求不能占用名额
+	release()
+	r, ok := cl.Acquire(context.Background(), cfg)
+	if !ok {
+		t.Fatal("slot should be free after release")
+	}
+	r()
+	if cl.inFlight != 0 || len(cl.waiters) != 0 {
+		t.Errorf("inFlight = %d, waiters = %d, want 0", cl.inFlight, len(cl.waiters))
+	}
+}
+
+func TestProxy_ConcurrencyLimitReturns503(t *testing.T) {
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-unblock
+		w.Write([]byte(`{"choices":[]}`))
+	}))
+	defer backend.Close()
+	defer close(unblock)
+
+	proxy := newTestProxy(&Config{
+		Backends: []Backend{{Name: "b1", URL: backend.URL}},
+		Models: map[string]*ModelAlias{
+			"model-a": {Routes: []ModelRoute{{Backend: "b1", Model: "m1", Priority: 1}}},
+		},
+		Concurrency: ConcurrencyConfig{MaxInFlight: 1, QueueTimeoutMs: 20},
+	})
+	send := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"model-a"}`)))
+		return w
+	}
+
+	go send()
+	<-started
+	before := metricsRegistry.CounterValue("llm_proxy_rejections_total", "reason", "concurrency")
+	w := send()
+	if got := metricsRegistry.CounterValue("llm_proxy_rejections_total", "reason", "concurrency"); got != before+1 {
+		t.Errorf("rejections{reason=concurrency} = %v, want %v", got, before+1)
+	}
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503", w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("503 should carry Retry-After")
+	}
+}
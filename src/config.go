@@ -1,38 +1,321 @@
 package main
 
 import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
 	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/BurntSushi/toml"
 	"gopkg.in/yaml.v3"
 )
 
 type Backend struct {
-	Name    string `yaml:"name"`
-	URL     string `yaml:"url"`
-	APIKey  string `yaml:"api_key,omitempty"`
-	Enabled *bool  `yaml:"enabled,omitempty"`
+	Name               string            `yaml:"name"`
+	URL                string            `yaml:"url"`
+	Protocol           string            `yaml:"protocol,omitempty"`
+	APIKey             string            `yaml:"api_key,omitempty"`
+	APIKeys            []string          `yaml:"api_keys,omitempty"`
+	KeyCooldownSeconds int               `yaml:"key_cooldown_seconds,omitempty"`
+	Enabled            *bool             `yaml:"enabled,omitempty"`
+	AllowedFields      []string          `yaml:"allowed_fields,omitempty"`
+	Quota              *Quota            `yaml:"quota,omitempty"`
+	SystemPrompt       string            `yaml:"system_prompt,omitempty"`
+	RateLimit          *BackendRateLimit `yaml:"rate_limit,omitempty"`
+	Deployment         string            `yaml:"deployment,omitempty"`
+	APIVersion         string            `yaml:"api_version,omitempty"`
+	AWS                *AWSCredentials   `yaml:"aws,omitempty"`
+	ConnectionPool     *ConnectionPool   `yaml:"connection_pool,omitempty"`
+	TLS                *BackendTLS       `yaml:"tls,omitempty"`
+}
+
+// BackendTLS 配置连接后端时的 TLS 要求：MinVersion 为 "1.2"（默认）或 "1.3"；
+// PinnedSHA256 为证书公钥（SPKI）SHA-256 的 base64 列表，可带 "sha256/" 前缀，
+// 证书链中任意一张证书匹配即可；InsecureSkipVerify 跳过证书校验，仅用于开发环境
+type BackendTLS struct {
+	MinVersion         string   `yaml:"min_version,omitempty"`
+	PinnedSHA256       []string `yaml:"pinned_sha256,omitempty"`
+	InsecureSkipVerify bool     `yaml:"insecure_skip_verify,omitempty"`
+}
+
+var tlsVersions = map[string]uint16{"1.2": tls.VersionTLS12, "1.3": tls.VersionTLS13}
+
+// AWSCredentials 是 Bedrock 后端用于 SigV4 签名的凭据
+type AWSCredentials struct {
+	Region          string `yaml:"region"`
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+	SessionToken    string `yaml:"session_token,omitempty"`
+}
+
+const (
+	ProtocolOpenAI    = "openai"
+	ProtocolAnthropic = "anthropic"
+	ProtocolGoogle    = "google"
+	ProtocolAzure     = "azure"
+	ProtocolBedrock   = "bedrock"
+)
+
+// defaultAzureAPIVersion 是 Azure OpenAI 后端未配置 api_version 时使用的版本
+const defaultAzureAPIVersion = "2024-10-21"
+
+// GetAPIVersion 返回 Azure OpenAI 的 api-version 查询参数
+func (b *Backend) GetAPIVersion() string {
+	if b.APIVersion == "" {
+		return defaultAzureAPIVersion
+	}
+	return b.APIVersion
+}
+
+// GetDeployment 返回 Azure OpenAI 的部署名，未配置时使用路由的模型名
+func (b *Backend) GetDeployment(model string) string {
+	if b.Deployment == "" {
+		return model
+	}
+	return b.Deployment
+}
+
+func (b *Backend) GetProtocol() string {
+	if b.Protocol == "" {
+		return ProtocolOpenAI
+	}
+	return b.Protocol
+}
+
+// SupportsEmbeddings 只有 OpenAI 兼容后端（含 Azure OpenAI）提供 embeddings
+func (b *Backend) SupportsEmbeddings() bool {
+	return b.GetProtocol() == ProtocolOpenAI || b.GetProtocol() == ProtocolAzure
+}
+
+// SupportsPath 判断后端能否处理该接口：embeddings 见 SupportsEmbeddings，
+// Bedrock 后端只转发 Anthropic Messages 请求
+func (b *Backend) SupportsPath(path string) bool {
+	if isEmbeddingsPath(path) {
+		return b.SupportsEmbeddings()
+	}
+	if b.GetProtocol() == ProtocolBedrock {
+		return strings.HasSuffix(strings.TrimSuffix(path, "/"), "/messages")
+	}
+	return true
+}
+
+// Keys 返回后端的全部 API Key：api_key 在前，api_keys 依次在后，去除空值与重复
+func (b *Backend) Keys() []string {
+	seen := make(map[string]bool, len(b.APIKeys)+1)
+	var keys []string
+	for _, k := range append([]string{b.APIKey}, b.APIKeys...) {
+		if k != "" && !seen[k] {
+			seen[k] = true
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+func (b *Backend) GetKeyCooldown() time.Duration {
+	if b.KeyCooldownSeconds <= 0 {
+		return time.Minute
+	}
+	return time.Duration(b.KeyCooldownSeconds) * time.Second
+}
+
+type BackendRateLimit struct {
+	RPS            float64 `yaml:"rps"`
+	Burst          int     `yaml:"burst"`
+	Policy         string  `yaml:"policy"`
+	QueueTimeoutMs int     `yaml:"queue_timeout_ms"`
+}
+
+func (r *BackendRateLimit) GetPolicy() string {
+	if r.Policy == "" {
+		return OverLimitQueue
+	}
+	return r.Policy
+}
+
+func (r *BackendRateLimit) GetQueueTimeout() time.Duration {
+	if r.QueueTimeoutMs <= 0 {
+		return 5 * time.Second
+	}
+	return time.Duration(r.QueueTimeoutMs) * time.Millisecond
 }
 
 func (b *Backend) IsEnabled() bool {
 	return b.Enabled == nil || *b.Enabled
 }
 
+type RateLimitRule struct {
+	RPS   float64 `yaml:"rps"`
+	Burst int     `yaml:"burst"`
+	TPM   int64   `yaml:"tpm,omitempty"`
+}
+
+type KeyRateLimit struct {
+	KeySHA256 string  `yaml:"key_sha256"`
+	RPS       float64 `yaml:"rps"`
+	Burst     int     `yaml:"burst"`
+}
+
+// RateLimitConfig 入站请求限流：rps 为 0 表示不限制全局速率
+type RateLimitConfig struct {
+	RPS    float64                  `yaml:"rps"`
+	Burst  int                      `yaml:"burst"`
+	Keys   []KeyRateLimit           `yaml:"keys,omitempty"`
+	Models map[string]RateLimitRule `yaml:"models,omitempty"`
+}
+
+func (c *RateLimitConfig) keyRule(hash string) (RateLimitRule, bool) {
+	for _, k := range c.Keys {
+		if strings.EqualFold(k.KeySHA256, hash) {
+			return RateLimitRule{RPS: k.RPS, Burst: k.Burst}, true
+		}
+	}
+	return RateLimitRule{}, false
+}
+
+// ConcurrencyConfig 限制代理同时处理的请求数，MaxInFlight 为 0 时不限制
+type ConcurrencyConfig struct {
+	MaxInFlight    int `yaml:"max_in_flight"`
+	QueueTimeoutMs int `yaml:"queue_timeout_ms"`
+}
+
+// GetQueueTimeout 返回名额已满时请求最多排队等待的时长，未配置时为 30 秒
+func (c *ConcurrencyConfig) GetQueueTimeout() time.Duration {
+	if c.QueueTimeoutMs <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(c.QueueTimeoutMs) * time.Millisecond
+}
+
+// ConnectionPool 配置发往后端的 HTTP 连接池与超时，未配置的字段沿用 Go 默认值；
+// MaxConnsPerHost 与 ResponseHeaderTimeoutSeconds 为 0 时不限制。
+// 后端可以配置自己的 connection_pool，其中非零字段覆盖全局配置
+type ConnectionPool struct {
+	MaxConnsPerHost              int    `yaml:"max_conns_per_host"`
+	MaxIdleConns                 int    `yaml:"max_idle_conns"`
+	MaxIdleConnsPerHost          int    `yaml:"max_idle_conns_per_host"`
+	IdleConnTimeoutSeconds       int    `yaml:"idle_conn_timeout_seconds"`
+	KeepAliveSeconds             int    `yaml:"keep_alive_seconds"`
+	ResponseHeaderTimeoutSeconds int    `yaml:"response_header_timeout_seconds"`
+	HTTPVersion                  string `yaml:"http_version,omitempty"`
+}
+
+// HTTP 版本选项：默认通过 TLS ALPN 协商，HTTPVersion1 强制使用 HTTP/1.1，
+// HTTPVersion2 只使用 HTTP/2（http:// 地址使用明文 h2c）
+const (
+	HTTPVersion1 = "http1"
+	HTTPVersion2 = "http2"
+)
+
+// For 返回后端生效的连接池配置：全局配置叠加后端自身配置中的非零字段
+func (c ConnectionPool) For(b *Backend) ConnectionPool {
+	if b == nil || b.ConnectionPool == nil {
+		return c
+	}
+	o := b.ConnectionPool
+	for _, f := range []struct{ dst, src *int }{
+		{&c.MaxConnsPerHost, &o.MaxConnsPerHost},
+		{&c.MaxIdleConns, &o.MaxIdleConns},
+		{&c.MaxIdleConnsPerHost, &o.MaxIdleConnsPerHost},
+		{&c.IdleConnTimeoutSeconds, &o.IdleConnTimeoutSeconds},
+		{&c.KeepAliveSeconds, &o.KeepAliveSeconds},
+		{&c.ResponseHeaderTimeoutSeconds, &o.ResponseHeaderTimeoutSeconds},
+	} {
+		if *f.src > 0 {
+			*f.dst = *f.src
+		}
+	}
+	if o.HTTPVersion != "" {
+		c.HTTPVersion = o.HTTPVersion
+	}
+	return c
+}
+
+func (c *ConnectionPool) GetMaxIdleConns() int {
+	if c.MaxIdleConns <= 0 {
+		return 100
+	}
+	return c.MaxIdleConns
+}
+
+func (c *ConnectionPool) GetMaxIdleConnsPerHost() int {
+	if c.MaxIdleConnsPerHost <= 0 {
+		return http.DefaultMaxIdleConnsPerHost
+	}
+	return c.MaxIdleConnsPerHost
+}
+
+func (c *ConnectionPool) GetIdleConnTimeout() time.Duration {
+	if c.IdleConnTimeoutSeconds <= 0 {
+		return 90 * time.Second
+	}
+	return time.Duration(c.IdleConnTimeoutSeconds) * time.Second
+}
+
+func (c *ConnectionPool) GetKeepAlive() time.Duration {
+	if c.KeepAliveSeconds <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(c.KeepAliveSeconds) * time.Second
+}
+
+type Quota struct {
+	Tokens     int64   `yaml:"tokens"`
+	Period     string  `yaml:"period,omitempty"`
+	TaperStart float64 `yaml:"taper_start,omitempty"`
+}
+
+func (q *Quota) GetTaperStart() float64 {
+	if q.TaperStart <= 0 || q.TaperStart >= 1 {
+		return 0.8
+	}
+	return q.TaperStart
+}
+
+func (q *Quota) periodKey(t time.Time) string {
+	if q.Period == "daily" {
+		return t.Format("2006-01-02")
+	}
+	return t.Format("2006-01")
+}
+
 type ModelRoute struct {
-	Backend  string `yaml:"backend"`
-	Model    string `yaml:"model"`
-	Priority int    `yaml:"priority"`
-	Enabled  *bool  `yaml:"enabled,omitempty"`
+	Backend    string   `yaml:"backend"`
+	Model      string   `yaml:"model"`
+	Priority   int      `yaml:"priority"`
+	Weight     float64  `yaml:"weight,omitempty"`
+	Enabled    *bool    `yaml:"enabled,omitempty"`
+	Transforms []string `yaml:"transforms,omitempty"`
 }
 
 func (r *ModelRoute) IsEnabled() bool {
 	return r.Enabled == nil || *r.Enabled
 }
 
+// GetWeight 返回同优先级组内的相对权重，未配置或非正数时为 1
+func (r *ModelRoute) GetWeight() float64 {
+	if r.Weight <= 0 {
+		return 1
+	}
+	return r.Weight
+}
+
 type ModelAlias struct {
-	Enabled *bool        `yaml:"enabled,omitempty"`
-	Routes  []ModelRoute `yaml:"routes"`
+	Enabled          *bool        `yaml:"enabled,omitempty"`
+	Routes           []ModelRoute `yaml:"routes"`
+	KeepChoice       *int         `yaml:"keep_choice,omitempty"`
+	DefaultStream    *bool        `yaml:"default_stream,omitempty"`
+	SystemPrompt     string       `yaml:"system_prompt,omitempty"`
+	SystemPromptFile string       `yaml:"system_prompt_file,omitempty"`
+	MaxAttempts      int          `yaml:"max_attempts,omitempty"`
 }
 
 func (m *ModelAlias) IsEnabled() bool {
@@ -40,39 +323,644 @@ func (m *ModelAlias) IsEnabled() bool {
 }
 
 type Fallback struct {
-	CooldownSeconds int                 `yaml:"cooldown_seconds"`
-	MaxRetries      int                 `yaml:"max_retries"`
-	AliasFallback   map[string][]string `yaml:"alias_fallback,omitempty"`
+	CooldownSeconds    int                         `yaml:"cooldown_seconds"`
+	MaxRetries         int                         `yaml:"max_retries"`
+	ClientMaxAttempts  bool                        `yaml:"client_max_attempts"`
+	MaxCooldownSeconds int                         `yaml:"max_cooldown_seconds"`
+	CooldownBackoff    CooldownBackoff             `yaml:"cooldown_backoff,omitempty"`
+	AliasFallback      map[string][]FallbackTarget `yaml:"alias_fallback,omitempty"`
+	Mutations          []RetryMutation             `yaml:"mutations,omitempty"`
+	ChurnAlert         ChurnAlert                  `yaml:"churn_alert,omitempty"`
+}
+
+// FallbackTarget 是别名回退目标，可以直接写别名，也可以写成 {alias, weight, priority}
+type FallbackTarget struct {
+	Alias    string  `yaml:"alias"`
+	Weight   float64 `yaml:"weight,omitempty"`
+	Priority int     `yaml:"priority,omitempty"`
+}
+
+func (t *FallbackTarget) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		t.Alias = node.Value
+		return nil
+	}
+	type plain FallbackTarget
+	return node.Decode((*plain)(t))
+}
+
+func (t *FallbackTarget) GetWeight() float64 {
+	if t.Weight <= 0 {
+		return 1
+	}
+	return t.Weight
+}
+
+// MaxAttempts 返回单次请求最多尝试的后端数：默认取 max_retries（未配置时为路由数）；
+// 开启 client_max_attempts 时请求头可以降低该值，但不能超过配置上限，无效值忽略
+func (f *Fallback) MaxAttempts(routes int, header string) int {
+	max := f.MaxRetries
+	if max <= 0 {
+		max = routes
+	}
+	if !f.ClientMaxAttempts || header == "" {
+		return max
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(header))
+	if err != nil || n <= 0 {
+		return max
+	}
+	if n < max {
+		return n
+	}
+	return max
+}
+
+// Cooldown 返回后端失败后的默认冷却时长
+func (f *Fallback) Cooldown() time.Duration {
+	return time.Duration(f.CooldownSeconds) * time.Second
+}
+
+// GetMaxCooldown 返回冷却可延长到的上限（Retry-After 与逐次递增的冷却），未配置时为 10 分钟
+func (f *Fallback) GetMaxCooldown() time.Duration {
+	if f.MaxCooldownSeconds <= 0 {
+		return 10 * time.Minute
+	}
+	return time.Duration(f.MaxCooldownSeconds) * time.Second
+}
+
+// ApplyRetryAfter 取 base 与上游 Retry-After 中较长者，Retry-After 最多延长到 max_cooldown_seconds
+func (f *Fallback) ApplyRetryAfter(base, retryAfter time.Duration) time.Duration {
+	if retryAfter <= base {
+		return base
+	}
+	return max(base, min(retryAfter, f.GetMaxCooldown()))
+}
+
+// EscalatedCooldown 返回路由第 failures 次连续失败后的冷却时长，r 为 [0,1) 的随机数，用于计算浮动。
+// 未配置 cooldown_backoff 时即 cooldown_seconds
+func (f *Fallback) EscalatedCooldown(failures int, r float64) time.Duration {
+	base := f.Cooldown()
+	limit := max(base, f.GetMaxCooldown())
+	d := base
+	if f.CooldownBackoff.Multiplier > 1 {
+		for i := 1; i < failures && d < limit; i++ {
+			d = time.Duration(float64(d) * f.CooldownBackoff.Multiplier)
+		}
+	}
+	if j := min(f.CooldownBackoff.Jitter, 1); j > 0 {
+		d = time.Duration(float64(d) * (1 + j*(2*r-1)))
+	}
+	return min(d, limit)
+}
+
+// RetryMutation 在匹配的失败响应后修改请求体，并用修改后的请求重试同一路由
+type RetryMutation struct {
+	ErrorCodes    []string               `yaml:"error_codes,omitempty"`
+	ErrorPatterns []string               `yaml:"error_patterns,omitempty"`
+	Set           map[string]interface{} `yaml:"set,omitempty"`
+	Scale         map[string]float64     `yaml:"scale,omitempty"`
+	Drop          []string               `yaml:"drop,omitempty"`
+}
+
+// CooldownBackoff 让连续失败的路由冷却逐次变长：第 n 次连续失败冷却 cooldown_seconds × multiplier^(n-1)，
+// 不超过 max_cooldown_seconds，路由成功后重置。Jitter 为随机浮动比例（0~1），避免多个路由同时恢复
+type CooldownBackoff struct {
+	Multiplier float64 `yaml:"multiplier"`
+	Jitter     float64 `yaml:"jitter"`
+}
+
+type ChurnAlert struct {
+	WindowSeconds int    `yaml:"window_seconds"`
+	Threshold     int    `yaml:"threshold"`
+	Webhook       string `yaml:"webhook,omitempty"`
+}
+
+func (c *ChurnAlert) IsEnabled() bool {
+	return c.Threshold > 0
+}
+
+func (c *ChurnAlert) GetWindow() time.Duration {
+	if c.WindowSeconds <= 0 {
+		return 10 * time.Minute
+	}
+	return time.Duration(c.WindowSeconds) * time.Second
+}
+
+type CircuitBreakerConfig struct {
+	Enabled            bool `yaml:"enabled"`
+	FailureThreshold   int  `yaml:"failure_threshold"`
+	SuccessThreshold   int  `yaml:"success_threshold"`
+	OpenTimeoutSeconds int  `yaml:"open_timeout_seconds"`
+}
+
+func (c *CircuitBreakerConfig) GetFailureThreshold() int {
+	if c.FailureThreshold <= 0 {
+		return 5
+	}
+	return c.FailureThreshold
+}
+
+func (c *CircuitBreakerConfig) GetSuccessThreshold() int {
+	if c.SuccessThreshold <= 0 {
+		return 2
+	}
+	return c.SuccessThreshold
+}
+
+func (c *CircuitBreakerConfig) GetOpenTimeout() time.Duration {
+	if c.OpenTimeoutSeconds <= 0 {
+		return time.Minute
+	}
+	return time.Duration(c.OpenTimeoutSeconds) * time.Second
+}
+
+const (
+	StrategyRandom           = "random"
+	StrategyRoundRobin       = "round_robin"
+	StrategyLeastConnections = "least_connections"
+	StrategyWeightedRandom   = "weighted_random"
+	StrategyStickyHash       = "sticky_hash"
+	StrategyScoreWeighted    = "score_weighted"
+)
+
+type LoadBalance struct {
+	Strategy string `yaml:"strategy"`
+}
+
+func (l *LoadBalance) GetStrategy() string {
+	if l.Strategy == "" {
+		return StrategyRandom
+	}
+	return l.Strategy
 }
 
 type Detection struct {
-	ErrorCodes    []string `yaml:"error_codes"`
-	ErrorPatterns []string `yaml:"error_patterns"`
+	ErrorCodes               []string                     `yaml:"error_codes"`
+	ErrorPatterns            []string                     `yaml:"error_patterns"`
+	FallbackOnEmpty          *bool                        `yaml:"fallback_on_empty,omitempty"`
+	FallbackOnInvalidRequest bool                         `yaml:"fallback_on_invalid_request"`
+	Protocols                map[string]ProtocolDetection `yaml:"protocols,omitempty"`
+}
+
+// ProtocolDetection 是只对某一协议后端生效的回退规则
+type ProtocolDetection struct {
+	ErrorCodes    []string `yaml:"error_codes,omitempty"`
+	ErrorPatterns []string `yaml:"error_patterns,omitempty"`
+}
+
+// defaultProtocolDetection 为各协议内置的过载/瞬时错误标记，配置的协议规则在此基础上追加
+var defaultProtocolDetection = map[string]ProtocolDetection{
+	ProtocolAnthropic: {ErrorCodes: []string{"529"}, ErrorPatterns: []string{"overloaded_error"}},
+	ProtocolOpenAI:    {ErrorPatterns: []string{"server_error"}},
+	ProtocolGoogle:    {ErrorPatterns: []string{"RESOURCE_EXHAUSTED"}},
+	ProtocolAzure:     {ErrorPatterns: []string{"server_error"}},
+}
+
+// ForProtocol 合并全局规则、协议内置规则与配置的协议规则
+func (d *Detection) ForProtocol(protocol string) ProtocolDetection {
+	def, custom := defaultProtocolDetection[protocol], d.Protocols[protocol]
+	return ProtocolDetection{
+		ErrorCodes:    slices.Concat(d.ErrorCodes, def.ErrorCodes, custom.ErrorCodes),
+		ErrorPatterns: slices.Concat(d.ErrorPatterns, def.ErrorPatterns, custom.ErrorPatterns),
+	}
+}
+
+// ShouldFallbackOnEmpty 后端返回 2xx 但响应体为空（或流中没有任何事件）时是否视为失败并回退，默认开启
+func (d *Detection) ShouldFallbackOnEmpty() bool {
+	return d.FallbackOnEmpty == nil || *d.FallbackOnEmpty
 }
 
 type Logging struct {
-	Level         string `yaml:"level"`
-	RequestDir    string `yaml:"request_dir"`
-	ErrorDir      string `yaml:"error_dir"`
-	GeneralFile   string `yaml:"general_file"`
-	SeparateFiles bool   `yaml:"separate_files"`
-	MaskSensitive *bool  `yaml:"mask_sensitive,omitempty"`
-	EnableMetrics bool   `yaml:"enable_metrics"`
-	MaxFileSizeMB int    `yaml:"max_file_size_mb"`
+	Level         string   `yaml:"level"`
+	RequestDir    string   `yaml:"request_dir"`
+	ErrorDir      string   `yaml:"error_dir"`
+	GeneralFile   string   `yaml:"general_file"`
+	SeparateFiles bool     `yaml:"separate_files"`
+	MaskSensitive *bool    `yaml:"mask_sensitive,omitempty"`
+	EnableMetrics bool     `yaml:"enable_metrics"`
+	MaxFileSizeMB int      `yaml:"max_file_size_mb"`
+	RouteTrace    bool     `yaml:"route_trace"`
+	AccessLog     bool     `yaml:"access_log"`
+	AccessFile    string   `yaml:"access_file,omitempty"`
+	RedactFields  []string `yaml:"redact_fields,omitempty"`
 }
 
 func (l *Logging) ShouldMaskSensitive() bool {
 	return l.MaskSensitive == nil || *l.MaskSensitive
 }
 
+type Embeddings struct {
+	BatchWindowMs int `yaml:"batch_window_ms"`
+	MaxBatchSize  int `yaml:"max_batch_size"`
+}
+
+func (e *Embeddings) BatchingEnabled() bool {
+	return e.BatchWindowMs > 0
+}
+
+func (e *Embeddings) GetMaxBatchSize() int {
+	if e.MaxBatchSize <= 0 {
+		return 16
+	}
+	return e.MaxBatchSize
+}
+
+// 流式响应的刷新方式
+const (
+	StreamFlushEvent   = "event"
+	StreamFlushBatched = "batched"
+)
+
+// Streaming 配置流式响应的改写与刷新。FlushMode 为 event（默认）时每个事件写出后立即刷新；
+// 为 batched 时合并写入，累计 FlushBytes 字节或首个未刷新写入经过 FlushIntervalMs 后再刷新。
+// HeartbeatIntervalMs 大于 0 时，上游空闲超过该间隔向客户端发送 keep-alive 注释
+type Streaming struct {
+	DedupeRole          bool   `yaml:"dedupe_role"`
+	AnthropicUsage      bool   `yaml:"anthropic_usage"`
+	NormalizeToolCalls  bool   `yaml:"normalize_tool_calls"`
+	FlushMode           string `yaml:"flush_mode,omitempty"`
+	FlushIntervalMs     int    `yaml:"flush_interval_ms,omitempty"`
+	FlushBytes          int    `yaml:"flush_bytes,omitempty"`
+	HeartbeatIntervalMs int    `yaml:"heartbeat_interval_ms,omitempty"`
+}
+
+// GetHeartbeatInterval 返回心跳间隔，0 表示不发送心跳
+func (s *Streaming) GetHeartbeatInterval() time.Duration {
+	return time.Duration(max(s.HeartbeatIntervalMs, 0)) * time.Millisecond
+}
+
+func (s *Streaming) GetFlushInterval() time.Duration {
+	if s.FlushIntervalMs <= 0 {
+		return 50 * time.Millisecond
+	}
+	return time.Duration(s.FlushIntervalMs) * time.Millisecond
+}
+
+func (s *Streaming) GetFlushBytes() int {
+	if s.FlushBytes <= 0 {
+		return 4096
+	}
+	return s.FlushBytes
+}
+
+type HealthCheck struct {
+	Enabled         bool   `yaml:"enabled"`
+	IntervalSeconds int    `yaml:"interval_seconds"`
+	TimeoutSeconds  int    `yaml:"timeout_seconds"`
+	Path            string `yaml:"path,omitempty"`
+}
+
+func (h *HealthCheck) GetInterval() time.Duration {
+	if h.IntervalSeconds <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(h.IntervalSeconds) * time.Second
+}
+
+func (h *HealthCheck) GetTimeout() time.Duration {
+	if h.TimeoutSeconds <= 0 {
+		return 5 * time.Second
+	}
+	return time.Duration(h.TimeoutSeconds) * time.Second
+}
+
+type CORS struct {
+	AllowedOrigins   []string `yaml:"allowed_origins,omitempty"`
+	AllowedMethods   []string `yaml:"allowed_methods,omitempty"`
+	AllowedHeaders   []string `yaml:"allowed_headers,omitempty"`
+	AllowCredentials bool     `yaml:"allow_credentials"`
+	MaxAgeSeconds    int      `yaml:"max_age_seconds"`
+}
+
+func (c *CORS) IsEnabled() bool {
+	return len(c.AllowedOrigins) > 0
+}
+
+func (c *CORS) GetAllowedMethods() []string {
+	if len(c.AllowedMethods) == 0 {
+		return []string{"GET", "POST", "OPTIONS"}
+	}
+	return c.AllowedMethods
+}
+
+func (c *CORS) GetAllowedHeaders() []string {
+	if len(c.AllowedHeaders) == 0 {
+		return []string{"Authorization", "Content-Type"}
+	}
+	return c.AllowedHeaders
+}
+
+// AllowsOrigin 判断来源是否在白名单中，支持精确匹配与 *
+func (c *CORS) AllowsOrigin(origin string) bool {
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// UpstreamHeaders 控制哪些客户端请求头转发给后端。Deny 中的头总是被移除；
+// Allow 非空时只转发其中列出的头（以及 Content-Type、Accept、Authorization）。
+// 名称不区分大小写，以 * 结尾表示前缀匹配；逐跳头始终不转发
+type UpstreamHeaders struct {
+	Allow []string `yaml:"allow,omitempty"`
+	Deny  []string `yaml:"deny,omitempty"`
+}
+
+type Timeout struct {
+	TotalSeconds       int `yaml:"total_seconds"`
+	MinOverrideSeconds int `yaml:"min_override_seconds"`
+	MaxOverrideSeconds int `yaml:"max_override_seconds"`
+	MinAttemptMs       int `yaml:"min_attempt_ms"`
+}
+
+func (t *Timeout) GetTotalTimeout() time.Duration {
+	if t.TotalSeconds <= 0 {
+		return 5 * time.Minute
+	}
+	return time.Duration(t.TotalSeconds) * time.Second
+}
+
+func (t *Timeout) GetMinOverride() time.Duration {
+	if t.MinOverrideSeconds <= 0 {
+		return time.Second
+	}
+	return time.Duration(t.MinOverrideSeconds) * time.Second
+}
+
+func (t *Timeout) GetMaxOverride() time.Duration {
+	if t.MaxOverrideSeconds <= 0 {
+		return 30 * time.Minute
+	}
+	return time.Duration(t.MaxOverrideSeconds) * time.Second
+}
+
+// GetMinAttempt 返回发起回退尝试所需的最少剩余时间，剩余总超时不足时不再尝试下一个后端，
+// 未配置时为 500 毫秒
+func (t *Timeout) GetMinAttempt() time.Duration {
+	if t.MinAttemptMs <= 0 {
+		return 500 * time.Millisecond
+	}
+	return time.Duration(t.MinAttemptMs) * time.Millisecond
+}
+
+// Shutdown 控制优雅关闭：收到信号后 /readyz 先返回 503 并等待 ready_delay_seconds，
+// 再停止接收新请求，最多等待 drain_timeout_seconds 让处理中的请求（含流式响应）完成
+type Shutdown struct {
+	DrainTimeoutSeconds int `yaml:"drain_timeout_seconds"`
+	ReadyDelaySeconds   int `yaml:"ready_delay_seconds"`
+}
+
+func (s *Shutdown) GetDrainTimeout() time.Duration {
+	if s.DrainTimeoutSeconds <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(s.DrainTimeoutSeconds) * time.Second
+}
+
+func (s *Shutdown) GetReadyDelay() time.Duration {
+	if s.ReadyDelaySeconds <= 0 {
+		return 0
+	}
+	return time.Duration(s.ReadyDelaySeconds) * time.Second
+}
+
+// RequestTimeout 根据 X-LLM-Proxy-Timeout 请求头（秒）计算单次请求的总超时：
+// 无效值忽略并使用全局配置，超出范围时限制在 min/max 之间
+func (t *Timeout) RequestTimeout(header string) time.Duration {
+	seconds, err := strconv.Atoi(strings.TrimSpace(header))
+	if err != nil || seconds <= 0 {
+		return t.GetTotalTimeout()
+	}
+	d := time.Duration(seconds) * time.Second
+	if min := t.GetMinOverride(); d < min {
+		return min
+	}
+	if max := t.GetMaxOverride(); d > max {
+		return max
+	}
+	return d
+}
+
+type Metrics struct {
+	PushGateway string `yaml:"push_gateway,omitempty"`
+	PushJob     string `yaml:"push_job,omitempty"`
+}
+
+func (m *Metrics) GetPushJob() string {
+	if m.PushJob == "" {
+		return "llm-proxy"
+	}
+	return m.PushJob
+}
+
+// Price 是每 1K token 的输入/输出价格（美元）
+type Price struct {
+	InputPer1K  float64 `yaml:"input_per_1k"`
+	OutputPer1K float64 `yaml:"output_per_1k"`
+}
+
+// Pricing 按模型别名或后端配置价格，用于估算请求成本
+type Pricing struct {
+	Models   map[string]Price `yaml:"models,omitempty"`
+	Backends map[string]Price `yaml:"backends,omitempty"`
+}
+
+// PriceFor 返回请求适用的价格，模型别名的价格优先于后端价格
+func (p *Pricing) PriceFor(modelAlias, backend string) (Price, bool) {
+	if price, ok := p.Models[modelAlias]; ok {
+		return price, true
+	}
+	price, ok := p.Backends[backend]
+	return price, ok
+}
+
 type Config struct {
-	Listen      string                 `yaml:"listen"`
-	ProxyAPIKey string                 `yaml:"proxy_api_key"`
-	Backends    []Backend              `yaml:"backends"`
-	Models      map[string]*ModelAlias `yaml:"models"`
-	Fallback    Fallback               `yaml:"fallback"`
-	Detection   Detection              `yaml:"detection"`
-	Logging     Logging                `yaml:"logging"`
+	Listen         string                 `yaml:"listen"`
+	ProxyAPIKey    string                 `yaml:"proxy_api_key"`
+	ProxyAPIKeys   []ProxyKey             `yaml:"proxy_api_keys,omitempty"`
+	AdminAPIKey    string                 `yaml:"admin_api_key,omitempty"`
+	MaxBodyBytes   int64                  `yaml:"max_request_body_bytes"`
+	MaxJSONDepth   int                    `yaml:"max_json_depth"`
+	MaxMessages    int                    `yaml:"max_messages"`
+	DefaultModel   string                 `yaml:"default_model,omitempty"`
+	Backends       []Backend              `yaml:"backends"`
+	Models         map[string]*ModelAlias `yaml:"models"`
+	Fallback       Fallback               `yaml:"fallback"`
+	CircuitBreaker CircuitBreakerConfig   `yaml:"circuit_breaker"`
+	LoadBalance    LoadBalance            `yaml:"load_balance"`
+	RateLimit      RateLimitConfig        `yaml:"rate_limit"`
+	Concurrency    ConcurrencyConfig      `yaml:"concurrency"`
+	ConnectionPool ConnectionPool         `yaml:"connection_pool"`
+	Detection      Detection              `yaml:"detection"`
+	Logging        Logging                `yaml:"logging"`
+	Metrics        Metrics                `yaml:"metrics"`
+	Embeddings     Embeddings             `yaml:"embeddings"`
+	Streaming      Streaming              `yaml:"streaming"`
+	Timeout        Timeout                `yaml:"timeout"`
+	HealthCheck    HealthCheck            `yaml:"health_check"`
+	CORS           CORS                   `yaml:"cors"`
+	Pricing        Pricing                `yaml:"pricing"`
+	Shutdown       Shutdown               `yaml:"shutdown"`
+	Headers        UpstreamHeaders        `yaml:"upstream_headers"`
+}
+
+// ProxyKey 是分配给单个团队的代理 API Key，AllowedModels 为空时可访问所有模型别名
+type ProxyKey struct {
+	Name          string   `yaml:"name,omitempty"`
+	Key           string   `yaml:"key"`
+	AllowedModels []string `yaml:"allowed_models,omitempty"`
+}
+
+func (k *ProxyKey) AllowsModel(alias string) bool {
+	return len(k.AllowedModels) == 0 || slices.Contains(k.AllowedModels, alias)
+}
+
+// AuthEnabled 在配置了 proxy_api_key 或 proxy_api_keys 任一项时返回 true
+func (c *Config) AuthEnabled() bool {
+	return c.ProxyAPIKey != "" || len(c.ProxyAPIKeys) > 0
+}
+
+// LookupProxyKey 查找与 key 匹配的代理 API Key；proxy_api_key 视为不限模型的 Key
+func (c *Config) LookupProxyKey(key string) (*ProxyKey, bool) {
+	if key == "" {
+		return nil, false
+	}
+	if c.ProxyAPIKey != "" && key == c.ProxyAPIKey {
+		return &ProxyKey{Key: c.ProxyAPIKey}, true
+	}
+	for i := range c.ProxyAPIKeys {
+		if c.ProxyAPIKeys[i].Key == key {
+			return &c.ProxyAPIKeys[i], true
+		}
+	}
+	return nil, false
+}
+
+// GetMaxBodyBytes 返回请求体大小上限，未配置时为 32 MiB
+func (c *Config) GetMaxBodyBytes() int64 {
+	if c.MaxBodyBytes <= 0 {
+		return 32 << 20
+	}
+	return c.MaxBodyBytes
+}
+
+// GetMaxJSONDepth 返回请求体 JSON 的最大嵌套深度，未配置时为 64
+func (c *Config) GetMaxJSONDepth() int {
+	if c.MaxJSONDepth <= 0 {
+		return 64
+	}
+	return c.MaxJSONDepth
+}
+
+// modelPatternPrefix 标记正则别名，如 "re:^ft:gpt-4o:.+$"；不带前缀但含 * 的别名按通配符匹配
+const modelPatternPrefix = "re:"
+
+func isModelPattern(alias string) bool {
+	return strings.HasPrefix(alias, modelPatternPrefix) || strings.Contains(alias, "*")
+}
+
+var modelPatternCache sync.Map
+
+// compileModelPattern 将模式别名编译为完整匹配的正则，通配符 * 匹配任意字符（含 / 与 :）
+func compileModelPattern(alias string) (*regexp.Regexp, error) {
+	if re, ok := modelPatternCache.Load(alias); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	var expr string
+	if rest, ok := strings.CutPrefix(alias, modelPatternPrefix); ok {
+		expr = "^(?:" + rest + ")$"
+	} else {
+		parts := strings.Split(alias, "*")
+		for i := range parts {
+			parts[i] = regexp.QuoteMeta(parts[i])
+		}
+		expr = "^" + strings.Join(parts, ".*") + "$"
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	modelPatternCache.Store(alias, re)
+	return re, nil
+}
+
+// MatchModelPattern 在通配符与正则别名中查找匹配请求模型名的配置，返回命中的别名；
+// 多个模式同时匹配时取最长的模式，长度相同按字典序
+func (c *Config) MatchModelPattern(model string) (string, *ModelAlias, bool) {
+	var best string
+	for alias := range c.Models {
+		if !isModelPattern(alias) {
+			continue
+		}
+		if best != "" && (len(alias) < len(best) || len(alias) == len(best) && alias > best) {
+			continue
+		}
+		if re, err := compileModelPattern(alias); err == nil && re.MatchString(model) {
+			best = alias
+		}
+	}
+	if best == "" {
+		return "", nil, false
+	}
+	return best, c.Models[best], true
+}
+
+// LookupModel 查找请求模型名对应的别名配置，精确匹配优先于模式匹配
+func (c *Config) LookupModel(model string) (*ModelAlias, bool) {
+	if m, exists := c.Models[model]; exists {
+		return m, true
+	}
+	_, m, exists := c.MatchModelPattern(model)
+	return m, exists
+}
+
+// ConfiguredAlias 返回请求模型名命中的配置别名：精确匹配时为模型名本身，模式匹配时为模式别名。
+// 指标按它打标签，客户端无法通过任意模型名制造新的时间序列
+func (c *Config) ConfiguredAlias(model string) string {
+	if _, exists := c.Models[model]; exists {
+		return model
+	}
+	if pattern, _, exists := c.MatchModelPattern(model); exists {
+		return pattern
+	}
+	return model
+}
+
+// MaxAttempts 返回请求模型别名时最多尝试的后端数，别名的 max_attempts 优先于 fallback.max_retries
+func (c *Config) MaxAttempts(alias string, routes int, header string) int {
+	f := c.Fallback
+	if m, ok := c.LookupModel(alias); ok && m != nil && m.MaxAttempts > 0 {
+		f.MaxRetries = m.MaxAttempts
+	}
+	return f.MaxAttempts(routes, header)
+}
+
+// DefaultStream 在请求体未指定 stream 时决定是否流式：别名未配置 default_stream 时保持非流式；
+// 配置后先看 Accept 头（text/event-stream 或 application/json），没有提示时使用别名默认值
+func (c *Config) DefaultStream(alias, accept string) bool {
+	m, exists := c.LookupModel(alias)
+	if !exists || m == nil || m.DefaultStream == nil {
+		return false
+	}
+	switch {
+	case strings.Contains(accept, "text/event-stream"):
+		return true
+	case strings.Contains(accept, "application/json"):
+		return false
+	}
+	return *m.DefaultStream
+}
+
+// KeepChoice 返回别名配置的保留 choice 下标，未配置时返回 false
+func (c *Config) KeepChoice(alias string) (int, bool) {
+	m, exists := c.LookupModel(alias)
+	if !exists || m == nil || m.KeepChoice == nil {
+		return 0, false
+	}
+	return *m.KeepChoice, true
 }
 
 type ConfigManager struct {
@@ -95,16 +983,111 @@ func (cm *ConfigManager) load() error {
 	if err != nil {
 		return err
 	}
-	var cfg Config
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
+	cfg, err := parseConfigFile(cm.configPath, data)
+	if err != nil {
 		return err
 	}
 	stat, _ := os.Stat(cm.configPath)
-	cm.config = &cfg
+	cm.config = cfg
 	cm.lastMod = stat.ModTime()
 	return nil
 }
 
+// parseConfigFile 按扩展名识别配置格式：.json 与 .toml 先用各自的解析器读出，
+// 再转成 YAML 与 YAML 配置走同一解析流程（字段名、环境变量替换与校验都相同）；其他扩展名按 YAML 解析
+func parseConfigFile(path string, data []byte) (*Config, error) {
+	var doc interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("解析 JSON 配置失败: %w", err)
+		}
+	case ".toml":
+		var table map[string]interface{}
+		if err := toml.Unmarshal(data, &table); err != nil {
+			return nil, fmt.Errorf("解析 TOML 配置失败: %w", err)
+		}
+		doc = table
+	default:
+		return parseConfig(data)
+	}
+	converted, err := yaml.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	return parseConfig(converted)
+}
+
+// parseConfig 解析 YAML 配置，并在解码前替换字符串值中的环境变量引用
+func parseConfig(data []byte) (*Config, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	if err := expandEnvNode(&root); err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if len(root.Content) == 0 {
+		return &cfg, nil
+	}
+	if err := root.Decode(&cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("配置校验失败:\n%w", err)
+	}
+	return &cfg, nil
+}
+
+var envRefPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// expandEnvNode 替换所有标量值（不含映射键）中的 ${VAR} 与 ${VAR:-default}：
+// 变量未设置或为空时使用默认值，未设置且没有默认值时报错
+func expandEnvNode(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		expanded, err := expandEnv(node.Value)
+		if err != nil {
+			return fmt.Errorf("第 %d 行: %w", node.Line, err)
+		}
+		if expanded != node.Value && node.Style == 0 {
+			// 未加引号的值按替换后的内容重新推断类型（如 rps: ${RPS}）
+			node.Tag = ""
+		}
+		node.Value = expanded
+		return nil
+	}
+	for i, child := range node.Content {
+		if node.Kind == yaml.MappingNode && i%2 == 0 {
+			continue
+		}
+		if err := expandEnvNode(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func expandEnv(value string) (string, error) {
+	var missing string
+	expanded := envRefPattern.ReplaceAllStringFunc(value, func(ref string) string {
+		m := envRefPattern.FindStringSubmatch(ref)
+		name, hasDefault, def := m[1], m[2] != "", m[3]
+		v, ok := os.LookupEnv(name)
+		if hasDefault && v == "" {
+			return def
+		}
+		if !ok && missing == "" {
+			missing = name
+		}
+		return v
+	})
+	if missing != "" {
+		return "", fmt.Errorf("环境变量 %s 未设置且没有默认值", missing)
+	}
+	return expanded, nil
+}
+
 func (cm *ConfigManager) Get() *Config {
 	cm.mu.RLock()
 	stat, err := os.Stat(cm.configPath)
@@ -123,31 +1106,44 @@ func (cm *ConfigManager) Get() *Config {
 	}
 	if err := cm.tryReload(); err != nil {
 		LogGeneral("WARN", "配置重载失败: %v，继续使用旧配置", err)
+		// 记录这次修改时间，文件再次变化前不重复解析同一份无效配置
+		cm.lastMod = stat.ModTime()
 	}
 	return cm.config
 }
 
+// Reload 强制重新加载配置（如收到 SIGHUP），使环境变量的变化在配置文件未修改时也能生效
+func (cm *ConfigManager) Reload() error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	return cm.tryReload()
+}
+
 func (cm *ConfigManager) tryReload() error {
 	data, err := os.ReadFile(cm.configPath)
 	if err != nil {
 		return err
 	}
-	var cfg Config
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
+	cfg, err := parseConfigFile(cm.configPath, data)
+	if err != nil {
 		return err
 	}
 	stat, _ := os.Stat(cm.configPath)
-	cm.config = &cfg
+	cm.config = cfg
 	cm.lastMod = stat.ModTime()
 	LogGeneral("INFO", "配置重载成功")
 	return nil
 }
 
 func (cm *ConfigManager) GetBackend(name string) *Backend {
-	cfg := cm.Get()
-	for i := range cfg.Backends {
-		if cfg.Backends[i].Name == name {
-			return &cfg.Backends[i]
+	return cm.Get().GetBackend(name)
+}
+
+// GetBackend 按名称查找后端，不存在时返回 nil
+func (c *Config) GetBackend(name string) *Backend {
+	for i := range c.Backends {
+		if c.Backends[i].Name == name {
+			return &c.Backends[i]
 		}
 	}
 	return nil
@@ -1,7 +1,16 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestBackend_IsEnabled(t *testing.T) {
@@ -98,3 +107,480 @@ func TestConfigManager_GetBackend(t *testing.T) {
 		}
 	}
 }
+
+func TestConfig_DefaultStream(t *testing.T) {
+	cfg := &Config{
+		Models: map[string]*ModelAlias{
+			"stream-default": {DefaultStream: boolPtr(true)},
+			"json-default":   {DefaultStream: boolPtr(false)},
+			"unset":          {},
+		},
+	}
+
+	tests := []struct {
+		alias  string
+		accept string
+		want   bool
+	}{
+		{"stream-default", "", true},
+		{"stream-default", "*/*", true},
+		{"stream-default", "application/json", false},
+		{"json-default", "", false},
+		{"json-default", "text/event-stream", true},
+		{"unset", "", false},
+		{"unset", "text/event-stream", false},
+		{"missing", "", false},
+	}
+
+	for _, tt := range tests {
+		if got := cfg.DefaultStream(tt.alias, tt.accept); got != tt.want {
+			t.Errorf("DefaultStream(%q, %q) = %v, want %v", tt.alias, tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestTimeout_RequestTimeout(t *testing.T) {
+	cfg := &Timeout{TotalSeconds: 60, MinOverrideSeconds: 5, MaxOverrideSeconds: 1200}
+
+	tests := []struct {
+		name   string
+		header string
+		want   time.Duration
+	}{
+		{"absent header uses config", "", 60 * time.Second},
+		{"valid override", "900", 900 * time.Second},
+		{"above max clamped", "3600", 1200 * time.Second},
+		{"below min clamped", "1", 5 * time.Second},
+		{"invalid value ignored", "abc", 60 * time.Second},
+		{"non-positive ignored", "-10", 60 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := cfg.RequestTimeout(tt.header); got != tt.want {
+			t.Errorf("%s: RequestTimeout(%q) = %v, want %v", tt.name, tt.header, got, tt.want)
+		}
+	}
+
+	if got := (&Timeout{}).RequestTimeout(""); got != 5*time.Minute {
+		t.Errorf("default RequestTimeout = %v, want 5m", got)
+	}
+}
+
+func TestFallback_MaxAttempts(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    Fallback
+		routes int
+		header string
+		want   int
+	}{
+		{"config default", Fallback{MaxRetries: 3}, 5, "", 3},
+		{"unlimited uses route count", Fallback{}, 5, "", 5},
+		{"header ignored without gate", Fallback{MaxRetries: 3}, 5, "1", 3},
+		{"header lowers attempts", Fallback{MaxRetries: 3, ClientMaxAttempts: true}, 5, "2", 2},
+		{"header clamped to config", Fallback{MaxRetries: 3, ClientMaxAttempts: true}, 5, "9", 3},
+		{"header clamped to route count", Fallback{ClientMaxAttempts: true}, 2, "9", 2},
+		{"invalid header ignored", Fallback{MaxRetries: 3, ClientMaxAttempts: true}, 5, "x", 3},
+		{"non-positive header ignored", Fallback{MaxRetries: 3, ClientMaxAttempts: true}, 5, "0", 3},
+	}
+
+	for _, tt := range tests {
+		if got := tt.cfg.MaxAttempts(tt.routes, tt.header); got != tt.want {
+			t.Errorf("%s: MaxAttempts(%d, %q) = %d, want %d", tt.name, tt.routes, tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestConfig_MaxAttempts(t *testing.T) {
+	cfg := &Config{
+		Fallback: Fallback{MaxRetries: 4, ClientMaxAttempts: true},
+		Models: map[string]*ModelAlias{
+			"capped":    {MaxAttempts: 2},
+			"inherits":  {},
+			"ft:gpt-*":  {MaxAttempts: 1},
+			"unlimited": {},
+		},
+	}
+	tests := []struct {
+		name   string
+		alias  string
+		routes int
+		header string
+		want   int
+	}{
+		{"alias cap below route count", "capped", 8, "", 2},
+		{"header still lowers alias cap", "capped", 8, "1", 1},
+		{"header cannot exceed alias cap", "capped", 8, "5", 2},
+		{"alias without cap uses fallback", "inherits", 8, "", 4},
+		{"pattern alias cap", "ft:gpt-acme", 8, "", 1},
+		{"unknown alias uses fallback", "missing", 8, "", 4},
+	}
+	for _, tt := range tests {
+		if got := cfg.MaxAttempts(tt.alias, tt.routes, tt.header); got != tt.want {
+			t.Errorf("%s: MaxAttempts(%q, %d, %q) = %d, want %d", tt.name, tt.alias, tt.routes, tt.header, got, tt.want)
+		}
+	}
+
+	unlimited := &Config{Models: map[string]*ModelAlias{"m": {}}}
+	if got := unlimited.MaxAttempts("m", 8, ""); got != 8 {
+		t.Errorf("without any cap MaxAttempts = %d, want every route (8)", got)
+	}
+}
+
+func TestParseConfig_EnvSubstitution(t *testing.T) {
+	t.Setenv("LLM_PROXY_TEST_KEY", "sk-from-env")
+	t.Setenv("LLM_PROXY_TEST_RPS", "7")
+	t.Setenv("LLM_PROXY_TEST_EMPTY", "")
+
+	tests := []struct {
+		name    string
+		yaml    string
+		check   func(*Config) bool
+		wantErr string
+	}{
+		{
+			name:  "present var",
+			yaml:  "proxy_api_key: ${LLM_PROXY_TEST_KEY}\n",
+			check: func(c *Config) bool { return c.ProxyAPIKey == "sk-from-env" },
+		},
+		{
+			name:  "embedded in string",
+			yaml:  "backends:\n  - name: b1\n    url: \"https://${LLM_PROXY_TEST_MISSING:-api.example.com}/v1\"\n",
+			check: func(c *Config) bool { return c.Backends[0].URL == "https://api.example.com/v1" },
+		},
+		{
+			name:  "missing var with default",
+			yaml:  "proxy_api_key: ${LLM_PROXY_TEST_MISSING:-fallback}\n",
+			check: func(c *Config) bool { return c.ProxyAPIKey == "fallback" },
+		},
+		{
+			name:  "empty var with default",
+			yaml:  "proxy_api_key: ${LLM_PROXY_TEST_EMPTY:-fallback}\n",
+			check: func(c *Config) bool { return c.ProxyAPIKey == "fallback" },
+		},
+		{
+			name:  "numeric field",
+			yaml:  "rate_limit:\n  rps: ${LLM_PROXY_TEST_RPS}\n",
+			check: func(c *Config) bool { return c.RateLimit.RPS == 7 },
+		},
+		{
+			name:    "missing var without default",
+			yaml:    "backends:\n  - name: b1\n    api_key: ${LLM_PROXY_TEST_MISSING}\n",
+			wantErr: "LLM_PROXY_TEST_MISSING",
+		},
+	}
+
+	for _, tt := range tests {
+		cfg, err := parseConfig([]byte(tt.yaml))
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: error = %v, want mention of %s", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		if !tt.check(cfg) {
+			t.Errorf("%s: substitution not applied: %+v", tt.name, cfg)
+		}
+	}
+}
+
+func TestConfigManager_Get_AppliesBackendAndFallbackChanges(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"choices":[]}`))
+	}))
+	defer healthy.Close()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	before := "backends:\n  - name: b1\n    url: " + failing.URL + "\n" +
+		"models:\n  model-a:\n    routes:\n      - {backend: b1, model: m1, priority: 1}\n"
+	if err := os.WriteFile(path, []byte(before), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cm, err := NewConfigManager(path)
+	if err != nil {
+		t.Fatalf("NewConfigManager: %v", err)
+	}
+	cd := NewCooldownManager()
+	proxy := NewProxy(cm, NewRouter(cm, cd), cd, NewDetector(cm))
+
+	send := func(model string) int {
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"`+model+`"}`)))
+		return w.Code
+	}
+	if code := send("model-b"); code != http.StatusBadRequest {
+		t.Fatalf("model-b before reload = %d, want 400", code)
+	}
+
+	after := "backends:\n  - name: b1\n    url: " + failing.URL + "\n  - name: b2\n    url: " + healthy.URL + "\n" +
+		"models:\n  model-a:\n    routes:\n      - {backend: b1, model: m1, priority: 1}\n" +
+		"  model-b:\n    routes:\n      - {backend: b2, model: m2, priority: 1}\n" +
+		"fallback:\n  alias_fallback:\n    model-a: [model-b]\n" +
+		"detection:\n  error_codes: [\"5xx\"]\n"
+	if err := os.WriteFile(path, []byte(after), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// 确保修改时间变化，触发 Get 中的重载
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := send("model-b"); code != http.StatusOK {
+		t.Errorf("new backend after reload = %d, want 200", code)
+	}
+	if code := send("model-a"); code != http.StatusOK {
+		t.Errorf("new alias fallback after reload = %d, want 200", code)
+	}
+}
+
+func TestNewConfigManager_FormatsByExtension(t *testing.T) {
+	yamlConfig := `listen: ":8080"
+backends:
+  - name: b1
+    url: "http://b1"
+    api_keys: ["k1", "k2"]
+models:
+  model-a:
+    routes:
+      - {backend: b1, model: m1, priority: 1, weight: 2}
+fallback:
+  cooldown_seconds: 60
+  alias_fallback:
+    model-a: [model-a]
+`
+	jsonConfig := `{
+  "listen": ":8080",
+  "backends": [{"name": "b1", "url": "http://b1", "api_keys": ["k1", "k2"]}],
+  "models": {"model-a": {"routes": [{"backend": "b1", "model": "m1", "priority": 1, "weight": 2}]}},
+  "fallback": {"cooldown_seconds": 60, "alias_fallback": {"model-a": ["model-a"]}}
+}`
+	tomlConfig := `listen = ":8080"
+
+[[backends]]
+name = "b1"
+url = "http://b1"
+api_keys = ["k1", "k2"]
+
+[models.model-a]
+routes = [{backend = "b1", model = "m1", priority = 1, weight = 2}]
+
+[fallback]
+cooldown_seconds = 60
+
+[fallback.alias_fallback]
+model-a = ["model-a"]
+`
+	var tabbed bytes.Buffer
+	if err := json.Indent(&tabbed, []byte(jsonConfig), "", "\t"); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	load := func(name, content string) *ConfigManager {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		cm, err := NewConfigManager(path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		return cm
+	}
+
+	want := load("config.yaml", yamlConfig).Get()
+	for name, content := range map[string]string{
+		"config.yml":     yamlConfig,
+		"config.json":    jsonConfig,
+		"tabbed.json":    tabbed.String(),
+		"config.toml":    tomlConfig,
+		"uppercase.TOML": tomlConfig,
+	} {
+		if got := load(name, content).Get(); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s parsed to %+v, want %+v", name, got, want)
+		}
+	}
+
+	for name, wantErr := range map[string]string{
+		"bad.json": "解析 JSON 配置失败",
+		"bad.toml": "解析 TOML 配置失败",
+	} {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte("listen: \":8080\"\n"), 0o644)
+		if _, err := NewConfigManager(path); err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Errorf("%s: error = %v, want %q", name, err, wantErr)
+		}
+	}
+
+	cm := load("reload.json", jsonConfig)
+	path := filepath.Join(dir, "reload.json")
+	os.WriteFile(path, []byte(strings.Replace(jsonConfig, `"cooldown_seconds": 60`, `"cooldown_seconds": 90`, 1)), 0o644)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(path, future, future)
+	if got := cm.Get().Fallback.CooldownSeconds; got != 90 {
+		t.Errorf("JSON reload cooldown_seconds = %d, want 90", got)
+	}
+}
+
+func TestParseConfig_AliasFallbackTargets(t *testing.T) {
+	cfg, err := parseConfig([]byte("models:\n  main: {}\n  plain: {}\n  weighted: {}\nfallback:\n  alias_fallback:\n    main:\n      - plain\n      - alias: weighted\n        weight: 3\n        priority: 1\n"))
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+	want := []FallbackTarget{{Alias: "plain"}, {Alias: "weighted", Weight: 3, Priority: 1}}
+	if got := cfg.Fallback.AliasFallback["main"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("alias_fallback = %+v, want %+v", got, want)
+	}
+}
+
+func TestParseConfig_InvalidModelPattern(t *testing.T) {
+	_, err := parseConfig([]byte("models:\n  \"re:ft:(gpt\":\n    routes: []\n"))
+	if err == nil || !strings.Contains(err.Error(), "re:ft:(gpt") {
+		t.Errorf("error = %v, want invalid pattern error", err)
+	}
+}
+
+func TestConfigManager_Reload_PicksUpEnvChange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("proxy_api_key: ${LLM_PROXY_TEST_KEY}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("LLM_PROXY_TEST_KEY", "sk-old")
+	cm, err := NewConfigManager(path)
+	if err != nil {
+		t.Fatalf("NewConfigManager: %v", err)
+	}
+
+	os.Setenv("LLM_PROXY_TEST_KEY", "sk-new")
+	if got := cm.Get().ProxyAPIKey; got != "sk-old" {
+		t.Fatalf("before reload ProxyAPIKey = %q, want sk-old", got)
+	}
+	if err := cm.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if got := cm.Get().ProxyAPIKey; got != "sk-new" {
+		t.Errorf("after reload ProxyAPIKey = %q, want sk-new", got)
+	}
+
+	os.Unsetenv("LLM_PROXY_TEST_KEY")
+	if err := cm.Reload(); err == nil {
+		t.Error("reload with a missing variable should fail")
+	}
+	if got := cm.Get().ProxyAPIKey; got != "sk-new" {
+		t.Errorf("failed reload should keep the previous config, got %q", got)
+	}
+}
+
+func TestModelRoute_GetWeight(t *testing.T) {
+	cfg, err := parseConfig([]byte(`
+backends:
+  - {name: "a", url: "http://a"}
+  - {name: "b", url: "http://b"}
+  - {name: "c", url: "http://c"}
+models:
+  "model-a":
+    routes:
+      - backend: "a"
+        priority: 1
+        weight: 70
+      - backend: "b"
+        priority: 1
+        weight: 30
+      - backend: "c"
+        priority: 1
+`))
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+
+	routes := cfg.Models["model-a"].Routes
+	want := []float64{70, 30, 1}
+	for i, route := range routes {
+		if got := route.GetWeight(); got != want[i] {
+			t.Errorf("route %s weight = %v, want %v", route.Backend, got, want[i])
+		}
+	}
+	if got := (&ModelRoute{Weight: -5}).GetWeight(); got != 1 {
+		t.Errorf("negative weight should default to 1, got %v", got)
+	}
+}
+
+func TestConfig_GetMaxBodyBytes(t *testing.T) {
+	if got := (&Config{}).GetMaxBodyBytes(); got != 32<<20 {
+		t.Errorf("default = %d, want %d", got, 32<<20)
+	}
+	if got := (&Config{MaxBodyBytes: 2048}).GetMaxBodyBytes(); got != 2048 {
+		t.Errorf("configured = %d, want 2048", got)
+	}
+}
+
+func TestConfig_GetMaxJSONDepth(t *testing.T) {
+	if got := (&Config{}).GetMaxJSONDepth(); got != 64 {
+		t.Errorf("default = %d, want 64", got)
+	}
+	if got := (&Config{MaxJSONDepth: 10}).GetMaxJSONDepth(); got != 10 {
+		t.Errorf("configured = %d, want 10", got)
+	}
+}
+
+func TestFallback_ApplyRetryAfter(t *testing.T) {
+	tests := []struct {
+		name       string
+		fallback   Fallback
+		retryAfter time.Duration
+		want       time.Duration
+	}{
+		{"absent uses base", Fallback{}, 0, 30 * time.Second},
+		{"shorter than base", Fallback{}, 10 * time.Second, 30 * time.Second},
+		{"longer than base", Fallback{}, 2 * time.Minute, 2 * time.Minute},
+		{"capped at default max", Fallback{}, time.Hour, 10 * time.Minute},
+		{"capped at configured max", Fallback{MaxCooldownSeconds: 60}, 5 * time.Minute, time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.fallback.ApplyRetryAfter(30*time.Second, tt.retryAfter); got != tt.want {
+				t.Errorf("ApplyRetryAfter = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFallback_EscalatedCooldown(t *testing.T) {
+	backoff := Fallback{CooldownSeconds: 10, MaxCooldownSeconds: 60, CooldownBackoff: CooldownBackoff{Multiplier: 2}}
+	jittered := backoff
+	jittered.CooldownBackoff.Jitter = 0.5
+
+	tests := []struct {
+		name     string
+		fallback Fallback
+		failures int
+		r        float64
+		want     time.Duration
+	}{
+		{"no backoff stays fixed", Fallback{CooldownSeconds: 10}, 5, 0.5, 10 * time.Second},
+		{"first failure uses base", backoff, 1, 0.5, 10 * time.Second},
+		{"doubles", backoff, 2, 0.5, 20 * time.Second},
+		{"doubles again", backoff, 3, 0.5, 40 * time.Second},
+		{"capped", backoff, 4, 0.5, time.Minute},
+		{"stays capped", backoff, 1000, 0.5, time.Minute},
+		{"jitter down", jittered, 2, 0, 10 * time.Second},
+		{"jitter up", jittered, 2, 0.75, 25 * time.Second},
+		{"jitter never exceeds cap", jittered, 4, 0.99, time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.fallback.EscalatedCooldown(tt.failures, tt.r); got != tt.want {
+				t.Errorf("EscalatedCooldown(%d, %v) = %v, want %v", tt.failures, tt.r, got, tt.want)
+			}
+		})
+	}
+}
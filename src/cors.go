@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// withCORS 为浏览器客户端添加 CORS 支持：预检请求直接返回 204，
+// 实际请求在响应中附加 Access-Control-Allow-* 头。未配置 allowed_origins 时不做任何处理
+func withCORS(cfgMgr *ConfigManager, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cors := cfgMgr.Get().CORS
+		origin := r.Header.Get("Origin")
+		if !cors.IsEnabled() || origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		w.Header().Add("Vary", "Origin")
+		if !cors.AllowsOrigin(origin) {
+			if preflight {
+				LogGeneral("WARN", "CORS 预检被拒绝，来源: %s", origin)
+				httpError(w, "来源不被允许", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		// 允许携带凭据时不能使用 *，回显具体来源
+		if cors.AllowCredentials || !slices.Contains(cors.AllowedOrigins, "*") {
+			h.Set("Access-Control-Allow-Origin", origin)
+		} else {
+			h.Set("Access-Control-Allow-Origin", "*")
+		}
+		if cors.AllowCredentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if preflight {
+			h.Set("Access-Control-Allow-Methods", strings.Join(cors.GetAllowedMethods(), ", "))
+			h.Set("Access-Control-Allow-Headers", strings.Join(cors.GetAllowedHeaders(), ", "))
+			if cors.MaxAgeSeconds > 0 {
+				h.Set("Access-Control-Max-Age", strconv.Itoa(cors.MaxAgeSeconds))
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
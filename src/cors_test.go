@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWithCORS(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+
+	tests := []struct {
+		name        string
+		cors        CORS
+		method      string
+		origin      string
+		wantCode    int
+		wantOrigin  string
+		wantMethods string
+		wantCreds   string
+		wantBody    string
+	}{
+		{
+			name:        "preflight allowed origin",
+			cors:        CORS{AllowedOrigins: []string{"https://app.example.com"}},
+			method:      "OPTIONS",
+			origin:      "https://app.example.com",
+			wantCode:    http.StatusNoContent,
+			wantOrigin:  "https://app.example.com",
+			wantMethods: "GET, POST, OPTIONS",
+		},
+		{
+			name:     "preflight disallowed origin",
+			cors:     CORS{AllowedOrigins: []string{"https://app.example.com"}},
+			method:   "OPTIONS",
+			origin:   "https://evil.example.com",
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:       "actual request allowed origin",
+			cors:       CORS{AllowedOrigins: []string{"https://app.example.com"}},
+			method:     "POST",
+			origin:     "https://app.example.com",
+			wantCode:   http.StatusOK,
+			wantOrigin: "https://app.example.com",
+			wantBody:   "ok",
+		},
+		{
+			name:     "actual request disallowed origin",
+			cors:     CORS{AllowedOrigins: []string{"https://app.example.com"}},
+			method:   "POST",
+			origin:   "https://evil.example.com",
+			wantCode: http.StatusOK,
+			wantBody: "ok",
+		},
+		{
+			name:       "wildcard origin",
+			cors:       CORS{AllowedOrigins: []string{"*"}},
+			method:     "POST",
+			origin:     "https://any.example.com",
+			wantCode:   http.StatusOK,
+			wantOrigin: "*",
+			wantBody:   "ok",
+		},
+		{
+			name:       "wildcard with credentials echoes origin",
+			cors:       CORS{AllowedOrigins: []string{"*"}, AllowCredentials: true},
+			method:     "POST",
+			origin:     "https://any.example.com",
+			wantCode:   http.StatusOK,
+			wantOrigin: "https://any.example.com",
+			wantCreds:  "true",
+			wantBody:   "ok",
+		},
+		{
+			name:     "cors disabled",
+			method:   "OPTIONS",
+			origin:   "https://app.example.com",
+			wantCode: http.StatusOK,
+			wantBody: "ok",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := withCORS(newTestConfigManager(&Config{CORS: tt.cors}), next)
+			req := httptest.NewRequest(tt.method, "/v1/chat/completions", nil)
+			req.Header.Set("Origin", tt.origin)
+			if tt.method == "OPTIONS" {
+				req.Header.Set("Access-Control-Request-Method", "POST")
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantCode)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Methods"); got != tt.wantMethods {
+				t.Errorf("Allow-Methods = %q, want %q", got, tt.wantMethods)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Credentials"); got != tt.wantCreds {
+				t.Errorf("Allow-Credentials = %q, want %q", got, tt.wantCreds)
+			}
+			if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
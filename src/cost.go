@@ -0,0 +1,6 @@
+package main
+
+// EstimateCost 按每 1K token 价格估算请求成本（美元），输入与输出分别计价
+func EstimateCost(usage Usage, price Price) float64 {
+	return float64(usage.PromptTokens)/1000*price.InputPer1K + float64(usage.CompletionTokens)/1000*price.OutputPer1K
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEstimateCost(t *testing.T) {
+	tests := []struct {
+		name  string
+		usage Usage
+		price Price
+		want  float64
+	}{
+		{"input and output priced separately", Usage{PromptTokens: 2000, CompletionTokens: 500}, Price{InputPer1K: 0.003, OutputPer1K: 0.015}, 0.0135},
+		{"missing pricing", Usage{PromptTokens: 2000, CompletionTokens: 500}, Price{}, 0},
+		{"zero usage", Usage{}, Price{InputPer1K: 0.003, OutputPer1K: 0.015}, 0},
+		{"output only", Usage{CompletionTokens: 1000}, Price{InputPer1K: 1, OutputPer1K: 0.002}, 0.002},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := EstimateCost(tt.usage, tt.price); math.Abs(got-tt.want) > 1e-12 {
+				t.Errorf("EstimateCost = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPricing_PriceFor(t *testing.T) {
+	p := Pricing{
+		Models:   map[string]Price{"model-a": {InputPer1K: 1}},
+		Backends: map[string]Price{"b1": {InputPer1K: 2}, "b2": {InputPer1K: 3}},
+	}
+	tests := []struct {
+		model, backend string
+		want           float64
+		wantOK         bool
+	}{
+		{"model-a", "b1", 1, true},
+		{"model-b", "b2", 3, true},
+		{"model-b", "b3", 0, false},
+	}
+	for _, tt := range tests {
+		price, ok := p.PriceFor(tt.model, tt.backend)
+		if price.InputPer1K != tt.want || ok != tt.wantOK {
+			t.Errorf("PriceFor(%s, %s) = %v/%v, want %v/%v", tt.model, tt.backend, price.InputPer1K, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestProxy_AccessLogCost(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"choices":[],"usage":{"prompt_tokens":1000,"completion_tokens":2000}}`))
+	}))
+	defer backend.Close()
+
+	buf := captureAccessLog(t)
+	proxy := newTestProxy(&Config{
+		Backends: []Backend{{Name: "b1", URL: backend.URL}},
+		Models: map[string]*ModelAlias{
+			"model-a": {Routes: []ModelRoute{{Backend: "b1", Model: "m1", Priority: 1}}},
+		},
+		Logging: Logging{AccessLog: true},
+		Pricing: Pricing{Backends: map[string]Price{"b1": {InputPer1K: 0.01, OutputPer1K: 0.03}}},
+	})
+
+	req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"model-a"}`))
+	proxy.ServeHTTP(httptest.NewRecorder(), req)
+
+	var entry AccessLogEntry
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("access log line is not JSON: %v", err)
+	}
+	if math.Abs(entry.Cost-0.07) > 1e-12 {
+		t.Errorf("cost_usd = %v, want 0.07", entry.Cost)
+	}
+}
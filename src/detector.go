@@ -1,6 +1,9 @@
 package main
 
 import (
+	"fmt"
+	"math"
+	"slices"
 	"strconv"
 	"strings"
 )
@@ -13,22 +16,34 @@ func NewDetector(cfg *ConfigManager) *Detector {
 	return &Detector{configMgr: cfg}
 }
 
-func (d *Detector) ShouldFallback(statusCode int, body string) bool {
+// nonRetryableStatus 中的状态码在响应体带有 nonRetryableMarkers 标记时视为请求本身有误，
+// 换后端也会得到同样的错误。401/403（Key 相关）、429 与 5xx 不在此列，仍按配置回退
+var (
+	nonRetryableStatus  = []int{400, 404, 422}
+	nonRetryableMarkers = []string{"invalid_request_error", "validation_error", "model_not_found", "invalid_type", "missing_required_parameter"}
+)
+
+// isNonRetryable 判断失败响应是否为明确不可重试的客户端错误
+func isNonRetryable(statusCode int, body string) bool {
+	return slices.Contains(nonRetryableStatus, statusCode) && containsAny(body, nonRetryableMarkers)
+}
+
+// ShouldFallback 判断 protocol 协议后端的失败响应是否需要回退到下一个后端，规则见 Detection.ForProtocol。
+// 关键字显式匹配时总是回退；否则明确不可重试的客户端错误（见 isNonRetryable）不回退，
+// 除非开启 fallback_on_invalid_request
+func (d *Detector) ShouldFallback(protocol string, statusCode int, body string) bool {
 	cfg := d.configMgr.Get()
+	rules := cfg.Detection.ForProtocol(protocol)
 
-	for _, pattern := range cfg.Detection.ErrorCodes {
-		if d.matchStatusCode(statusCode, pattern) {
-			return true
-		}
+	if containsAny(body, rules.ErrorPatterns) {
+		return true
 	}
 
-	for _, pattern := range cfg.Detection.ErrorPatterns {
-		if strings.Contains(body, pattern) {
-			return true
-		}
+	if !cfg.Detection.FallbackOnInvalidRequest && isNonRetryable(statusCode, body) {
+		return false
 	}
 
-	return false
+	return d.matchAnyCode(statusCode, rules.ErrorCodes)
 }
 
 func (d *Detector) matchStatusCode(code int, pattern string) bool {
@@ -44,3 +59,69 @@ func (d *Detector) matchStatusCode(code int, pattern string) bool {
 	}
 	return code == exact
 }
+
+// MatchMutation 返回第一个匹配该失败响应的重试修改规则及其下标。
+// 规则同时配置状态码与关键字时两者都需满足，均未配置的规则不匹配
+func (d *Detector) MatchMutation(statusCode int, body string) (int, *RetryMutation) {
+	cfg := d.configMgr.Get()
+	for i := range cfg.Fallback.Mutations {
+		m := &cfg.Fallback.Mutations[i]
+		if len(m.ErrorCodes) == 0 && len(m.ErrorPatterns) == 0 {
+			continue
+		}
+		if len(m.ErrorCodes) > 0 && !d.matchAnyCode(statusCode, m.ErrorCodes) {
+			continue
+		}
+		if len(m.ErrorPatterns) > 0 && !containsAny(body, m.ErrorPatterns) {
+			continue
+		}
+		return i, m
+	}
+	return -1, nil
+}
+
+func (d *Detector) matchAnyCode(code int, patterns []string) bool {
+	for _, pattern := range patterns {
+		if d.matchStatusCode(code, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAny(body string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if strings.Contains(body, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyMutation 按规则修改请求体（model 字段不受影响），返回修改说明
+func applyMutation(body map[string]interface{}, m *RetryMutation) []string {
+	var notes []string
+	for _, field := range m.Drop {
+		if _, exists := body[field]; exists && field != "model" {
+			delete(body, field)
+			notes = append(notes, "删除 "+field)
+		}
+	}
+	for field, factor := range m.Scale {
+		v, ok := body[field].(float64)
+		if !ok || field == "model" {
+			continue
+		}
+		scaled := math.Max(1, math.Floor(v*factor))
+		body[field] = scaled
+		notes = append(notes, fmt.Sprintf("%s: %g -> %g", field, v, scaled))
+	}
+	for field, v := range m.Set {
+		if field == "model" {
+			continue
+		}
+		body[field] = v
+		notes = append(notes, fmt.Sprintf("%s = %v", field, v))
+	}
+	return notes
+}
@@ -39,7 +39,7 @@ func TestDetector_MatchStatusCode_Exact(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		got := d.ShouldFallback(tt.code, "")
+		got := d.ShouldFallback(ProtocolOpenAI, tt.code, "")
 		if got != tt.expected {
 			t.Errorf("ShouldFallback(%d) = %v, want %v", tt.code, got, tt.expected)
 		}
@@ -67,7 +67,7 @@ func TestDetector_MatchStatusCode_Wildcard(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		got := d.ShouldFallback(tt.code, "")
+		got := d.ShouldFallback(ProtocolOpenAI, tt.code, "")
 		if got != tt.expected {
 			t.Errorf("ShouldFallback(%d) with 4xx/5xx = %v, want %v", tt.code, got, tt.expected)
 		}
@@ -90,7 +90,7 @@ func TestDetector_MatchStatusCode_Mixed(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		got := d.ShouldFallback(tt.code, "")
+		got := d.ShouldFallback(ProtocolOpenAI, tt.code, "")
 		if got != tt.expected {
 			t.Errorf("ShouldFallback(%d) with mixed = %v, want %v", tt.code, got, tt.expected)
 		}
@@ -112,7 +112,7 @@ func TestDetector_ErrorPatterns(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		got := d.ShouldFallback(200, tt.body)
+		got := d.ShouldFallback(ProtocolOpenAI, 200, tt.body)
 		if got != tt.expected {
 			t.Errorf("ShouldFallback(200, %q) = %v, want %v", tt.body, got, tt.expected)
 		}
@@ -134,17 +134,152 @@ func TestDetector_Combined(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		got := d.ShouldFallback(tt.code, tt.body)
+		got := d.ShouldFallback(ProtocolOpenAI, tt.code, tt.body)
 		if got != tt.expected {
 			t.Errorf("ShouldFallback(%d, %q) = %v, want %v", tt.code, tt.body, got, tt.expected)
 		}
 	}
 }
 
+func TestDetector_ShouldFallback_Classification(t *testing.T) {
+	validation := `{"error":{"type":"invalid_request_error","message":"messages: field required"}}`
+	notFound := `{"error":{"code":"model_not_found","message":"The model does not exist"}}`
+
+	tests := []struct {
+		name     string
+		code     int
+		body     string
+		expected bool
+	}{
+		{"400 validation", 400, validation, false},
+		{"404 model not found", 404, notFound, false},
+		{"422 validation", 422, `{"detail":[{"type":"missing_required_parameter"}]}`, false},
+		{"400 without validation marker", 400, `{"error":"upstream hiccup"}`, true},
+		{"401 invalid key", 401, `{"error":{"type":"authentication_error"}}`, true},
+		{"403 forbidden", 403, validation, true},
+		{"429 rate limited", 429, `{"error":{"type":"rate_limit_error"}}`, true},
+		{"500 server error", 500, validation, true},
+		{"503 overloaded", 503, `{"error":{"type":"overloaded_error"}}`, true},
+	}
+
+	d := newDetectorWithConfig([]string{"4xx", "5xx"}, nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := d.ShouldFallback(ProtocolOpenAI, tt.code, tt.body); got != tt.expected {
+				t.Errorf("ShouldFallback(%d, %q) = %v, want %v", tt.code, tt.body, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestDetector_ShouldFallback_InvalidRequestOverrides(t *testing.T) {
+	body := `{"error":{"type":"invalid_request_error","message":"context window exceeded"}}`
+
+	if d := newDetectorWithConfig([]string{"400"}, []string{"context window"}); !d.ShouldFallback(ProtocolOpenAI, 400, body) {
+		t.Error("explicit error pattern should still trigger fallback")
+	}
+
+	cm := &ConfigManager{config: &Config{Detection: Detection{ErrorCodes: []string{"400"}, FallbackOnInvalidRequest: true}}}
+	if !NewDetector(cm).ShouldFallback(ProtocolOpenAI, 400, body) {
+		t.Error("fallback_on_invalid_request should restore fallback on validation errors")
+	}
+}
+
+func TestDetector_ShouldFallback_ProtocolRules(t *testing.T) {
+	cm := &ConfigManager{config: &Config{Detection: Detection{
+		ErrorCodes: []string{"5xx"},
+		Protocols: map[string]ProtocolDetection{
+			"newprovider": {ErrorPatterns: []string{"capacity_exhausted"}, ErrorCodes: []string{"499"}},
+		},
+	}}}
+	d := NewDetector(cm)
+
+	tests := []struct {
+		name     string
+		protocol string
+		code     int
+		body     string
+		expected bool
+	}{
+		{"custom substring", "newprovider", 400, `{"error":"capacity_exhausted"}`, true},
+		{"custom code", "newprovider", 499, "", true},
+		{"custom substring other protocol", ProtocolOpenAI, 400, `{"error":"capacity_exhausted"}`, false},
+		{"default anthropic overloaded", ProtocolAnthropic, 400, `{"type":"error","error":{"type":"overloaded_error"}}`, true},
+		{"default anthropic 529", ProtocolAnthropic, 529, "", true},
+		{"global codes still apply", "newprovider", 502, "", true},
+		{"unmatched 200 body", "newprovider", 200, `{"choices":[{"message":{"content":"hi"}}]}`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := d.ShouldFallback(tt.protocol, tt.code, tt.body); got != tt.expected {
+				t.Errorf("ShouldFallback(%s, %d, %q) = %v, want %v", tt.protocol, tt.code, tt.body, got, tt.expected)
+			}
+		})
+	}
+}
+
 func TestDetector_InvalidPattern(t *testing.T) {
 	d := newDetectorWithConfig([]string{"abc", "xxx", ""}, nil)
 
-	if d.ShouldFallback(500, "") {
+	if d.ShouldFallback(ProtocolOpenAI, 500, "") {
 		t.Error("Invalid patterns should not match")
 	}
 }
+
+func TestDetector_MatchMutation(t *testing.T) {
+	cfg := &Config{
+		Fallback: Fallback{
+			Mutations: []RetryMutation{
+				{},
+				{ErrorCodes: []string{"400"}, ErrorPatterns: []string{"context_length_exceeded"}, Scale: map[string]float64{"max_tokens": 0.5}},
+				{ErrorPatterns: []string{"unsupported parameter"}, Drop: []string{"logprobs"}},
+			},
+		},
+	}
+	d := NewDetector(&ConfigManager{config: cfg})
+
+	tests := []struct {
+		status int
+		body   string
+		want   int
+	}{
+		{400, `{"error":{"code":"context_length_exceeded"}}`, 1},
+		{500, `{"error":{"code":"context_length_exceeded"}}`, -1},
+		{400, `{"error":"unsupported parameter: logprobs"}`, 2},
+		{400, `{"error":"bad request"}`, -1},
+	}
+
+	for _, tt := range tests {
+		if got, _ := d.MatchMutation(tt.status, tt.body); got != tt.want {
+			t.Errorf("MatchMutation(%d, %q) = %d, want %d", tt.status, tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestApplyMutation(t *testing.T) {
+	body := map[string]interface{}{"model": "m1", "max_tokens": float64(4096), "logprobs": true}
+	m := &RetryMutation{
+		Scale: map[string]float64{"max_tokens": 0.5, "missing": 2},
+		Drop:  []string{"logprobs", "model"},
+		Set:   map[string]interface{}{"temperature": 0.1},
+	}
+
+	notes := applyMutation(body, m)
+
+	if body["max_tokens"] != float64(2048) {
+		t.Errorf("max_tokens = %v, want 2048", body["max_tokens"])
+	}
+	if _, exists := body["logprobs"]; exists {
+		t.Error("logprobs should be dropped")
+	}
+	if body["model"] != "m1" {
+		t.Error("model must never be mutated")
+	}
+	if body["temperature"] != 0.1 {
+		t.Errorf("temperature = %v, want 0.1", body["temperature"])
+	}
+	if len(notes) != 3 {
+		t.Errorf("expected 3 notes, got %v", notes)
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+func isEmbeddingsPath(path string) bool {
+	return path == "/v1/embeddings" || path == "/embeddings"
+}
+
+// singleEmbeddingInput 判断请求是否为可合并的单条 embeddings 请求
+func singleEmbeddingInput(path string, reqBody map[string]interface{}) (interface{}, bool) {
+	if !isEmbeddingsPath(path) {
+		return nil, false
+	}
+	switch input := reqBody["input"].(type) {
+	case string:
+		return input, true
+	case []interface{}:
+		if len(input) == 1 {
+			if s, ok := input[0].(string); ok {
+				return s, true
+			}
+		}
+	}
+	return nil, false
+}
+
+type embeddingResult struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+type embeddingBatch struct {
+	key        string
+	modelAlias string
+	reqBody    map[string]interface{}
+	request    *http.Request
+	inputs     []interface{}
+	waiters    []chan embeddingResult
+	timer      *time.Timer
+	dispatched bool
+}
+
+// EmbeddingBatcher 合并时间窗口内的单条 embeddings 请求。批次请求不属于任何一个调用方，
+// 其 ctx 派生自 baseCtx（服务关闭排空超时后取消），并受请求总超时限制
+type EmbeddingBatcher struct {
+	proxy   *Proxy
+	pending map[string]*embeddingBatch
+	baseCtx context.Context
+	mu      sync.Mutex
+}
+
+func NewEmbeddingBatcher(p *Proxy) *EmbeddingBatcher {
+	return &EmbeddingBatcher{proxy: p, pending: make(map[string]*embeddingBatch), baseCtx: context.Background()}
+}
+
+// SetBaseContext 设置批次请求的父 ctx，应与服务器请求的 BaseContext 相同，关闭时一并取消
+func (eb *EmbeddingBatcher) SetBaseContext(ctx context.Context) {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+	eb.baseCtx = ctx
+}
+
+// Submit 将单条 embeddings 请求放入批次，等待批次发出后写回属于自己的结果
+func (eb *EmbeddingBatcher) Submit(w http.ResponseWriter, r *http.Request, reqID, modelAlias string, reqBody map[string]interface{}, input interface{}) {
+	cfg := eb.proxy.configMgr.Get().Embeddings
+	key := embeddingBatchKey(modelAlias, reqBody, r)
+	result := make(chan embeddingResult, 1)
+
+	eb.mu.Lock()
+	batch, exists := eb.pending[key]
+	if !exists {
+		batch = &embeddingBatch{key: key, modelAlias: modelAlias, reqBody: reqBody, request: r}
+		eb.pending[key] = batch
+		batch.timer = time.AfterFunc(time.Duration(cfg.BatchWindowMs)*time.Millisecond, func() {
+			eb.flush(key, batch)
+		})
+	}
+	batch.inputs = append(batch.inputs, input)
+	batch.waiters = append(batch.waiters, result)
+	full := len(batch.inputs) >= cfg.GetMaxBatchSize()
+	if full {
+		// 已满的批次立即摘除，后续请求进入新批次
+		delete(eb.pending, key)
+	}
+	eb.mu.Unlock()
+
+	LogGeneral("DEBUG", "[%s] embeddings 请求加入批次: 模型=%s", reqID, modelAlias)
+	if full {
+		batch.timer.Stop()
+		go eb.flush(key, batch)
+	}
+
+	select {
+	case res := <-result:
+		for k, v := range res.header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(res.status)
+		w.Write(res.body)
+	case <-r.Context().Done():
+		LogGeneral("WARN", "[%s] 客户端在批次返回前断开", reqID)
+	}
+}
+
+func (eb *EmbeddingBatcher) flush(key string, batch *embeddingBatch) {
+	eb.mu.Lock()
+	if batch.dispatched {
+		eb.mu.Unlock()
+		return
+	}
+	batch.dispatched = true
+	if eb.pending[key] == batch {
+		delete(eb.pending, key)
+	}
+	baseCtx := eb.baseCtx
+	eb.mu.Unlock()
+
+	reqID := newRequestID()
+	merged := make(map[string]interface{}, len(batch.reqBody))
+	for k, v := range batch.reqBody {
+		merged[k] = v
+	}
+	merged["input"] = batch.inputs
+	body, _ := json.Marshal(merged)
+
+	cfg := eb.proxy.configMgr.Get()
+	ctx, cancel := context.WithTimeout(baseCtx, cfg.Timeout.RequestTimeout(batch.request.Header.Get(timeoutHeader)))
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, batch.request.URL.String(), bytes.NewReader(body))
+	req.Header = batch.request.Header.Clone()
+	req.RemoteAddr = batch.request.RemoteAddr
+
+	LogGeneral("INFO", "[%s] 发送 embeddings 批次: 模型=%s 条数=%d", reqID, batch.modelAlias, len(batch.inputs))
+	rec := newBufferedResponse()
+	eb.proxy.forward(rec, req, reqID, batch.modelAlias, merged, body)
+
+	results := splitEmbeddingResponse(rec, len(batch.inputs))
+	for i, waiter := range batch.waiters {
+		waiter <- results[i]
+	}
+}
+
+// splitEmbeddingResponse 按 index 将批量响应拆回各调用方；失败或无法解析时所有调用方收到同一响应
+func splitEmbeddingResponse(rec *bufferedResponse, n int) []embeddingResult {
+	results := make([]embeddingResult, n)
+	shared := embeddingResult{status: rec.status, header: rec.header, body: rec.body.Bytes()}
+	for i := range results {
+		results[i] = shared
+	}
+	if rec.status < 200 || rec.status >= 300 {
+		return results
+	}
+
+	var resp struct {
+		Object string                   `json:"object"`
+		Model  string                   `json:"model"`
+		Data   []map[string]interface{} `json:"data"`
+		Usage  map[string]float64       `json:"usage"`
+	}
+	if err := json.Unmarshal(rec.body.Bytes(), &resp); err != nil || len(resp.Data) != n {
+		return results
+	}
+
+	usage := make(map[string]interface{}, len(resp.Usage))
+	for k, v := range resp.Usage {
+		usage[k] = int64(v) / int64(n)
+	}
+
+	header := rec.header.Clone()
+	header.Del("Content-Length")
+	for pos, item := range resp.Data {
+		idx := pos
+		if v, ok := item["index"].(float64); ok {
+			idx = int(v)
+		}
+		if idx < 0 || idx >= n {
+			return results
+		}
+		item["index"] = 0
+		single := map[string]interface{}{
+			"object": resp.Object,
+			"model":  resp.Model,
+			"data":   []interface{}{item},
+		}
+		if resp.Usage != nil {
+			single["usage"] = usage
+		}
+		data, _ := json.Marshal(single)
+		results[idx] = embeddingResult{status: rec.status, header: header, body: data}
+	}
+	return results
+}
+
+func embeddingBatchKey(modelAlias string, reqBody map[string]interface{}, r *http.Request) string {
+	rest := make(map[string]interface{}, len(reqBody))
+	for k, v := range reqBody {
+		if k != "input" {
+			rest[k] = v
+		}
+	}
+	params, _ := json.Marshal(rest)
+	return modelAlias + "|" + r.URL.Path + "|" + r.Header.Get("Authorization") + "|" + string(params)
+}
+
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newBufferedResponse() *bufferedResponse {
+	return &bufferedResponse{header: make(http.Header), status: http.StatusOK}
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	b.status = status
+}
+
+func (b *bufferedResponse) Write(data []byte) (int, error) {
+	return b.body.Write(data)
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newEmbeddingBackend(calls *int32, batchSizes *[]int, mu *sync.Mutex) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(calls, 1)
+		var req struct {
+			Input []string `json:"input"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		*batchSizes = append(*batchSizes, len(req.Input))
+		mu.Unlock()
+
+		var data []map[string]interface{}
+		for i, in := range req.Input {
+			data = append(data, map[string]interface{}{
+				"object":    "embedding",
+				"index":     i,
+				"embedding": []float64{float64(len(in))},
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"object": "list",
+			"model":  "emb-real",
+			"data":   data,
+			"usage":  map[string]int{"prompt_tokens": 6 * len(req.Input), "total_tokens": 6 * len(req.Input)},
+		})
+	}))
+}
+
+func TestSingleEmbeddingInput(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		body map[string]interface{}
+		ok   bool
+	}{
+		{"string input", "/v1/embeddings", map[string]interface{}{"input": "hi"}, true},
+		{"single element array", "/v1/embeddings", map[string]interface{}{"input": []interface{}{"hi"}}, true},
+		{"multi input", "/v1/embeddings", map[string]interface{}{"input": []interface{}{"a", "b"}}, false},
+		{"token array", "/v1/embeddings", map[string]interface{}{"input": []interface{}{float64(1)}}, false},
+		{"chat path", "/v1/chat/completions", map[string]interface{}{"input": "hi"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := singleEmbeddingInput(tt.path, tt.body); ok != tt.ok {
+				t.Errorf("got %v, want %v", ok, tt.ok)
+			}
+		})
+	}
+}
+
+func TestEmbeddingBatcher_BatchesConcurrentRequests(t *testing.T) {
+	tests := []struct {
+		name      string
+		maxBatch  int
+		requests  int
+		wantSizes []int
+		wantCalls int32
+	}{
+		{"single batch within window", 10, 3, []int{3}, 1},
+		{"split by max batch size", 2, 4, []int{2, 2}, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+			var sizes []int
+			var mu sync.Mutex
+			backend := newEmbeddingBackend(&calls, &sizes, &mu)
+			defer backend.Close()
+
+			proxy := newTestProxy(&Config{
+				Backends: []Backend{{Name: "b1", URL: backend.URL}},
+				Models: map[string]*ModelAlias{
+					"emb": {Routes: []ModelRoute{{Backend: "b1", Model: "emb-real", Priority: 1}}},
+				},
+				Embeddings: Embeddings{BatchWindowMs: 200, MaxBatchSize: tt.maxBatch},
+			})
+
+			var wg sync.WaitGroup
+			for i := 0; i < tt.requests; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					input := strings.Repeat("x", i+1)
+					body := fmt.Sprintf(`{"model":"emb","input":%q}`, input)
+					req := httptest.NewRequest("POST", "/v1/embeddings", strings.NewReader(body))
+					w := httptest.NewRecorder()
+					proxy.ServeHTTP(w, req)
+
+					if w.Code != http.StatusOK {
+						t.Errorf("request %d: expected 200, got %d", i, w.Code)
+						return
+					}
+					var resp struct {
+						Data []struct {
+							Index     int       `json:"index"`
+							Embedding []float64 `json:"embedding"`
+						} `json:"data"`
+					}
+					json.Unmarshal(w.Body.Bytes(), &resp)
+					if len(resp.Data) != 1 {
+						t.Errorf("request %d: expected 1 embedding, got %d", i, len(resp.Data))
+						return
+					}
+					if resp.Data[0].Index != 0 || resp.Data[0].Embedding[0] != float64(len(input)) {
+						t.Errorf("request %d: got wrong embedding %+v", i, resp.Data[0])
+					}
+				}(i)
+			}
+			wg.Wait()
+
+			if calls != tt.wantCalls {
+				t.Errorf("expected %d backend calls, got %d", tt.wantCalls, calls)
+			}
+			if fmt.Sprint(sizes) != fmt.Sprint(tt.wantSizes) {
+				t.Errorf("batch sizes = %v, want %v", sizes, tt.wantSizes)
+			}
+		})
+	}
+}
+
+func TestEmbeddingBatcher_DisabledPassesThrough(t *testing.T) {
+	var calls int32
+	var sizes []int
+	var mu sync.Mutex
+	backend := newEmbeddingBackend(&calls, &sizes, &mu)
+	defer backend.Close()
+
+	proxy := newTestProxy(&Config{
+		Backends: []Backend{{Name: "b1", URL: backend.URL}},
+		Models: map[string]*ModelAlias{
+			"emb": {Routes: []ModelRoute{{Backend: "b1", Model: "emb-real", Priority: 1}}},
+		},
+	})
+
+	req := httptest.NewRequest("POST", "/v1/embeddings", strings.NewReader(`{"model":"emb","input":["a"]}`))
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK || calls != 1 {
+		t.Errorf("expected direct passthrough, got code=%d calls=%d", w.Code, calls)
+	}
+}
+
+func TestSplitEmbeddingResponse_ErrorSharedByAll(t *testing.T) {
+	rec := newBufferedResponse()
+	rec.WriteHeader(http.StatusBadGateway)
+	rec.Write([]byte("upstream down"))
+
+	results := splitEmbeddingResponse(rec, 2)
+	for i, res := range results {
+		if res.status != http.StatusBadGateway || string(res.body) != "upstream down" {
+			t.Errorf("result %d should carry shared error, got %d %q", i, res.status, res.body)
+		}
+	}
+}
+
+func TestProxy_EmbeddingsPassthrough(t *testing.T) {
+	var gotPath string
+	var gotBody map[string]interface{}
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"error":"overloaded"}`))
+	}))
+	defer failing.Close()
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&gotBody)
+		w.Write([]byte(`{"object":"list","data":[{"object":"embedding","index":0,"embedding":[0.1]}]}`))
+	}))
+	defer healthy.Close()
+
+	tests := []struct {
+		name     string
+		backends []Backend
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "routes to openai backend",
+			backends: []Backend{{Name: "primary", URL: healthy.URL}, {Name: "secondary", URL: failing.URL}},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "falls back on 5xx",
+			backends: []Backend{{Name: "primary", URL: failing.URL}, {Name: "secondary", URL: healthy.URL}},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "skips non-openai protocol",
+			backends: []Backend{{Name: "primary", URL: failing.URL, Protocol: ProtocolAnthropic}, {Name: "secondary", URL: healthy.URL}},
+			wantCode: http.StatusOK,
+		},
+		{
+			name: "unsupported protocol only",
+			backends: []Backend{
+				{Name: "primary", URL: failing.URL, Protocol: ProtocolAnthropic},
+				{Name: "secondary", URL: failing.URL, Protocol: ProtocolGoogle},
+			},
+			wantCode: http.StatusBadRequest,
+			wantBody: "不支持 embeddings",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotPath, gotBody = "", nil
+			proxy := newTestProxy(&Config{
+				Backends: tt.backends,
+				Models: map[string]*ModelAlias{
+					"embed": {Routes: []ModelRoute{
+						{Backend: "primary", Model: "text-embedding-3-small", Priority: 1},
+						{Backend: "secondary", Model: "text-embedding-3-small", Priority: 2},
+					}},
+				},
+				Detection: Detection{ErrorCodes: []string{"5xx"}},
+			})
+
+			req := httptest.NewRequest("POST", "/v1/embeddings", strings.NewReader(`{"model":"embed","input":["a","b"],"dimensions":256}`))
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("expected %d, got %d: %s", tt.wantCode, w.Code, w.Body.String())
+			}
+			if tt.wantBody != "" {
+				if !strings.Contains(w.Body.String(), tt.wantBody) {
+					t.Errorf("body = %q, want it to mention %q", w.Body.String(), tt.wantBody)
+				}
+				return
+			}
+			if gotPath != "/v1/embeddings" {
+				t.Errorf("upstream path = %q", gotPath)
+			}
+			if gotBody["model"] != "text-embedding-3-small" || gotBody["dimensions"] != float64(256) {
+				t.Errorf("body not preserved: %v", gotBody)
+			}
+		})
+	}
+}
+
+func TestEmbeddingBatcher_BatchRequestIsBounded(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout Timeout
+		cancel  bool
+	}{
+		{name: "total timeout", timeout: Timeout{TotalSeconds: 1}},
+		{name: "shutdown", timeout: Timeout{TotalSeconds: 60}, cancel: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			started := make(chan struct{})
+			released := make(chan struct{})
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.Copy(io.Discard, r.Body)
+				close(started)
+				<-r.Context().Done()
+				close(released)
+			}))
+			defer backend.Close()
+
+			proxy := newTestProxy(&Config{
+				Backends: []Backend{{Name: "b1", URL: backend.URL}},
+				Models: map[string]*ModelAlias{
+					"emb": {Routes: []ModelRoute{{Backend: "b1", Model: "emb-real", Priority: 1}}},
+				},
+				Embeddings: Embeddings{BatchWindowMs: 10},
+				Timeout:    tt.timeout,
+			})
+			baseCtx, cancelBase := context.WithCancel(context.Background())
+			defer cancelBase()
+			proxy.embeddings.SetBaseContext(baseCtx)
+
+			go proxy.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/v1/embeddings", strings.NewReader(`{"model":"emb","input":"a"}`)))
+			<-started
+			if tt.cancel {
+				cancelBase()
+			}
+			select {
+			case <-released:
+			case <-time.After(3 * time.Second):
+				t.Fatal("batched upstream request was not cancelled")
+			}
+		})
+	}
+}
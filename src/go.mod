@@ -3,6 +3,7 @@ module llm-proxy
 go 1.25.5
 
 require (
+	github.com/BurntSushi/toml v1.4.0
 	github.com/google/uuid v1.6.0
 	gopkg.in/yaml.v3 v3.0.1
 )
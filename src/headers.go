@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// hopByHopHeaders 只对单跳连接有意义，代理不能原样转发（RFC 9110 7.6.1）
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// essentialHeaders 在配置了 allow 时仍然转发，否则后端无法解析请求或完成透传认证；
+// W3C Trace Context 头让后端延续调用方的链路
+var essentialHeaders = []string{"Content-Type", "Accept", "Authorization", "Traceparent", "Tracestate"}
+
+// copyUpstreamHeaders 按 upstream_headers 配置把客户端请求头复制到发往后端的请求：
+// 先去掉逐跳头及 Connection 中声明的头，再应用 deny 与 allow
+func copyUpstreamHeaders(dst, src http.Header, rules *UpstreamHeaders) {
+	skip := make(map[string]bool, len(hopByHopHeaders))
+	for _, h := range hopByHopHeaders {
+		skip[h] = true
+	}
+	for _, v := range src.Values("Connection") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				skip[http.CanonicalHeaderKey(name)] = true
+			}
+		}
+	}
+
+	for k, v := range src {
+		if skip[http.CanonicalHeaderKey(k)] || !rules.Forwards(k) {
+			continue
+		}
+		dst[k] = v
+	}
+}
+
+// Forwards 判断名为 name 的客户端请求头是否允许转发，deny 优先于 allow
+func (h *UpstreamHeaders) Forwards(name string) bool {
+	if matchHeaderName(h.Deny, name) {
+		return false
+	}
+	if len(h.Allow) == 0 {
+		return true
+	}
+	return matchHeaderName(essentialHeaders, name) || matchHeaderName(h.Allow, name)
+}
+
+func matchHeaderName(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if prefix, ok := strings.CutSuffix(p, "*"); ok {
+			if len(name) >= len(prefix) && strings.EqualFold(name[:len(prefix)], prefix) {
+				return true
+			}
+		} else if strings.EqualFold(p, name) {
+			return true
+		}
+	}
+	return false
+}
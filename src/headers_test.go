@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestCopyUpstreamHeaders(t *testing.T) {
+	src := http.Header{
+		"Content-Type":      {"application/json"},
+		"Authorization":     {"Bearer client"},
+		"Cookie":            {"session=abc"},
+		"Anthropic-Beta":    {"prompt-caching-2024-07-31"},
+		"X-Internal-Team":   {"search"},
+		"X-Trace":           {"t1"},
+		"Connection":        {"keep-alive, X-Trace"},
+		"Keep-Alive":        {"timeout=5"},
+		"Transfer-Encoding": {"chunked"},
+		"Traceparent":       {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		"Tracestate":        {"vendor=opaque"},
+	}
+
+	tests := []struct {
+		name  string
+		rules UpstreamHeaders
+		want  []string
+	}{
+		{
+			name: "no rules strips only hop-by-hop",
+			want: []string{"Anthropic-Beta", "Authorization", "Content-Type", "Cookie", "Traceparent", "Tracestate", "X-Internal-Team"},
+		},
+		{
+			name:  "deny with prefix",
+			rules: UpstreamHeaders{Deny: []string{"cookie", "X-Internal-*"}},
+			want:  []string{"Anthropic-Beta", "Authorization", "Content-Type", "Traceparent", "Tracestate"},
+		},
+		{
+			name:  "allow keeps essentials and trace context",
+			rules: UpstreamHeaders{Allow: []string{"anthropic-beta"}},
+			want:  []string{"Anthropic-Beta", "Authorization", "Content-Type", "Traceparent", "Tracestate"},
+		},
+		{
+			name:  "deny wins over allow",
+			rules: UpstreamHeaders{Allow: []string{"anthropic-*"}, Deny: []string{"Anthropic-Beta", "Tracestate"}},
+			want:  []string{"Authorization", "Content-Type", "Traceparent"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := http.Header{}
+			copyUpstreamHeaders(dst, src, &tt.rules)
+			var got []string
+			for k := range dst {
+				got = append(got, k)
+			}
+			sort.Strings(got)
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("forwarded %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxy_UpstreamHeaderRules(t *testing.T) {
+	var got http.Header
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		w.Write([]byte(`{"choices":[]}`))
+	}))
+	defer backend.Close()
+
+	proxy := newTestProxy(&Config{
+		Backends: []Backend{{Name: "b1", URL: backend.URL, APIKey: "sk-backend"}},
+		Models: map[string]*ModelAlias{
+			"model-a": {Routes: []ModelRoute{{Backend: "b1", Model: "m1", Priority: 1}}},
+		},
+		Headers: UpstreamHeaders{Allow: []string{"anthropic-beta"}, Deny: []string{"Cookie"}},
+	})
+
+	req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"model-a"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Cookie", "session=abc")
+	req.Header.Set("Anthropic-Beta", "context-1m-2025-08-07")
+	req.Header.Set("X-Internal-Team", "search")
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+
+	if v := got.Get("Anthropic-Beta"); v != "context-1m-2025-08-07" {
+		t.Errorf("anthropic-beta = %q, want it forwarded", v)
+	}
+	for _, h := range []string{"Cookie", "X-Internal-Team"} {
+		if v := got.Get(h); v != "" {
+			t.Errorf("%s = %q, want it stripped", h, v)
+		}
+	}
+	if v := got.Get("Authorization"); v != "Bearer sk-backend" {
+		t.Errorf("Authorization = %q, want backend key", v)
+	}
+	if got.Get(requestIDHeader) == "" {
+		t.Error("request id should still be forwarded")
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// 详细健康状态中的整体状态
+const (
+	HealthHealthy   = "healthy"
+	HealthDegraded  = "degraded"
+	HealthUnhealthy = "unhealthy"
+)
+
+// HealthHandler 提供健康检查：基础状态无需认证（供编排系统探活），
+// 详细状态（/health/detail 或 /health?verbose=true）包含后端名称与地址，需要代理 API Key
+type HealthHandler struct {
+	configMgr *ConfigManager
+	cooldown  *CooldownManager
+	breaker   *CircuitBreaker
+	checker   *HealthChecker
+	draining  atomic.Bool
+}
+
+func NewHealthHandler(cfg *ConfigManager, cd *CooldownManager, breaker *CircuitBreaker, checker *HealthChecker) *HealthHandler {
+	return &HealthHandler{configMgr: cfg, cooldown: cd, breaker: breaker, checker: checker}
+}
+
+type backendHealth struct {
+	Name     string        `json:"name"`
+	URL      string        `json:"url"`
+	Enabled  bool          `json:"enabled"`
+	Protocol string        `json:"protocol"`
+	Usable   bool          `json:"usable"`
+	Probe    *probeHealth  `json:"probe,omitempty"`
+	Routes   []routeHealth `json:"routes,omitempty"`
+}
+
+type probeHealth struct {
+	Healthy   bool      `json:"healthy"`
+	LatencyMs int64     `json:"latency_ms"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+type routeHealth struct {
+	Model       string       `json:"model"`
+	CoolingDown bool         `json:"cooling_down"`
+	Circuit     CircuitState `json:"circuit"`
+}
+
+func (h *HealthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/livez":
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok"))
+		return
+	case "/readyz":
+		h.serveReady(w)
+		return
+	}
+
+	verbose := r.URL.Path == "/health/detail" || (r.URL.Path == "/health" && r.URL.Query().Get("verbose") == "true")
+	if !verbose {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok"))
+		return
+	}
+
+	cfg := h.configMgr.Get()
+	if !authorized(cfg, r) {
+		LogGeneral("WARN", "健康详情认证失败，客户端: %s", r.RemoteAddr)
+		httpError(w, "无效的 API Key", http.StatusUnauthorized)
+		return
+	}
+
+	backends := h.backendStatus(cfg)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":   overallHealth(backends),
+		"backends": backends,
+	})
+}
+
+// SetDraining 在开始关闭时调用，此后 /readyz 始终返回 503，负载均衡不再分配新请求
+func (h *HealthHandler) SetDraining() {
+	h.draining.Store(true)
+}
+
+// serveReady 在配置已加载且至少有一个可用后端时返回 200，否则返回 503；关闭过程中始终返回 503
+func (h *HealthHandler) serveReady(w http.ResponseWriter) {
+	if h.draining.Load() {
+		httpError(w, "正在关闭", http.StatusServiceUnavailable)
+		return
+	}
+	cfg := h.configMgr.Get()
+	if cfg == nil {
+		httpError(w, "配置未加载", http.StatusServiceUnavailable)
+		return
+	}
+	for _, b := range h.backendStatus(cfg) {
+		if b.Usable {
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("ok"))
+			return
+		}
+	}
+	httpError(w, "没有可用的后端", http.StatusServiceUnavailable)
+}
+
+// overallHealth 按可用后端数量汇总状态：已启用的后端全部可用为 healthy，
+// 全部不可用（或没有启用的后端）为 unhealthy，其余为 degraded
+func overallHealth(backends []backendHealth) string {
+	enabled, usable := 0, 0
+	for _, b := range backends {
+		if !b.Enabled {
+			continue
+		}
+		enabled++
+		if b.Usable {
+			usable++
+		}
+	}
+	switch {
+	case usable == 0:
+		return HealthUnhealthy
+	case usable < enabled:
+		return HealthDegraded
+	}
+	return HealthHealthy
+}
+
+func (h *HealthHandler) backendStatus(cfg *Config) []backendHealth {
+	models := make(map[string]map[string]bool)
+	for _, alias := range cfg.Models {
+		if alias == nil {
+			continue
+		}
+		for _, route := range alias.Routes {
+			if models[route.Backend] == nil {
+				models[route.Backend] = make(map[string]bool)
+			}
+			models[route.Backend][route.Model] = true
+		}
+	}
+
+	result := make([]backendHealth, 0, len(cfg.Backends))
+	for _, b := range cfg.Backends {
+		bh := backendHealth{Name: b.Name, URL: b.URL, Enabled: b.IsEnabled(), Protocol: b.GetProtocol()}
+		if probe, ok := h.checker.Result(b.Name); ok {
+			bh.Probe = &probeHealth{
+				Healthy:   probe.Healthy,
+				LatencyMs: probe.Latency.Milliseconds(),
+				Error:     probe.Error,
+				CheckedAt: probe.CheckedAt,
+			}
+		}
+		names := make([]string, 0, len(models[b.Name]))
+		for model := range models[b.Name] {
+			names = append(names, model)
+		}
+		sort.Strings(names)
+		// 没有路由的后端只看启用状态与探测结果；有路由时至少一条路由未冷却且未熔断才算可用
+		routable := len(names) == 0
+		for _, model := range names {
+			key := h.cooldown.Key(b.Name, model)
+			rh := routeHealth{
+				Model:       model,
+				CoolingDown: h.cooldown.IsCoolingDown(key),
+				Circuit:     h.breaker.State(key),
+			}
+			if !rh.CoolingDown && rh.Circuit != CircuitOpen {
+				routable = true
+			}
+			bh.Routes = append(bh.Routes, rh)
+		}
+		bh.Usable = bh.Enabled && routable && h.checker.IsHealthy(b.Name)
+		result = append(result, bh)
+	}
+	return result
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHealthHandler_Auth(t *testing.T) {
+	proxy := newTestProxy(&Config{
+		ProxyAPIKey: "sk-test-key",
+		Backends:    []Backend{{Name: "backend1", URL: "http://backend1.internal"}},
+	})
+
+	tests := []struct {
+		name     string
+		path     string
+		auth     string
+		wantCode int
+	}{
+		{"basic without key", "/health", "", http.StatusOK},
+		{"basic k8s without key", "/healthz", "", http.StatusOK},
+		{"detail without key", "/health/detail", "", http.StatusUnauthorized},
+		{"detail wrong key", "/health/detail", "Bearer wrong", http.StatusUnauthorized},
+		{"detail with key", "/health/detail", "Bearer sk-test-key", http.StatusOK},
+		{"verbose without key", "/health?verbose=true", "", http.StatusUnauthorized},
+		{"verbose with key", "/health?verbose=true", "Bearer sk-test-key", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+
+			if w.Code != tt.wantCode {
+				t.Errorf("expected %d, got %d", tt.wantCode, w.Code)
+			}
+			if w.Code != http.StatusOK && strings.Contains(w.Body.String(), "backend1") {
+				t.Errorf("unauthenticated response leaks backend info: %s", w.Body.String())
+			}
+		})
+	}
+}
+
+// unreadBody 在被读取时让测试失败，用于确认存活探针不读取请求体
+type unreadBody struct{ t *testing.T }
+
+func (b unreadBody) Read([]byte) (int, error) {
+	b.t.Error("liveness probe read the request body")
+	return 0, io.EOF
+}
+
+func TestHealthz_SkipsAuthAndBody(t *testing.T) {
+	cfg := &Config{
+		ProxyAPIKey: "sk-test-key",
+		CORS:        CORS{AllowedOrigins: []string{"*"}},
+		Logging:     Logging{AccessLog: true},
+	}
+	proxy := newTestProxy(cfg)
+	handler := withCORS(newTestConfigManager(cfg), proxy)
+
+	for _, path := range []string{"/healthz", "/health", "/livez"} {
+		req := httptest.NewRequest("POST", path, unreadBody{t})
+		req.Header.Set("Authorization", "Bearer wrong")
+		req.Header.Set("Origin", "https://probe.example.com")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: status = %d, want 200", path, w.Code)
+		}
+	}
+}
+
+func TestHealthHandler_DetailStatus(t *testing.T) {
+	disabled := false
+	proxy := newTestProxy(&Config{
+		Backends: []Backend{
+			{Name: "backend1", URL: "http://backend1.internal"},
+			{Name: "backend2", URL: "http://backend2.internal", Enabled: &disabled},
+		},
+		Models: map[string]*ModelAlias{
+			"model-a": {Routes: []ModelRoute{
+				{Backend: "backend1", Model: "m1", Priority: 1},
+				{Backend: "backend2", Model: "m2", Priority: 2},
+			}},
+		},
+	})
+	proxy.cooldown.SetCooldown(proxy.cooldown.Key("backend1", "m1"), time.Minute)
+
+	req := httptest.NewRequest("GET", "/health/detail", nil)
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+
+	var resp struct {
+		Status   string          `json:"status"`
+		Backends []backendHealth `json:"backends"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if len(resp.Backends) != 2 {
+		t.Fatalf("expected 2 backends, got %+v", resp.Backends)
+	}
+
+	b1 := resp.Backends[0]
+	if b1.Name != "backend1" || b1.URL != "http://backend1.internal" || !b1.Enabled {
+		t.Errorf("unexpected backend1 status: %+v", b1)
+	}
+	if len(b1.Routes) != 1 || !b1.Routes[0].CoolingDown || b1.Routes[0].Circuit != CircuitClosed {
+		t.Errorf("backend1 route should be cooling down with closed circuit: %+v", b1.Routes)
+	}
+	if resp.Backends[1].Enabled {
+		t.Error("backend2 should be reported as disabled")
+	}
+}
+
+func TestHealthHandler_CompactAndVerbose(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data":[]}`))
+	}))
+	defer healthy.Close()
+
+	proxy := newTestProxy(&Config{
+		Backends: []Backend{
+			{Name: "backend1", URL: failing.URL, Protocol: ProtocolAnthropic},
+			{Name: "backend2", URL: healthy.URL},
+		},
+		Models: map[string]*ModelAlias{
+			"model-a": {Routes: []ModelRoute{{Backend: "backend2", Model: "m2", Priority: 1}}},
+		},
+		HealthCheck: HealthCheck{Enabled: true},
+	})
+	proxy.router.health.CheckAll(context.Background())
+
+	for _, path := range []string{"/health", "/health?verbose=false"} {
+		req := httptest.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+		if w.Code != http.StatusOK || w.Body.String() != "ok" {
+			t.Errorf("%s: compact response = %d %q, want 200 \"ok\"", path, w.Code, w.Body.String())
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/health?verbose=true", nil)
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+
+	var resp struct {
+		Status   string          `json:"status"`
+		Backends []backendHealth `json:"backends"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if resp.Status != HealthDegraded {
+		t.Errorf("status = %q, want %q", resp.Status, HealthDegraded)
+	}
+	if len(resp.Backends) != 2 {
+		t.Fatalf("expected 2 backends, got %+v", resp.Backends)
+	}
+	b1 := resp.Backends[0]
+	if b1.Protocol != ProtocolAnthropic || b1.Usable || b1.Probe == nil || b1.Probe.Healthy || b1.Probe.Error == "" {
+		t.Errorf("backend1 should report a failed probe: %+v (probe %+v)", b1, b1.Probe)
+	}
+	b2 := resp.Backends[1]
+	if b2.Protocol != ProtocolOpenAI || !b2.Usable || b2.Probe == nil || !b2.Probe.Healthy {
+		t.Errorf("backend2 should be usable with a healthy probe: %+v (probe %+v)", b2, b2.Probe)
+	}
+}
+
+func TestOverallHealth(t *testing.T) {
+	tests := []struct {
+		name     string
+		backends []backendHealth
+		want     string
+	}{
+		{"all usable", []backendHealth{{Enabled: true, Usable: true}, {Enabled: true, Usable: true}}, HealthHealthy},
+		{"some usable", []backendHealth{{Enabled: true, Usable: true}, {Enabled: true}}, HealthDegraded},
+		{"none usable", []backendHealth{{Enabled: true}, {Enabled: true}}, HealthUnhealthy},
+		{"disabled ignored", []backendHealth{{Enabled: true, Usable: true}, {Enabled: false}}, HealthHealthy},
+		{"no backends", nil, HealthUnhealthy},
+	}
+
+	for _, tt := range tests {
+		if got := overallHealth(tt.backends); got != tt.want {
+			t.Errorf("%s: overallHealth() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHealthHandler_LivezAndReadyz(t *testing.T) {
+	disabled := false
+	tests := []struct {
+		name      string
+		cfg       *Config
+		coolDown  bool
+		wantReady int
+	}{
+		{"no backends", &Config{}, false, http.StatusServiceUnavailable},
+		{
+			name:      "only disabled backends",
+			cfg:       &Config{Backends: []Backend{{Name: "b1", URL: "http://b1.internal", Enabled: &disabled}}},
+			wantReady: http.StatusServiceUnavailable,
+		},
+		{
+			name: "all routes cooling down",
+			cfg: &Config{
+				Backends: []Backend{{Name: "b1", URL: "http://b1.internal"}},
+				Models: map[string]*ModelAlias{
+					"model-a": {Routes: []ModelRoute{{Backend: "b1", Model: "m1", Priority: 1}}},
+				},
+			},
+			coolDown:  true,
+			wantReady: http.StatusServiceUnavailable,
+		},
+		{
+			name: "usable backend",
+			cfg: &Config{
+				Backends: []Backend{{Name: "b1", URL: "http://b1.internal"}},
+				Models: map[string]*ModelAlias{
+					"model-a": {Routes: []ModelRoute{{Backend: "b1", Model: "m1", Priority: 1}}},
+				},
+			},
+			wantReady: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := newTestProxy(tt.cfg)
+			if tt.coolDown {
+				proxy.cooldown.SetCooldown(proxy.cooldown.Key("b1", "m1"), time.Minute)
+			}
+
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, httptest.NewRequest("GET", "/livez", nil))
+			if w.Code != http.StatusOK {
+				t.Errorf("/livez = %d, want 200", w.Code)
+			}
+
+			w = httptest.NewRecorder()
+			proxy.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+			if w.Code != tt.wantReady {
+				t.Errorf("/readyz = %d, want %d", w.Code, tt.wantReady)
+			}
+		})
+	}
+}
+
+func TestHealthHandler_ReadyzFailsWhileDraining(t *testing.T) {
+	proxy := newTestProxy(&Config{Backends: []Backend{{Name: "b1", URL: "http://b1"}}})
+
+	ready := func() int {
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+		return w.Code
+	}
+	if code := ready(); code != http.StatusOK {
+		t.Fatalf("/readyz before shutdown = %d, want 200", code)
+	}
+	proxy.health.SetDraining()
+	if code := ready(); code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz while draining = %d, want 503", code)
+	}
+}
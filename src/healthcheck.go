@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ProbeResult 是一次主动健康检查的结果
+type ProbeResult struct {
+	Healthy   bool
+	Latency   time.Duration
+	Error     string
+	CheckedAt time.Time
+}
+
+// HealthChecker 定期向已启用的后端发送轻量请求（模型列表），
+// 探测失败的后端在路由中降级，并计入熔断器；恢复后清除其冷却。
+// 探测与转发共用每个后端的 HTTP 客户端，连接池与 TLS 配置对探测同样生效
+type HealthChecker struct {
+	configMgr *ConfigManager
+	cooldown  *CooldownManager
+	breaker   *CircuitBreaker
+	clients   *upstreamClients
+	results   map[string]ProbeResult
+	now       func() time.Time
+	mu        sync.RWMutex
+}
+
+func NewHealthChecker(cfg *ConfigManager, cd *CooldownManager, breaker *CircuitBreaker, clients *upstreamClients) *HealthChecker {
+	return &HealthChecker{
+		configMgr: cfg,
+		cooldown:  cd,
+		breaker:   breaker,
+		clients:   clients,
+		results:   make(map[string]ProbeResult),
+		now:       time.Now,
+	}
+}
+
+// Run 按配置的间隔循环探测，直到 ctx 取消；未启用时只等待配置变更
+func (hc *HealthChecker) Run(ctx context.Context) {
+	for {
+		cfg := hc.configMgr.Get().HealthCheck
+		if cfg.Enabled {
+			hc.CheckAll(ctx)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(cfg.GetInterval()):
+		}
+	}
+}
+
+// CheckAll 并发探测所有已启用的后端
+func (hc *HealthChecker) CheckAll(ctx context.Context) {
+	cfg := hc.configMgr.Get()
+	var wg sync.WaitGroup
+	for i := range cfg.Backends {
+		b := &cfg.Backends[i]
+		if !b.IsEnabled() || b.GetProtocol() == ProtocolBedrock {
+			// Bedrock 运行时端点没有模型列表接口，不做主动探测
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			hc.record(cfg, b, hc.probe(ctx, cfg, b))
+		}()
+	}
+	wg.Wait()
+}
+
+// IsHealthy 返回后端最近一次探测是否成功；未启用健康检查或尚未探测时视为健康
+func (hc *HealthChecker) IsHealthy(backend string) bool {
+	if !hc.configMgr.Get().HealthCheck.Enabled {
+		return true
+	}
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+	result, exists := hc.results[backend]
+	return !exists || result.Healthy
+}
+
+// Result 返回后端最近一次探测结果
+func (hc *HealthChecker) Result(backend string) (ProbeResult, bool) {
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+	result, exists := hc.results[backend]
+	return result, exists
+}
+
+func (hc *HealthChecker) record(cfg *Config, b *Backend, result ProbeResult) {
+	hc.mu.Lock()
+	prev, existed := hc.results[b.Name]
+	hc.results[b.Name] = result
+	hc.mu.Unlock()
+
+	if !result.Healthy {
+		if !existed || prev.Healthy {
+			LogGeneral("WARN", "健康检查失败: 后端=%s 错误=%s", b.Name, result.Error)
+		}
+		for _, key := range backendRouteKeys(cfg, hc.cooldown, b.Name) {
+			hc.breaker.RecordFailure(key)
+		}
+		return
+	}
+	if existed && !prev.Healthy {
+		LogGeneral("INFO", "健康检查恢复: 后端=%s 耗时=%dms", b.Name, result.Latency.Milliseconds())
+		hc.cooldown.ClearBackend(b.Name)
+	}
+}
+
+// probe 按后端协议请求模型列表接口，2xx 视为健康
+func (hc *HealthChecker) probe(ctx context.Context, cfg *Config, b *Backend) ProbeResult {
+	ctx, cancel := context.WithTimeout(ctx, cfg.HealthCheck.GetTimeout())
+	defer cancel()
+
+	start := hc.now()
+	result := ProbeResult{CheckedAt: start}
+	req, err := newProbeRequest(ctx, &cfg.HealthCheck, b)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	resp, err := hc.clients.Get(b.Name, cfg.ConnectionPool.For(b), b.TLS).Do(req)
+	result.Latency = hc.now().Sub(start)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		result.Error = fmt.Sprintf("状态码 %d", resp.StatusCode)
+		return result
+	}
+	result.Healthy = true
+	return result
+}
+
+func newProbeRequest(ctx context.Context, cfg *HealthCheck, b *Backend) (*http.Request, error) {
+	path := cfg.Path
+	if path == "" {
+		path = "/v1/models"
+		switch b.GetProtocol() {
+		case ProtocolGoogle:
+			path = "/v1beta/models"
+		case ProtocolAzure:
+			path = "/openai/models"
+		}
+	}
+	targetURL, err := backendTargetURL(b.URL, path)
+	if err != nil {
+		return nil, err
+	}
+
+	var apiKey string
+	if keys := b.Keys(); len(keys) > 0 {
+		apiKey = keys[0]
+	}
+	if b.GetProtocol() == ProtocolGoogle && apiKey != "" {
+		q := targetURL.Query()
+		q.Set("key", apiKey)
+		targetURL.RawQuery = q.Encode()
+	}
+	if b.GetProtocol() == ProtocolAzure {
+		q := targetURL.Query()
+		q.Set("api-version", b.GetAPIVersion())
+		targetURL.RawQuery = q.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, targetURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	switch b.GetProtocol() {
+	case ProtocolAnthropic:
+		req.Header.Set("x-api-key", apiKey)
+		req.Header.Set("anthropic-version", anthropicVersion)
+	case ProtocolOpenAI:
+		if apiKey != "" {
+			req.Header.Set("Authorization", "Bearer "+apiKey)
+		}
+	case ProtocolAzure:
+		req.Header.Set("api-key", apiKey)
+	}
+	return req, nil
+}
+
+// backendRouteKeys 返回配置中指向该后端的所有路由冷却键
+func backendRouteKeys(cfg *Config, cd *CooldownManager, backend string) []CooldownKey {
+	seen := make(map[CooldownKey]bool)
+	var keys []CooldownKey
+	for _, alias := range cfg.Models {
+		if alias == nil {
+			continue
+		}
+		for _, route := range alias.Routes {
+			key := cd.Key(route.Backend, route.Model)
+			if route.Backend == backend && !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newFlippingBackend(healthy *atomic.Bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/models" || !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"data":[]}`))
+	}))
+}
+
+func TestHealthChecker_CheckAll_FlipsHealthyUnhealthy(t *testing.T) {
+	var healthy atomic.Bool
+	backend := newFlippingBackend(&healthy)
+	defer backend.Close()
+
+	cm := newTestConfigManager(&Config{
+		Backends: []Backend{{Name: "b1", URL: backend.URL + "/v1"}},
+		Models: map[string]*ModelAlias{
+			"model-a": {Routes: []ModelRoute{{Backend: "b1", Model: "m1", Priority: 1}}},
+		},
+		HealthCheck: HealthCheck{Enabled: true},
+	})
+	cd := NewCooldownManager()
+	router := NewRouter(cm, cd)
+	hc := router.health
+
+	if !hc.IsHealthy("b1") {
+		t.Fatal("unprobed backend should be treated as healthy")
+	}
+
+	hc.CheckAll(context.Background())
+	if hc.IsHealthy("b1") {
+		t.Fatal("backend returning 503 should be unhealthy")
+	}
+	if result, ok := hc.Result("b1"); !ok || result.Error == "" {
+		t.Errorf("failed probe result = %+v, want an error", result)
+	}
+
+	key := cd.Key("b1", "m1")
+	cd.SetCooldown(key, time.Hour)
+	healthy.Store(true)
+	hc.CheckAll(context.Background())
+	if !hc.IsHealthy("b1") {
+		t.Fatal("backend should recover after a successful probe")
+	}
+	if cd.IsCoolingDown(key) {
+		t.Error("recovery should clear the backend's cooldown")
+	}
+}
+
+func TestHealthChecker_DisabledReportsHealthy(t *testing.T) {
+	var healthy atomic.Bool
+	backend := newFlippingBackend(&healthy)
+	defer backend.Close()
+
+	cfg := &Config{
+		Backends:    []Backend{{Name: "b1", URL: backend.URL}},
+		HealthCheck: HealthCheck{Enabled: true},
+	}
+	cm := newTestConfigManager(cfg)
+	cd := NewCooldownManager()
+	hc := NewHealthChecker(cm, cd, NewCircuitBreaker(cm), &upstreamClients{})
+
+	hc.CheckAll(context.Background())
+	cfg.HealthCheck.Enabled = false
+	if !hc.IsHealthy("b1") {
+		t.Error("stale probe results must be ignored once health checks are disabled")
+	}
+}
+
+func TestHealthChecker_FailuresFeedCircuitBreaker(t *testing.T) {
+	var healthy atomic.Bool
+	backend := newFlippingBackend(&healthy)
+	defer backend.Close()
+
+	cm := newTestConfigManager(&Config{
+		Backends: []Backend{{Name: "b1", URL: backend.URL}},
+		Models: map[string]*ModelAlias{
+			"model-a": {Routes: []ModelRoute{{Backend: "b1", Model: "m1", Priority: 1}}},
+		},
+		CircuitBreaker: CircuitBreakerConfig{Enabled: true, FailureThreshold: 2},
+		HealthCheck:    HealthCheck{Enabled: true},
+	})
+	cd := NewCooldownManager()
+	router := NewRouter(cm, cd)
+
+	router.health.CheckAll(context.Background())
+	router.health.CheckAll(context.Background())
+
+	if got := router.breaker.State(cd.Key("b1", "m1")); got != CircuitOpen {
+		t.Errorf("circuit after repeated probe failures = %s, want open", got)
+	}
+}
+
+func TestRouter_Resolve_DeprioritizesUnhealthyBackend(t *testing.T) {
+	var healthy, alsoHealthy atomic.Bool
+	alsoHealthy.Store(true)
+	sick := newFlippingBackend(&healthy)
+	defer sick.Close()
+	fine := newFlippingBackend(&alsoHealthy)
+	defer fine.Close()
+
+	cm := newTestConfigManager(&Config{
+		Backends: []Backend{
+			{Name: "sick", URL: sick.URL},
+			{Name: "fine", URL: fine.URL},
+		},
+		Models: map[string]*ModelAlias{
+			"model-a": {Routes: []ModelRoute{
+				{Backend: "sick", Model: "m1", Priority: 1},
+				{Backend: "fine", Model: "m1", Priority: 2},
+			}},
+		},
+		HealthCheck: HealthCheck{Enabled: true},
+	})
+	router := NewRouter(cm, NewCooldownManager())
+	router.health.CheckAll(context.Background())
+
+	routes, trace := router.ResolveTrace("model-a")
+	if len(routes) != 2 || routes[0].BackendName != "fine" || routes[1].BackendName != "sick" {
+		t.Fatalf("routes = %+v, want fine before unhealthy sick", routes)
+	}
+	if len(trace.Candidates) != 2 || !trace.Candidates[1].Unhealthy {
+		t.Errorf("trace candidates = %+v, want sick marked unhealthy last", trace.Candidates)
+	}
+
+	healthy.Store(true)
+	router.health.CheckAll(context.Background())
+	routes, _ = router.Resolve("model-a")
+	if routes[0].BackendName != "sick" {
+		t.Errorf("recovered backend should regain its priority, got %+v", routes)
+	}
+}
+
+func TestNewProbeRequest_PerProtocol(t *testing.T) {
+	tests := []struct {
+		name       string
+		backend    Backend
+		path       string
+		wantURL    string
+		wantHeader string
+		wantValue  string
+	}{
+		{
+			name:       "openai",
+			backend:    Backend{URL: "https://api.example.com/v1", APIKey: "sk-a"},
+			wantURL:    "https://api.example.com/v1/models",
+			wantHeader: "Authorization",
+			wantValue:  "Bearer sk-a",
+		},
+		{
+			name:       "anthropic",
+			backend:    Backend{URL: "https://api.anthropic.com", Protocol: ProtocolAnthropic, APIKey: "sk-ant"},
+			wantURL:    "https://api.anthropic.com/v1/models",
+			wantHeader: "x-api-key",
+			wantValue:  "sk-ant",
+		},
+		{
+			name:    "google",
+			backend: Backend{URL: "https://generativelanguage.googleapis.com", Protocol: ProtocolGoogle, APIKey: "g-key"},
+			wantURL: "https://generativelanguage.googleapis.com/v1beta/models?key=g-key",
+		},
+		{
+			name:       "azure",
+			backend:    Backend{URL: "https://res.openai.azure.com", Protocol: ProtocolAzure, APIKey: "az-key"},
+			wantURL:    "https://res.openai.azure.com/openai/models?api-version=" + defaultAzureAPIVersion,
+			wantHeader: "api-key",
+			wantValue:  "az-key",
+		},
+		{
+			name:    "custom path",
+			backend: Backend{URL: "https://api.example.com"},
+			path:    "/healthz",
+			wantURL: "https://api.example.com/healthz",
+		},
+	}
+
+	for _, tt := range tests {
+		req, err := newProbeRequest(context.Background(), &HealthCheck{Path: tt.path}, &tt.backend)
+		if err != nil {
+			t.Fatalf("%s: newProbeRequest error: %v", tt.name, err)
+		}
+		if got := req.URL.String(); got != tt.wantURL {
+			t.Errorf("%s: URL = %q, want %q", tt.name, got, tt.wantURL)
+		}
+		if tt.wantHeader != "" && req.Header.Get(tt.wantHeader) != tt.wantValue {
+			t.Errorf("%s: %s = %q, want %q", tt.name, tt.wantHeader, req.Header.Get(tt.wantHeader), tt.wantValue)
+		}
+	}
+}
+
+func TestHealthChecker_UsesBackendTLS(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data":[]}`))
+	}))
+	defer backend.Close()
+
+	tests := []struct {
+		name        string
+		tls         *BackendTLS
+		wantHealthy bool
+	}{
+		{"self-signed without skip verify", nil, false},
+		{"insecure_skip_verify", &BackendTLS{InsecureSkipVerify: true}, true},
+		{"pin mismatch", &BackendTLS{InsecureSkipVerify: true, PinnedSHA256: []string{"AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cm := newTestConfigManager(&Config{
+				Backends: []Backend{{Name: "dev", URL: backend.URL, TLS: tt.tls}},
+				Models: map[string]*ModelAlias{
+					"model-a": {Routes: []ModelRoute{{Backend: "dev", Model: "m1", Priority: 1}}},
+				},
+				CircuitBreaker: CircuitBreakerConfig{Enabled: true, FailureThreshold: 1},
+				HealthCheck:    HealthCheck{Enabled: true},
+			})
+			cd := NewCooldownManager()
+			router := NewRouter(cm, cd)
+
+			router.health.CheckAll(context.Background())
+
+			if got := router.health.IsHealthy("dev"); got != tt.wantHealthy {
+				result, _ := router.health.Result("dev")
+				t.Errorf("healthy = %v, want %v (error: %s)", got, tt.wantHealthy, result.Error)
+			}
+			if open := router.breaker.State(cd.Key("dev", "m1")) == CircuitOpen; open == tt.wantHealthy {
+				t.Errorf("circuit open = %v with healthy = %v", open, tt.wantHealthy)
+			}
+		})
+	}
+}
+
+func TestHealthChecker_UsesBackendConnectionPool(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(1200 * time.Millisecond)
+		w.Write([]byte(`{"data":[]}`))
+	}))
+	defer backend.Close()
+
+	cm := newTestConfigManager(&Config{
+		Backends: []Backend{{
+			Name: "slow", URL: backend.URL,
+			ConnectionPool: &ConnectionPool{ResponseHeaderTimeoutSeconds: 1},
+		}},
+		HealthCheck: HealthCheck{Enabled: true, TimeoutSeconds: 10},
+	})
+	router := NewRouter(cm, NewCooldownManager())
+	cfg := cm.Get()
+	shared := router.clients.Get("slow", cfg.ConnectionPool.For(&cfg.Backends[0]), nil)
+
+	router.health.CheckAll(context.Background())
+
+	result, _ := router.health.Result("slow")
+	if result.Healthy || !strings.Contains(result.Error, "awaiting response headers") {
+		t.Errorf("probe should hit the backend's response header timeout, got %+v", result)
+	}
+	if router.clients.clients["slow"].client != shared {
+		t.Error("probe should reuse the backend's cached client instead of building its own")
+	}
+}
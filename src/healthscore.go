@@ -0,0 +1,78 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// 健康评分参数：每次请求结果以 scoreAlpha 的权重并入移动平均；平均延迟等于 scoreLatencyRef 时
+// 延迟因子为 0.5；评分不低于 scoreMin，低分后端仍能分到少量流量，恢复后评分可以回升
+const (
+	scoreAlpha      = 0.2
+	scoreLatencyRef = 2 * time.Second
+	scoreMin        = 0.05
+)
+
+// HealthScorer 按后端维护最近请求错误率与响应延迟的指数加权移动平均（EWMA），
+// 折算为 (0, 1] 的健康评分，score_weighted 策略按评分调整同优先级组内的选择概率
+type HealthScorer struct {
+	stats map[string]*backendScore
+	mu    sync.Mutex
+}
+
+type backendScore struct {
+	errorRate float64
+	latency   float64
+	samples   int
+}
+
+func NewHealthScorer() *HealthScorer {
+	return &HealthScorer{stats: make(map[string]*backendScore)}
+}
+
+// Record 记录一次后端请求结果。延迟只取成功的请求（收到响应头为止），
+// 快速失败的请求不拉低平均延迟
+func (h *HealthScorer) Record(backend string, latency time.Duration, success bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, exists := h.stats[backend]
+	if !exists {
+		s = &backendScore{}
+		h.stats[backend] = s
+	}
+
+	failed := 1.0
+	if success {
+		failed = 0
+	}
+	if s.samples == 0 {
+		s.errorRate = failed
+	} else {
+		s.errorRate += scoreAlpha * (failed - s.errorRate)
+	}
+	if success {
+		if sec := latency.Seconds(); s.latency == 0 {
+			s.latency = sec
+		} else {
+			s.latency += scoreAlpha * (sec - s.latency)
+		}
+	}
+	s.samples++
+	metricsRegistry.SetGauge("llm_proxy_backend_health_score", s.score(), "backend", backend)
+}
+
+// Score 返回后端的健康评分，尚无请求记录的后端为 1
+func (h *HealthScorer) Score(backend string) float64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, exists := h.stats[backend]
+	if !exists {
+		return 1
+	}
+	return s.score()
+}
+
+func (s *backendScore) score() float64 {
+	ref := scoreLatencyRef.Seconds()
+	return max((1-s.errorRate)*ref/(ref+s.latency), scoreMin)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestHealthScorer_Score(t *testing.T) {
+	h := NewHealthScorer()
+	if got := h.Score("unknown"); got != 1 {
+		t.Errorf("unscored backend = %v, want 1", got)
+	}
+
+	h.Record("fast", 200*time.Millisecond, true)
+	h.Record("slow", 4*time.Second, true)
+	if fast, slow := h.Score("fast"), h.Score("slow"); fast <= slow {
+		t.Errorf("fast backend score %v should exceed slow backend score %v", fast, slow)
+	}
+	if got, want := h.Score("slow"), 2.0/(2+4); math.Abs(got-want) > 1e-9 {
+		t.Errorf("slow score = %v, want %v", got, want)
+	}
+
+	for i := 0; i < 50; i++ {
+		h.Record("failing", time.Millisecond, false)
+	}
+	if got := h.Score("failing"); got != scoreMin {
+		t.Errorf("always-failing backend score = %v, want floor %v", got, scoreMin)
+	}
+	for i := 0; i < 30; i++ {
+		h.Record("failing", 200*time.Millisecond, true)
+	}
+	if got := h.Score("failing"); got < 0.8 {
+		t.Errorf("recovered backend score = %v, want it to climb back", got)
+	}
+	if got := metricsRegistry.CounterValue("llm_proxy_backend_health_score", "backend", "failing"); got != h.Score("failing") {
+		t.Errorf("health score gauge = %v, want %v", got, h.Score("failing"))
+	}
+}
+
+func TestRouter_Resolve_ScoreWeightedShiftsToBetterBackend(t *testing.T) {
+	cfg := &Config{
+		Backends: []Backend{
+			{Name: "steady", URL: "http://steady.com"},
+			{Name: "flaky", URL: "http://flaky.com"},
+		},
+		Models: map[string]*ModelAlias{
+			"model-a": {
+				Routes: []ModelRoute{
+					{Backend: "steady", Model: "m1", Priority: 1},
+					{Backend: "flaky", Model: "m2", Priority: 1},
+				},
+			},
+		},
+		LoadBalance: LoadBalance{Strategy: StrategyScoreWeighted},
+	}
+	router := NewRouter(newTestConfigManager(cfg), NewCooldownManager())
+	router.rng = rand.New(rand.NewSource(3))
+
+	share := func() float64 {
+		const iterations = 2000
+		steady := 0
+		for i := 0; i < iterations; i++ {
+			routes, _ := router.Resolve("model-a")
+			if len(routes) != 2 {
+				t.Fatalf("both routes should stay available, got %+v", routes)
+			}
+			if routes[0].BackendName == "steady" {
+				steady++
+			}
+		}
+		return float64(steady) / iterations
+	}
+
+	if got := share(); math.Abs(got-0.5) > 0.05 {
+		t.Errorf("without outcomes steady selected %.3f of the time, want about 0.5", got)
+	}
+
+	prev := 0.5
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 5; i++ {
+			router.scores.Record("steady", 300*time.Millisecond, true)
+			router.scores.Record("flaky", 3*time.Second, i%2 == 0)
+		}
+		got := share()
+		if got <= prev {
+			t.Errorf("round %d: steady share %.3f should grow past %.3f", round, got, prev)
+		}
+		prev = got
+	}
+	if prev < 0.75 {
+		t.Errorf("steady share %.3f, want the better backend to dominate", prev)
+	}
+
+	_, trace := router.ResolveTrace("model-a")
+	weight := router.routeWeights(cfg, cfg.Models["model-a"].Routes, true)
+	for _, c := range trace.Candidates {
+		if want := weight(ModelRoute{Backend: c.Backend}); c.Weight != want {
+			t.Errorf("trace weight for %s = %v, want score-adjusted %v", c.Backend, c.Weight, want)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import "sync"
+
+// InFlightTracker 统计每个后端正在处理中的请求数
+type InFlightTracker struct {
+	counts map[string]int64
+	mu     sync.Mutex
+}
+
+func NewInFlightTracker() *InFlightTracker {
+	return &InFlightTracker{counts: make(map[string]int64)}
+}
+
+// Begin 计数加一并返回释放函数，释放函数可重复调用但只生效一次
+func (t *InFlightTracker) Begin(backend string) func() {
+	t.mu.Lock()
+	t.counts[backend]++
+	t.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.counts[backend]--
+			if t.counts[backend] <= 0 {
+				delete(t.counts, backend)
+			}
+		})
+	}
+}
+
+func (t *InFlightTracker) Count(backend string) int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.counts[backend]
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestInFlightTracker_BeginRelease(t *testing.T) {
+	tracker := NewInFlightTracker()
+
+	r1 := tracker.Begin("b1")
+	r2 := tracker.Begin("b1")
+	if got := tracker.Count("b1"); got != 2 {
+		t.Fatalf("expected 2 in flight, got %d", got)
+	}
+
+	r1()
+	r1()
+	if got := tracker.Count("b1"); got != 1 {
+		t.Errorf("release should only take effect once, got %d", got)
+	}
+	r2()
+	if got := tracker.Count("b1"); got != 0 {
+		t.Errorf("expected 0 in flight, got %d", got)
+	}
+}
+
+func TestInFlightTracker_Concurrent(t *testing.T) {
+	tracker := NewInFlightTracker()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release := tracker.Begin("b1")
+			defer release()
+		}()
+	}
+	wg.Wait()
+
+	if got := tracker.Count("b1"); got != 0 {
+		t.Errorf("expected 0 in flight after all requests finished, got %d", got)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -144,6 +145,19 @@ func LogGeneral(level, format string, args ...interface{}) {
 	}
 }
 
+// CloseLogger 刷新并关闭日志文件，用于进程退出前
+func CloseLogger() error {
+	logMu.Lock()
+	defer logMu.Unlock()
+	if generalLogger == nil {
+		return nil
+	}
+	generalLogger.Sync()
+	err := generalLogger.Close()
+	generalLogger = nil
+	return err
+}
+
 func LogRequest(cfg *Config, reqID string, content string) error {
 	if testMode {
 		return nil
@@ -197,6 +211,14 @@ type RequestMetrics struct {
 	Attempts     int
 	TotalLatency time.Duration
 	BackendTimes map[string]time.Duration
+	// MetricAlias 为指标的 model 标签，模式别名命中时取模式本身；Protocol 为最近一次尝试的后端协议
+	MetricAlias string
+	Protocol    string
+	// Usage 为实际返回响应的后端消耗的 token，UsageEstimated 表示按输出内容估算
+	Usage          Usage
+	UsageEstimated bool
+	// Cost 为按 pricing 配置估算的成本（美元），未配置价格时为 0
+	Cost float64
 }
 
 func NewRequestMetrics(reqID, modelAlias string) *RequestMetrics {
@@ -204,6 +226,7 @@ func NewRequestMetrics(reqID, modelAlias string) *RequestMetrics {
 		StartTime:    time.Now(),
 		RequestID:    reqID,
 		ModelAlias:   modelAlias,
+		MetricAlias:  modelAlias,
 		BackendTimes: make(map[string]time.Duration),
 	}
 }
@@ -213,11 +236,54 @@ func (m *RequestMetrics) RecordBackendTime(backend string, duration time.Duratio
 	m.Attempts++
 }
 
+// RecordUsage 记录请求的 token 用量，Finish 时按模型别名与最终后端累计；
+// estimated 表示上游未返回 usage、按输出内容估算
+func (m *RequestMetrics) RecordUsage(usage Usage, estimated bool) {
+	m.Usage = usage
+	m.UsageEstimated = estimated
+}
+
+func (m *RequestMetrics) recordTokens(finalBackend string) {
+	est := strconv.FormatBool(m.UsageEstimated)
+	for _, t := range []struct {
+		kind   string
+		tokens int64
+	}{
+		{"prompt", m.Usage.PromptTokens},
+		{"completion", m.Usage.CompletionTokens},
+		{"total", m.Usage.TotalTokens},
+	} {
+		if t.tokens > 0 {
+			metricsRegistry.AddCounter("llm_proxy_tokens_total", float64(t.tokens), "model", m.MetricAlias, "backend", finalBackend, "type", t.kind, "estimated", est)
+		}
+	}
+}
+
 func (m *RequestMetrics) Finish(success bool, finalBackend string) {
+	m.TotalLatency = time.Since(m.StartTime)
+
+	result := "success"
+	if !success {
+		result = "failure"
+	}
+	// 没有最终后端时协议标签为空，不沿用最后一次尝试的协议
+	protocol := m.Protocol
+	if finalBackend == "" {
+		protocol = ""
+	}
+	metricsRegistry.IncCounter("llm_proxy_requests_total", "model", m.MetricAlias, "backend", finalBackend, "protocol", protocol, "result", result)
+	metricsRegistry.ObserveHistogram("llm_proxy_request_duration_seconds", m.TotalLatency.Seconds(), "model", m.MetricAlias, "backend", finalBackend, "protocol", protocol)
+	if m.Attempts > 1 {
+		metricsRegistry.AddCounter("llm_proxy_retries_total", float64(m.Attempts-1), "model", m.MetricAlias)
+	}
+	m.recordTokens(finalBackend)
+	if m.Cost > 0 {
+		metricsRegistry.AddCounter("llm_proxy_cost_usd_total", m.Cost, "model", m.MetricAlias, "backend", finalBackend)
+	}
+
 	if !enableMetrics || testMode {
 		return
 	}
-	m.TotalLatency = time.Since(m.StartTime)
 
 	status := "成功"
 	if !success {
@@ -229,7 +295,11 @@ func (m *RequestMetrics) Finish(success bool, finalBackend string) {
 		backendDetails = append(backendDetails, fmt.Sprintf("%s=%dms", backend, duration.Milliseconds()))
 	}
 
-	LogGeneral("INFO", "[性能指标] 请求=%s 模型=%s 状态=%s 后端=%s 尝试次数=%d 总耗时=%dms 后端耗时=[%s]",
+	tokens := fmt.Sprintf("%d/%d/%d", m.Usage.PromptTokens, m.Usage.CompletionTokens, m.Usage.TotalTokens)
+	if m.UsageEstimated {
+		tokens += "(估算)"
+	}
+	LogGeneral("INFO", "[性能指标] 请求=%s 模型=%s 状态=%s 后端=%s 尝试次数=%d 总耗时=%dms 后端耗时=[%s] tokens(输入/输出/合计)=%s 估算成本=$%.6f",
 		m.RequestID, m.ModelAlias, status, finalBackend, m.Attempts, m.TotalLatency.Milliseconds(),
-		strings.Join(backendDetails, ", "))
+		strings.Join(backendDetails, ", "), tokens, m.Cost)
 }
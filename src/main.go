@@ -1,12 +1,20 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
+// metricsFlushTimeout 是关闭时推送最终指标的时限，不占用请求排空时间
+const metricsFlushTimeout = 5 * time.Second
+
 func main() {
 	configPath := flag.String("config", "config.yaml", "path to config file")
 	flag.Parse()
@@ -20,8 +28,12 @@ func main() {
 	if err := InitLogger(cfg); err != nil {
 		log.Fatalf("初始化日志失败: %v", err)
 	}
+	if err := InitAccessLog(&cfg.Logging); err != nil {
+		log.Fatalf("初始化访问日志失败: %v", err)
+	}
 
 	cooldown := NewCooldownManager()
+	cooldown.Subscribe(NewChurnMonitor(configMgr).Observe)
 	go func() {
 		for {
 			time.Sleep(time.Minute)
@@ -29,13 +41,71 @@ func main() {
 		}
 	}()
 	router := NewRouter(configMgr, cooldown)
+	probeCtx, stopProbe := context.WithCancel(context.Background())
+	go router.health.Run(probeCtx)
 	detector := NewDetector(configMgr)
 	proxy := NewProxy(configMgr, router, cooldown, detector)
 
 	LogGeneral("INFO", "LLM Proxy 启动，监听地址: %s", cfg.Listen)
 	LogGeneral("INFO", "已加载 %d 个后端，%d 个模型别名", len(cfg.Backends), len(cfg.Models))
 
-	if err := http.ListenAndServe(cfg.Listen, proxy); err != nil {
-		log.Fatalf("服务器启动失败: %v", err)
+	// 所有请求的 ctx 派生自 requestCtx，排空超时后取消，让仍在进行的流式响应及时结束
+	requestCtx, cancelRequests := context.WithCancel(context.Background())
+	proxy.embeddings.SetBaseContext(requestCtx)
+	server := &http.Server{
+		Addr:        cfg.Listen,
+		Handler:     withCORS(configMgr, proxy),
+		BaseContext: func(net.Listener) context.Context { return requestCtx },
+	}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("服务器启动失败: %v", err)
+		}
+	}()
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := configMgr.Reload(); err != nil {
+				LogGeneral("WARN", "配置重载失败: %v，继续使用旧配置", err)
+			}
+		}
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-quit
+	LogGeneral("INFO", "收到信号 %v，开始关闭", sig)
+	stopProbe()
+
+	shutdown := configMgr.Get().Shutdown
+	proxy.health.SetDraining()
+	if delay := shutdown.GetReadyDelay(); delay > 0 {
+		LogGeneral("INFO", "就绪检查已置为失败，%s 后停止接收请求", delay)
+		time.Sleep(delay)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdown.GetDrainTimeout())
+	defer cancel()
+	gracefulShutdown(ctx, server, cancelRequests, func(ctx context.Context) error {
+		return FlushMetrics(ctx, configMgr.Get())
+	})
+}
+
+// gracefulShutdown 按顺序关闭：先停止接收请求并在 ctx 时限内等待处理中的请求；
+// 超时后取消剩余请求（cancelRequests）并关闭连接，再刷新指标，最后关闭日志文件
+func gracefulShutdown(ctx context.Context, server *http.Server, cancelRequests context.CancelFunc, flush func(context.Context) error) {
+	if err := server.Shutdown(ctx); err != nil {
+		LogGeneral("WARN", "等待处理中的请求超时: %v，取消剩余请求", err)
+		cancelRequests()
+		server.Close()
+	}
+	flushCtx, cancel := context.WithTimeout(context.Background(), metricsFlushTimeout)
+	defer cancel()
+	if err := flush(flushCtx); err != nil {
+		LogGeneral("WARN", "刷新指标失败: %v", err)
 	}
+	LogGeneral("INFO", "LLM Proxy 已停止")
+	CloseLogger()
 }
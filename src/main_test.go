@@ -1,11 +1,133 @@
 package main
 
 import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestMain(m *testing.M) {
 	SetTestMode(true)
 	os.Exit(m.Run())
 }
+
+func TestGracefulShutdown_FlushesMetrics(t *testing.T) {
+	var pushedPath, pushedBody string
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pushedPath = r.Method + " " + r.URL.Path
+		body, _ := io.ReadAll(r.Body)
+		pushedBody = string(body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer gateway.Close()
+
+	metricsRegistry.IncCounter("llm_proxy_requests_total", "model", "shutdown-test", "backend", "b1", "result", "success")
+	cfg := &Config{Metrics: Metrics{PushGateway: gateway.URL}}
+	server := &http.Server{Addr: "127.0.0.1:0"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	gracefulShutdown(ctx, server, func() {}, func(ctx context.Context) error {
+		return FlushMetrics(ctx, cfg)
+	})
+
+	if pushedPath != "PUT /metrics/job/llm-proxy" {
+		t.Errorf("unexpected push request: %q", pushedPath)
+	}
+	if !strings.Contains(pushedBody, `model="shutdown-test"`) {
+		t.Errorf("pushed body missing final metrics: %s", pushedBody)
+	}
+}
+
+func TestGracefulShutdown_FlushAfterServerStops(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Start()
+	defer server.Close()
+
+	flushed := false
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	gracefulShutdown(ctx, server.Config, func() {}, func(ctx context.Context) error {
+		if _, err := http.Get(server.URL); err == nil {
+			t.Error("server should stop accepting requests before flush")
+		}
+		flushed = true
+		return nil
+	})
+
+	if !flushed {
+		t.Error("flush should be invoked on shutdown")
+	}
+}
+
+// newDrainTestServer 启动一个处理耗时为 delay 的服务器，请求 ctx 派生自可取消的 base ctx
+func newDrainTestServer(delay time.Duration) (*httptest.Server, context.CancelFunc, chan error) {
+	base, cancel := context.WithCancel(context.Background())
+	started := make(chan error, 1)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- nil
+		select {
+		case <-time.After(delay):
+			w.Write([]byte("done"))
+		case <-r.Context().Done():
+		}
+	}))
+	server.Config.BaseContext = func(net.Listener) context.Context { return base }
+	server.Start()
+	return server, cancel, started
+}
+
+func TestGracefulShutdown_DrainsInFlightRequest(t *testing.T) {
+	server, cancelRequests, started := newDrainTestServer(200 * time.Millisecond)
+	defer server.Close()
+
+	result := make(chan string, 1)
+	go func() {
+		resp, err := http.Get(server.URL)
+		if err != nil {
+			result <- err.Error()
+			return
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		result <- string(body)
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	gracefulShutdown(ctx, server.Config, cancelRequests, func(context.Context) error { return nil })
+
+	if got := <-result; got != "done" {
+		t.Errorf("in-flight request = %q, want it to complete within the drain window", got)
+	}
+}
+
+func TestGracefulShutdown_CancelsRequestsAfterDrainTimeout(t *testing.T) {
+	server, cancelRequests, started := newDrainTestServer(time.Minute)
+	defer server.Close()
+
+	go http.Get(server.URL)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	gracefulShutdown(ctx, server.Config, cancelRequests, func(context.Context) error { return nil })
+
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("shutdown took %s, want it bounded by the drain timeout", elapsed)
+	}
+}
+
+func TestFlushMetrics_NoGateway(t *testing.T) {
+	if err := FlushMetrics(context.Background(), &Config{}); err != nil {
+		t.Errorf("FlushMetrics without gateway should be no-op, got %v", err)
+	}
+}
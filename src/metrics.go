@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type metricKind string
+
+const (
+	kindCounter   metricKind = "counter"
+	kindGauge     metricKind = "gauge"
+	kindHistogram metricKind = "histogram"
+)
+
+// durationBuckets 请求耗时直方图的桶边界（秒），覆盖快速失败到长时间流式请求
+var durationBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+type metricSeries struct {
+	kind    metricKind
+	name    string
+	labels  string
+	value   float64
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+var metricHelp = map[string]string{
+	"llm_proxy_requests_total":              "Total proxied requests by model alias, final backend, backend protocol and result.",
+	"llm_proxy_request_duration_seconds":    "End-to-end request latency by model alias, final backend and backend protocol.",
+	"llm_proxy_retries_total":               "Total extra backend attempts beyond the first, by model alias.",
+	"llm_proxy_fallbacks_total":             "Total times a backend failure caused fallback to the next route.",
+	"llm_proxy_upstream_responses_total":    "Upstream responses by backend and HTTP status code.",
+	"llm_proxy_cooldown_entries_total":      "Total times a backend entered cooldown.",
+	"llm_proxy_cooldown_churn_alerts_total": "Total cooldown churn alerts raised per backend.",
+	"llm_proxy_rejections_total":            "Total requests rejected by the proxy's own limits, by reason.",
+	"llm_proxy_in_flight_requests":          "Requests currently being handled.",
+	"llm_proxy_queued_requests":             "Requests waiting for a concurrency slot.",
+}
+
+type MetricsRegistry struct {
+	series map[string]*metricSeries
+	mu     sync.RWMutex
+}
+
+func NewMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{
+		series: make(map[string]*metricSeries),
+	}
+}
+
+var metricsRegistry = NewMetricsRegistry()
+
+func (m *MetricsRegistry) getSeries(kind metricKind, name string, labels []string) *metricSeries {
+	lbl := formatLabels(labels)
+	key := name + "{" + lbl + "}"
+	s, exists := m.series[key]
+	if !exists {
+		s = &metricSeries{kind: kind, name: name, labels: lbl}
+		if kind == kindHistogram {
+			s.buckets = make([]uint64, len(durationBuckets))
+		}
+		m.series[key] = s
+	}
+	return s
+}
+
+// AddCounter 累加计数器，labels 为 key/value 交替排列
+func (m *MetricsRegistry) AddCounter(name string, value float64, labels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.getSeries(kindCounter, name, labels).value += value
+}
+
+func (m *MetricsRegistry) IncCounter(name string, labels ...string) {
+	m.AddCounter(name, 1, labels...)
+}
+
+func (m *MetricsRegistry) SetGauge(name string, value float64, labels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.getSeries(kindGauge, name, labels).value = value
+}
+
+func (m *MetricsRegistry) AddGauge(name string, delta float64, labels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.getSeries(kindGauge, name, labels).value += delta
+}
+
+func (m *MetricsRegistry) ObserveHistogram(name string, value float64, labels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.getSeries(kindHistogram, name, labels)
+	for i, bound := range durationBuckets {
+		if value <= bound {
+			s.buckets[i]++
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+// CounterValue 返回计数器或仪表的当前值，直方图返回观测次数
+func (m *MetricsRegistry) CounterValue(name string, labels ...string) float64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	s, exists := m.series[name+"{"+formatLabels(labels)+"}"]
+	if !exists {
+		return 0
+	}
+	if s.kind == kindHistogram {
+		return float64(s.count)
+	}
+	return s.value
+}
+
+// WritePrometheus 以 Prometheus 文本格式输出全部指标
+func (m *MetricsRegistry) WritePrometheus(w io.Writer) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	byName := make(map[string][]*metricSeries)
+	for _, s := range m.series {
+		byName[s.name] = append(byName[s.name], s)
+	}
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		series := byName[name]
+		sort.Slice(series, func(i, j int) bool {
+			return series[i].labels < series[j].labels
+		})
+		if help, ok := metricHelp[name]; ok {
+			fmt.Fprintf(&buf, "# HELP %s %s\n", name, help)
+		}
+		fmt.Fprintf(&buf, "# TYPE %s %s\n", name, series[0].kind)
+		for _, s := range series {
+			if s.kind != kindHistogram {
+				fmt.Fprintf(&buf, "%s%s %g\n", s.name, wrapLabels(s.labels), s.value)
+				continue
+			}
+			for i, bound := range durationBuckets {
+				fmt.Fprintf(&buf, "%s_bucket%s %d\n", s.name, wrapLabels(joinLabels(s.labels, fmt.Sprintf(`le="%g"`, bound))), s.buckets[i])
+			}
+			fmt.Fprintf(&buf, "%s_bucket%s %d\n", s.name, wrapLabels(joinLabels(s.labels, `le="+Inf"`)), s.count)
+			fmt.Fprintf(&buf, "%s_sum%s %g\n", s.name, wrapLabels(s.labels), s.sum)
+			fmt.Fprintf(&buf, "%s_count%s %d\n", s.name, wrapLabels(s.labels), s.count)
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// ServeHTTP 暴露 /metrics 端点
+func (m *MetricsRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WritePrometheus(w)
+}
+
+// Push 将当前指标推送到 Prometheus Pushgateway
+func (m *MetricsRegistry) Push(ctx context.Context, gatewayURL, job string) error {
+	var buf bytes.Buffer
+	if err := m.WritePrometheus(&buf); err != nil {
+		return err
+	}
+	target := strings.TrimSuffix(gatewayURL, "/") + "/metrics/job/" + job
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, target, &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("pushgateway 返回状态 %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// FlushMetrics 在退出前推送最终指标，未配置 push_gateway 时不做任何事
+func FlushMetrics(ctx context.Context, cfg *Config) error {
+	if cfg.Metrics.PushGateway == "" {
+		return nil
+	}
+	if err := metricsRegistry.Push(ctx, cfg.Metrics.PushGateway, cfg.Metrics.GetPushJob()); err != nil {
+		return err
+	}
+	LogGeneral("INFO", "最终指标已推送到 %s", cfg.Metrics.PushGateway)
+	return nil
+}
+
+func formatLabels(labels []string) string {
+	var parts []string
+	for i := 0; i+1 < len(labels); i += 2 {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[i+1])
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, labels[i], value))
+	}
+	return strings.Join(parts, ",")
+}
+
+func joinLabels(labels, extra string) string {
+	if labels == "" {
+		return extra
+	}
+	return labels + "," + extra
+}
+
+func wrapLabels(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsRegistry_WritePrometheus(t *testing.T) {
+	m := NewMetricsRegistry()
+	m.IncCounter("llm_proxy_requests_total", "model", "a", "backend", "b1", "result", "success")
+	m.IncCounter("llm_proxy_requests_total", "model", "a", "backend", "b1", "result", "success")
+	m.SetGauge("test_gauge", 3)
+	m.ObserveHistogram("llm_proxy_request_duration_seconds", 0.3, "model", "a")
+
+	var out strings.Builder
+	if err := m.WritePrometheus(&out); err != nil {
+		t.Fatalf("WritePrometheus failed: %v", err)
+	}
+	got := out.String()
+
+	want := []string{
+		"# TYPE llm_proxy_requests_total counter",
+		`llm_proxy_requests_total{model="a",backend="b1",result="success"} 2`,
+		"# TYPE test_gauge gauge",
+		"test_gauge 3",
+		"# TYPE llm_proxy_request_duration_seconds histogram",
+		`llm_proxy_request_duration_seconds_bucket{model="a",le="0.25"} 0`,
+		`llm_proxy_request_duration_seconds_bucket{model="a",le="0.5"} 1`,
+		`llm_proxy_request_duration_seconds_bucket{model="a",le="+Inf"} 1`,
+		`llm_proxy_request_duration_seconds_sum{model="a"} 0.3`,
+		`llm_proxy_request_duration_seconds_count{model="a"} 1`,
+	}
+	for _, line := range want {
+		if !strings.Contains(got, line+"\n") {
+			t.Errorf("output missing %q:\n%s", line, got)
+		}
+	}
+}
+
+func TestFormatLabels_Escaping(t *testing.T) {
+	got := formatLabels([]string{"model", `a"b\c`})
+	if got != `model="a\"b\\c"` {
+		t.Errorf("formatLabels() = %s", got)
+	}
+}
+
+func TestProxy_MetricsEndpoint(t *testing.T) {
+	calls := 0
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer backend.Close()
+
+	proxy := newTestProxy(&Config{
+		ProxyAPIKey: "sk-test",
+		Backends: []Backend{
+			{Name: "metrics-b1", URL: backend.URL},
+			{Name: "metrics-b2", URL: backend.URL},
+		},
+		Models: map[string]*ModelAlias{
+			"metrics-model": {Routes: []ModelRoute{
+				{Backend: "metrics-b1", Model: "m1", Priority: 1},
+				{Backend: "metrics-b2", Model: "m2", Priority: 2},
+			}},
+		},
+		Detection: Detection{ErrorCodes: []string{"5xx"}},
+	})
+
+	series := []struct {
+		name   string
+		labels []string
+	}{
+		{"llm_proxy_requests_total", []string{"model", "metrics-model", "backend", "metrics-b2", "protocol", "openai", "result", "success"}},
+		{"llm_proxy_fallbacks_total", []string{"model", "metrics-model", "backend", "metrics-b1"}},
+		{"llm_proxy_upstream_responses_total", []string{"backend", "metrics-b1", "code", "503"}},
+		{"llm_proxy_retries_total", []string{"model", "metrics-model"}},
+		{"llm_proxy_request_duration_seconds", []string{"model", "metrics-model", "backend", "metrics-b2", "protocol", "openai"}},
+	}
+	before := make([]float64, len(series))
+	for i, s := range series {
+		before[i] = metricsRegistry.CounterValue(s.name, s.labels...)
+	}
+
+	req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"metrics-model"}`))
+	req.Header.Set("Authorization", "Bearer sk-test")
+	proxy.ServeHTTP(httptest.NewRecorder(), req)
+
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	for i, s := range series {
+		if got := metricsRegistry.CounterValue(s.name, s.labels...); got != before[i]+1 {
+			t.Errorf("%s%v = %v, want %v", s.name, s.labels, got, before[i]+1)
+		}
+	}
+	if !strings.Contains(w.Body.String(), `llm_proxy_requests_total{model="metrics-model",backend="metrics-b2",protocol="openai",result="success"}`) {
+		t.Errorf("/metrics missing request series:\n%s", w.Body.String())
+	}
+}
+
+func TestProxy_MetricsLabels(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"type":"message","content":[]}`))
+	}))
+	defer backend.Close()
+
+	proxy := newTestProxy(&Config{
+		Backends: []Backend{{Name: "labels-claude", URL: backend.URL, Protocol: ProtocolAnthropic}},
+		Models: map[string]*ModelAlias{
+			"labels-ft-*": {Routes: []ModelRoute{{Backend: "labels-claude", Model: "claude-sonnet", Priority: 1}}},
+		},
+	})
+
+	labels := []string{"model", "labels-ft-*", "backend", "labels-claude", "protocol", ProtocolAnthropic, "result", "success"}
+	before := metricsRegistry.CounterValue("llm_proxy_requests_total", labels...)
+	for _, model := range []string{"labels-ft-a", "labels-ft-b", "labels-ft-c"} {
+		req := httptest.NewRequest("POST", "/v1/messages", strings.NewReader(`{"model":"`+model+`","max_tokens":16}`))
+		proxy.ServeHTTP(httptest.NewRecorder(), req)
+		if got := metricsRegistry.CounterValue("llm_proxy_requests_total", "model", model, "backend", "labels-claude", "protocol", ProtocolAnthropic, "result", "success"); got != 0 {
+			t.Errorf("requested model %s got its own series", model)
+		}
+	}
+	if got := metricsRegistry.CounterValue("llm_proxy_requests_total", labels...) - before; got != 3 {
+		t.Errorf("requests labelled with the pattern alias and protocol = %v, want 3", got)
+	}
+}
+
+func TestProxy_TokenUsageMetrics(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		want     map[string]float64
+	}{
+		{
+			name:     "openai",
+			response: `{"choices":[],"usage":{"prompt_tokens":11,"completion_tokens":5,"total_tokens":16}}`,
+			want:     map[string]float64{"prompt": 11, "completion": 5, "total": 16},
+		},
+		{
+			name:     "anthropic",
+			response: `{"type":"message","content":[],"usage":{"input_tokens":30,"output_tokens":8}}`,
+			want:     map[string]float64{"prompt": 30, "completion": 8, "total": 38},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}))
+			defer failing.Close()
+			serving := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(tt.response))
+			}))
+			defer serving.Close()
+
+			model := "tokens-" + tt.name
+			proxy := newTestProxy(&Config{
+				Backends: []Backend{
+					{Name: "tokens-failing", URL: failing.URL},
+					{Name: "tokens-serving", URL: serving.URL},
+				},
+				Models: map[string]*ModelAlias{
+					model: {Routes: []ModelRoute{
+						{Backend: "tokens-failing", Model: "m1", Priority: 1},
+						{Backend: "tokens-serving", Model: "m1", Priority: 2},
+					}},
+				},
+				Detection: Detection{ErrorCodes: []string{"5xx"}},
+			})
+
+			labels := func(backend, kind string) []string {
+				return []string{"model", model, "backend", backend, "type", kind, "estimated", "false"}
+			}
+			before := make(map[string]float64)
+			for kind := range tt.want {
+				before[kind] = metricsRegistry.CounterValue("llm_proxy_tokens_total", labels("tokens-serving", kind)...)
+			}
+
+			req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"`+model+`"}`))
+			proxy.ServeHTTP(httptest.NewRecorder(), req)
+
+			for kind, want := range tt.want {
+				if got := metricsRegistry.CounterValue("llm_proxy_tokens_total", labels("tokens-serving", kind)...) - before[kind]; got != want {
+					t.Errorf("%s tokens = %v, want %v", kind, got, want)
+				}
+				if got := metricsRegistry.CounterValue("llm_proxy_tokens_total", labels("tokens-failing", kind)...); got != 0 {
+					t.Errorf("%s tokens attributed to failed backend: %v", kind, got)
+				}
+			}
+		})
+	}
+}
+
+func TestRequestMetrics_RecordUsage(t *testing.T) {
+	m := NewRequestMetrics("req-1", "usage-fields-model")
+	usage, _ := parseUsage([]byte(`{"usage":{"input_tokens":4,"output_tokens":6}}`))
+	m.RecordUsage(usage, false)
+	m.Finish(true, "b1")
+
+	if m.Usage.PromptTokens != 4 || m.Usage.CompletionTokens != 6 || m.Usage.TotalTokens != 10 || m.UsageEstimated {
+		t.Errorf("unexpected usage fields: %+v estimated=%v", m.Usage, m.UsageEstimated)
+	}
+	if got := metricsRegistry.CounterValue("llm_proxy_tokens_total", "model", "usage-fields-model", "backend", "b1", "type", "total", "estimated", "false"); got != 10 {
+		t.Errorf("total tokens counter = %v, want 10", got)
+	}
+}
+
+func TestProxy_RejectionMetricsByReason(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer backend.Close()
+
+	tests := []struct {
+		reason string
+		limits func(*Config)
+	}{
+		{"rate_limit_global", func(c *Config) { c.RateLimit = RateLimitConfig{RPS: 0.01, Burst: 1} }},
+		{"rate_limit_model", func(c *Config) {
+			c.RateLimit = RateLimitConfig{Models: map[string]RateLimitRule{"model-a": {RPS: 0.01, Burst: 1}}}
+		}},
+		{"tpm", func(c *Config) {
+			c.RateLimit = RateLimitConfig{Models: map[string]RateLimitRule{"model-a": {TPM: 1}}}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.reason, func(t *testing.T) {
+			cfg := &Config{
+				Backends: []Backend{{Name: "b1", URL: backend.URL}},
+				Models: map[string]*ModelAlias{
+					"model-a": {Routes: []ModelRoute{{Backend: "b1", Model: "m1", Priority: 1}}},
+				},
+			}
+			tt.limits(cfg)
+			proxy := newTestProxy(cfg)
+			before := metricsRegistry.CounterValue("llm_proxy_rejections_total", "reason", tt.reason)
+
+			body := `{"model":"model-a","messages":[{"role":"user","content":"` + strings.Repeat("long prompt ", 50) + `"}]}`
+			var last *httptest.ResponseRecorder
+			for i := 0; i < 2; i++ {
+				last = httptest.NewRecorder()
+				proxy.ServeHTTP(last, httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body)))
+			}
+			if last.Code != http.StatusTooManyRequests {
+				t.Fatalf("status = %d, want 429", last.Code)
+			}
+			if got := metricsRegistry.CounterValue("llm_proxy_rejections_total", "reason", tt.reason); got != before+1 {
+				t.Errorf("rejections{reason=%s} = %v, want %v", tt.reason, got, before+1)
+			}
+		})
+	}
+}
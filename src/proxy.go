@@ -1,12 +1,22 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -14,20 +24,67 @@ import (
 )
 
 type Proxy struct {
-	configMgr *ConfigManager
-	router    *Router
-	cooldown  *CooldownManager
-	detector  *Detector
+	configMgr  *ConfigManager
+	router     *Router
+	cooldown   *CooldownManager
+	detector   *Detector
+	embeddings *EmbeddingBatcher
+	outbound   *OutboundLimiter
+	health     *HealthHandler
+	admin      *AdminHandler
+	keys       *KeyRotator
+	limiter    *RateLimiter
+	tpm        *TPMLimiter
+	inFlight   *ConcurrencyLimiter
 }
 
+// timeoutHeader 允许客户端覆盖单次请求的总超时（秒），不会转发给后端
+const timeoutHeader = "X-LLM-Proxy-Timeout"
+
+// maxAttemptsHeader 允许客户端限制单次请求的后端尝试次数，不会转发给后端
+const maxAttemptsHeader = "X-Max-Attempts"
+
+// sessionHeader 标识客户端会话，sticky_hash 策略据此将同一会话固定到同一后端，不会转发给后端
+const sessionHeader = "X-LLM-Proxy-Session"
+
+// adminKeyHeader 携带 admin_api_key，数据面请求带上它时才返回 X-Route-Decision 等调试信息，不会转发给后端
+const adminKeyHeader = "X-LLM-Proxy-Admin-Key"
+
+// anthropicVersion 是客户端未携带 anthropic-version 时发给 Anthropic 后端的默认版本；
+// 客户端自带的 anthropic-version 与 anthropic-beta 原样转发
+const anthropicVersion = "2023-06-01"
+
+// requestIDHeader 携带请求 ID：客户端传入合法值时沿用，否则使用代理生成的 ID；回写给客户端并转发给后端。
+// 后端自己返回的请求 ID 改用 upstreamRequestIDHeader 回写
+const (
+	requestIDHeader         = "X-Request-Id"
+	upstreamRequestIDHeader = "X-Upstream-Request-Id"
+)
+
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
 func NewProxy(cfg *ConfigManager, router *Router, cd *CooldownManager, det *Detector) *Proxy {
-	return &Proxy{configMgr: cfg, router: router, cooldown: cd, detector: det}
+	p := &Proxy{configMgr: cfg, router: router, cooldown: cd, detector: det, outbound: NewOutboundLimiter(), keys: NewKeyRotator(), limiter: NewRateLimiter(), tpm: NewTPMLimiter(), inFlight: NewConcurrencyLimiter()}
+	p.embeddings = NewEmbeddingBatcher(p)
+	p.health = NewHealthHandler(cfg, cd, router.breaker, router.health)
+	p.admin = NewAdminHandler(cfg, cd, router.breaker)
+	return p
 }
 
 func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if r.URL.Path == "/health" || r.URL.Path == "/healthz" {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("ok"))
+	switch r.URL.Path {
+	case "/health", "/healthz", "/health/detail", "/livez", "/readyz":
+		p.health.ServeHTTP(w, r)
+		return
+	}
+
+	if strings.HasPrefix(r.URL.Path, "/admin/") {
+		p.admin.ServeHTTP(w, r)
+		return
+	}
+
+	if r.URL.Path == "/metrics" {
+		metricsRegistry.ServeHTTP(w, r)
 		return
 	}
 
@@ -37,43 +94,328 @@ func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	cfg := p.configMgr.Get()
+	reqID := requestID(r)
+	w.Header().Set(requestIDHeader, reqID)
 
-	if cfg.ProxyAPIKey != "" {
-		auth := r.Header.Get("Authorization")
-		expected := "Bearer " + cfg.ProxyAPIKey
-		if auth != expected {
-			LogGeneral("WARN", "API Key 验证失败，客户端: %s", r.RemoteAddr)
-			http.Error(w, "无效的 API Key", http.StatusUnauthorized)
-			return
-		}
+	if cfg.Logging.AccessLog {
+		entry := &AccessLogEntry{Time: time.Now(), RequestID: reqID, ClientIP: clientIP(r), Method: r.Method, Path: r.URL.Path}
+		aw := &accessLogWriter{ResponseWriter: w}
+		w = aw
+		r = r.WithContext(withAccessEntry(r.Context(), entry))
+		defer func() {
+			entry.Status = aw.status
+			entry.BytesOut = aw.bytes
+			entry.LatencyMs = time.Since(entry.Time).Milliseconds()
+			writeAccessLog(entry)
+		}()
+	}
+
+	proxyKey, ok := authenticate(cfg, r)
+	if !ok {
+		LogGeneral("WARN", "API Key 验证失败，客户端: %s", r.RemoteAddr)
+		httpError(w, "无效的 API Key", http.StatusUnauthorized)
+		return
 	}
 
-	reqID := time.Now().Format("2006-01-02_15-04-05") + "_" + uuid.New().String()[:8]
+	reqCtx, cancel := context.WithTimeout(r.Context(), cfg.Timeout.RequestTimeout(r.Header.Get(timeoutHeader)))
+	defer cancel()
+	r = r.WithContext(reqCtx)
 
-	body, err := io.ReadAll(r.Body)
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, cfg.GetMaxBodyBytes()))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		LogGeneral("WARN", "[%s] 请求体超过上限 %d 字节，客户端: %s", reqID, tooLarge.Limit, r.RemoteAddr)
+		httpError(w, fmt.Sprintf("请求体超过 %d 字节上限", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+		return
+	}
 	if err != nil {
 		LogGeneral("ERROR", "[%s] 读取请求体失败: %v", reqID, err)
-		http.Error(w, "读取请求体失败", http.StatusBadRequest)
+		httpError(w, "读取请求体失败", http.StatusBadRequest)
 		return
 	}
 	r.Body = io.NopCloser(bytes.NewReader(body))
+	access := accessEntryFrom(r.Context())
+	access.BytesIn = int64(len(body))
+
+	if depth := cfg.GetMaxJSONDepth(); jsonDepthExceeds(body, depth) {
+		LogGeneral("WARN", "[%s] 请求体 JSON 嵌套超过 %d 层，客户端: %s", reqID, depth, r.RemoteAddr)
+		httpError(w, fmt.Sprintf("请求体 JSON 嵌套超过 %d 层", depth), http.StatusBadRequest)
+		return
+	}
 
 	var reqBody map[string]interface{}
 	json.Unmarshal(body, &reqBody)
 
+	if messages, _ := reqBody["messages"].([]interface{}); cfg.MaxMessages > 0 && len(messages) > cfg.MaxMessages {
+		LogGeneral("WARN", "[%s] 请求包含 %d 条消息，超过上限 %d", reqID, len(messages), cfg.MaxMessages)
+		httpError(w, fmt.Sprintf("消息数量超过 %d 条上限", cfg.MaxMessages), http.StatusBadRequest)
+		return
+	}
+
 	modelAlias, _ := reqBody["model"].(string)
+	if modelAlias == "" && cfg.DefaultModel != "" {
+		modelAlias = cfg.DefaultModel
+		LogGeneral("DEBUG", "[%s] 请求缺少 model 字段，使用默认模型 %s", reqID, modelAlias)
+	}
 	if modelAlias == "" {
 		LogGeneral("WARN", "[%s] 请求缺少 model 字段", reqID)
-		http.Error(w, "缺少 model 字段", http.StatusBadRequest)
+		httpError(w, "缺少 model 字段", http.StatusBadRequest)
 		return
 	}
 
 	LogGeneral("INFO", "[%s] 收到请求: 模型=%s 客户端=%s", reqID, modelAlias, r.RemoteAddr)
+	access.Model = modelAlias
+
+	if proxyKey != nil && !proxyKey.AllowsModel(modelAlias) {
+		LogGeneral("WARN", "[%s] API Key %s 无权访问模型 %s", reqID, proxyKey.Name, modelAlias)
+		httpError(w, fmt.Sprintf("无权访问模型 %s", modelAlias), http.StatusForbidden)
+		return
+	}
+
+	if _, present := reqBody["stream"]; !present && cfg.DefaultStream(modelAlias, r.Header.Get("Accept")) {
+		LogGeneral("DEBUG", "[%s] 请求未指定 stream，按模型默认值使用流式", reqID)
+		reqBody["stream"] = true
+		body, _ = json.Marshal(reqBody)
+	}
+	access.Stream, _ = reqBody["stream"].(bool)
+
+	allowed, limit := p.limiter.Allow(&cfg.RateLimit, r, modelAlias)
+	if limit != nil {
+		limit.SetHeaders(w.Header())
+	}
+	if !allowed {
+		LogGeneral("WARN", "[%s] 请求超出速率限制: 模型=%s 客户端=%s", reqID, modelAlias, r.RemoteAddr)
+		metricsRegistry.IncCounter("llm_proxy_rejections_total", "reason", rateLimitReason(limit))
+		httpError(w, "请求过于频繁", http.StatusTooManyRequests)
+		return
+	}
+
+	release, admitted := p.inFlight.Acquire(r.Context(), &cfg.Concurrency)
+	if !admitted {
+		LogGeneral("WARN", "[%s] 并发请求已满，排队超时: 模型=%s", reqID, modelAlias)
+		metricsRegistry.IncCounter("llm_proxy_rejections_total", "reason", "concurrency")
+		// 排队超时说明名额持续占满，建议客户端稍后重试
+		w.Header().Set("Retry-After", "1")
+		httpError(w, "代理繁忙，请稍后重试", http.StatusServiceUnavailable)
+		return
+	}
+	defer release()
+
+	reservation, allowed, wait := p.tpm.Reserve(&cfg.RateLimit, modelAlias, EstimatePromptTokens(reqBody))
+	if !allowed {
+		LogGeneral("WARN", "[%s] 请求超出模型 TPM 限制: 模型=%s", reqID, modelAlias)
+		metricsRegistry.IncCounter("llm_proxy_rejections_total", "reason", "tpm")
+		w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(wait)))
+		httpError(w, "超出模型每分钟 token 限制", http.StatusTooManyRequests)
+		return
+	}
+
+	var usage Usage
+	var hasUsage bool
+	if reservation != nil {
+		// 按实际用量校正预占；流式响应或后端未返回 usage 时保留估算的预占量，
+		// 请求未成功（后端错误、5xx、客户端提前断开等）时全部退回
+		sw := &accessLogWriter{ResponseWriter: w}
+		w = sw
+		defer func() {
+			switch {
+			case hasUsage:
+				p.tpm.Reconcile(reservation, usage.TotalTokens)
+			case sw.status < 200 || sw.status >= 300:
+				p.tpm.Reconcile(reservation, 0)
+			}
+		}()
+	}
+
+	if input, ok := singleEmbeddingInput(r.URL.Path, reqBody); ok && cfg.Embeddings.BatchingEnabled() {
+		p.embeddings.Submit(w, r, reqID, modelAlias, reqBody, input)
+		return
+	}
+
+	if isCompletionsPath(r.URL.Path) {
+		usage, hasUsage = p.handleLegacyCompletion(w, r, reqID, modelAlias, reqBody)
+	} else {
+		usage, hasUsage = p.forward(w, r, reqID, modelAlias, reqBody, body)
+	}
+}
+
+// backendTargetURL 将请求路径拼接到后端地址上；后端地址已包含路径前缀（如 /v1）时不重复拼接
+func backendTargetURL(backendURL, reqPath string) (*url.URL, error) {
+	targetURL, err := url.Parse(backendURL)
+	if err != nil {
+		return nil, err
+	}
+	backendPath := targetURL.Path
+	if backendPath != "" && strings.HasPrefix(reqPath, backendPath) {
+		targetURL.Path = reqPath
+	} else {
+		targetURL.Path = backendPath + reqPath
+	}
+	return targetURL, nil
+}
+
+// jsonDepthExceeds 在解析前扫描请求体，对象与数组的嵌套层数超过 max 时返回 true，
+// 避免对病态输入构建深层 map 以及后续递归处理
+func jsonDepthExceeds(body []byte, max int) bool {
+	depth := 0
+	inString, escaped := false, false
+	for _, c := range body {
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+		case '{', '[':
+			depth++
+			if depth > max {
+				return true
+			}
+		case '}', ']':
+			depth--
+		}
+	}
+	return false
+}
+
+// azureTargetURL 构造 Azure OpenAI 的部署地址：{url}/openai/deployments/{deployment}/{操作}?api-version=...，
+// 操作取自请求路径去掉 /v1 前缀后的部分，客户端的其他查询参数保留
+func azureTargetURL(backend *Backend, model, reqPath, rawQuery string) (*url.URL, error) {
+	targetURL, err := url.Parse(backend.URL)
+	if err != nil {
+		return nil, err
+	}
+	operation := strings.TrimPrefix(strings.TrimPrefix(reqPath, "/v1"), "/")
+	targetURL.Path = strings.TrimSuffix(targetURL.Path, "/") + "/openai/deployments/" + url.PathEscape(backend.GetDeployment(model)) + "/" + operation
+
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, err
+	}
+	query.Set("api-version", backend.GetAPIVersion())
+	targetURL.RawQuery = query.Encode()
+	return targetURL, nil
+}
+
+// cloneBody 浅拷贝请求体
+func cloneBody(body map[string]interface{}) map[string]interface{} {
+	cloned := make(map[string]interface{}, len(body))
+	for k, v := range body {
+		cloned[k] = v
+	}
+	return cloned
+}
+
+// authorized 校验代理 API Key，未配置时放行所有请求
+func authorized(cfg *Config, r *http.Request) bool {
+	_, ok := authenticate(cfg, r)
+	return ok
+}
+
+// authenticate 返回请求使用的代理 API Key；未配置任何 Key 时放行并返回 nil
+func authenticate(cfg *Config, r *http.Request) (*ProxyKey, bool) {
+	if !cfg.AuthEnabled() {
+		return nil, true
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return nil, false
+	}
+	return cfg.LookupProxyKey(token)
+}
+
+func newRequestID() string {
+	return time.Now().Format("2006-01-02_15-04-05") + "_" + uuid.New().String()[:8]
+}
+
+// requestID 沿用客户端传入的合法请求 ID，否则生成新的 ID
+func requestID(r *http.Request) string {
+	if id := r.Header.Get(requestIDHeader); requestIDPattern.MatchString(id) {
+		return id
+	}
+	return newRequestID()
+}
+
+// httpError 以 JSON 返回代理自身的错误，并附带响应头中的请求 ID 便于排查
+func httpError(w http.ResponseWriter, msg string, code int) {
+	body := map[string]string{"message": msg, "type": "proxy_error"}
+	if id := w.Header().Get(requestIDHeader); id != "" {
+		body["request_id"] = id
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{"error": body})
+}
+
+// isConnectError 判断错误是否发生在与后端建立连接阶段（DNS 解析、拨号），此时请求尚未发出
+func isConnectError(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// maxUpstreamErrorSnippet 是包装非 JSON 错误响应时保留的上游响应体长度上限
+const maxUpstreamErrorSnippet = 512
+
+// writeUpstreamError 把后端的错误响应转发给客户端。响应体是 JSON 时原样转发；
+// HTML 错误页或纯文本等非 JSON 内容包装成统一的 JSON 错误，保留原始状态码与截断后的原文
+func writeUpstreamError(w http.ResponseWriter, status int, body []byte) {
+	if json.Valid(body) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write(body)
+		return
+	}
+
+	snippet := strings.Join(strings.Fields(string(body)), " ")
+	if len(snippet) > maxUpstreamErrorSnippet {
+		snippet = strings.ToValidUTF8(snippet[:maxUpstreamErrorSnippet], "") + "..."
+	}
+	msg := map[string]interface{}{
+		"message":         fmt.Sprintf("后端返回了非 JSON 的错误响应: %d %s", status, http.StatusText(status)),
+		"type":            "upstream_error",
+		"upstream_status": status,
+		"upstream_body":   snippet,
+	}
+	if id := w.Header().Get(requestIDHeader); id != "" {
+		msg["request_id"] = id
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"error": msg})
+}
+
+// forward 解析路由并依次尝试后端，直到成功或全部失败；
+// 非流式成功响应带有 usage 时返回实际消耗的 token
+func (p *Proxy) forward(w http.ResponseWriter, r *http.Request, reqID, modelAlias string, reqBody map[string]interface{}, body []byte) (usage Usage, hasUsage bool) {
+	cfg := p.configMgr.Get()
 
-	routes, _ := p.router.Resolve(modelAlias)
+	var decision string
+	routes, trace := p.router.ResolveSession(modelAlias, r.Header.Get(sessionHeader), cfg.Logging.RouteTrace)
+	if trace != nil {
+		decision = trace.String()
+		LogGeneral("DEBUG", "[%s] 路由决策: %s", reqID, decision)
+		// 路由决策包含后端名称等部署信息，只返回给携带 admin_api_key 的调用方
+		if debugAuthorized(cfg, r) {
+			w.Header().Set("X-Route-Decision", decision)
+		}
+	}
 	if len(routes) == 0 {
 		LogGeneral("WARN", "[%s] 未知的模型别名: %s", reqID, modelAlias)
-		http.Error(w, fmt.Sprintf("未知的模型别名: %s", modelAlias), http.StatusBadRequest)
+		httpError(w, fmt.Sprintf("未知的模型别名: %s", modelAlias), http.StatusBadRequest)
 		return
 	}
 
@@ -88,27 +430,56 @@ func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	logBuilder.WriteString(fmt.Sprintf("================== 请求日志 ==================\n"))
 	logBuilder.WriteString(fmt.Sprintf("请求ID: %s\n时间: %s\n客户端: %s\n\n", reqID, time.Now().Format(time.RFC3339), r.RemoteAddr))
 	logBuilder.WriteString("--- 请求头 ---\n")
-	for k, v := range r.Header {
-		logBuilder.WriteString(fmt.Sprintf("%s: %s\n", k, strings.Join(v, ", ")))
-	}
+	logBuilder.WriteString(formatLoggedHeaders(r.Header))
 	logBuilder.WriteString("\n--- 请求体 ---\n")
-	logBuilder.WriteString(string(body))
+	logBuilder.Write(redactBody(body, cfg.Logging.RedactFields))
 	logBuilder.WriteString("\n")
+	if decision != "" {
+		logBuilder.WriteString("\n--- 路由决策 ---\n" + decision + "\n")
+	}
 
 	var lastErr error
 	var lastStatus int
 	var lastBody string
-
-	maxRetries := cfg.Fallback.MaxRetries
-	if maxRetries <= 0 {
-		maxRetries = len(routes)
+	var unsupported []string
+	aliasCfg, _ := cfg.LookupModel(modelAlias)
+	operation := r.URL.Path
+	if isEmbeddingsPath(operation) {
+		operation = "embeddings"
 	}
 
+	maxRetries := cfg.MaxAttempts(modelAlias, len(routes), r.Header.Get(maxAttemptsHeader))
+
 	metrics := NewRequestMetrics(reqID, modelAlias)
+	metricAlias := cfg.ConfiguredAlias(modelAlias)
+	metrics.MetricAlias = metricAlias
 	var finalBackend string
 
-	for i, route := range routes {
-		if i >= maxRetries {
+	// 修改请求后的重试与换 Key 重试不占用 max_retries 名额
+	mutated := make(map[int]bool)
+	keyRetries := make(map[string]int)
+	keyRetried := 0
+	attempted := false
+	// 单次尝试占用的半开探测名额、上游请求与在途计数在进入下一次尝试或返回时释放，
+	// 被放弃的尝试立即取消，不在循环中堆叠 defer 拖到整个请求结束
+	var attemptCleanups []func()
+	endAttempt := func() {
+		for j := len(attemptCleanups) - 1; j >= 0; j-- {
+			attemptCleanups[j]()
+		}
+		attemptCleanups = nil
+	}
+	defer endAttempt()
+	for i := 0; i < len(routes); i++ {
+		endAttempt()
+		if i-len(mutated)-keyRetried >= maxRetries {
+			break
+		}
+		route := routes[i]
+		// 所有尝试共用请求的总超时，剩余时间不足以完成一次调用时直接返回最后的失败
+		if deadline, ok := r.Context().Deadline(); ok && attempted && time.Until(deadline) < cfg.Timeout.GetMinAttempt() {
+			logBuilder.WriteString(fmt.Sprintf("\n剩余时间 %v 不足，停止尝试\n", time.Until(deadline).Round(time.Millisecond)))
+			LogGeneral("WARN", "[%s] 请求总超时剩余不足 %v，不再尝试后端 %s", reqID, cfg.Timeout.GetMinAttempt(), route.BackendName)
 			break
 		}
 
@@ -116,102 +487,353 @@ func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		logBuilder.WriteString(fmt.Sprintf("后端: %s\n模型: %s\n", route.BackendName, route.Model))
 		LogGeneral("DEBUG", "[%s] 尝试后端 %s (模型: %s)", reqID, route.BackendName, route.Model)
 
-		modifiedBody := make(map[string]interface{})
-		for k, v := range reqBody {
-			modifiedBody[k] = v
+		backend := p.configMgr.GetBackend(route.BackendName)
+		if backend != nil && !backend.SupportsPath(r.URL.Path) {
+			unsupported = append(unsupported, route.BackendName)
+			logBuilder.WriteString(fmt.Sprintf("跳过: %s 协议后端不支持 %s\n", backend.GetProtocol(), operation))
+			LogGeneral("DEBUG", "[%s] 跳过不支持 %s 的后端: %s (%s)", reqID, operation, route.BackendName, backend.GetProtocol())
+			continue
 		}
+		switch p.outbound.Acquire(r.Context(), backend) {
+		case rateFallback:
+			logBuilder.WriteString("跳过: 超出后端速率限制\n")
+			LogGeneral("WARN", "[%s] 后端 %s 超出速率限制，尝试下一个后端", reqID, route.BackendName)
+			continue
+		case rateRejected:
+			logBuilder.WriteString("\n--- 最终结果 ---\n超出后端速率限制，拒绝请求\n")
+			LogGeneral("WARN", "[%s] 后端 %s 超出速率限制，拒绝请求", reqID, route.BackendName)
+			metricsRegistry.IncCounter("llm_proxy_rejections_total", "reason", "backend_rate_limit")
+			WriteRequestLog(cfg, reqID, logBuilder.String())
+			metrics.Finish(false, "")
+			httpError(w, fmt.Sprintf("后端 %s 超出速率限制", route.BackendName), http.StatusTooManyRequests)
+			return
+		}
+
+		routeKey := p.cooldown.Key(route.BackendName, route.Model)
+		releaseProbe, allowed := p.router.breaker.Allow(routeKey)
+		if !allowed {
+			logBuilder.WriteString("跳过: 熔断中\n")
+			LogGeneral("DEBUG", "[%s] 跳过熔断中的后端: %s", reqID, routeKey)
+			continue
+		}
+		// 客户端断开、超时等未记录成败就结束的路径由此释放半开探测名额
+		attemptCleanups = append(attemptCleanups, releaseProbe)
+
+		modifiedBody := cloneBody(reqBody)
 		modifiedBody["model"] = route.Model
+		tc := &TransformContext{ReqID: reqID, ModelAlias: modelAlias, Backend: backend, Model: route.Model, Alias: aliasCfg, ClientIP: clientIP(r)}
+		transforms := resolveTransforms(route.Transforms)
+		for _, note := range applyRequestTransforms(transforms, tc, modifiedBody) {
+			logBuilder.WriteString(note + "\n")
+			LogGeneral("DEBUG", "[%s] 后端 %s: %s", reqID, route.BackendName, note)
+		}
 
+		// 热重载可能在路由与转发之间移除后端，此时 backend 为 nil，按 OpenAI 兼容协议转发
+		protocol := ProtocolOpenAI
+		if backend != nil {
+			protocol = backend.GetProtocol()
+		}
+		bedrock := protocol == ProtocolBedrock
+		if bedrock {
+			modifiedBody = bedrockBody(modifiedBody, r.Header)
+		}
 		newBody, _ := json.Marshal(modifiedBody)
 
-		targetURL, err := url.Parse(route.BackendURL)
+		var targetURL *url.URL
+		var err error
+		if bedrock {
+			targetURL, err = bedrockTargetURL(backend, route.Model, isStream)
+		} else if protocol == ProtocolAzure {
+			targetURL, err = azureTargetURL(backend, route.Model, r.URL.Path, r.URL.RawQuery)
+		} else {
+			targetURL, err = backendTargetURL(route.BackendURL, r.URL.Path)
+			if err == nil {
+				targetURL.RawQuery = r.URL.RawQuery
+			}
+		}
 		if err != nil {
 			lastErr = err
 			logBuilder.WriteString(fmt.Sprintf("解析后端URL失败: %v\n", err))
 			LogGeneral("ERROR", "[%s] 解析后端URL失败: %v", reqID, err)
+			p.router.breaker.RecordFailure(routeKey)
 			continue
 		}
 
-		backendPath := targetURL.Path
-		reqPath := r.URL.Path
-		if backendPath != "" && strings.HasPrefix(reqPath, backendPath) {
-			targetURL.Path = reqPath
-		} else {
-			targetURL.Path = backendPath + reqPath
-		}
-		targetURL.RawQuery = r.URL.RawQuery
-
 		logBuilder.WriteString(fmt.Sprintf("目标URL: %s\n", targetURL.String()))
 
-		proxyReq, _ := http.NewRequest(r.Method, targetURL.String(), bytes.NewReader(newBody))
-		for k, v := range r.Header {
-			proxyReq.Header[k] = v
-		}
+		upstreamCtx, cancelUpstream := context.WithCancel(r.Context())
+		attemptCleanups = append(attemptCleanups, cancelUpstream)
+		proxyReq, _ := http.NewRequestWithContext(upstreamCtx, r.Method, targetURL.String(), bytes.NewReader(newBody))
+		copyUpstreamHeaders(proxyReq.Header, r.Header, &cfg.Headers)
+		proxyReq.Header.Del(timeoutHeader)
+		proxyReq.Header.Del(maxAttemptsHeader)
+		proxyReq.Header.Del(sessionHeader)
+		proxyReq.Header.Del(adminKeyHeader)
+		proxyReq.Header.Set(requestIDHeader, reqID)
 		proxyReq.Header.Set("Content-Length", fmt.Sprintf("%d", len(newBody)))
+		if protocol == ProtocolAnthropic && proxyReq.Header.Get("anthropic-version") == "" {
+			proxyReq.Header.Set("anthropic-version", anthropicVersion)
+		}
 
-		backend := p.configMgr.GetBackend(route.BackendName)
-		if backend != nil && backend.APIKey != "" {
-			proxyReq.Header.Set("Authorization", "Bearer "+backend.APIKey)
+		apiKey, keyIdx := p.keys.NextAPIKey(backend)
+		switch {
+		case bedrock:
+			if err := signBedrockRequest(proxyReq, newBody, backend.AWS, time.Now()); err != nil {
+				lastErr = err
+				logBuilder.WriteString(fmt.Sprintf("签名失败: %v\n", err))
+				LogGeneral("ERROR", "[%s] 后端 %s 请求签名失败: %v", reqID, route.BackendName, err)
+				releaseProbe()
+				continue
+			}
+		case apiKey == "":
+		case protocol == ProtocolAzure:
+			// Azure OpenAI 使用 api-key 头认证，不能带上客户端的 Authorization
+			proxyReq.Header.Del("Authorization")
+			proxyReq.Header.Set("api-key", apiKey)
+		case protocol == ProtocolAnthropic:
+			// Anthropic 原生接口使用 x-api-key 头认证
+			proxyReq.Header.Del("Authorization")
+			proxyReq.Header.Set("x-api-key", apiKey)
+		default:
+			proxyReq.Header.Set("Authorization", "Bearer "+apiKey)
 		}
 
-		client := &http.Client{Timeout: 5 * time.Minute}
+		var backendTLS *BackendTLS
+		if backend != nil {
+			backendTLS = backend.TLS
+		}
+		client := p.router.clients.Get(route.BackendName, cfg.ConnectionPool.For(backend), backendTLS)
+		release := p.router.inflight.Begin(route.BackendName)
+		attemptCleanups = append(attemptCleanups, release)
 		backendStart := time.Now()
+		attempted = true
 		resp, err := client.Do(proxyReq)
 		backendDuration := time.Since(backendStart)
 		metrics.RecordBackendTime(route.BackendName, backendDuration)
+		access := accessEntryFrom(r.Context())
+		access.Attempts++
+		access.Backend = route.BackendName
+		if backend != nil {
+			access.Protocol = protocol
+		}
+		metrics.Protocol = protocol
 
+		if err != nil && errors.Is(r.Context().Err(), context.DeadlineExceeded) {
+			// 请求总超时已用尽，剩余后端也没有时间可用
+			release()
+			logBuilder.WriteString(fmt.Sprintf("请求超时: %v\n", err))
+			LogGeneral("WARN", "[%s] 请求超时，后端 %s 未在时限内响应", reqID, route.BackendName)
+			WriteRequestLog(cfg, reqID, logBuilder.String())
+			metrics.Finish(false, "")
+			httpError(w, "请求超时", http.StatusGatewayTimeout)
+			return
+		}
+		if err != nil && r.Context().Err() != nil {
+			// 客户端已断开，不是后端故障，不冷却也不再尝试其他后端
+			release()
+			logBuilder.WriteString("客户端已断开，取消请求\n")
+			LogGeneral("INFO", "[%s] 客户端在后端 %s 响应前断开，取消请求", reqID, route.BackendName)
+			WriteRequestLog(cfg, reqID, logBuilder.String())
+			metrics.Finish(false, "")
+			return
+		}
 		if err != nil {
+			release()
 			lastErr = err
 			logBuilder.WriteString(fmt.Sprintf("请求失败: %v\n", err))
 			LogGeneral("WARN", "[%s] 后端 %s 请求失败: %v", reqID, route.BackendName, err)
-			key := p.cooldown.Key(route.BackendName, route.Model)
-			p.cooldown.SetCooldown(key, time.Duration(cfg.Fallback.CooldownSeconds)*time.Second)
+			p.router.breaker.RecordFailure(routeKey)
+			p.router.scores.Record(route.BackendName, backendDuration, false)
+			if isConnectError(err) {
+				// 连接或 DNS 失败说明请求没有到达后端，立即换下一个后端，只做基础冷却，
+				// 逐次递增的冷却留给后端过载等真正处理过请求的失败
+				p.cooldown.SetCooldown(routeKey, cfg.Fallback.Cooldown())
+			} else {
+				p.cooldown.SetCooldown(routeKey, p.cooldown.Escalate(routeKey, &cfg.Fallback))
+			}
+			metricsRegistry.IncCounter("llm_proxy_upstream_responses_total", "backend", route.BackendName, "code", "error")
+			metricsRegistry.IncCounter("llm_proxy_fallbacks_total", "model", metricAlias, "backend", route.BackendName)
 			continue
 		}
 
+		metricsRegistry.IncCounter("llm_proxy_upstream_responses_total", "backend", route.BackendName, "code", strconv.Itoa(resp.StatusCode))
+
 		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			var respBody []byte
+			var streamBody io.Reader
+			var empty bool
+			var streamErr error
+			var headerSent bool
+			if isStream && bedrock {
+				// Bedrock 以 AWS event stream 返回流式响应，转换为 Anthropic SSE 后再转发
+				resp.Body = bedrockEventStream(resp.Body)
+				resp.Header.Set("Content-Type", "text/event-stream")
+				resp.Header.Del("Content-Length")
+			}
+			if isStream {
+				// 等待首个事件期间同样发送心跳，首次心跳时写出响应头
+				prelude := startStreamPrelude(w, cfg.Streaming.GetHeartbeatInterval(), func() {
+					copyResponseHeaders(w, resp)
+					w.WriteHeader(resp.StatusCode)
+				})
+				streamBody, empty, streamErr = peekStream(resp.Body)
+				headerSent = prelude.Stop()
+			} else {
+				respBody = readResponseBody(resp)
+				empty = len(bytes.TrimSpace(respBody)) == 0
+			}
+			if streamErr != nil && r.Context().Err() == nil {
+				// 尚未向客户端写入任何内容，流中断视为后端故障，回退到下一个后端
+				resp.Body.Close()
+				release()
+				lastErr = fmt.Errorf("后端 %s 流式响应在首个事件前中断: %w", route.BackendName, streamErr)
+				logBuilder.WriteString(fmt.Sprintf("状态: %d 流在首个事件前中断: %v\n操作: 冷却 %s，尝试下一个后端\n", resp.StatusCode, streamErr, routeKey))
+				LogGeneral("WARN", "[%s] 后端 %s 流式响应在首个事件前中断: %v，触发回退", reqID, route.BackendName, streamErr)
+				p.router.breaker.RecordFailure(routeKey)
+				p.router.scores.Record(route.BackendName, backendDuration, false)
+				p.cooldown.SetCooldown(routeKey, p.cooldown.Escalate(routeKey, &cfg.Fallback))
+				if headerSent {
+					p.abortCommittedStream(cfg, reqID, &logBuilder, metrics, route.BackendName)
+					return
+				}
+				metricsRegistry.IncCounter("llm_proxy_fallbacks_total", "model", metricAlias, "backend", route.BackendName)
+				continue
+			}
+			if empty && resp.StatusCode != http.StatusNoContent && cfg.Detection.ShouldFallbackOnEmpty() {
+				resp.Body.Close()
+				release()
+				lastStatus = http.StatusBadGateway
+				lastBody = fmt.Sprintf("后端 %s 返回空响应", route.BackendName)
+				logBuilder.WriteString(fmt.Sprintf("状态: %d 空响应\n操作: 冷却 %s，尝试下一个后端\n", resp.StatusCode, routeKey))
+				LogGeneral("WARN", "[%s] 后端 %s 返回空响应: 状态=%d，触发回退", reqID, route.BackendName, resp.StatusCode)
+				p.router.breaker.RecordFailure(routeKey)
+				p.router.scores.Record(route.BackendName, backendDuration, false)
+				p.cooldown.SetCooldown(routeKey, p.cooldown.Escalate(routeKey, &cfg.Fallback))
+				if headerSent {
+					p.abortCommittedStream(cfg, reqID, &logBuilder, metrics, route.BackendName)
+					return
+				}
+				metricsRegistry.IncCounter("llm_proxy_fallbacks_total", "model", metricAlias, "backend", route.BackendName)
+				continue
+			}
+
+			p.router.breaker.RecordSuccess(routeKey)
+			p.router.scores.Record(route.BackendName, backendDuration, true)
+			p.cooldown.RecordSuccess(routeKey)
 			logBuilder.WriteString(fmt.Sprintf("状态: %d 成功\n", resp.StatusCode))
 			LogGeneral("INFO", "[%s] 请求成功: 后端=%s 状态=%d 耗时=%dms", reqID, route.BackendName, resp.StatusCode, backendDuration.Milliseconds())
 			WriteRequestLog(cfg, reqID, logBuilder.String())
 
 			finalBackend = route.BackendName
-			metrics.Finish(true, finalBackend)
 
-			for k, v := range resp.Header {
-				w.Header()[k] = v
+			if !headerSent {
+				copyResponseHeaders(w, resp)
 			}
-			w.WriteHeader(resp.StatusCode)
 
 			if isStream {
-				p.streamResponse(w, resp.Body)
+				// 客户端断开或关闭排空超时时立即取消上游请求并关闭连接，不等到下一次写入失败
+				stop := context.AfterFunc(r.Context(), func() {
+					LogGeneral("INFO", "[%s] 客户端断开、请求超时或服务关闭，取消后端 %s 的流式请求", reqID, route.BackendName)
+					cancelUpstream()
+					resp.Body.Close()
+				})
+				if !headerSent {
+					w.WriteHeader(resp.StatusCode)
+				}
+				counter := &streamUsageCounter{}
+				p.streamResponse(w, io.TeeReader(streamBody, counter), p.streamFilters(cfg, r, modelAlias), &cfg.Streaming)
+				stop()
+				streamUsage, estimated := counter.Usage()
+				metrics.RecordUsage(streamUsage, estimated)
+				if !estimated {
+					// 估算值不用于 TPM 校正与配额统计，保留预占量
+					usage, hasUsage = streamUsage, true
+					p.router.RecordUsage(route.BackendName, usage.TotalTokens)
+				}
 			} else {
-				io.Copy(w, resp.Body)
+				if usage, hasUsage = parseUsage(respBody); hasUsage {
+					p.router.RecordUsage(route.BackendName, usage.TotalTokens)
+					metrics.RecordUsage(usage, false)
+				}
+				if transformed := applyResponseTransforms(transforms, tc, respBody); !bytes.Equal(transformed, respBody) {
+					respBody = transformed
+					w.Header().Del("Content-Length")
+				}
+				if idx, ok := cfg.KeepChoice(modelAlias); ok {
+					if selected, changed := selectChoice(respBody, idx); changed {
+						respBody = selected
+						w.Header().Del("Content-Length")
+					}
+				}
+				w.WriteHeader(resp.StatusCode)
+				w.Write(respBody)
 			}
 			resp.Body.Close()
+			if price, ok := cfg.Pricing.PriceFor(modelAlias, finalBackend); ok {
+				metrics.Cost = EstimateCost(metrics.Usage, price)
+			}
+			accessEntryFrom(r.Context()).Cost = metrics.Cost
+			// usage 在转发响应时才能得到，指标在此之后汇总
+			metrics.Finish(true, finalBackend)
 			return
 		}
 
-		respBody, _ := io.ReadAll(resp.Body)
+		respBody := readResponseBody(resp)
 		resp.Body.Close()
+		release()
 		lastStatus = resp.StatusCode
 		lastBody = string(respBody)
 
 		logBuilder.WriteString(fmt.Sprintf("状态: %d\n响应: %s\n", resp.StatusCode, lastBody))
 		LogGeneral("WARN", "[%s] 后端 %s 返回错误: 状态=%d", reqID, route.BackendName, resp.StatusCode)
 
-		if p.detector.ShouldFallback(resp.StatusCode, lastBody) {
-			key := p.cooldown.Key(route.BackendName, route.Model)
-			p.cooldown.SetCooldown(key, time.Duration(cfg.Fallback.CooldownSeconds)*time.Second)
-			logBuilder.WriteString(fmt.Sprintf("操作: 冷却 %s，尝试下一个后端\n", key))
-			LogGeneral("INFO", "[%s] 触发回退: %s 进入冷却", reqID, key)
+		retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		if resp.StatusCode == http.StatusTooManyRequests && backend != nil && len(backend.Keys()) > 1 &&
+			p.keys.Cooldown(backend, apiKey, cfg.Fallback.ApplyRetryAfter(backend.GetKeyCooldown(), retryAfter)) {
+			// 仅冷却触发限流的 Key，换用后端的其余 Key 重试同一路由，每个 Key 至多一次
+			LogGeneral("INFO", "[%s] 后端 %s 的第 %d 个 Key 被限流，冷却该 Key", reqID, route.BackendName, keyIdx+1)
+			releaseProbe()
+			if keyRetries[route.BackendName] < len(backend.Keys())-1 {
+				keyRetries[route.BackendName]++
+				keyRetried++
+				logBuilder.WriteString(fmt.Sprintf("操作: 冷却后端 %s 的第 %d 个 Key，换用其他 Key 重试\n", route.BackendName, keyIdx+1))
+				routes = append(routes[:i+1:i+1], append([]ResolvedRoute{route}, routes[i+1:]...)...)
+				continue
+			}
+			logBuilder.WriteString(fmt.Sprintf("操作: 冷却后端 %s 的第 %d 个 Key，尝试下一个后端\n", route.BackendName, keyIdx+1))
+			metricsRegistry.IncCounter("llm_proxy_fallbacks_total", "model", metricAlias, "backend", route.BackendName)
+			continue
+		}
+
+		if idx, m := p.detector.MatchMutation(resp.StatusCode, lastBody); m != nil && !mutated[idx] {
+			// 每条规则每个请求只应用一次，修改后立即重试同一路由
+			mutated[idx] = true
+			reqBody = cloneBody(reqBody)
+			notes := strings.Join(applyMutation(reqBody, m), ", ")
+			logBuilder.WriteString(fmt.Sprintf("操作: 修改请求后重试 (%s)\n", notes))
+			LogGeneral("INFO", "[%s] 后端 %s 失败匹配重试修改规则 #%d: %s", reqID, route.BackendName, idx+1, notes)
+			releaseProbe()
+			routes = append(routes[:i+1:i+1], append([]ResolvedRoute{route}, routes[i+1:]...)...)
+			continue
+		}
+
+		if p.detector.ShouldFallback(protocol, resp.StatusCode, lastBody) {
+			p.router.breaker.RecordFailure(routeKey)
+			p.router.scores.Record(route.BackendName, backendDuration, false)
+			p.cooldown.SetCooldown(routeKey, cfg.Fallback.ApplyRetryAfter(p.cooldown.Escalate(routeKey, &cfg.Fallback), retryAfter))
+			logBuilder.WriteString(fmt.Sprintf("操作: 冷却 %s，尝试下一个后端\n", routeKey))
+			LogGeneral("INFO", "[%s] 触发回退: %s 进入冷却", reqID, routeKey)
+			metricsRegistry.IncCounter("llm_proxy_fallbacks_total", "model", metricAlias, "backend", route.BackendName)
 			continue
 		}
 
+		p.router.breaker.RecordSuccess(routeKey)
+		p.router.scores.Record(route.BackendName, backendDuration, true)
+		p.cooldown.RecordSuccess(routeKey)
 		WriteRequestLog(cfg, reqID, logBuilder.String())
 		finalBackend = route.BackendName
 		metrics.Finish(false, finalBackend)
-		w.WriteHeader(resp.StatusCode)
-		w.Write(respBody)
+		writeUpstreamError(w, resp.StatusCode, respBody)
 		return
 	}
 
@@ -223,17 +845,188 @@ func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	metrics.Finish(false, "")
 
 	if lastErr != nil {
-		http.Error(w, fmt.Sprintf("所有后端均失败: %v", lastErr), http.StatusBadGateway)
+		httpError(w, fmt.Sprintf("所有后端均失败: %v", lastErr), http.StatusBadGateway)
+		return
+	}
+	if lastStatus == 0 && len(unsupported) > 0 {
+		httpError(w, fmt.Sprintf("模型 %s 的后端不支持 %s: %s", modelAlias, operation, strings.Join(unsupported, ", ")), http.StatusBadRequest)
+		return
+	}
+	if lastStatus == 0 {
+		httpError(w, "没有可用的后端", http.StatusServiceUnavailable)
 		return
 	}
-	w.WriteHeader(lastStatus)
-	w.Write([]byte(lastBody))
+	writeUpstreamError(w, lastStatus, []byte(lastBody))
+	return
 }
 
-func (p *Proxy) streamResponse(w http.ResponseWriter, body io.ReadCloser) {
+// filterBodyFields 删除不在允许列表中的请求体字段（model 始终保留），返回被删除的字段名
+func filterBodyFields(body map[string]interface{}, allowed []string) []string {
+	allowSet := make(map[string]bool, len(allowed)+1)
+	for _, f := range allowed {
+		allowSet[f] = true
+	}
+	allowSet["model"] = true
+
+	var dropped []string
+	for k := range body {
+		if !allowSet[k] {
+			dropped = append(dropped, k)
+			delete(body, k)
+		}
+	}
+	sort.Strings(dropped)
+	return dropped
+}
+
+// ensureSystemPrompt 在请求没有非空系统提示词时补充 prompt，返回是否做了修改。
+// 兼容 Anthropic 风格的顶层 system 字段与 OpenAI 风格的 system 消息。
+func ensureSystemPrompt(body map[string]interface{}, prompt string) bool {
+	if system, exists := body["system"]; exists {
+		if s, ok := system.(string); ok && strings.TrimSpace(s) == "" {
+			body["system"] = prompt
+			return true
+		}
+		return false
+	}
+
+	messages, ok := body["messages"].([]interface{})
+	if !ok {
+		return false
+	}
+	for i, m := range messages {
+		msg, _ := m.(map[string]interface{})
+		if msg == nil || (msg["role"] != "system" && msg["role"] != "developer") {
+			continue
+		}
+		if content, ok := msg["content"].(string); ok && strings.TrimSpace(content) == "" {
+			filled := make(map[string]interface{}, len(msg))
+			for k, v := range msg {
+				filled[k] = v
+			}
+			filled["content"] = prompt
+			updated := make([]interface{}, len(messages))
+			copy(updated, messages)
+			updated[i] = filled
+			body["messages"] = updated
+			return true
+		}
+		return false
+	}
+
+	updated := make([]interface{}, 0, len(messages)+1)
+	updated = append(updated, map[string]interface{}{"role": "system", "content": prompt})
+	updated = append(updated, messages...)
+	body["messages"] = updated
+	return true
+}
+
+// streamFilters 根据配置构建流式响应的逐行改写链
+func (p *Proxy) streamFilters(cfg *Config, r *http.Request, modelAlias string) []sseLineFilter {
+	var filters []sseLineFilter
+	if cfg.Streaming.AnthropicUsage && strings.HasSuffix(r.URL.Path, "/messages") {
+		filters = append(filters, newAnthropicUsageTrailer().Filter)
+	}
+	if idx, ok := cfg.KeepChoice(modelAlias); ok {
+		filters = append(filters, newChoiceSelector(idx).Filter)
+	}
+	if cfg.Streaming.NormalizeToolCalls {
+		filters = append(filters, newToolCallIndexer().Filter)
+	}
+	if cfg.Streaming.DedupeRole {
+		filters = append(filters, newRoleDeduper().Filter)
+	}
+	if isLegacyCompletion(r) {
+		filters = append(filters, completionStreamConverter)
+	}
+	return filters
+}
+
+// readResponseBody 读取非流式响应体；gzip/deflate 编码的响应先解压，便于解析 usage、
+// 应用响应转换与匹配错误关键字，并移除编码相关响应头。解压失败时原样返回
+func readResponseBody(resp *http.Response) []byte {
+	body, _ := io.ReadAll(resp.Body)
+	var reader io.ReadCloser
+	var err error
+	switch strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))) {
+	case "gzip":
+		reader, err = gzip.NewReader(bytes.NewReader(body))
+	case "deflate":
+		// HTTP 的 deflate 通常带 zlib 头，少数服务端发送原始 deflate 数据
+		if reader, err = zlib.NewReader(bytes.NewReader(body)); err != nil {
+			reader, err = flate.NewReader(bytes.NewReader(body)), nil
+		}
+	default:
+		return body
+	}
+	if err != nil {
+		return body
+	}
+	defer reader.Close()
+	decoded, err := io.ReadAll(reader)
+	if err != nil {
+		return body
+	}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	return decoded
+}
+
+// copyResponseHeaders 将后端响应头复制给客户端，后端的请求 ID 改用 upstreamRequestIDHeader 转发
+func copyResponseHeaders(w http.ResponseWriter, resp *http.Response) {
+	for k, v := range resp.Header {
+		if k == requestIDHeader {
+			k = upstreamRequestIDHeader
+		}
+		w.Header()[k] = v
+	}
+}
+
+// abortCommittedStream 结束首个事件前失败、但心跳已写出响应头的流式请求：状态码已发送，无法再回退，
+// 只能关闭响应
+func (p *Proxy) abortCommittedStream(cfg *Config, reqID string, logBuilder *strings.Builder, metrics *RequestMetrics, backendName string) {
+	logBuilder.WriteString("操作: 心跳已写出响应头，无法回退，结束响应\n")
+	LogGeneral("WARN", "[%s] 后端 %s 的流式响应在首个事件前失败，响应头已随心跳发送，无法回退", reqID, backendName)
+	WriteRequestLog(cfg, reqID, logBuilder.String())
+	WriteErrorLog(cfg, reqID, logBuilder.String())
+	metrics.Finish(false, backendName)
+}
+
+// peekStream 读取流式响应直到出现第一个非空行，返回包含已读内容的完整读取器；
+// 流在任何事件之前结束时 empty 为 true，非正常结束（连接中断等）时同时返回读取错误
+func peekStream(body io.Reader) (stream io.Reader, empty bool, err error) {
+	reader := bufio.NewReader(body)
+	var head bytes.Buffer
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		head.Write(line)
+		if len(bytes.TrimSpace(line)) > 0 {
+			return io.MultiReader(&head, reader), false, nil
+		}
+		if readErr == io.EOF {
+			return &head, true, nil
+		}
+		if readErr != nil {
+			return &head, true, readErr
+		}
+	}
+}
+
+// streamResponse 将流式响应转发给客户端，刷新时机由 streaming.flush_mode 控制
+func (p *Proxy) streamResponse(w http.ResponseWriter, body io.Reader, filters []sseLineFilter, cfg *Streaming) {
 	flusher, ok := w.(http.Flusher)
 	if !ok {
-		io.Copy(w, body)
+		if len(filters) > 0 {
+			streamLines(w, body, chainFilters(filters))
+		} else {
+			io.Copy(w, body)
+		}
+		return
+	}
+	sw := newStreamWriter(w, flusher, cfg)
+	defer sw.Close()
+	if len(filters) > 0 {
+		streamLines(sw, body, chainFilters(filters))
 		return
 	}
 
@@ -241,8 +1034,11 @@ func (p *Proxy) streamResponse(w http.ResponseWriter, body io.ReadCloser) {
 	for {
 		n, err := body.Read(buf)
 		if n > 0 {
-			w.Write(buf[:n])
-			flusher.Flush()
+			if _, werr := sw.Write(buf[:n]); werr != nil {
+				// 客户端已断开
+				break
+			}
+			sw.Flush()
 		}
 		if err != nil {
 			break
@@ -250,15 +1046,25 @@ func (p *Proxy) streamResponse(w http.ResponseWriter, body io.ReadCloser) {
 	}
 }
 
+// handleModels 返回 OpenAI 兼容的模型列表，按别名排序；?verbose=true 时在 llm_proxy 字段中附带
+// 后端协议、当前是否可用与回退链，该字段包含部署信息，需要代理 API Key
 func (p *Proxy) handleModels(w http.ResponseWriter, r *http.Request) {
 	cfg := p.configMgr.Get()
 	LogGeneral("DEBUG", "收到模型列表请求: 客户端=%s", r.RemoteAddr)
 
+	verbose := r.URL.Query().Get("verbose") == "true"
+	if verbose && !authorized(cfg, r) {
+		LogGeneral("WARN", "模型详情认证失败，客户端: %s", r.RemoteAddr)
+		httpError(w, "无效的 API Key", http.StatusUnauthorized)
+		return
+	}
+
 	type Model struct {
-		ID      string `json:"id"`
-		Object  string `json:"object"`
-		Created int64  `json:"created"`
-		OwnedBy string `json:"owned_by"`
+		ID       string     `json:"id"`
+		Object   string     `json:"object"`
+		Created  int64      `json:"created"`
+		OwnedBy  string     `json:"owned_by"`
+		LLMProxy *modelInfo `json:"llm_proxy,omitempty"`
 	}
 
 	type Response struct {
@@ -268,19 +1074,57 @@ func (p *Proxy) handleModels(w http.ResponseWriter, r *http.Request) {
 
 	var models []Model
 	for alias, modelAlias := range cfg.Models {
-		if modelAlias == nil || !modelAlias.IsEnabled() {
+		if modelAlias == nil || !modelAlias.IsEnabled() || isModelPattern(alias) {
 			continue
 		}
-		models = append(models, Model{
+		model := Model{
 			ID:      alias,
 			Object:  "model",
 			Created: time.Now().Unix(),
 			OwnedBy: "llm-proxy",
-		})
+		}
+		if verbose {
+			model.LLMProxy = p.modelInfo(cfg, alias, modelAlias)
+		}
+		models = append(models, model)
 	}
+	sort.Slice(models, func(i, j int) bool { return models[i].ID < models[j].ID })
 
 	LogGeneral("DEBUG", "返回 %d 个可用模型", len(models))
 	resp := Response{Object: "list", Data: models}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
+
+// modelInfo 是模型列表中代理专有的扩展字段
+type modelInfo struct {
+	Protocols []string `json:"protocols"`
+	Healthy   bool     `json:"healthy"`
+	Fallbacks []string `json:"fallbacks,omitempty"`
+}
+
+// modelInfo 汇总别名的后端协议与回退链；至少一条路由的后端已启用、健康且未冷却、未熔断时视为可用
+func (p *Proxy) modelInfo(cfg *Config, alias string, m *ModelAlias) *modelInfo {
+	info := &modelInfo{Protocols: []string{}}
+	seen := make(map[string]bool)
+	for _, route := range m.Routes {
+		backend := p.configMgr.GetBackend(route.Backend)
+		if backend == nil {
+			continue
+		}
+		if protocol := backend.GetProtocol(); !seen[protocol] {
+			seen[protocol] = true
+			info.Protocols = append(info.Protocols, protocol)
+		}
+		key := p.cooldown.Key(route.Backend, route.Model)
+		if route.IsEnabled() && backend.IsEnabled() && p.router.health.IsHealthy(backend.Name) &&
+			!p.cooldown.IsCoolingDown(key) && !p.router.breaker.IsOpen(key) {
+			info.Healthy = true
+		}
+	}
+	sort.Strings(info.Protocols)
+	for _, target := range cfg.Fallback.AliasFallback[alias] {
+		info.Fallbacks = append(info.Fallbacks, target.Alias)
+	}
+	return info
+}
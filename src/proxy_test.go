@@ -1,11 +1,25 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"testing/iotest"
+	"time"
 )
 
 func TestProxy_HealthEndpoint(t *testing.T) {
@@ -117,6 +131,55 @@ func TestProxy_APIKeyValidation_NoKeyConfigured(t *testing.T) {
 	}
 }
 
+func TestProxy_MultipleAPIKeys(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"choices":[]}`))
+	}))
+	defer backend.Close()
+
+	route := []ModelRoute{{Backend: "backend1", Model: "m1", Priority: 1}}
+	proxy := newTestProxy(&Config{
+		ProxyAPIKey: "sk-legacy",
+		ProxyAPIKeys: []ProxyKey{
+			{Name: "team-a", Key: "sk-team-a", AllowedModels: []string{"model-a"}},
+			{Name: "team-b", Key: "sk-team-b"},
+		},
+		Backends: []Backend{{Name: "backend1", URL: backend.URL}},
+		Models: map[string]*ModelAlias{
+			"model-a": {Routes: route},
+			"model-b": {Routes: route},
+		},
+	})
+
+	tests := []struct {
+		name     string
+		key      string
+		model    string
+		wantCode int
+	}{
+		{"unknown key", "sk-unknown", "model-a", http.StatusUnauthorized},
+		{"allowed model", "sk-team-a", "model-a", http.StatusOK},
+		{"disallowed model", "sk-team-a", "model-b", http.StatusForbidden},
+		{"key without allowlist", "sk-team-b", "model-b", http.StatusOK},
+		{"legacy key", "sk-legacy", "model-b", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"model": "` + tt.model + `"}`
+			req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+			req.Header.Set("Authorization", "Bearer "+tt.key)
+			w := httptest.NewRecorder()
+
+			proxy.ServeHTTP(w, req)
+
+			if w.Code != tt.wantCode {
+				t.Errorf("expected %d, got %d: %s", tt.wantCode, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
 func TestProxy_MissingModel(t *testing.T) {
 	cfg := &Config{}
 	cm := newTestConfigManager(cfg)
@@ -200,6 +263,71 @@ func TestProxy_ModelsEndpoint(t *testing.T) {
 	}
 }
 
+func TestProxy_ModelsEndpoint_Verbose(t *testing.T) {
+	proxy := newTestProxy(&Config{
+		ProxyAPIKey: "sk-proxy",
+		Backends: []Backend{
+			{Name: "oa", URL: "http://oa"},
+			{Name: "an", URL: "http://an", Protocol: ProtocolAnthropic},
+		},
+		Models: map[string]*ModelAlias{
+			"zeta":  {Routes: []ModelRoute{{Backend: "oa", Model: "m1", Priority: 1}}},
+			"alpha": {Routes: []ModelRoute{{Backend: "an", Model: "m2", Priority: 1}, {Backend: "oa", Model: "m3", Priority: 2}}},
+		},
+		Fallback: Fallback{AliasFallback: map[string][]FallbackTarget{"alpha": {{Alias: "zeta"}}}},
+	})
+	proxy.cooldown.SetCooldown(proxy.cooldown.Key("oa", "m1"), time.Minute)
+
+	get := func(path, key string) (int, string) {
+		req := httptest.NewRequest("GET", path, nil)
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+		return w.Code, w.Body.String()
+	}
+
+	code, body := get("/v1/models", "")
+	if code != http.StatusOK {
+		t.Fatalf("standard: status = %d", code)
+	}
+	if strings.Contains(body, "llm_proxy") {
+		t.Errorf("standard response should not include extension fields: %s", body)
+	}
+	if strings.Index(body, `"alpha"`) > strings.Index(body, `"zeta"`) {
+		t.Errorf("models not sorted alphabetically: %s", body)
+	}
+
+	if code, _ := get("/v1/models?verbose=true", ""); code != http.StatusUnauthorized {
+		t.Errorf("verbose without key: status = %d, want 401", code)
+	}
+
+	code, body = get("/v1/models?verbose=true", "sk-proxy")
+	if code != http.StatusOK {
+		t.Fatalf("verbose: status = %d", code)
+	}
+	var resp struct {
+		Data []struct {
+			ID       string     `json:"id"`
+			LLMProxy *modelInfo `json:"llm_proxy"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(body), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Data) != 2 || resp.Data[0].ID != "alpha" || resp.Data[1].ID != "zeta" {
+		t.Fatalf("unexpected models: %s", body)
+	}
+	alpha, zeta := resp.Data[0].LLMProxy, resp.Data[1].LLMProxy
+	if alpha == nil || !reflect.DeepEqual(alpha.Protocols, []string{"anthropic", "openai"}) || !alpha.Healthy || !reflect.DeepEqual(alpha.Fallbacks, []string{"zeta"}) {
+		t.Errorf("alpha = %+v", alpha)
+	}
+	if zeta == nil || zeta.Healthy || len(zeta.Fallbacks) != 0 {
+		t.Errorf("zeta = %+v, want unhealthy without fallbacks", zeta)
+	}
+}
+
 func TestSmartPathJoin(t *testing.T) {
 	tests := []struct {
 		backendPath string
@@ -226,3 +354,1617 @@ func TestSmartPathJoin(t *testing.T) {
 		}
 	}
 }
+
+func newTestProxy(cfg *Config) *Proxy {
+	cm := newTestConfigManager(cfg)
+	cd := NewCooldownManager()
+	return NewProxy(cm, NewRouter(cm, cd), cd, NewDetector(cm))
+}
+
+func TestFilterBodyFields(t *testing.T) {
+	body := map[string]interface{}{
+		"model":        "m1",
+		"messages":     []interface{}{},
+		"temperature":  0.5,
+		"experimental": true,
+		"metadata":     map[string]interface{}{"a": 1},
+	}
+
+	dropped := filterBodyFields(body, []string{"messages", "temperature"})
+
+	if strings.Join(dropped, ",") != "experimental,metadata" {
+		t.Errorf("dropped = %v, want [experimental metadata]", dropped)
+	}
+	for _, k := range []string{"model", "messages", "temperature"} {
+		if _, ok := body[k]; !ok {
+			t.Errorf("allowed field %q should be kept", k)
+		}
+	}
+	if len(body) != 3 {
+		t.Errorf("expected 3 fields left, got %d", len(body))
+	}
+}
+
+func TestProxy_AllowedFieldsPerBackend(t *testing.T) {
+	var received map[string]interface{}
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&received)
+		w.Write([]byte(`{}`))
+	}))
+	defer backend.Close()
+
+	tests := []struct {
+		name        string
+		allowed     []string
+		wantPresent []string
+		wantAbsent  []string
+	}{
+		{"strict backend", []string{"messages", "stream"}, []string{"model", "messages", "stream"}, []string{"x_experimental"}},
+		{"no allow-list", nil, []string{"model", "messages", "stream", "x_experimental"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received = nil
+			proxy := newTestProxy(&Config{
+				Backends: []Backend{{Name: "b1", URL: backend.URL, AllowedFields: tt.allowed}},
+				Models: map[string]*ModelAlias{
+					"model-a": {Routes: []ModelRoute{{Backend: "b1", Model: "m1", Priority: 1}}},
+				},
+			})
+
+			body := `{"model":"model-a","messages":[],"stream":false,"x_experimental":1}`
+			req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d", w.Code)
+			}
+			for _, k := range tt.wantPresent {
+				if _, ok := received[k]; !ok {
+					t.Errorf("field %q should be forwarded", k)
+				}
+			}
+			for _, k := range tt.wantAbsent {
+				if _, ok := received[k]; ok {
+					t.Errorf("field %q should be stripped", k)
+				}
+			}
+		})
+	}
+}
+
+func TestEnsureSystemPrompt(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		wantChanged bool
+		wantFirst   string
+	}{
+		{"no system message", `{"messages":[{"role":"user","content":"hi"}]}`, true, "min"},
+		{"empty system message", `{"messages":[{"role":"system","content":" "},{"role":"user","content":"hi"}]}`, true, "min"},
+		{"existing system message", `{"messages":[{"role":"system","content":"custom"},{"role":"user","content":"hi"}]}`, false, "custom"},
+		{"anthropic empty system", `{"system":"","messages":[{"role":"user","content":"hi"}]}`, true, ""},
+		{"no messages field", `{"input":"hi"}`, false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body map[string]interface{}
+			json.Unmarshal([]byte(tt.body), &body)
+
+			if got := ensureSystemPrompt(body, "min"); got != tt.wantChanged {
+				t.Fatalf("changed = %v, want %v", got, tt.wantChanged)
+			}
+			if tt.wantFirst == "" {
+				return
+			}
+			first := body["messages"].([]interface{})[0].(map[string]interface{})
+			if first["role"] != "system" || first["content"] != tt.wantFirst {
+				t.Errorf("first message = %v, want system %q", first, tt.wantFirst)
+			}
+		})
+	}
+}
+
+func TestProxy_BackendSystemPrompt(t *testing.T) {
+	var received []map[string]interface{}
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		received = append(received, body)
+		w.Write([]byte(`{}`))
+	}))
+	defer backend.Close()
+
+	proxy := newTestProxy(&Config{
+		Backends: []Backend{
+			{Name: "picky", URL: backend.URL, SystemPrompt: "You are a helpful assistant."},
+			{Name: "lenient", URL: backend.URL},
+		},
+		Models: map[string]*ModelAlias{
+			"picky-model":   {Routes: []ModelRoute{{Backend: "picky", Model: "m1", Priority: 1}}},
+			"lenient-model": {Routes: []ModelRoute{{Backend: "lenient", Model: "m1", Priority: 1}}},
+		},
+	})
+
+	for _, model := range []string{"picky-model", "lenient-model"} {
+		body := `{"model":"` + model + `","messages":[{"role":"user","content":"hi"}]}`
+		req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+		proxy.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	if len(received) != 2 {
+		t.Fatalf("expected 2 backend requests, got %d", len(received))
+	}
+	picky := received[0]["messages"].([]interface{})
+	if len(picky) != 2 || picky[0].(map[string]interface{})["content"] != "You are a helpful assistant." {
+		t.Errorf("picky backend should receive default system prompt, got %v", picky)
+	}
+	lenient := received[1]["messages"].([]interface{})
+	if len(lenient) != 1 {
+		t.Errorf("other backends should be untouched, got %v", lenient)
+	}
+}
+
+func TestProxy_InFlightReleasedOnClientDisconnect(t *testing.T) {
+	started := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := 0; ; i++ {
+			if _, err := w.Write([]byte("data: {}\n\n")); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+			if i == 0 {
+				close(started)
+			}
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(20 * time.Millisecond):
+			}
+		}
+	}))
+	defer backend.Close()
+
+	proxy := newTestProxy(&Config{
+		Backends: []Backend{{Name: "b1", URL: backend.URL}},
+		Models: map[string]*ModelAlias{
+			"model-a": {Routes: []ModelRoute{{Backend: "b1", Model: "m1", Priority: 1}}},
+		},
+	})
+	server := httptest.NewServer(proxy)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, "POST", server.URL+"/v1/chat/completions", strings.NewReader(`{"model":"model-a","stream":true}`))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	<-started
+	if got := proxy.router.inflight.Count("b1"); got != 1 {
+		t.Errorf("expected 1 in flight during stream, got %d", got)
+	}
+
+	cancel()
+	resp.Body.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for proxy.router.inflight.Count("b1") != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("in-flight count not released after client disconnect")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestProxy_RetryMutationOnContextLength(t *testing.T) {
+	var maxTokens []float64
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		tokens, _ := body["max_tokens"].(float64)
+		maxTokens = append(maxTokens, tokens)
+		if tokens > 1000 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":{"code":"context_length_exceeded"}}`))
+			return
+		}
+		w.Write([]byte(`{"choices":[]}`))
+	}))
+	defer backend.Close()
+
+	proxy := newTestProxy(&Config{
+		Backends: []Backend{{Name: "b1", URL: backend.URL}},
+		Models: map[string]*ModelAlias{
+			"model-a": {Routes: []ModelRoute{{Backend: "b1", Model: "m1", Priority: 1}}},
+		},
+		Fallback: Fallback{
+			CooldownSeconds: 300,
+			Mutations: []RetryMutation{
+				{ErrorPatterns: []string{"context_length_exceeded"}, Scale: map[string]float64{"max_tokens": 0.25}},
+			},
+		},
+		Detection: Detection{ErrorCodes: []string{"4xx"}},
+	})
+
+	req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"model-a","max_tokens":4000}`))
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 after mutated retry, got %d: %s", w.Code, w.Body.String())
+	}
+	if len(maxTokens) != 2 || maxTokens[0] != 4000 || maxTokens[1] != 1000 {
+		t.Errorf("max_tokens per attempt = %v, want [4000 1000]", maxTokens)
+	}
+	if proxy.cooldown.IsCoolingDown(proxy.cooldown.Key("b1", "m1")) {
+		t.Error("a request-caused failure fixed by mutation should not cool down the route")
+	}
+}
+
+func TestProxy_RetryMutationAppliedOnce(t *testing.T) {
+	calls := 0
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":{"code":"context_length_exceeded"}}`))
+	}))
+	defer backend.Close()
+
+	proxy := newTestProxy(&Config{
+		Backends: []Backend{{Name: "b1", URL: backend.URL}},
+		Models: map[string]*ModelAlias{
+			"model-a": {Routes: []ModelRoute{{Backend: "b1", Model: "m1", Priority: 1}}},
+		},
+		Fallback: Fallback{
+			Mutations: []RetryMutation{
+				{ErrorPatterns: []string{"context_length_exceeded"}, Scale: map[string]float64{"max_tokens": 0.5}},
+			},
+		},
+	})
+
+	req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"model-a","max_tokens":4000}`))
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+
+	if calls != 2 {
+		t.Errorf("expected original attempt plus one mutated retry, got %d calls", calls)
+	}
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected upstream 400 to be returned, got %d", w.Code)
+	}
+}
+
+func TestProxy_DefaultStreamOnlyWhenAmbiguous(t *testing.T) {
+	var gotStream interface{}
+	var present bool
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		gotStream, present = body["stream"]
+		w.Write([]byte(`{}`))
+	}))
+	defer backend.Close()
+
+	proxy := newTestProxy(&Config{
+		Backends: []Backend{{Name: "b1", URL: backend.URL}},
+		Models: map[string]*ModelAlias{
+			"model-a": {DefaultStream: boolPtr(true), Routes: []ModelRoute{{Backend: "b1", Model: "m1", Priority: 1}}},
+		},
+	})
+
+	tests := []struct {
+		name        string
+		body        string
+		accept      string
+		wantPresent bool
+		wantStream  interface{}
+	}{
+		{"ambiguous uses default", `{"model":"model-a"}`, "", true, true},
+		{"explicit false kept", `{"model":"model-a","stream":false}`, "", true, false},
+		{"accept json wins", `{"model":"model-a"}`, "application/json", false, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStream, present = nil, false
+			req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(tt.body))
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			proxy.ServeHTTP(httptest.NewRecorder(), req)
+
+			if present != tt.wantPresent || gotStream != tt.wantStream {
+				t.Errorf("upstream stream = %v (present=%v), want %v (present=%v)", gotStream, present, tt.wantStream, tt.wantPresent)
+			}
+		})
+	}
+}
+
+func TestProxy_EmptySuccessTriggersFallback(t *testing.T) {
+	empty := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.Header.Get("Accept"), "event-stream") {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Write([]byte("\n\n"))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer empty.Close()
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("data: {\"choices\":[]}\n\ndata: [DONE]\n\n"))
+	}))
+	defer healthy.Close()
+
+	tests := []struct {
+		name   string
+		body   string
+		accept string
+	}{
+		{"non-streaming empty body", `{"model":"model-a"}`, ""},
+		{"streaming without events", `{"model":"model-a","stream":true}`, "text/event-stream"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := newTestProxy(&Config{
+				Backends: []Backend{{Name: "empty", URL: empty.URL}, {Name: "healthy", URL: healthy.URL}},
+				Models: map[string]*ModelAlias{
+					"model-a": {Routes: []ModelRoute{
+						{Backend: "empty", Model: "m1", Priority: 1},
+						{Backend: "healthy", Model: "m2", Priority: 2},
+					}},
+				},
+				Fallback: Fallback{CooldownSeconds: 60},
+			})
+
+			req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(tt.body))
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "choices") {
+				t.Errorf("expected fallback to healthy backend, got %d: %q", w.Code, w.Body.String())
+			}
+			if !proxy.cooldown.IsCoolingDown(proxy.cooldown.Key("empty", "m1")) {
+				t.Error("backend returning an empty success should be cooled down")
+			}
+		})
+	}
+}
+
+func TestProxy_EmptySuccessFallbackDisabled(t *testing.T) {
+	empty := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer empty.Close()
+
+	proxy := newTestProxy(&Config{
+		Backends: []Backend{{Name: "empty", URL: empty.URL}},
+		Models: map[string]*ModelAlias{
+			"model-a": {Routes: []ModelRoute{{Backend: "empty", Model: "m1", Priority: 1}}},
+		},
+		Detection: Detection{FallbackOnEmpty: boolPtr(false)},
+	})
+
+	req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"model-a"}`))
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK || w.Body.Len() != 0 {
+		t.Errorf("with fallback_on_empty disabled the empty success should pass through, got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestPeekStream(t *testing.T) {
+	tests := []struct {
+		input string
+		empty bool
+	}{
+		{"", true},
+		{"\n\r\n\n", true},
+		{"\n: keep-alive\n\ndata: x\n\n", false},
+		{"data: [DONE]", false},
+	}
+	for _, tt := range tests {
+		stream, empty, err := peekStream(strings.NewReader(tt.input))
+		if empty != tt.empty || err != nil {
+			t.Errorf("peekStream(%q) empty=%v err=%v, want %v", tt.input, empty, err, tt.empty)
+		}
+		if out, _ := io.ReadAll(stream); string(out) != tt.input {
+			t.Errorf("peekStream(%q) should preserve all bytes, got %q", tt.input, out)
+		}
+	}
+
+	broken := io.MultiReader(strings.NewReader("\n"), iotest.ErrReader(io.ErrUnexpectedEOF))
+	if _, empty, err := peekStream(broken); !empty || err != io.ErrUnexpectedEOF {
+		t.Errorf("stream failing before any event: empty=%v err=%v, want empty with ErrUnexpectedEOF", empty, err)
+	}
+}
+
+// newDroppingStreamBackend 返回一个发出流式响应头和 prefix 后直接断开连接的后端
+func newDroppingStreamBackend(t *testing.T, prefix string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("hijack: %v", err)
+			return
+		}
+		defer conn.Close()
+		buf.WriteString("HTTP/1.1 200 OK\r\nContent-Type: text/event-stream\r\nTransfer-Encoding: chunked\r\n\r\n")
+		if prefix != "" {
+			fmt.Fprintf(buf, "%x\r\n%s\r\n", len(prefix), prefix)
+		}
+		buf.Flush()
+	}))
+}
+
+func TestProxy_StreamDroppedBeforeFirstEvent(t *testing.T) {
+	var healthyCalls atomic.Int32
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		healthyCalls.Add(1)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {\"choices\":[]}\n\ndata: [DONE]\n\n"))
+	}))
+	defer healthy.Close()
+
+	tests := []struct {
+		name         string
+		prefix       string
+		wantFallback bool
+		wantBody     string
+	}{
+		{"dropped before any data", "", true, "[DONE]"},
+		{"dropped after first chunk", "data: {\"partial\":true}\n\n", false, "partial"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			healthyCalls.Store(0)
+			flaky := newDroppingStreamBackend(t, tt.prefix)
+			defer flaky.Close()
+
+			proxy := newTestProxy(&Config{
+				Backends: []Backend{{Name: "flaky", URL: flaky.URL}, {Name: "healthy", URL: healthy.URL}},
+				Models: map[string]*ModelAlias{
+					"model-a": {Routes: []ModelRoute{
+						{Backend: "flaky", Model: "m1", Priority: 1},
+						{Backend: "healthy", Model: "m2", Priority: 2},
+					}},
+				},
+				Fallback: Fallback{CooldownSeconds: 60},
+				// 空响应回退关闭时，中断的流仍应回退
+				Detection: Detection{FallbackOnEmpty: boolPtr(false)},
+			})
+
+			req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"model-a","stream":true}`))
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("got %d %q, want 200 containing %q", w.Code, w.Body.String(), tt.wantBody)
+			}
+			if got := healthyCalls.Load() == 1; got != tt.wantFallback {
+				t.Errorf("fell back to healthy backend = %v, want %v", got, tt.wantFallback)
+			}
+			if cooling := proxy.cooldown.IsCoolingDown(proxy.cooldown.Key("flaky", "m1")); cooling != tt.wantFallback {
+				t.Errorf("flaky backend cooling = %v, want %v", cooling, tt.wantFallback)
+			}
+		})
+	}
+}
+
+func TestProxy_HeartbeatBeforeFirstEvent(t *testing.T) {
+	var healthyCalls atomic.Int32
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		healthyCalls.Add(1)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {\"choices\":[]}\n\ndata: [DONE]\n\n"))
+	}))
+	defer healthy.Close()
+
+	tests := []struct {
+		name          string
+		delay         time.Duration
+		events        string
+		wantHeartbeat bool
+		wantFallback  bool
+	}{
+		{"delayed first event", 120 * time.Millisecond, "data: {\"choices\":[]}\n\ndata: [DONE]\n\n", true, false},
+		{"prompt first event", 0, "data: {\"choices\":[]}\n\ndata: [DONE]\n\n", false, false},
+		{"empty before first heartbeat", 0, "", false, true},
+		{"empty after heartbeat", 120 * time.Millisecond, "", true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			healthyCalls.Store(0)
+			slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.Copy(io.Discard, r.Body)
+				w.Header().Set("Content-Type", "text/event-stream")
+				w.WriteHeader(http.StatusOK)
+				w.(http.Flusher).Flush()
+				time.Sleep(tt.delay)
+				w.Write([]byte(tt.events))
+			}))
+			defer slow.Close()
+
+			proxy := newTestProxy(&Config{
+				Backends: []Backend{{Name: "slow", URL: slow.URL}, {Name: "healthy", URL: healthy.URL}},
+				Models: map[string]*ModelAlias{
+					"model-a": {Routes: []ModelRoute{
+						{Backend: "slow", Model: "m1", Priority: 1},
+						{Backend: "healthy", Model: "m2", Priority: 2},
+					}},
+				},
+				Streaming: Streaming{HeartbeatIntervalMs: 40},
+			})
+
+			req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"model-a","stream":true}`))
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+
+			body := w.Body.String()
+			if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/event-stream" {
+				t.Errorf("got %d %q, want 200 text/event-stream", w.Code, w.Header().Get("Content-Type"))
+			}
+			if got := strings.HasPrefix(body, ": keep-alive\n\n"); got != tt.wantHeartbeat {
+				t.Errorf("heartbeat before first event = %v, want %v:\n%q", got, tt.wantHeartbeat, body)
+			}
+			if tt.events != "" && !strings.HasSuffix(body, tt.events) {
+				t.Errorf("events not delivered after heartbeats:\n%q", body)
+			}
+			if got := healthyCalls.Load() == 1; got != tt.wantFallback {
+				t.Errorf("fell back to healthy backend = %v, want %v", got, tt.wantFallback)
+			}
+		})
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestProxy_FailedAttemptCancelledBeforeFallback(t *testing.T) {
+	var failedCtx context.Context
+	var failedDone atomic.Bool
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		failedDone.Store(failedCtx != nil && failedCtx.Err() != nil)
+		w.Write([]byte(`{"choices":[]}`))
+	}))
+	defer healthy.Close()
+
+	cfg := &Config{
+		Backends: []Backend{{Name: "failing", URL: "http://failing.invalid"}, {Name: "healthy", URL: healthy.URL}},
+		Models: map[string]*ModelAlias{
+			"model-a": {Routes: []ModelRoute{
+				{Backend: "failing", Model: "m1", Priority: 1},
+				{Backend: "healthy", Model: "m2", Priority: 2},
+			}},
+		},
+		Detection: Detection{ErrorCodes: []string{"5xx"}},
+	}
+	proxy := newTestProxy(cfg)
+	proxy.router.clients.clients = map[string]*upstreamClient{
+		"failing": {pool: cfg.ConnectionPool.For(&cfg.Backends[0]), client: &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			failedCtx = r.Context()
+			return &http.Response{StatusCode: http.StatusInternalServerError, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(`{}`)), Request: r}, nil
+		})}},
+	}
+
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"model-a"}`)))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200 from the fallback: %s", w.Code, w.Body.String())
+	}
+	if !failedDone.Load() {
+		t.Error("the failed attempt's upstream context should be cancelled before the fallback is sent")
+	}
+}
+
+func TestProxy_ClientCancelAbortsUpstream(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream bool
+	}{
+		{"streaming", true},
+		{"waiting for response", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			started := make(chan struct{})
+			upstreamDone := make(chan struct{})
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.ReadAll(r.Body)
+				if tt.stream {
+					w.Header().Set("Content-Type", "text/event-stream")
+					w.Write([]byte("data: {}\n\n"))
+					w.(http.Flusher).Flush()
+				}
+				close(started)
+				// 慢后端：不再写入任何数据，只有上游连接被取消时才会返回
+				select {
+				case <-r.Context().Done():
+					close(upstreamDone)
+				case <-time.After(5 * time.Second):
+				}
+			}))
+			defer backend.Close()
+
+			proxy := newTestProxy(&Config{
+				Backends: []Backend{{Name: "b1", URL: backend.URL}},
+				Models: map[string]*ModelAlias{
+					"model-a": {Routes: []ModelRoute{{Backend: "b1", Model: "m1", Priority: 1}}},
+				},
+				Fallback: Fallback{CooldownSeconds: 60},
+			})
+			server := httptest.NewServer(proxy)
+			defer server.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			body := `{"model":"model-a","stream":` + strconv.FormatBool(tt.stream) + `}`
+			req, _ := http.NewRequestWithContext(ctx, "POST", server.URL+"/v1/chat/completions", strings.NewReader(body))
+			go func() {
+				if resp, err := http.DefaultClient.Do(req); err == nil {
+					resp.Body.Close()
+				}
+			}()
+
+			<-started
+			cancel()
+
+			select {
+			case <-upstreamDone:
+			case <-time.After(time.Second):
+				t.Fatal("upstream request was not cancelled after client disconnect")
+			}
+			if proxy.cooldown.IsCoolingDown(proxy.cooldown.Key("b1", "m1")) {
+				t.Error("client disconnect must not cool down the backend")
+			}
+		})
+	}
+}
+
+func TestProxy_TimeoutHeaderOverridesTotalTimeout(t *testing.T) {
+	forwarded := make(chan string, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded <- r.Header.Get(timeoutHeader)
+		io.ReadAll(r.Body)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer backend.Close()
+
+	proxy := newTestProxy(&Config{
+		Backends: []Backend{{Name: "b1", URL: backend.URL}},
+		Models: map[string]*ModelAlias{
+			"model-a": {Routes: []ModelRoute{{Backend: "b1", Model: "m1", Priority: 1}}},
+		},
+		Fallback: Fallback{CooldownSeconds: 60},
+		Timeout:  Timeout{TotalSeconds: 60, MinOverrideSeconds: 1},
+	})
+
+	req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"model-a"}`))
+	req.Header.Set(timeoutHeader, "1")
+	w := httptest.NewRecorder()
+	start := time.Now()
+	proxy.ServeHTTP(w, req)
+
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("request took %v, want the 1s header timeout to apply", elapsed)
+	}
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusGatewayTimeout)
+	}
+	if got := <-forwarded; got != "" {
+		t.Errorf("%s header forwarded to backend: %q", timeoutHeader, got)
+	}
+	if proxy.cooldown.IsCoolingDown(proxy.cooldown.Key("b1", "m1")) {
+		t.Error("request timeout must not cool down the backend")
+	}
+}
+
+func TestProxy_AttemptsShareTotalTimeout(t *testing.T) {
+	tests := []struct {
+		name      string
+		delay     time.Duration
+		wantCalls int
+	}{
+		{"slow first attempt leaves no time", 700 * time.Millisecond, 1},
+		{"stops once remaining budget is too short", 300 * time.Millisecond, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+				time.Sleep(tt.delay)
+				w.WriteHeader(http.StatusInternalServerError)
+			}))
+			defer backend.Close()
+
+			cfg := &Config{
+				Models:    map[string]*ModelAlias{"model-a": {}},
+				Detection: Detection{ErrorCodes: []string{"5xx"}},
+				Timeout:   Timeout{TotalSeconds: 60, MinOverrideSeconds: 1},
+			}
+			for i := 1; i <= 4; i++ {
+				name := fmt.Sprintf("b%d", i)
+				cfg.Backends = append(cfg.Backends, Backend{Name: name, URL: backend.URL})
+				cfg.Models["model-a"].Routes = append(cfg.Models["model-a"].Routes, ModelRoute{Backend: name, Model: "m1", Priority: i})
+			}
+			proxy := newTestProxy(cfg)
+
+			req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"model-a"}`))
+			req.Header.Set(timeoutHeader, "1")
+			w := httptest.NewRecorder()
+			start := time.Now()
+			proxy.ServeHTTP(w, req)
+			elapsed := time.Since(start)
+
+			if elapsed > time.Second+100*time.Millisecond {
+				t.Errorf("request took %v, want it within the 1s total timeout", elapsed)
+			}
+			if got := int(calls.Load()); got != tt.wantCalls {
+				t.Errorf("backend calls = %d, want %d", got, tt.wantCalls)
+			}
+			if w.Code != http.StatusInternalServerError {
+				t.Errorf("status = %d, want the last upstream failure (500)", w.Code)
+			}
+		})
+	}
+}
+
+func TestProxy_MaxAttemptsHeaderCapsAttempts(t *testing.T) {
+	tests := []struct {
+		name      string
+		gate      bool
+		header    string
+		wantCalls int
+	}{
+		{"header caps attempts", true, "1", 1},
+		{"header cannot exceed config", true, "10", 3},
+		{"invalid header ignored", true, "zero", 3},
+		{"header ignored when not enabled", false, "1", 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			forwarded := ""
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				forwarded = r.Header.Get(maxAttemptsHeader)
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(`{"error":"boom"}`))
+			}))
+			defer backend.Close()
+
+			proxy := newTestProxy(&Config{
+				Backends: []Backend{
+					{Name: "b1", URL: backend.URL},
+					{Name: "b2", URL: backend.URL},
+					{Name: "b3", URL: backend.URL},
+				},
+				Models: map[string]*ModelAlias{
+					"model-a": {Routes: []ModelRoute{
+						{Backend: "b1", Model: "m1", Priority: 1},
+						{Backend: "b2", Model: "m1", Priority: 2},
+						{Backend: "b3", Model: "m1", Priority: 3},
+					}},
+				},
+				Fallback:  Fallback{MaxRetries: 3, ClientMaxAttempts: tt.gate},
+				Detection: Detection{ErrorCodes: []string{"5xx"}},
+			})
+
+			req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"model-a"}`))
+			req.Header.Set(maxAttemptsHeader, tt.header)
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+
+			if calls != tt.wantCalls {
+				t.Errorf("backend calls = %d, want %d", calls, tt.wantCalls)
+			}
+			if forwarded != "" {
+				t.Errorf("%s header forwarded to backend: %q", maxAttemptsHeader, forwarded)
+			}
+		})
+	}
+}
+
+func TestProxy_ModelMaxAttempts(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxAttempts int
+		wantCalls   int
+	}{
+		{"capped below route count", 2, 2},
+		{"unlimited tries every route", 0, 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				w.WriteHeader(http.StatusInternalServerError)
+			}))
+			defer backend.Close()
+
+			cfg := &Config{
+				Models:    map[string]*ModelAlias{"model-a": {MaxAttempts: tt.maxAttempts}},
+				Detection: Detection{ErrorCodes: []string{"5xx"}},
+			}
+			for i := 1; i <= 5; i++ {
+				name := fmt.Sprintf("b%d", i)
+				cfg.Backends = append(cfg.Backends, Backend{Name: name, URL: backend.URL})
+				cfg.Models["model-a"].Routes = append(cfg.Models["model-a"].Routes, ModelRoute{Backend: name, Model: "m1", Priority: i})
+			}
+			proxy := newTestProxy(cfg)
+
+			proxy.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"model-a"}`)))
+			if calls != tt.wantCalls {
+				t.Errorf("backend calls = %d, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+// countingReader 产生无限的数据并记录被读取的字节数
+type countingReader struct {
+	read int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 'a'
+	}
+	c.read += int64(len(p))
+	return len(p), nil
+}
+
+func TestProxy_RequestBodyLimit(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"choices":[]}`))
+	}))
+	defer backend.Close()
+
+	proxy := newTestProxy(&Config{
+		Backends: []Backend{{Name: "b1", URL: backend.URL}},
+		Models: map[string]*ModelAlias{
+			"model-a": {Routes: []ModelRoute{{Backend: "b1", Model: "m1", Priority: 1}}},
+		},
+		MaxBodyBytes: 1024,
+	})
+
+	req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"model-a"}`))
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("under-limit request: status = %d, want 200", w.Code)
+	}
+
+	body := &countingReader{}
+	req = httptest.NewRequest("POST", "/v1/chat/completions", body)
+	w = httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("over-limit request: status = %d, want 413", w.Code)
+	}
+	if body.read > 64*1024 {
+		t.Errorf("proxy read %d bytes of an oversized body, want it to stop near the 1024 byte limit", body.read)
+	}
+}
+
+func TestProxy_RetryAfterExtendsCooldown(t *testing.T) {
+	throttled := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer throttled.Close()
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"choices":[]}`))
+	}))
+	defer ok.Close()
+
+	proxy := newTestProxy(&Config{
+		Backends: []Backend{{Name: "throttled", URL: throttled.URL}, {Name: "ok", URL: ok.URL}},
+		Models: map[string]*ModelAlias{
+			"model-a": {Routes: []ModelRoute{
+				{Backend: "throttled", Model: "m1", Priority: 1},
+				{Backend: "ok", Model: "m1", Priority: 2},
+			}},
+		},
+		Fallback:  Fallback{CooldownSeconds: 10},
+		Detection: Detection{ErrorCodes: []string{"429"}},
+	})
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	proxy.cooldown.now = func() time.Time { return now }
+
+	req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"model-a"}`))
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+
+	until, cooling := proxy.cooldown.Snapshot()[proxy.cooldown.Key("throttled", "m1")]
+	if !cooling {
+		t.Fatal("throttled route should be cooling down")
+	}
+	if got := until.Sub(now); got != 120*time.Second {
+		t.Errorf("cooldown = %v, want Retry-After of 120s", got)
+	}
+}
+
+func TestAzureTargetURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		backend Backend
+		path    string
+		query   string
+		want    string
+	}{
+		{
+			name:    "configured deployment and version",
+			backend: Backend{URL: "https://res.openai.azure.com/", Deployment: "gpt4o-prod", APIVersion: "2024-06-01"},
+			path:    "/v1/chat/completions",
+			want:    "https://res.openai.azure.com/openai/deployments/gpt4o-prod/chat/completions?api-version=2024-06-01",
+		},
+		{
+			name:    "deployment defaults to route model",
+			backend: Backend{URL: "https://res.openai.azure.com"},
+			path:    "/v1/embeddings",
+			query:   "foo=bar",
+			want:    "https://res.openai.azure.com/openai/deployments/m1/embeddings?api-version=" + defaultAzureAPIVersion + "&foo=bar",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := azureTargetURL(&tt.backend, "m1", tt.path, tt.query)
+			if err != nil {
+				t.Fatalf("azureTargetURL error: %v", err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("URL = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxy_AzureBackend(t *testing.T) {
+	var gotPath, gotVersion, gotKey, gotAuth string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotVersion = r.URL.Query().Get("api-version")
+		gotKey = r.Header.Get("api-key")
+		gotAuth = r.Header.Get("Authorization")
+		w.Write([]byte(`{"choices":[]}`))
+	}))
+	defer backend.Close()
+
+	proxy := newTestProxy(&Config{
+		ProxyAPIKey: "sk-proxy",
+		Backends: []Backend{{
+			Name: "azure", URL: backend.URL, Protocol: ProtocolAzure,
+			APIKey: "az-secret", Deployment: "gpt4o-prod", APIVersion: "2024-06-01",
+		}},
+		Models: map[string]*ModelAlias{
+			"model-a": {Routes: []ModelRoute{{Backend: "azure", Model: "gpt-4o", Priority: 1}}},
+		},
+	})
+
+	req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"model-a"}`))
+	req.Header.Set("Authorization", "Bearer sk-proxy")
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body.String())
+	}
+	if gotPath != "/openai/deployments/gpt4o-prod/chat/completions" || gotVersion != "2024-06-01" {
+		t.Errorf("upstream path/version = %s ? %s", gotPath, gotVersion)
+	}
+	if gotKey != "az-secret" || gotAuth != "" {
+		t.Errorf("upstream api-key = %q, Authorization = %q; want api-key only", gotKey, gotAuth)
+	}
+}
+
+func TestProxy_AnthropicBackendAuth(t *testing.T) {
+	var gotKey, gotAuth, gotVersion string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotKey = r.Header.Get("x-api-key")
+		gotAuth = r.Header.Get("Authorization")
+		gotVersion = r.Header.Get("anthropic-version")
+		w.Write([]byte(`{"type":"message","content":[]}`))
+	}))
+	defer backend.Close()
+
+	proxy := newTestProxy(&Config{
+		ProxyAPIKey: "sk-proxy",
+		Backends:    []Backend{{Name: "claude", URL: backend.URL, Protocol: ProtocolAnthropic, APIKey: "ant-secret"}},
+		Models: map[string]*ModelAlias{
+			"model-a": {Routes: []ModelRoute{{Backend: "claude", Model: "claude-sonnet", Priority: 1}}},
+		},
+	})
+
+	req := httptest.NewRequest("POST", "/v1/messages", strings.NewReader(`{"model":"model-a","max_tokens":16}`))
+	req.Header.Set("Authorization", "Bearer sk-proxy")
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body.String())
+	}
+	if gotKey != "ant-secret" || gotAuth != "" {
+		t.Errorf("upstream x-api-key = %q, Authorization = %q; want x-api-key only", gotKey, gotAuth)
+	}
+	if gotVersion != anthropicVersion {
+		t.Errorf("upstream anthropic-version = %q, want %q", gotVersion, anthropicVersion)
+	}
+}
+
+func TestProxy_JSONLimits(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"choices":[]}`))
+	}))
+	defer backend.Close()
+
+	proxy := newTestProxy(&Config{
+		Backends: []Backend{{Name: "b1", URL: backend.URL}},
+		Models: map[string]*ModelAlias{
+			"model-a": {Routes: []ModelRoute{{Backend: "b1", Model: "m1", Priority: 1}}},
+		},
+		MaxJSONDepth: 8,
+		MaxMessages:  2,
+	})
+
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+	}{
+		{
+			name:     "normal payload",
+			body:     `{"model":"model-a","messages":[{"role":"user","content":"[[{\"not\":\"nested\"}]]]]]]]]"}]}`,
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "over depth",
+			body:     `{"model":"model-a","x":` + strings.Repeat("[", 10) + strings.Repeat("]", 10) + `}`,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "too many messages",
+			body:     `{"model":"model-a","messages":[{"role":"user","content":"a"},{"role":"assistant","content":"b"},{"role":"user","content":"c"}]}`,
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantCode, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestProxy_RequestID(t *testing.T) {
+	var upstreamID string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamID = r.Header.Get("X-Request-Id")
+		w.Header().Set("X-Request-Id", "provider-req-1")
+		w.Write([]byte(`{"choices":[]}`))
+	}))
+	defer backend.Close()
+
+	proxy := newTestProxy(&Config{
+		Backends: []Backend{{Name: "b1", URL: backend.URL}},
+		Models: map[string]*ModelAlias{
+			"model-a": {Routes: []ModelRoute{{Backend: "b1", Model: "m1", Priority: 1}}},
+		},
+	})
+
+	tests := []struct {
+		name      string
+		inboundID string
+		body      string
+		wantCode  int
+		wantID    string
+	}{
+		{
+			name:     "generated id echoed",
+			body:     `{"model":"model-a"}`,
+			wantCode: http.StatusOK,
+		},
+		{
+			name:      "inbound id reused",
+			inboundID: "client-trace-42",
+			body:      `{"model":"model-a"}`,
+			wantCode:  http.StatusOK,
+			wantID:    "client-trace-42",
+		},
+		{
+			name:      "invalid inbound id replaced",
+			inboundID: "../../etc/passwd",
+			body:      `{"model":"model-a"}`,
+			wantCode:  http.StatusOK,
+		},
+		{
+			name:      "error body carries id",
+			inboundID: "client-trace-43",
+			body:      `{"model":"unknown"}`,
+			wantCode:  http.StatusBadRequest,
+			wantID:    "client-trace-43",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstreamID = ""
+			req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(tt.body))
+			if tt.inboundID != "" {
+				req.Header.Set("X-Request-Id", tt.inboundID)
+			}
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantCode, w.Body.String())
+			}
+			id := w.Header().Get("X-Request-Id")
+			if id == "" || id == tt.inboundID && tt.wantID == "" {
+				t.Fatalf("X-Request-Id = %q, want a generated id", id)
+			}
+			if tt.wantID != "" && id != tt.wantID {
+				t.Errorf("X-Request-Id = %q, want %q", id, tt.wantID)
+			}
+
+			if tt.wantCode == http.StatusOK {
+				if upstreamID != id {
+					t.Errorf("upstream X-Request-Id = %q, want %q", upstreamID, id)
+				}
+				if got := w.Header().Get("X-Upstream-Request-Id"); got != "provider-req-1" {
+					t.Errorf("X-Upstream-Request-Id = %q", got)
+				}
+				return
+			}
+			var errBody struct {
+				Error struct {
+					Message   string `json:"message"`
+					RequestID string `json:"request_id"`
+				} `json:"error"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &errBody); err != nil {
+				t.Fatalf("error body is not JSON: %s", w.Body.String())
+			}
+			if errBody.Error.RequestID != id || errBody.Error.Message == "" {
+				t.Errorf("error body = %+v, want request_id %q", errBody.Error, id)
+			}
+		})
+	}
+}
+
+func TestProxy_JSONErrorsOnAllEndpoints(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      *Config
+		method   string
+		path     string
+		wantCode int
+	}{
+		{"models verbose without key", &Config{ProxyAPIKey: "sk-proxy"}, "GET", "/v1/models?verbose=true", http.StatusUnauthorized},
+		{"health detail without key", &Config{ProxyAPIKey: "sk-proxy"}, "GET", "/health/detail", http.StatusUnauthorized},
+		{"readyz without backends", &Config{}, "GET", "/readyz", http.StatusServiceUnavailable},
+		{"admin disabled", &Config{}, "POST", "/admin/cooldown/reset", http.StatusForbidden},
+		{"admin wrong method", &Config{ProxyAPIKey: "sk-proxy"}, "GET", "/admin/cooldown/reset", http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := newTestProxy(tt.cfg)
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.wantCode == http.StatusMethodNotAllowed {
+				req.Header.Set("Authorization", "Bearer sk-proxy")
+			}
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantCode, w.Body.String())
+			}
+			var errBody struct {
+				Error struct {
+					Message string `json:"message"`
+				} `json:"error"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &errBody); err != nil || errBody.Error.Message == "" {
+				t.Errorf("error body is not a JSON error: %s", w.Body.String())
+			}
+			if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+				t.Errorf("Content-Type = %q, want application/json", ct)
+			}
+		})
+	}
+}
+
+func TestProxy_DefaultModel(t *testing.T) {
+	var gotModel string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		gotModel, _ = body["model"].(string)
+		w.Write([]byte(`{"choices":[]}`))
+	}))
+	defer backend.Close()
+
+	tests := []struct {
+		name         string
+		defaultModel string
+		body         string
+		wantCode     int
+		wantModel    string
+	}{
+		{
+			name:         "default applied",
+			defaultModel: "model-a",
+			body:         `{"messages":[]}`,
+			wantCode:     http.StatusOK,
+			wantModel:    "m1",
+		},
+		{
+			name:         "explicit model wins",
+			defaultModel: "model-a",
+			body:         `{"model":"model-b","messages":[]}`,
+			wantCode:     http.StatusOK,
+			wantModel:    "m2",
+		},
+		{
+			name:     "no default still errors",
+			body:     `{"messages":[]}`,
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotModel = ""
+			proxy := newTestProxy(&Config{
+				Backends: []Backend{{Name: "b1", URL: backend.URL}},
+				Models: map[string]*ModelAlias{
+					"model-a": {Routes: []ModelRoute{{Backend: "b1", Model: "m1", Priority: 1}}},
+					"model-b": {Routes: []ModelRoute{{Backend: "b1", Model: "m2", Priority: 1}}},
+				},
+				DefaultModel: tt.defaultModel,
+			})
+
+			req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantCode, w.Body.String())
+			}
+			if gotModel != tt.wantModel {
+				t.Errorf("upstream model = %q, want %q", gotModel, tt.wantModel)
+			}
+		})
+	}
+}
+
+func TestProxy_DecompressesEncodedResponses(t *testing.T) {
+	compress := func(encoding string, data []byte) []byte {
+		var buf bytes.Buffer
+		var wc io.WriteCloser
+		if encoding == "gzip" {
+			wc = gzip.NewWriter(&buf)
+		} else {
+			wc = zlib.NewWriter(&buf)
+		}
+		wc.Write(data)
+		wc.Close()
+		return buf.Bytes()
+	}
+
+	keep := 1
+	for _, encoding := range []string{"gzip", "deflate"} {
+		t.Run(encoding, func(t *testing.T) {
+			failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Encoding", encoding)
+				w.WriteHeader(http.StatusTooManyRequests)
+				w.Write(compress(encoding, []byte(`{"error":"insufficient_quota"}`)))
+			}))
+			defer failing.Close()
+			ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Encoding", encoding)
+				w.Write(compress(encoding, []byte(`{"choices":[{"index":0},{"index":1}],"usage":{"total_tokens":42}}`)))
+			}))
+			defer ok.Close()
+
+			proxy := newTestProxy(&Config{
+				Backends: []Backend{{Name: "b1", URL: failing.URL}, {Name: "b2", URL: ok.URL}},
+				Models: map[string]*ModelAlias{
+					"model-a": {
+						KeepChoice: &keep,
+						Routes: []ModelRoute{
+							{Backend: "b1", Model: "m1", Priority: 1},
+							{Backend: "b2", Model: "m1", Priority: 2},
+						},
+					},
+				},
+				Fallback:  Fallback{CooldownSeconds: 60},
+				Detection: Detection{ErrorPatterns: []string{"insufficient_quota"}},
+			})
+
+			req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"model-a"}`))
+			req.Header.Set("Accept-Encoding", encoding)
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+
+			if !proxy.cooldown.IsCoolingDown(proxy.cooldown.Key("b1", "m1")) {
+				t.Error("error pattern in a compressed error body should trigger fallback")
+			}
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, body = %q", w.Code, w.Body.String())
+			}
+			if got := w.Header().Get("Content-Encoding"); got != "" {
+				t.Errorf("Content-Encoding = %q, want it stripped after decompression", got)
+			}
+			var resp struct {
+				Choices []struct {
+					Index int `json:"index"`
+				} `json:"choices"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("client received undecodable body %q: %v", w.Body.String(), err)
+			}
+			if len(resp.Choices) != 1 {
+				t.Errorf("keep_choice should apply to the decompressed body, got %d choices", len(resp.Choices))
+			}
+		})
+	}
+}
+
+func TestProxy_AnthropicVersionAndBetaHeaders(t *testing.T) {
+	tests := []struct {
+		name        string
+		protocol    string
+		version     string
+		beta        string
+		wantVersion string
+	}{
+		{"client headers survive", ProtocolAnthropic, "2024-10-22", "prompt-caching-2024-07-31,context-1m-2025-08-07", "2024-10-22"},
+		{"default version when absent", ProtocolAnthropic, "", "", anthropicVersion},
+		{"openai backend untouched", ProtocolOpenAI, "", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got http.Header
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.Header.Clone()
+				w.Write([]byte(`{"content":[]}`))
+			}))
+			defer backend.Close()
+
+			proxy := newTestProxy(&Config{
+				Backends: []Backend{{Name: "b1", URL: backend.URL, Protocol: tt.protocol}},
+				Models: map[string]*ModelAlias{
+					"model-a": {Routes: []ModelRoute{{Backend: "b1", Model: "m1", Priority: 1}}},
+				},
+			})
+			req := httptest.NewRequest("POST", "/v1/messages", strings.NewReader(`{"model":"model-a","max_tokens":1}`))
+			if tt.version != "" {
+				req.Header.Set("anthropic-version", tt.version)
+			}
+			if tt.beta != "" {
+				req.Header.Set("anthropic-beta", tt.beta)
+			}
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+			}
+
+			if v := got.Get("anthropic-version"); v != tt.wantVersion {
+				t.Errorf("anthropic-version = %q, want %q", v, tt.wantVersion)
+			}
+			if v := got.Get("anthropic-beta"); v != tt.beta {
+				t.Errorf("anthropic-beta = %q, want %q", v, tt.beta)
+			}
+		})
+	}
+}
+
+func TestProxy_WrapsNonJSONUpstreamErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		contentType string
+		body        string
+		wantSnippet string
+	}{
+		{"html 502", http.StatusBadGateway, "text/html", "<html>\n<head><title>502 Bad Gateway</title></head>\n<body>nginx</body>\n</html>", "<html> <head><title>502 Bad Gateway</title></head> <body>nginx</body> </html>"},
+		{"plain text 503", http.StatusServiceUnavailable, "text/plain", "upstream connect error or disconnect/reset before headers\n", "upstream connect error or disconnect/reset before headers"},
+		{"json passes through", http.StatusBadRequest, "application/json", `{"error":{"message":"bad request","type":"invalid_request_error"}}`, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer backend.Close()
+
+			proxy := newTestProxy(&Config{
+				Backends: []Backend{{Name: "b1", URL: backend.URL}},
+				Models: map[string]*ModelAlias{
+					"model-a": {Routes: []ModelRoute{{Backend: "b1", Model: "m1", Priority: 1}}},
+				},
+			})
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"model-a"}`)))
+
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+				t.Errorf("Content-Type = %q, want JSON", ct)
+			}
+			if tt.wantSnippet == "" {
+				if w.Body.String() != tt.body {
+					t.Errorf("body = %s, want upstream JSON unchanged", w.Body.String())
+				}
+				return
+			}
+			var resp struct {
+				Error struct {
+					Type           string `json:"type"`
+					UpstreamStatus int    `json:"upstream_status"`
+					UpstreamBody   string `json:"upstream_body"`
+					RequestID      string `json:"request_id"`
+				} `json:"error"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("body is not JSON: %v: %s", err, w.Body.String())
+			}
+			if resp.Error.Type != "upstream_error" || resp.Error.UpstreamStatus != tt.status || resp.Error.RequestID == "" {
+				t.Errorf("error envelope = %+v", resp.Error)
+			}
+			if resp.Error.UpstreamBody != tt.wantSnippet {
+				t.Errorf("upstream_body = %q, want %q", resp.Error.UpstreamBody, tt.wantSnippet)
+			}
+		})
+	}
+}
+
+func TestIsConnectError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"dns", &url.Error{Op: "Post", Err: &net.DNSError{Err: "no such host", Name: "backend.invalid"}}, true},
+		{"dial refused", &url.Error{Op: "Post", Err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}}, true},
+		{"reset while reading", &url.Error{Op: "Post", Err: &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}}, false},
+		{"other", errors.New("unexpected EOF"), false},
+	}
+	for _, tt := range tests {
+		if got := isConnectError(tt.err); got != tt.want {
+			t.Errorf("%s: isConnectError = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestProxy_ConnectErrorSkipsCooldownEscalation(t *testing.T) {
+	overloaded := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer overloaded.Close()
+	unreachable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	unreachable.Close()
+
+	tests := []struct {
+		name string
+		url  string
+		want time.Duration
+	}{
+		{"connect error uses base cooldown", unreachable.URL, 10 * time.Second},
+		{"429 escalates", overloaded.URL, 40 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Backends: []Backend{{Name: "b1", URL: tt.url}},
+				Models: map[string]*ModelAlias{
+					"model-a": {Routes: []ModelRoute{{Backend: "b1", Model: "m1", Priority: 1}}},
+				},
+				Fallback:  Fallback{CooldownSeconds: 10, CooldownBackoff: CooldownBackoff{Multiplier: 2}},
+				Detection: Detection{ErrorCodes: []string{"429"}},
+			}
+			proxy := newTestProxy(cfg)
+			key := proxy.cooldown.Key("b1", "m1")
+			// 之前已连续失败两次，下一次过载失败应冷却 10s × 2²
+			proxy.cooldown.Escalate(key, &cfg.Fallback)
+			proxy.cooldown.Escalate(key, &cfg.Fallback)
+
+			start := time.Now()
+			proxy.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"model-a"}`)))
+			until, ok := proxy.cooldown.Snapshot()[key]
+			if !ok {
+				t.Fatal("route should be cooling down")
+			}
+			if got := until.Sub(start); got < tt.want || got > tt.want+time.Second {
+				t.Errorf("cooldown = %v, want about %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxy_CancelledHalfOpenProbeRecovers(t *testing.T) {
+	var calls atomic.Int32
+	started := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		if calls.Add(1) == 1 {
+			close(started)
+			<-r.Context().Done()
+			return
+		}
+		w.Write([]byte(`{"choices":[]}`))
+	}))
+	defer backend.Close()
+
+	proxy := newTestProxy(&Config{
+		Backends: []Backend{{Name: "b1", URL: backend.URL}},
+		Models: map[string]*ModelAlias{
+			"model-a": {Routes: []ModelRoute{{Backend: "b1", Model: "m1", Priority: 1}}},
+		},
+		CircuitBreaker: CircuitBreakerConfig{Enabled: true, FailureThreshold: 1, OpenTimeoutSeconds: 10},
+	})
+	key := proxy.cooldown.Key("b1", "m1")
+	proxy.router.breaker.RecordFailure(key)
+	opened := time.Now()
+	proxy.router.breaker.now = func() time.Time { return opened.Add(11 * time.Second) }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"model-a"}`)).WithContext(ctx)
+		proxy.ServeHTTP(httptest.NewRecorder(), req)
+		close(done)
+	}()
+	<-started
+	if !proxy.router.breaker.IsOpen(key) {
+		t.Error("route should be excluded while the half-open probe is in flight")
+	}
+	cancel()
+	<-done
+
+	if proxy.router.breaker.IsOpen(key) {
+		t.Fatal("cancelled probe should release the half-open slot")
+	}
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"model-a"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("next request status = %d, want 200: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+type Usage struct {
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+	TotalTokens      int64 `json:"total_tokens"`
+	InputTokens      int64 `json:"input_tokens"`
+	OutputTokens     int64 `json:"output_tokens"`
+}
+
+// parseUsage 从响应体中提取 usage，兼容 OpenAI 与 Anthropic 字段
+func parseUsage(body []byte) (Usage, bool) {
+	var resp struct {
+		Usage *Usage `json:"usage"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil || resp.Usage == nil {
+		return Usage{}, false
+	}
+	return resp.Usage.normalized(), true
+}
+
+// normalized 将 Anthropic 的 input/output_tokens 映射到 OpenAI 字段并补全 total_tokens
+func (u Usage) normalized() Usage {
+	if u.PromptTokens == 0 && u.InputTokens > 0 {
+		u.PromptTokens = u.InputTokens
+	}
+	if u.CompletionTokens == 0 && u.OutputTokens > 0 {
+		u.CompletionTokens = u.OutputTokens
+	}
+	if u.TotalTokens == 0 {
+		u.TotalTokens = u.PromptTokens + u.CompletionTokens
+	}
+	return u
+}
+
+type quotaUsage struct {
+	period string
+	used   int64
+}
+
+type QuotaTracker struct {
+	usage map[string]*quotaUsage
+	now   func() time.Time
+	mu    sync.Mutex
+}
+
+func NewQuotaTracker() *QuotaTracker {
+	return &QuotaTracker{
+		usage: make(map[string]*quotaUsage),
+		now:   time.Now,
+	}
+}
+
+func (qt *QuotaTracker) current(b *Backend) *quotaUsage {
+	period := b.Quota.periodKey(qt.now())
+	u, exists := qt.usage[b.Name]
+	if !exists || u.period != period {
+		u = &quotaUsage{period: period}
+		qt.usage[b.Name] = u
+	}
+	return u
+}
+
+func (qt *QuotaTracker) Record(b *Backend, tokens int64) {
+	if b == nil || b.Quota == nil || b.Quota.Tokens <= 0 || tokens <= 0 {
+		return
+	}
+	qt.mu.Lock()
+	defer qt.mu.Unlock()
+	u := qt.current(b)
+	u.used += tokens
+	if u.used >= b.Quota.Tokens {
+		LogGeneral("WARN", "后端 %s 配额已耗尽: 已用=%d 配额=%d 周期=%s", b.Name, u.used, b.Quota.Tokens, u.period)
+	}
+}
+
+func (qt *QuotaTracker) Used(b *Backend) int64 {
+	if b == nil || b.Quota == nil {
+		return 0
+	}
+	qt.mu.Lock()
+	defer qt.mu.Unlock()
+	return qt.current(b).used
+}
+
+// Factor 返回后端的配额权重系数：用量低于降权起点时为 1，
+// 之后线性下降，配额耗尽时为 0（本周期内排除该后端）
+func (qt *QuotaTracker) Factor(b *Backend) float64 {
+	if b == nil || b.Quota == nil || b.Quota.Tokens <= 0 {
+		return 1
+	}
+	used := qt.Used(b)
+	limit := float64(b.Quota.Tokens)
+	if float64(used) >= limit {
+		return 0
+	}
+	start := b.Quota.GetTaperStart() * limit
+	if float64(used) <= start {
+		return 1
+	}
+	return (limit - float64(used)) / (limit - start)
+}
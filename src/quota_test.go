@@ -0,0 +1,145 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestParseUsage(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		want  Usage
+		found bool
+	}{
+		{"openai", `{"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`, Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15}, true},
+		{"anthropic", `{"usage":{"input_tokens":7,"output_tokens":3}}`, Usage{PromptTokens: 7, CompletionTokens: 3, TotalTokens: 10, InputTokens: 7, OutputTokens: 3}, true},
+		{"no usage", `{"id":"x"}`, Usage{}, false},
+		{"invalid json", `not json`, Usage{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseUsage([]byte(tt.body))
+			if ok != tt.found {
+				t.Fatalf("found = %v, want %v", ok, tt.found)
+			}
+			if got != tt.want {
+				t.Errorf("parseUsage() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuotaTracker_Factor(t *testing.T) {
+	b := &Backend{Name: "b1", Quota: &Quota{Tokens: 1000, TaperStart: 0.5}}
+
+	tests := []struct {
+		name   string
+		record int64
+		want   float64
+	}{
+		{"no usage", 0, 1},
+		{"below taper start", 400, 1},
+		{"tapering halfway", 350, 0.5},
+		{"nearly exhausted", 150, 0.2},
+		{"exhausted", 100, 0},
+		{"over quota", 100, 0},
+	}
+
+	qt := NewQuotaTracker()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			qt.Record(b, tt.record)
+			if got := qt.Factor(b); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("Factor() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuotaTracker_NoQuota(t *testing.T) {
+	qt := NewQuotaTracker()
+	b := &Backend{Name: "b1"}
+	qt.Record(b, 1<<40)
+	if got := qt.Factor(b); got != 1 {
+		t.Errorf("backend without quota should have factor 1, got %v", got)
+	}
+}
+
+func TestQuotaTracker_PeriodReset(t *testing.T) {
+	qt := NewQuotaTracker()
+	now := time.Date(2026, 1, 31, 23, 0, 0, 0, time.UTC)
+	qt.now = func() time.Time { return now }
+	b := &Backend{Name: "b1", Quota: &Quota{Tokens: 100}}
+
+	qt.Record(b, 100)
+	if got := qt.Factor(b); got != 0 {
+		t.Fatalf("expected exhausted, got %v", got)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if got := qt.Factor(b); got != 1 {
+		t.Errorf("quota should reset in new period, got %v", got)
+	}
+}
+
+func TestRouter_Resolve_QuotaExhaustedExcluded(t *testing.T) {
+	cfg := &Config{
+		Backends: []Backend{
+			{Name: "backend1", URL: "http://backend1.com", Quota: &Quota{Tokens: 100}},
+			{Name: "backend2", URL: "http://backend2.com"},
+		},
+		Models: map[string]*ModelAlias{
+			"model-a": {
+				Routes: []ModelRoute{
+					{Backend: "backend1", Model: "m1", Priority: 1},
+					{Backend: "backend2", Model: "m2", Priority: 2},
+				},
+			},
+		},
+	}
+	router := NewRouter(newTestConfigManager(cfg), NewCooldownManager())
+
+	router.RecordUsage("backend1", 100)
+
+	routes, _ := router.Resolve("model-a")
+	if len(routes) != 1 || routes[0].BackendName != "backend2" {
+		t.Fatalf("exhausted backend should be excluded, got %+v", routes)
+	}
+}
+
+func TestRouter_Resolve_QuotaTapering(t *testing.T) {
+	cfg := &Config{
+		Backends: []Backend{
+			{Name: "backend1", URL: "http://backend1.com", Quota: &Quota{Tokens: 1000, TaperStart: 0.5}},
+			{Name: "backend2", URL: "http://backend2.com"},
+		},
+		Models: map[string]*ModelAlias{
+			"model-a": {
+				Routes: []ModelRoute{
+					{Backend: "backend1", Model: "m1", Priority: 1},
+					{Backend: "backend2", Model: "m2", Priority: 1},
+				},
+			},
+		},
+	}
+	router := NewRouter(newTestConfigManager(cfg), NewCooldownManager())
+
+	// 用量 950/1000，权重系数 0.1，应明显少于对等后端
+	router.RecordUsage("backend1", 950)
+
+	counts := make(map[string]int)
+	for i := 0; i < 2000; i++ {
+		routes, _ := router.Resolve("model-a")
+		counts[routes[0].BackendName]++
+	}
+
+	if counts["backend1"] == 0 {
+		t.Error("tapered backend should still receive some traffic")
+	}
+	if counts["backend1"]*3 > counts["backend2"] {
+		t.Errorf("tapered backend should receive far less traffic: %v", counts)
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TokenBucket 令牌桶，rate 为每秒补充的令牌数，burst 为桶容量
+type TokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func NewTokenBucket(rate float64, burst int, now time.Time) *TokenBucket {
+	if burst <= 0 {
+		burst = 1
+	}
+	return &TokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: now}
+}
+
+func (b *TokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+}
+
+// Remaining 返回当前可用的整数令牌数
+func (b *TokenBucket) Remaining(now time.Time) int {
+	b.refill(now)
+	return int(math.Floor(b.tokens))
+}
+
+// ResetIn 返回令牌桶补满所需的时长
+func (b *TokenBucket) ResetIn(now time.Time) time.Duration {
+	b.refill(now)
+	if b.rate <= 0 || b.tokens >= b.burst {
+		return 0
+	}
+	return time.Duration((b.burst - b.tokens) / b.rate * float64(time.Second))
+}
+
+// Adjust 追加扣除 n 个令牌（n 为负数时退回），允许透支，退回后不超过桶容量
+func (b *TokenBucket) Adjust(n float64, now time.Time) {
+	b.refill(now)
+	b.tokens -= n
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
+
+// Take 尝试取出 n 个令牌，失败时返回需要等待的时长
+func (b *TokenBucket) Take(n float64, now time.Time) (bool, time.Duration) {
+	b.refill(now)
+	if b.tokens >= n {
+		b.tokens -= n
+		return true, 0
+	}
+	if b.rate <= 0 {
+		return false, time.Hour
+	}
+	return false, time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+}
+
+const (
+	OverLimitQueue    = "queue"
+	OverLimitFallback = "fallback"
+	OverLimitReject   = "reject"
+)
+
+type rateDecision int
+
+const (
+	rateAllowed rateDecision = iota
+	rateFallback
+	rateRejected
+)
+
+type rateBucket struct {
+	rps    float64
+	burst  int
+	bucket *TokenBucket
+}
+
+// bucketFor 返回指定作用域的令牌桶，配置变化时重建该桶
+func bucketFor(buckets map[string]*rateBucket, scope string, rps float64, burst int, now time.Time) *TokenBucket {
+	rb, exists := buckets[scope]
+	if !exists || rb.rps != rps || rb.burst != burst {
+		rb = &rateBucket{rps: rps, burst: burst, bucket: NewTokenBucket(rps, burst, now)}
+		buckets[scope] = rb
+	}
+	return rb.bucket
+}
+
+// takeFrom 从指定作用域的令牌桶取一个令牌
+func takeFrom(buckets map[string]*rateBucket, scope string, rps float64, burst int, now time.Time) (bool, time.Duration) {
+	return bucketFor(buckets, scope, rps, burst, now).Take(1, now)
+}
+
+// OutboundLimiter 限制发往每个后端的请求速率
+type OutboundLimiter struct {
+	buckets map[string]*rateBucket
+	now     func() time.Time
+	mu      sync.Mutex
+}
+
+func NewOutboundLimiter() *OutboundLimiter {
+	return &OutboundLimiter{buckets: make(map[string]*rateBucket), now: time.Now}
+}
+
+func (ol *OutboundLimiter) take(b *Backend) (bool, time.Duration) {
+	ol.mu.Lock()
+	defer ol.mu.Unlock()
+	return takeFrom(ol.buckets, b.Name, b.RateLimit.RPS, b.RateLimit.Burst, ol.now())
+}
+
+// Acquire 为一次发往后端的请求申请配额，超限时按后端策略排队、回退或拒绝
+func (ol *OutboundLimiter) Acquire(ctx context.Context, b *Backend) rateDecision {
+	if b == nil || b.RateLimit == nil || b.RateLimit.RPS <= 0 {
+		return rateAllowed
+	}
+	ok, wait := ol.take(b)
+	if ok {
+		return rateAllowed
+	}
+
+	switch b.RateLimit.GetPolicy() {
+	case OverLimitReject:
+		return rateRejected
+	case OverLimitFallback:
+		return rateFallback
+	}
+
+	deadline := time.NewTimer(b.RateLimit.GetQueueTimeout())
+	defer deadline.Stop()
+	for {
+		retry := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			retry.Stop()
+			return rateFallback
+		case <-deadline.C:
+			retry.Stop()
+			return rateFallback
+		case <-retry.C:
+		}
+		if ok, wait = ol.take(b); ok {
+			return rateAllowed
+		}
+	}
+}
+
+// RateLimiter 限制客户端请求速率：配置了专属限额的客户端 Key 使用独立的令牌桶，
+// 其余客户端共享全局令牌桶；模型别名配置了限额时还需通过该模型的令牌桶
+type RateLimiter struct {
+	buckets map[string]*rateBucket
+	now     func() time.Time
+	mu      sync.Mutex
+}
+
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{buckets: make(map[string]*rateBucket), now: time.Now}
+}
+
+// clientKeyHash 返回请求携带的客户端 Key 的 SHA-256（十六进制），未携带时返回空字符串
+func clientKeyHash(r *http.Request) string {
+	key := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if key == "" {
+		key = r.Header.Get("x-api-key")
+	}
+	if key == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// RateLimitStatus 描述一次限流判断所依据的令牌桶状态
+type RateLimitStatus struct {
+	Scope      string
+	Limit      int
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// SetHeaders 写入 X-RateLimit-* 响应头，被拒绝时同时写入 Retry-After
+func (s *RateLimitStatus) SetHeaders(h http.Header) {
+	h.Set("X-RateLimit-Limit", strconv.Itoa(s.Limit))
+	h.Set("X-RateLimit-Remaining", strconv.Itoa(s.Remaining))
+	h.Set("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(s.Reset)))
+	if s.RetryAfter > 0 {
+		h.Set("Retry-After", strconv.Itoa(ceilSeconds(s.RetryAfter)))
+	}
+}
+
+// rateLimitReason 返回拒绝请求的令牌桶对应的 llm_proxy_rejections_total reason 标签
+func rateLimitReason(s *RateLimitStatus) string {
+	switch {
+	case s == nil:
+		return "rate_limit"
+	case strings.HasPrefix(s.Scope, "key:"):
+		return "rate_limit_key"
+	case strings.HasPrefix(s.Scope, "model:"):
+		return "rate_limit_model"
+	}
+	return "rate_limit_global"
+}
+
+// parseRetryAfter 解析上游响应的 Retry-After 头，支持秒数与 HTTP 日期两种格式，
+// 缺失或无法解析时返回 0
+func parseRetryAfter(v string, now time.Time) time.Duration {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs <= 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// Allow 判断请求是否可以放行，并返回最具体的（模型 > 客户端 Key > 全局）生效令牌桶状态；
+// 被拒绝时返回拒绝请求的令牌桶状态。没有任何令牌桶生效时状态为 nil
+func (rl *RateLimiter) Allow(cfg *RateLimitConfig, r *http.Request, modelAlias string) (bool, *RateLimitStatus) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	now := rl.now()
+
+	scope, rule := "global", RateLimitRule{RPS: cfg.RPS, Burst: cfg.Burst}
+	if hash := clientKeyHash(r); hash != "" {
+		if keyRule, ok := cfg.keyRule(hash); ok {
+			scope, rule = "key:"+hash, keyRule
+		}
+	}
+
+	var status *RateLimitStatus
+	check := func(scope string, rule RateLimitRule) bool {
+		bucket := bucketFor(rl.buckets, scope, rule.RPS, rule.Burst, now)
+		ok, wait := bucket.Take(1, now)
+		status = &RateLimitStatus{
+			Scope:      scope,
+			Limit:      int(bucket.burst),
+			Remaining:  bucket.Remaining(now),
+			Reset:      bucket.ResetIn(now),
+			RetryAfter: wait,
+		}
+		return ok
+	}
+
+	if rule.RPS > 0 && !check(scope, rule) {
+		return false, status
+	}
+	if modelRule, ok := cfg.Models[modelAlias]; ok && modelRule.RPS > 0 && !check("model:"+modelAlias, modelRule) {
+		// 被模型令牌桶拒绝的请求退回已取出的全局或客户端 Key 令牌，未放行的请求不消耗客户端额度
+		if rule.RPS > 0 {
+			bucketFor(rl.buckets, scope, rule.RPS, rule.Burst, now).Adjust(-1, now)
+		}
+		return false, status
+	}
+	return true, status
+}
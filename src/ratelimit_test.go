@@ -0,0 +1,394 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket_Take(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := NewTokenBucket(2, 2, now)
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := b.Take(1, now); !ok {
+			t.Fatalf("take %d within burst should succeed", i)
+		}
+	}
+	ok, wait := b.Take(1, now)
+	if ok || wait != 500*time.Millisecond {
+		t.Fatalf("over burst: ok=%v wait=%v, want false/500ms", ok, wait)
+	}
+	if ok, _ := b.Take(1, now.Add(500*time.Millisecond)); !ok {
+		t.Error("token should be refilled after wait")
+	}
+}
+
+func TestOutboundLimiter_Policies(t *testing.T) {
+	tests := []struct {
+		name   string
+		limit  BackendRateLimit
+		refill bool
+		want   rateDecision
+	}{
+		{"reject", BackendRateLimit{RPS: 0.001, Burst: 1, Policy: OverLimitReject}, false, rateRejected},
+		{"fallback", BackendRateLimit{RPS: 0.001, Burst: 1, Policy: OverLimitFallback}, false, rateFallback},
+		{"queue timeout", BackendRateLimit{RPS: 0.001, Burst: 1, Policy: OverLimitQueue, QueueTimeoutMs: 20}, false, rateFallback},
+		{"queue admitted", BackendRateLimit{RPS: 50, Burst: 1, Policy: OverLimitQueue, QueueTimeoutMs: 500}, true, rateAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ol := NewOutboundLimiter()
+			limit := tt.limit
+			b := &Backend{Name: "b1", RateLimit: &limit}
+
+			if got := ol.Acquire(context.Background(), b); got != rateAllowed {
+				t.Fatalf("first request should be allowed, got %v", got)
+			}
+			start := time.Now()
+			if got := ol.Acquire(context.Background(), b); got != tt.want {
+				t.Errorf("over limit: got %v, want %v", got, tt.want)
+			}
+			if tt.refill && time.Since(start) < 10*time.Millisecond {
+				t.Error("queued request should wait for a token")
+			}
+		})
+	}
+}
+
+func TestOutboundLimiter_QueueRespectsContext(t *testing.T) {
+	ol := NewOutboundLimiter()
+	b := &Backend{Name: "b1", RateLimit: &BackendRateLimit{RPS: 0.001, Burst: 1, QueueTimeoutMs: 5000}}
+	ol.Acquire(context.Background(), b)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if got := ol.Acquire(ctx, b); got != rateFallback {
+		t.Errorf("cancelled wait: got %v, want fallback", got)
+	}
+	if time.Since(start) > time.Second {
+		t.Error("queue wait should stop when the request context ends")
+	}
+}
+
+func TestProxy_OutboundRateLimit(t *testing.T) {
+	var limitedCalls, spareCalls int32
+	limited := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&limitedCalls, 1)
+		w.Write([]byte(`{}`))
+	}))
+	defer limited.Close()
+	spare := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&spareCalls, 1)
+		w.Write([]byte(`{}`))
+	}))
+	defer spare.Close()
+
+	tests := []struct {
+		policy    string
+		wantCode  int
+		wantSpare int32
+	}{
+		{OverLimitFallback, http.StatusOK, 1},
+		{OverLimitReject, http.StatusTooManyRequests, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			atomic.StoreInt32(&limitedCalls, 0)
+			atomic.StoreInt32(&spareCalls, 0)
+			proxy := newTestProxy(&Config{
+				Backends: []Backend{
+					{Name: "limited", URL: limited.URL, RateLimit: &BackendRateLimit{RPS: 0.001, Burst: 1, Policy: tt.policy}},
+					{Name: "spare", URL: spare.URL},
+				},
+				Models: map[string]*ModelAlias{
+					"model-a": {Routes: []ModelRoute{
+						{Backend: "limited", Model: "m1", Priority: 1},
+						{Backend: "spare", Model: "m2", Priority: 2},
+					}},
+				},
+			})
+
+			var last *httptest.ResponseRecorder
+			for i := 0; i < 2; i++ {
+				req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"model-a"}`))
+				last = httptest.NewRecorder()
+				proxy.ServeHTTP(last, req)
+			}
+
+			if last.Code != tt.wantCode {
+				t.Errorf("second request: expected %d, got %d", tt.wantCode, last.Code)
+			}
+			if limitedCalls != 1 || spareCalls != tt.wantSpare {
+				t.Errorf("calls limited=%d spare=%d, want 1/%d", limitedCalls, spareCalls, tt.wantSpare)
+			}
+		})
+	}
+}
+
+func hashKey(key string) string {
+	r := httptest.NewRequest("POST", "/", nil)
+	r.Header.Set("Authorization", "Bearer "+key)
+	return clientKeyHash(r)
+}
+
+func TestClientKeyHash(t *testing.T) {
+	bearer := httptest.NewRequest("POST", "/", nil)
+	bearer.Header.Set("Authorization", "Bearer team-a")
+	xkey := httptest.NewRequest("POST", "/", nil)
+	xkey.Header.Set("x-api-key", "team-a")
+
+	if clientKeyHash(bearer) != clientKeyHash(xkey) {
+		t.Error("Authorization and x-api-key with the same key should hash equally")
+	}
+	if h := clientKeyHash(bearer); len(h) != 64 || strings.Contains(h, "team-a") {
+		t.Errorf("expected sha256 hex digest, got %q", h)
+	}
+	if clientKeyHash(httptest.NewRequest("POST", "/", nil)) != "" {
+		t.Error("request without key should have empty hash")
+	}
+}
+
+func TestRateLimiter_PerKey(t *testing.T) {
+	cfg := &RateLimitConfig{
+		RPS:   0.001,
+		Burst: 1,
+		Keys: []KeyRateLimit{
+			{KeySHA256: hashKey("team-a"), RPS: 0.001, Burst: 2},
+			{KeySHA256: hashKey("team-b"), RPS: 0.001, Burst: 1},
+		},
+	}
+	rl := NewRateLimiter()
+	allow := func(key string) bool {
+		r := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+		if key != "" {
+			r.Header.Set("Authorization", "Bearer "+key)
+		}
+		ok, _ := rl.Allow(cfg, r, "model-a")
+		return ok
+	}
+
+	if !allow("team-a") || !allow("team-a") {
+		t.Fatal("team-a should get its burst of 2")
+	}
+	if allow("team-a") {
+		t.Error("team-a should be limited after its burst")
+	}
+	if !allow("team-b") {
+		t.Error("team-b has an independent bucket and should be allowed")
+	}
+	if allow("team-b") {
+		t.Error("team-b should be limited after its burst")
+	}
+
+	// 未配置专属限额的 Key 共享全局令牌桶
+	if !allow("other") {
+		t.Error("first unknown key request should use the global bucket")
+	}
+	if allow("") {
+		t.Error("global bucket should be shared by requests without a specific limit")
+	}
+}
+
+func TestRateLimiter_ModelScope(t *testing.T) {
+	cfg := &RateLimitConfig{Models: map[string]RateLimitRule{"model-a": {RPS: 0.001, Burst: 1}}}
+	rl := NewRateLimiter()
+	r := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+
+	if ok, _ := rl.Allow(cfg, r, "model-a"); !ok {
+		t.Fatal("first model-a request should be allowed")
+	}
+	if ok, _ := rl.Allow(cfg, r, "model-a"); ok {
+		t.Error("model-a should be limited")
+	}
+	if ok, _ := rl.Allow(cfg, r, "model-b"); !ok {
+		t.Error("model-b has no limit and should be allowed")
+	}
+}
+
+func TestRateLimiter_ModelRejectionRefundsKeyToken(t *testing.T) {
+	cfg := &RateLimitConfig{
+		Keys:   []KeyRateLimit{{KeySHA256: hashKey("team-a"), RPS: 0.001, Burst: 5}},
+		Models: map[string]RateLimitRule{"model-a": {RPS: 0.001, Burst: 1}},
+	}
+	rl := NewRateLimiter()
+	allow := func(model string) (bool, *RateLimitStatus) {
+		r := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+		r.Header.Set("Authorization", "Bearer team-a")
+		return rl.Allow(cfg, r, model)
+	}
+
+	if ok, _ := allow("model-a"); !ok {
+		t.Fatal("first model-a request should be allowed")
+	}
+	for i := 0; i < 3; i++ {
+		if ok, status := allow("model-a"); ok || status.Scope != "model:model-a" {
+			t.Fatalf("model-a retry %d should be rejected by the model bucket, got ok=%v status=%+v", i, ok, status)
+		}
+	}
+	ok, status := allow("model-b")
+	if !ok || status.Scope != "key:"+hashKey("team-a") {
+		t.Fatalf("model-b should be allowed by the key bucket, got ok=%v status=%+v", ok, status)
+	}
+	if status.Remaining != 3 {
+		t.Errorf("key bucket remaining = %d, want 3: model-level 429s must not consume key tokens", status.Remaining)
+	}
+}
+
+func TestProxy_ClientRateLimitRetryAfter(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer backend.Close()
+
+	proxy := newTestProxy(&Config{
+		Backends: []Backend{{Name: "b1", URL: backend.URL}},
+		Models: map[string]*ModelAlias{
+			"model-a": {Routes: []ModelRoute{{Backend: "b1", Model: "m1", Priority: 1}}},
+		},
+		RateLimit: RateLimitConfig{
+			Keys: []KeyRateLimit{{KeySHA256: hashKey("noisy"), RPS: 0.5, Burst: 1}},
+		},
+	})
+
+	send := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"model-a"}`))
+		req.Header.Set("Authorization", "Bearer "+key)
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := send("noisy"); w.Code != http.StatusOK {
+		t.Fatalf("first request: expected 200, got %d", w.Code)
+	}
+	w := send("noisy")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request: expected 429, got %d", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("Retry-After = %q, want 2", got)
+	}
+	if w := send("quiet"); w.Code != http.StatusOK {
+		t.Errorf("other key should not be limited, got %d", w.Code)
+	}
+}
+
+func TestTokenBucket_RemainingAndReset(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := NewTokenBucket(1, 4, now)
+	b.Take(1, now)
+	b.Take(1, now)
+
+	if got := b.Remaining(now); got != 2 {
+		t.Errorf("Remaining = %d, want 2", got)
+	}
+	if got := b.ResetIn(now); got != 2*time.Second {
+		t.Errorf("ResetIn = %v, want 2s", got)
+	}
+	if got := b.ResetIn(now.Add(5 * time.Second)); got != 0 {
+		t.Errorf("full bucket ResetIn = %v, want 0", got)
+	}
+}
+
+func TestRateLimiter_StatusMostSpecificScope(t *testing.T) {
+	cfg := &RateLimitConfig{
+		RPS:    10,
+		Burst:  10,
+		Keys:   []KeyRateLimit{{KeySHA256: hashKey("team-a"), RPS: 5, Burst: 5}},
+		Models: map[string]RateLimitRule{"model-a": {RPS: 2, Burst: 3}},
+	}
+	rl := NewRateLimiter()
+
+	tests := []struct {
+		key       string
+		model     string
+		wantScope string
+		wantLimit int
+	}{
+		{"", "model-b", "global", 10},
+		{"team-a", "model-b", "key:" + hashKey("team-a"), 5},
+		{"team-a", "model-a", "model:model-a", 3},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+		if tt.key != "" {
+			r.Header.Set("Authorization", "Bearer "+tt.key)
+		}
+		_, status := rl.Allow(cfg, r, tt.model)
+		if status == nil || status.Scope != tt.wantScope || status.Limit != tt.wantLimit {
+			t.Errorf("key=%q model=%s: status=%+v, want scope %s limit %d", tt.key, tt.model, status, tt.wantScope, tt.wantLimit)
+		}
+	}
+
+	if _, status := rl.Allow(&RateLimitConfig{}, httptest.NewRequest("POST", "/", nil), "model-a"); status != nil {
+		t.Errorf("no limits configured should return nil status, got %+v", status)
+	}
+}
+
+func TestProxy_RateLimitHeaders(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer backend.Close()
+
+	proxy := newTestProxy(&Config{
+		Backends: []Backend{{Name: "b1", URL: backend.URL}},
+		Models: map[string]*ModelAlias{
+			"model-a": {Routes: []ModelRoute{{Backend: "b1", Model: "m1", Priority: 1}}},
+		},
+		RateLimit: RateLimitConfig{RPS: 0.01, Burst: 3},
+	})
+
+	var remaining []string
+	var last *httptest.ResponseRecorder
+	for i := 0; i < 4; i++ {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"model-a"}`))
+		last = httptest.NewRecorder()
+		proxy.ServeHTTP(last, req)
+		if got := last.Header().Get("X-RateLimit-Limit"); got != "3" {
+			t.Errorf("request %d: X-RateLimit-Limit = %q, want 3", i, got)
+		}
+		if last.Header().Get("X-RateLimit-Reset") == "" {
+			t.Errorf("request %d: missing X-RateLimit-Reset", i)
+		}
+		remaining = append(remaining, last.Header().Get("X-RateLimit-Remaining"))
+	}
+
+	if want := "2,1,0,0"; strings.Join(remaining, ",") != want {
+		t.Errorf("X-RateLimit-Remaining sequence = %v, want %s", remaining, want)
+	}
+	if last.Code != http.StatusTooManyRequests || last.Header().Get("Retry-After") == "" {
+		t.Errorf("rejected request: code=%d Retry-After=%q", last.Code, last.Header().Get("Retry-After"))
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		value string
+		want  time.Duration
+	}{
+		{"seconds", "120", 120 * time.Second},
+		{"http date", now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second},
+		{"past date", now.Add(-time.Minute).Format(http.TimeFormat), 0},
+		{"absent", "", 0},
+		{"invalid", "soon", 0},
+		{"negative", "-5", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseRetryAfter(tt.value, now); got != tt.want {
+				t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const redactedValue = "[REDACTED]"
+
+// credentialHeaders 的值无论是否开启 mask_sensitive 都不会写入请求日志
+var credentialHeaders = []string{"Authorization", "Proxy-Authorization", "X-Api-Key", "Api-Key", "X-Goog-Api-Key"}
+
+// formatLoggedHeaders 按名称排序输出请求头，凭据类请求头只保留认证方案（如 Bearer）
+func formatLoggedHeaders(h http.Header) string {
+	names := make([]string, 0, len(h))
+	for k := range h {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, k := range names {
+		values := h[k]
+		if isCredentialHeader(k) {
+			masked := make([]string, len(values))
+			for i, v := range values {
+				masked[i] = maskCredential(v)
+			}
+			values = masked
+		}
+		fmt.Fprintf(&b, "%s: %s\n", k, strings.Join(values, ", "))
+	}
+	return b.String()
+}
+
+func isCredentialHeader(name string) bool {
+	for _, h := range credentialHeaders {
+		if strings.EqualFold(h, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func maskCredential(v string) string {
+	if scheme, _, ok := strings.Cut(v, " "); ok {
+		return scheme + " ****"
+	}
+	return "****"
+}
+
+// redactBody 将请求体中 logging.redact_fields 指定的字段替换为 [REDACTED]。
+// 路径以 . 分隔，字段名后加 [] 表示遍历数组，例如 messages[].content；
+// 请求体不是 JSON 或没有匹配字段时原样返回
+func redactBody(body []byte, paths []string) []byte {
+	if len(paths) == 0 {
+		return body
+	}
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return body
+	}
+	changed := false
+	for _, path := range paths {
+		if redactPath(doc, strings.Split(path, ".")) {
+			changed = true
+		}
+	}
+	if !changed {
+		return body
+	}
+	redacted, err := json.Marshal(doc)
+	if err != nil {
+		return body
+	}
+	return redacted
+}
+
+func redactPath(node interface{}, segments []string) bool {
+	obj, ok := node.(map[string]interface{})
+	if !ok || len(segments) == 0 {
+		return false
+	}
+	key, each := strings.CutSuffix(segments[0], "[]")
+	value, exists := obj[key]
+	if !exists {
+		return false
+	}
+
+	if !each {
+		if len(segments) == 1 {
+			obj[key] = redactedValue
+			return true
+		}
+		return redactPath(value, segments[1:])
+	}
+
+	items, ok := value.([]interface{})
+	if !ok {
+		return false
+	}
+	changed := false
+	for i, item := range items {
+		if len(segments) == 1 {
+			items[i] = redactedValue
+			changed = true
+		} else if redactPath(item, segments[1:]) {
+			changed = true
+		}
+	}
+	return changed
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestFormatLoggedHeaders_MasksCredentials(t *testing.T) {
+	h := http.Header{}
+	h.Set("Authorization", "Bearer sk-secret-token")
+	h.Set("X-Api-Key", "anthropic-secret")
+	h.Set("Content-Type", "application/json")
+
+	got := formatLoggedHeaders(h)
+
+	for _, secret := range []string{"sk-secret-token", "anthropic-secret"} {
+		if strings.Contains(got, secret) {
+			t.Errorf("logged headers leak %q:\n%s", secret, got)
+		}
+	}
+	for _, want := range []string{"Authorization: Bearer ****\n", "X-Api-Key: ****\n", "Content-Type: application/json\n"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("logged headers missing %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestRedactBody(t *testing.T) {
+	body := `{"model":"gpt-4","api_key":"k","messages":[{"role":"user","content":"secret prompt"},{"role":"assistant","content":"secret reply"}]}`
+
+	tests := []struct {
+		name      string
+		paths     []string
+		wantField map[string]interface{}
+		wantRaw   bool
+	}{
+		{
+			name:    "no paths configured",
+			wantRaw: true,
+		},
+		{
+			name:  "array field",
+			paths: []string{"messages[].content"},
+			wantField: map[string]interface{}{
+				"model":              "gpt-4",
+				"api_key":            "k",
+				"messages.0.role":    "user",
+				"messages.0.content": redactedValue,
+				"messages.1.content": redactedValue,
+				"messages.1.role":    "assistant",
+			},
+		},
+		{
+			name:  "top-level field",
+			paths: []string{"api_key"},
+			wantField: map[string]interface{}{
+				"api_key":            redactedValue,
+				"messages.0.content": "secret prompt",
+			},
+		},
+		{
+			name:    "no matching field",
+			paths:   []string{"prompt", "messages[].name"},
+			wantRaw: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := redactBody([]byte(body), tt.paths)
+			if tt.wantRaw {
+				if string(got) != body {
+					t.Errorf("body changed: %s", got)
+				}
+				return
+			}
+			var doc map[string]interface{}
+			if err := json.Unmarshal(got, &doc); err != nil {
+				t.Fatalf("redacted body is not JSON: %v", err)
+			}
+			for path, want := range tt.wantField {
+				if v := lookupPath(doc, path); v != want {
+					t.Errorf("%s = %v, want %v", path, v, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRedactBody_NonJSON(t *testing.T) {
+	body := []byte("not json")
+	if got := redactBody(body, []string{"messages[].content"}); string(got) != "not json" {
+		t.Errorf("non-JSON body changed: %s", got)
+	}
+}
+
+// lookupPath 按 a.0.b 形式读取解码后的 JSON
+func lookupPath(doc interface{}, path string) interface{} {
+	for _, seg := range strings.Split(path, ".") {
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			doc = node[seg]
+		case []interface{}:
+			i := int(seg[0] - '0')
+			if i >= len(node) {
+				return nil
+			}
+			doc = node[i]
+		default:
+			return nil
+		}
+	}
+	return doc
+}
@@ -1,31 +1,90 @@
 package main
 
 import (
+	"hash/fnv"
+	"math"
 	"math/rand"
+	"slices"
 	"sort"
+	"sync"
 	"time"
 )
 
 type Router struct {
 	configMgr *ConfigManager
 	cooldown  *CooldownManager
+	quota     *QuotaTracker
+	breaker   *CircuitBreaker
+	inflight  *InFlightTracker
+	scores    *HealthScorer
+	health    *HealthChecker
+	clients   *upstreamClients
+	cursors   map[string]uint64
+	rng       *rand.Rand
+	rngMu     sync.Mutex
+	mu        sync.Mutex
 }
 
 func NewRouter(cfg *ConfigManager, cd *CooldownManager) *Router {
-	return &Router{configMgr: cfg, cooldown: cd}
+	breaker := NewCircuitBreaker(cfg)
+	clients := &upstreamClients{}
+	return &Router{
+		configMgr: cfg,
+		cooldown:  cd,
+		quota:     NewQuotaTracker(),
+		breaker:   breaker,
+		inflight:  NewInFlightTracker(),
+		scores:    NewHealthScorer(),
+		health:    NewHealthChecker(cfg, cd, breaker, clients),
+		clients:   clients,
+		cursors:   make(map[string]uint64),
+		rng:       rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// nextCursor 返回别名的轮询游标并自增
+func (r *Router) nextCursor(alias string) uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c := r.cursors[alias]
+	r.cursors[alias] = c + 1
+	return c
+}
+
+// RecordUsage 记录后端实际消耗的 token，用于配额降权
+func (r *Router) RecordUsage(backendName string, tokens int64) {
+	r.quota.Record(r.configMgr.GetBackend(backendName), tokens)
 }
 
 type ResolvedRoute struct {
 	BackendName string
 	BackendURL  string
 	Model       string
+	Transforms  []string
 }
 
 func (r *Router) Resolve(alias string) ([]ResolvedRoute, error) {
-	return r.resolveWithVisited(alias, make(map[string]bool))
+	routes, _ := r.ResolveSession(alias, "", false)
+	return routes, nil
+}
+
+// ResolveTrace 与 Resolve 相同，同时返回路由决策过程（考虑过的候选与跳过原因）
+func (r *Router) ResolveTrace(alias string) ([]ResolvedRoute, *RouteTrace) {
+	return r.ResolveSession(alias, "", true)
+}
+
+// ResolveSession 解析别名的路由；sticky_hash 策略下同一 session 固定映射到同一后端。
+// traced 为 true 时同时返回路由决策过程
+func (r *Router) ResolveSession(alias, session string, traced bool) ([]ResolvedRoute, *RouteTrace) {
+	var trace *RouteTrace
+	if traced {
+		trace = &RouteTrace{Strategy: r.configMgr.Get().LoadBalance.GetStrategy()}
+	}
+	routes, _ := r.resolveWithVisited(alias, session, make(map[string]bool), trace)
+	return routes, trace
 }
 
-func (r *Router) resolveWithVisited(alias string, visited map[string]bool) ([]ResolvedRoute, error) {
+func (r *Router) resolveWithVisited(alias, session string, visited map[string]bool, trace *RouteTrace) ([]ResolvedRoute, error) {
 	if visited[alias] {
 		LogGeneral("WARN", "检测到循环回退: 别名=%s", alias)
 		return nil, nil
@@ -34,62 +93,137 @@ func (r *Router) resolveWithVisited(alias string, visited map[string]bool) ([]Re
 
 	cfg := r.configMgr.Get()
 	var result []ResolvedRoute
+	// 健康检查失败的后端排在该别名其他可用路由之后，作为最后的尝试
+	var degraded []ResolvedRoute
+	var degradedCandidates []RouteCandidate
 
 	modelAlias, exists := cfg.Models[alias]
+	// 模式别名匹配的是一族模型，转发给后端的是请求中的原始模型名
+	var forwardModel string
+	if !exists {
+		var pattern string
+		if pattern, modelAlias, exists = cfg.MatchModelPattern(alias); exists {
+			forwardModel = alias
+			LogGeneral("DEBUG", "模型 %s 匹配模式别名 %s", alias, pattern)
+		}
+	}
 	if exists && modelAlias != nil && modelAlias.IsEnabled() {
 		sorted := make([]ModelRoute, len(modelAlias.Routes))
 		copy(sorted, modelAlias.Routes)
+		if forwardModel != "" {
+			for i := range sorted {
+				sorted[i].Model = forwardModel
+			}
+		}
 		sort.Slice(sorted, func(i, j int) bool {
 			return sorted[i].Priority < sorted[j].Priority
 		})
 
-		rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+		strategy := cfg.LoadBalance.GetStrategy()
+		// 权重在加锁前按同一份配置快照算好，rngMu 只保护 rng
+		weight := r.routeWeights(cfg, sorted, strategy == StrategyScoreWeighted)
+		r.rngMu.Lock()
 		for i := 0; i < len(sorted); {
 			j := i + 1
 			for j < len(sorted) && sorted[j].Priority == sorted[i].Priority {
 				j++
 			}
 			if j-i > 1 {
-				rng.Shuffle(j-i, func(a, b int) {
-					sorted[i+a], sorted[i+b] = sorted[i+b], sorted[i+a]
-				})
+				switch {
+				case strategy == StrategyRoundRobin && i == 0:
+					rotateRoutes(sorted[i:j], r.nextCursor(alias))
+				case strategy == StrategyLeastConnections && i == 0:
+					weightedShuffle(r.rng, sorted[i:j], weight)
+					r.sortByInFlight(sorted[i:j])
+				case strategy == StrategyWeightedRandom && i == 0:
+					pickWeighted(r.rng, sorted[i:j], weight)
+				case strategy == StrategyWeightedRandom:
+					// 较低优先级组保持配置顺序，回退顺序可预期
+				case strategy == StrategyStickyHash && session != "":
+					stickyOrder(session, sorted[i:j], weight)
+				default:
+					weightedShuffle(r.rng, sorted[i:j], weight)
+				}
 			}
 			i = j
 		}
+		r.rngMu.Unlock()
 
 		for _, route := range sorted {
+			candidate := RouteCandidate{Alias: alias, Backend: route.Backend, Model: route.Model, Priority: route.Priority}
+			if trace != nil {
+				candidate.Weight = weight(route)
+				candidate.InFlight = r.inflight.Count(route.Backend)
+			}
+			skip := func(reason string) {
+				if trace != nil {
+					candidate.Skipped = reason
+					trace.Candidates = append(trace.Candidates, candidate)
+				}
+			}
+
 			if !route.IsEnabled() {
+				skip(SkipRouteDisabled)
 				continue
 			}
 			key := r.cooldown.Key(route.Backend, route.Model)
 			if r.cooldown.IsCoolingDown(key) {
 				LogGeneral("DEBUG", "跳过冷却中的后端: %s", key)
+				skip(SkipCooldown)
+				continue
+			}
+			if r.breaker.IsOpen(key) {
+				LogGeneral("DEBUG", "跳过熔断中的后端: %s", key)
+				skip(SkipCircuitOpen)
 				continue
 			}
-			backend := r.configMgr.GetBackend(route.Backend)
+			backend := cfg.GetBackend(route.Backend)
 			if backend == nil {
 				LogGeneral("WARN", "后端不存在: %s", route.Backend)
+				skip(SkipBackendMissing)
 				continue
 			}
 			if !backend.IsEnabled() {
 				LogGeneral("DEBUG", "跳过已禁用的后端: %s", route.Backend)
+				skip(SkipBackendDisabled)
+				continue
+			}
+			if r.quota.Factor(backend) <= 0 {
+				LogGeneral("DEBUG", "跳过配额耗尽的后端: %s", route.Backend)
+				skip(SkipQuotaExhausted)
 				continue
 			}
-			result = append(result, ResolvedRoute{
+			resolved := ResolvedRoute{
 				BackendName: backend.Name,
 				BackendURL:  backend.URL,
 				Model:       route.Model,
-			})
+				Transforms:  route.Transforms,
+			}
+			if !r.health.IsHealthy(backend.Name) {
+				LogGeneral("DEBUG", "健康检查失败的后端降级: %s", route.Backend)
+				candidate.Unhealthy = true
+				degraded = append(degraded, resolved)
+				degradedCandidates = append(degradedCandidates, candidate)
+				continue
+			}
+			if trace != nil {
+				trace.Candidates = append(trace.Candidates, candidate)
+			}
+			result = append(result, resolved)
+		}
+		result = append(result, degraded...)
+		if trace != nil {
+			trace.Candidates = append(trace.Candidates, degradedCandidates...)
 		}
 	}
 
-	fallbackRoutes := r.collectFallbackRoutes(alias, visited)
+	fallbackRoutes := r.collectFallbackRoutes(alias, session, visited, trace)
 	result = append(result, fallbackRoutes...)
 
 	return result, nil
 }
 
-func (r *Router) collectFallbackRoutes(alias string, visited map[string]bool) []ResolvedRoute {
+func (r *Router) collectFallbackRoutes(alias, session string, visited map[string]bool, trace *RouteTrace) []ResolvedRoute {
 	cfg := r.configMgr.Get()
 	fallbacks, exists := cfg.Fallback.AliasFallback[alias]
 	if !exists || len(fallbacks) == 0 {
@@ -97,8 +231,9 @@ func (r *Router) collectFallbackRoutes(alias string, visited map[string]bool) []
 	}
 
 	var result []ResolvedRoute
-	for _, fallbackAlias := range fallbacks {
-		routes, _ := r.resolveWithVisited(fallbackAlias, visited)
+	for _, target := range r.orderFallbacks(fallbacks) {
+		fallbackAlias := target.Alias
+		routes, _ := r.resolveWithVisited(fallbackAlias, session, visited, trace)
 		if len(routes) > 0 {
 			LogGeneral("DEBUG", "添加回退路由: %s -> %s", alias, fallbackAlias)
 			result = append(result, routes...)
@@ -106,3 +241,152 @@ func (r *Router) collectFallbackRoutes(alias string, visited map[string]bool) []
 	}
 	return result
 }
+
+// orderFallbacks 按 priority 升序排列回退目标；任一目标配置了 weight 时，
+// 同一优先级内按权重随机排序，否则保持配置顺序
+func (r *Router) orderFallbacks(targets []FallbackTarget) []FallbackTarget {
+	ordered := append([]FallbackTarget(nil), targets...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].Priority < ordered[j].Priority
+	})
+	if !slices.ContainsFunc(ordered, func(t FallbackTarget) bool { return t.Weight > 0 }) {
+		return ordered
+	}
+
+	r.rngMu.Lock()
+	defer r.rngMu.Unlock()
+	for i := 0; i < len(ordered); {
+		j := i + 1
+		for j < len(ordered) && ordered[j].Priority == ordered[i].Priority {
+			j++
+		}
+		keys := make([]float64, j-i)
+		for k := range keys {
+			keys[k] = math.Pow(r.rng.Float64(), 1/ordered[i+k].GetWeight())
+		}
+		sortByKey(ordered[i:j], keys)
+		i = j
+	}
+	return ordered
+}
+
+// rotateRoutes 将路由按游标循环左移，用于最高优先级组的轮询
+func rotateRoutes(routes []ModelRoute, cursor uint64) {
+	n := uint64(len(routes))
+	shift := int(cursor % n)
+	rotated := append(append([]ModelRoute{}, routes[shift:]...), routes[:shift]...)
+	copy(routes, rotated)
+}
+
+// sortByInFlight 按后端处理中的请求数升序排列，数量相同的保持原有（随机）顺序
+func (r *Router) sortByInFlight(routes []ModelRoute) {
+	counts := make(map[string]int64, len(routes))
+	for _, route := range routes {
+		counts[route.Backend] = r.inflight.Count(route.Backend)
+	}
+	sort.SliceStable(routes, func(a, b int) bool {
+		return counts[routes[a].Backend] < counts[routes[b].Backend]
+	})
+}
+
+// routeWeights 返回路由在同优先级组内的有效权重：配置的 weight 乘以配额降权系数，
+// scored 时（score_weighted 策略）再乘以后端的健康评分。各后端的系数按 cfg 预先算好，
+// 返回的函数不再读取配置或加锁
+func (r *Router) routeWeights(cfg *Config, routes []ModelRoute, scored bool) func(ModelRoute) float64 {
+	factors := make(map[string]float64, len(routes))
+	for _, route := range routes {
+		if _, done := factors[route.Backend]; done {
+			continue
+		}
+		factor := r.quota.Factor(cfg.GetBackend(route.Backend))
+		if scored {
+			factor *= r.scores.Score(route.Backend)
+		}
+		factors[route.Backend] = factor
+	}
+	return func(route ModelRoute) float64 {
+		return route.GetWeight() * factors[route.Backend]
+	}
+}
+
+// pickWeighted 按权重比例随机选出一条路由放在最前，其余路由保持原有顺序；
+// 权重全为 0 时不调整顺序
+func pickWeighted(rng *rand.Rand, routes []ModelRoute, weight func(ModelRoute) float64) {
+	weights := make([]float64, len(routes))
+	var total float64
+	for i, route := range routes {
+		if w := weight(route); w > 0 {
+			weights[i] = w
+			total += w
+		}
+	}
+	if total <= 0 {
+		return
+	}
+	target := rng.Float64() * total
+	picked := len(routes) - 1
+	for i, w := range weights {
+		if target < w {
+			picked = i
+			break
+		}
+		target -= w
+	}
+	for picked > 0 && weights[picked] <= 0 {
+		picked--
+	}
+	chosen := routes[picked]
+	copy(routes[1:picked+1], routes[:picked])
+	routes[0] = chosen
+}
+
+// stickyOrder 按 session 对路由做加权一致性哈希排序（rendezvous hashing）：
+// 同一 session 总是得到相同顺序，首选路由被冷却或熔断跳过时顺延到下一条，
+// 其余 session 的映射不受影响
+func stickyOrder(session string, routes []ModelRoute, weight func(ModelRoute) float64) {
+	keys := make([]float64, len(routes))
+	for i, route := range routes {
+		w := weight(route)
+		if w <= 0 {
+			keys[i] = -1
+			continue
+		}
+		h := fnv.New64a()
+		h.Write([]byte(session + "\x00" + route.Backend + "/" + route.Model))
+		// 映射到 (0, 1) 后与 weightedShuffle 一样按 u^(1/w) 排序
+		u := (float64(h.Sum64()>>11) + 0.5) / (1 << 53)
+		keys[i] = math.Pow(u, 1/w)
+	}
+	sortByKey(routes, keys)
+}
+
+// weightedShuffle 按权重对路由做加权随机排列（Efraimidis-Spirakis），
+// 权重越大越可能排在前面，权重为 0 的路由排在最后
+func weightedShuffle(rng *rand.Rand, routes []ModelRoute, weight func(ModelRoute) float64) {
+	keys := make([]float64, len(routes))
+	for i, route := range routes {
+		w := weight(route)
+		if w <= 0 {
+			keys[i] = -1
+			continue
+		}
+		keys[i] = math.Pow(rng.Float64(), 1/w)
+	}
+	sortByKey(routes, keys)
+}
+
+// sortByKey 按 keys 从大到小重排，键相同时保持原有顺序
+func sortByKey[T any](routes []T, keys []float64) {
+	idx := make([]int, len(routes))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(a, b int) bool {
+		return keys[idx[a]] > keys[idx[b]]
+	})
+	shuffled := make([]T, len(routes))
+	for i, k := range idx {
+		shuffled[i] = routes[k]
+	}
+	copy(routes, shuffled)
+}
@@ -1,6 +1,11 @@
 package main
 
 import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -200,8 +205,8 @@ func TestRouter_Resolve_AliasFallback(t *testing.T) {
 			},
 		},
 		Fallback: Fallback{
-			AliasFallback: map[string][]string{
-				"primary": {"fallback"},
+			AliasFallback: map[string][]FallbackTarget{
+				"primary": {{Alias: "fallback"}},
 			},
 		},
 	}
@@ -240,9 +245,9 @@ func TestRouter_Resolve_CircularFallback(t *testing.T) {
 			},
 		},
 		Fallback: Fallback{
-			AliasFallback: map[string][]string{
-				"alias-a": {"alias-b"},
-				"alias-b": {"alias-a"},
+			AliasFallback: map[string][]FallbackTarget{
+				"alias-a": {{Alias: "alias-b"}},
+				"alias-b": {{Alias: "alias-a"}},
 			},
 		},
 	}
@@ -357,3 +362,481 @@ func TestRouter_Resolve_MissingBackend(t *testing.T) {
 		t.Errorf("Expected backend1, got %s", routes[0].BackendName)
 	}
 }
+
+func TestRouter_Resolve_RoundRobin(t *testing.T) {
+	cfg := &Config{
+		Backends: []Backend{
+			{Name: "backend1", URL: "http://backend1.com"},
+			{Name: "backend2", URL: "http://backend2.com"},
+			{Name: "backend3", URL: "http://backend3.com"},
+			{Name: "backup", URL: "http://backup.com"},
+		},
+		Models: map[string]*ModelAlias{
+			"model-a": {
+				Routes: []ModelRoute{
+					{Backend: "backend1", Model: "m1", Priority: 1},
+					{Backend: "backend2", Model: "m2", Priority: 1},
+					{Backend: "backend3", Model: "m3", Priority: 1},
+					{Backend: "backup", Model: "m4", Priority: 2},
+				},
+			},
+		},
+		LoadBalance: LoadBalance{Strategy: StrategyRoundRobin},
+	}
+
+	cm := newTestConfigManager(cfg)
+	cd := NewCooldownManager()
+	router := NewRouter(cm, cd)
+
+	const perBackend = 50
+	counts := make(map[string]int)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < 3*perBackend; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			routes, _ := router.Resolve("model-a")
+			mu.Lock()
+			counts[routes[0].BackendName]++
+			mu.Unlock()
+			if len(routes) != 4 || routes[3].BackendName != "backup" {
+				t.Errorf("lower priority group should stay last, got %+v", routes)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, name := range []string{"backend1", "backend2", "backend3"} {
+		if counts[name] != perBackend {
+			t.Errorf("%s got %d first picks, want %d (counts=%v)", name, counts[name], perBackend, counts)
+		}
+	}
+}
+
+func TestRouter_Resolve_RoundRobinSequence(t *testing.T) {
+	cfg := &Config{
+		Backends: []Backend{
+			{Name: "backend1", URL: "http://backend1.com"},
+			{Name: "backend2", URL: "http://backend2.com"},
+		},
+		Models: map[string]*ModelAlias{
+			"model-a": {
+				Routes: []ModelRoute{
+					{Backend: "backend1", Model: "m1", Priority: 1},
+					{Backend: "backend2", Model: "m2", Priority: 1},
+				},
+			},
+		},
+		LoadBalance: LoadBalance{Strategy: StrategyRoundRobin},
+	}
+	router := NewRouter(newTestConfigManager(cfg), NewCooldownManager())
+
+	var got []string
+	for i := 0; i < 4; i++ {
+		routes, _ := router.Resolve("model-a")
+		got = append(got, routes[0].BackendName)
+	}
+
+	want := "backend1,backend2,backend1,backend2"
+	if strings.Join(got, ",") != want {
+		t.Errorf("round-robin order = %v, want %s", got, want)
+	}
+}
+
+func TestRouter_Resolve_LeastConnectionsStaggered(t *testing.T) {
+	cfg := &Config{
+		Backends: []Backend{
+			{Name: "backend1", URL: "http://backend1.com"},
+			{Name: "backend2", URL: "http://backend2.com"},
+			{Name: "backup", URL: "http://backup.com"},
+		},
+		Models: map[string]*ModelAlias{
+			"model-a": {
+				Routes: []ModelRoute{
+					{Backend: "backend1", Model: "m1", Priority: 1},
+					{Backend: "backend2", Model: "m2", Priority: 1},
+					{Backend: "backup", Model: "m3", Priority: 2},
+				},
+			},
+		},
+		LoadBalance: LoadBalance{Strategy: StrategyLeastConnections},
+	}
+	router := NewRouter(newTestConfigManager(cfg), NewCooldownManager())
+
+	pick := func() string {
+		routes, _ := router.Resolve("model-a")
+		if len(routes) != 3 || routes[2].BackendName != "backup" {
+			t.Fatalf("lower priority group should stay last, got %+v", routes)
+		}
+		return routes[0].BackendName
+	}
+
+	// backend1 上有两个长时间流式请求，backend2 上的请求很快完成
+	long1 := router.inflight.Begin("backend1")
+	long2 := router.inflight.Begin("backend1")
+	short := router.inflight.Begin("backend2")
+
+	for i := 0; i < 10; i++ {
+		if got := pick(); got != "backend2" {
+			t.Fatalf("expected less loaded backend2, got %s", got)
+		}
+	}
+
+	short()
+	extra := router.inflight.Begin("backend2")
+	extra2 := router.inflight.Begin("backend2")
+	extra3 := router.inflight.Begin("backend2")
+	if got := pick(); got != "backend1" {
+		t.Errorf("backend1 (2 in flight) should beat backend2 (3 in flight), got %s", got)
+	}
+
+	long1()
+	long2()
+	if got := pick(); got != "backend1" {
+		t.Errorf("idle backend1 should be preferred, got %s", got)
+	}
+	extra()
+	extra2()
+	extra3()
+}
+
+func TestPickWeighted_Distribution(t *testing.T) {
+	weights := map[string]float64{"a": 3, "b": 1, "c": 0, "d": 6}
+	weight := func(r ModelRoute) float64 { return weights[r.Backend] }
+	rng := rand.New(rand.NewSource(42))
+
+	const iterations = 20000
+	counts := make(map[string]int)
+	for i := 0; i < iterations; i++ {
+		routes := []ModelRoute{{Backend: "a"}, {Backend: "b"}, {Backend: "c"}, {Backend: "d"}}
+		pickWeighted(rng, routes, weight)
+		counts[routes[0].Backend]++
+
+		rest := make([]string, 0, 3)
+		for _, r := range routes[1:] {
+			rest = append(rest, r.Backend)
+		}
+		for j := 1; j < len(rest); j++ {
+			if rest[j-1] > rest[j] {
+				t.Fatalf("remaining routes should keep their order, got %v", rest)
+			}
+		}
+	}
+
+	if counts["c"] != 0 {
+		t.Errorf("zero-weight route picked %d times", counts["c"])
+	}
+	for name, w := range map[string]float64{"a": 0.3, "b": 0.1, "d": 0.6} {
+		got := float64(counts[name]) / iterations
+		if math.Abs(got-w) > 0.02 {
+			t.Errorf("route %s picked %.3f of the time, want %.2f ± 0.02", name, got, w)
+		}
+	}
+}
+
+func TestRouter_Resolve_WeightedRandomTopGroupOnly(t *testing.T) {
+	cfg := &Config{
+		Backends: []Backend{
+			{Name: "backend1", URL: "http://backend1.com"},
+			{Name: "backend2", URL: "http://backend2.com"},
+			{Name: "backup1", URL: "http://backup1.com"},
+			{Name: "backup2", URL: "http://backup2.com"},
+		},
+		Models: map[string]*ModelAlias{
+			"model-a": {
+				Routes: []ModelRoute{
+					{Backend: "backend1", Model: "m1", Priority: 1},
+					{Backend: "backend2", Model: "m2", Priority: 1},
+					{Backend: "backup1", Model: "m3", Priority: 2},
+					{Backend: "backup2", Model: "m4", Priority: 2},
+				},
+			},
+		},
+		LoadBalance: LoadBalance{Strategy: StrategyWeightedRandom},
+	}
+	router := NewRouter(newTestConfigManager(cfg), NewCooldownManager())
+	router.rng = rand.New(rand.NewSource(1))
+
+	seen := make(map[string]bool)
+	for i := 0; i < 50; i++ {
+		routes, _ := router.Resolve("model-a")
+		if len(routes) != 4 || routes[2].BackendName != "backup1" || routes[3].BackendName != "backup2" {
+			t.Fatalf("lower priority group should keep config order, got %+v", routes)
+		}
+		seen[routes[0].BackendName] = true
+	}
+	if !seen["backend1"] || !seen["backend2"] {
+		t.Errorf("both top-priority backends should be selected over 50 resolves, got %v", seen)
+	}
+}
+
+func TestRouter_Resolve_ConfiguredWeights(t *testing.T) {
+	for _, strategy := range []string{StrategyRandom, StrategyWeightedRandom} {
+		t.Run(strategy, func(t *testing.T) {
+			cfg := &Config{
+				Backends: []Backend{
+					{Name: "backend1", URL: "http://backend1.com"},
+					{Name: "backend2", URL: "http://backend2.com"},
+				},
+				Models: map[string]*ModelAlias{
+					"model-a": {
+						Routes: []ModelRoute{
+							{Backend: "backend1", Model: "m1", Priority: 1, Weight: 70},
+							{Backend: "backend2", Model: "m2", Priority: 1, Weight: 30},
+						},
+					},
+				},
+				LoadBalance: LoadBalance{Strategy: strategy},
+			}
+			router := NewRouter(newTestConfigManager(cfg), NewCooldownManager())
+			router.rng = rand.New(rand.NewSource(7))
+
+			const iterations = 5000
+			first := 0
+			for i := 0; i < iterations; i++ {
+				routes, _ := router.Resolve("model-a")
+				if routes[0].BackendName == "backend1" {
+					first++
+				}
+			}
+			if got := float64(first) / iterations; math.Abs(got-0.7) > 0.03 {
+				t.Errorf("backend1 selected %.3f of the time, want 0.70 ± 0.03", got)
+			}
+		})
+	}
+}
+
+func TestRouter_RouteWeights(t *testing.T) {
+	cfg := &Config{
+		Backends: []Backend{
+			{Name: "tapered", URL: "http://tapered.com", Quota: &Quota{Tokens: 1000, TaperStart: 0.5}},
+			{Name: "plain", URL: "http://plain.com"},
+		},
+	}
+	router := NewRouter(newTestConfigManager(cfg), NewCooldownManager())
+	router.quota.Record(&cfg.Backends[0], 750)
+	router.scores.Record("plain", 2*time.Second, true)
+	routes := []ModelRoute{
+		{Backend: "tapered", Weight: 4},
+		{Backend: "plain", Weight: 2},
+		{Backend: "missing"},
+	}
+
+	tests := []struct {
+		name   string
+		scored bool
+		want   []float64
+	}{
+		{"quota factor", false, []float64{2, 2, 1}},
+		{"with health score", true, []float64{2, 1, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			weight := router.routeWeights(cfg, routes, tt.scored)
+			for i, route := range routes {
+				if got := weight(route); math.Abs(got-tt.want[i]) > 1e-9 {
+					t.Errorf("%s weight = %v, want %v", route.Backend, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRouter_ResolveSession_StickyHash(t *testing.T) {
+	cfg := &Config{
+		Backends: []Backend{
+			{Name: "backend1", URL: "http://backend1.com"},
+			{Name: "backend2", URL: "http://backend2.com"},
+			{Name: "backend3", URL: "http://backend3.com"},
+		},
+		Models: map[string]*ModelAlias{
+			"model-a": {
+				Routes: []ModelRoute{
+					{Backend: "backend1", Model: "m1", Priority: 1},
+					{Backend: "backend2", Model: "m2", Priority: 1},
+					{Backend: "backend3", Model: "m3", Priority: 1},
+				},
+			},
+		},
+		LoadBalance: LoadBalance{Strategy: StrategyStickyHash},
+	}
+	cd := NewCooldownManager()
+	router := NewRouter(newTestConfigManager(cfg), cd)
+
+	pinned := make(map[string]string)
+	for i := 0; i < 20; i++ {
+		session := fmt.Sprintf("session-%d", i)
+		routes, _ := router.ResolveSession("model-a", session, false)
+		pinned[session] = routes[0].BackendName
+		for j := 0; j < 10; j++ {
+			if again, _ := router.ResolveSession("model-a", session, false); again[0].BackendName != pinned[session] {
+				t.Fatalf("%s mapped to %s, then %s", session, pinned[session], again[0].BackendName)
+			}
+		}
+	}
+	used := make(map[string]bool)
+	for _, b := range pinned {
+		used[b] = true
+	}
+	if len(used) < 2 || !used["backend1"] {
+		t.Fatalf("20 sessions should spread over several backends including backend1, got %v", used)
+	}
+
+	// 冷却首选后端后只有映射到它的会话改变，且改变后同样稳定
+	cd.SetCooldown(cd.Key("backend1", "m1"), time.Minute)
+	for session, before := range pinned {
+		routes, _ := router.ResolveSession("model-a", session, false)
+		got := routes[0].BackendName
+		if before != "backend1" && got != before {
+			t.Errorf("%s moved from %s to %s although its backend is available", session, before, got)
+		}
+		if got == "backend1" {
+			t.Errorf("%s still routed to cooled-down backend1", session)
+		}
+		if again, _ := router.ResolveSession("model-a", session, false); again[0].BackendName != got {
+			t.Errorf("%s rehashed inconsistently: %s then %s", session, got, again[0].BackendName)
+		}
+	}
+}
+
+func TestRouter_Resolve_PatternAliases(t *testing.T) {
+	cfg := &Config{
+		Backends: []Backend{
+			{Name: "exact", URL: "http://exact.com"},
+			{Name: "glob", URL: "http://glob.com"},
+			{Name: "regex", URL: "http://regex.com"},
+		},
+		Models: map[string]*ModelAlias{
+			"ft:gpt-4o:acme:v1": {Routes: []ModelRoute{{Backend: "exact", Model: "pinned", Priority: 1}}},
+			"ft:gpt-4o:*":       {Routes: []ModelRoute{{Backend: "glob", Priority: 1}}},
+			`re:ft:gpt-4o-mini:[a-z]+:v\d+`: {
+				Routes: []ModelRoute{{Backend: "regex", Model: "ignored", Priority: 1}},
+			},
+		},
+	}
+	router := NewRouter(newTestConfigManager(cfg), NewCooldownManager())
+
+	tests := []struct {
+		name        string
+		model       string
+		wantBackend string
+		wantModel   string
+	}{
+		{"exact beats pattern", "ft:gpt-4o:acme:v1", "exact", "pinned"},
+		{"glob family", "ft:gpt-4o:acme:v2", "glob", "ft:gpt-4o:acme:v2"},
+		{"regex family", "ft:gpt-4o-mini:acme:v3", "regex", "ft:gpt-4o-mini:acme:v3"},
+		{"regex is anchored", "ft:gpt-4o-mini:acme:v3-beta", "", ""},
+		{"no match", "gpt-4o", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			routes, _ := router.Resolve(tt.model)
+			if tt.wantBackend == "" {
+				if len(routes) != 0 {
+					t.Fatalf("expected no routes, got %+v", routes)
+				}
+				return
+			}
+			if len(routes) != 1 {
+				t.Fatalf("expected 1 route, got %+v", routes)
+			}
+			if routes[0].BackendName != tt.wantBackend || routes[0].Model != tt.wantModel {
+				t.Errorf("route = %s/%s, want %s/%s", routes[0].BackendName, routes[0].Model, tt.wantBackend, tt.wantModel)
+			}
+		})
+	}
+}
+
+func TestConfig_MatchModelPattern_MostSpecificWins(t *testing.T) {
+	cfg := &Config{Models: map[string]*ModelAlias{
+		"ft:*":        {},
+		"ft:gpt-4o:*": {},
+	}}
+	alias, _, ok := cfg.MatchModelPattern("ft:gpt-4o:acme")
+	if !ok || alias != "ft:gpt-4o:*" {
+		t.Errorf("MatchModelPattern = %q, %v; want ft:gpt-4o:*", alias, ok)
+	}
+}
+
+func TestRouter_Resolve_WeightedAliasFallback(t *testing.T) {
+	cfg := &Config{
+		Backends: []Backend{
+			{Name: "primary", URL: "http://primary.com"},
+			{Name: "heavy", URL: "http://heavy.com"},
+			{Name: "light", URL: "http://light.com"},
+			{Name: "last", URL: "http://last.com"},
+		},
+		Models: map[string]*ModelAlias{
+			"main":  {Routes: []ModelRoute{{Backend: "primary", Model: "m", Priority: 1}}},
+			"heavy": {Routes: []ModelRoute{{Backend: "heavy", Model: "m", Priority: 1}}},
+			"light": {Routes: []ModelRoute{{Backend: "light", Model: "m", Priority: 1}}},
+			"last":  {Routes: []ModelRoute{{Backend: "last", Model: "m", Priority: 1}}},
+		},
+		Fallback: Fallback{
+			AliasFallback: map[string][]FallbackTarget{
+				"main": {
+					{Alias: "last", Priority: 2},
+					{Alias: "light", Weight: 1, Priority: 1},
+					{Alias: "heavy", Weight: 3, Priority: 1},
+				},
+			},
+		},
+	}
+	cd := NewCooldownManager()
+	router := NewRouter(newTestConfigManager(cfg), cd)
+	router.rng = rand.New(rand.NewSource(1))
+
+	const n = 4000
+	heavyFirst := 0
+	for i := 0; i < n; i++ {
+		routes, _ := router.Resolve("main")
+		if len(routes) != 4 {
+			t.Fatalf("expected 4 routes, got %d", len(routes))
+		}
+		if routes[0].BackendName != "primary" || routes[3].BackendName != "last" {
+			t.Fatalf("unexpected order: %+v", routes)
+		}
+		if routes[1].BackendName == "heavy" {
+			heavyFirst++
+		}
+	}
+	// 权重 3:1 时 heavy 排在首位的概率为 3/4
+	if ratio := float64(heavyFirst) / n; math.Abs(ratio-0.75) > 0.03 {
+		t.Errorf("heavy first ratio = %.3f, want ~0.75", ratio)
+	}
+
+	cd.SetCooldown(cd.Key("heavy", "m"), time.Minute)
+	for i := 0; i < 20; i++ {
+		routes, _ := router.Resolve("main")
+		if len(routes) != 3 || routes[1].BackendName != "light" || routes[2].BackendName != "last" {
+			t.Fatalf("cooled-down fallback not skipped: %+v", routes)
+		}
+	}
+}
+
+func TestRouter_Resolve_UnweightedFallbackKeepsOrder(t *testing.T) {
+	cfg := &Config{
+		Backends: []Backend{
+			{Name: "b1", URL: "http://b1.com"},
+			{Name: "b2", URL: "http://b2.com"},
+			{Name: "b3", URL: "http://b3.com"},
+		},
+		Models: map[string]*ModelAlias{
+			"main": {Routes: []ModelRoute{{Backend: "b1", Model: "m", Priority: 1}}},
+			"fb-a": {Routes: []ModelRoute{{Backend: "b2", Model: "m", Priority: 1}}},
+			"fb-b": {Routes: []ModelRoute{{Backend: "b3", Model: "m", Priority: 1}}},
+		},
+		Fallback: Fallback{
+			AliasFallback: map[string][]FallbackTarget{"main": {{Alias: "fb-b"}, {Alias: "fb-a"}}},
+		},
+	}
+	router := NewRouter(newTestConfigManager(cfg), NewCooldownManager())
+	for i := 0; i < 20; i++ {
+		routes, _ := router.Resolve("main")
+		if len(routes) != 3 || routes[1].BackendName != "b3" || routes[2].BackendName != "b2" {
+			t.Fatalf("unweighted fallbacks reordered: %+v", routes)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 路由被跳过的原因
+const (
+	SkipRouteDisabled   = "route_disabled"
+	SkipCooldown        = "cooldown"
+	SkipCircuitOpen     = "circuit_open"
+	SkipBackendMissing  = "backend_missing"
+	SkipBackendDisabled = "backend_disabled"
+	SkipQuotaExhausted  = "quota_exhausted"
+)
+
+// RouteCandidate 是路由决策中考虑过的一条候选路由，Skipped 为空表示可用
+type RouteCandidate struct {
+	Alias     string
+	Backend   string
+	Model     string
+	Priority  int
+	Weight    float64
+	InFlight  int64
+	Unhealthy bool
+	Skipped   string
+}
+
+// RouteTrace 记录一次路由解析的决策过程，候选按最终尝试顺序排列（被跳过的夹在其中）
+type RouteTrace struct {
+	Strategy   string
+	Candidates []RouteCandidate
+}
+
+// Selected 返回第一条可用候选
+func (t *RouteTrace) Selected() (RouteCandidate, bool) {
+	for _, c := range t.Candidates {
+		if c.Skipped == "" {
+			return c, true
+		}
+	}
+	return RouteCandidate{}, false
+}
+
+// String 返回单行摘要，用于日志与 X-Route-Decision 响应头
+func (t *RouteTrace) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "strategy=%s", t.Strategy)
+	if c, ok := t.Selected(); ok {
+		fmt.Fprintf(&b, "; selected=%s/%s", c.Backend, c.Model)
+	} else {
+		b.WriteString("; selected=none")
+	}
+	for _, c := range t.Candidates {
+		fmt.Fprintf(&b, "; %s/%s alias=%s p=%d w=%.2f inflight=%d", c.Backend, c.Model, c.Alias, c.Priority, c.Weight, c.InFlight)
+		if c.Unhealthy {
+			b.WriteString(" unhealthy")
+		}
+		if c.Skipped != "" {
+			fmt.Fprintf(&b, " skipped=%s", c.Skipped)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRouter_ResolveTrace_SkipReasons(t *testing.T) {
+	disabled := false
+	cfg := &Config{
+		Backends: []Backend{
+			{Name: "cooling", URL: "http://cooling.com"},
+			{Name: "broken", URL: "http://broken.com"},
+			{Name: "off", URL: "http://off.com", Enabled: &disabled},
+			{Name: "spent", URL: "http://spent.com", Quota: &Quota{Tokens: 100}},
+			{Name: "healthy", URL: "http://healthy.com"},
+		},
+		Models: map[string]*ModelAlias{
+			"model-a": {
+				Routes: []ModelRoute{
+					{Backend: "cooling", Model: "m1", Priority: 1},
+					{Backend: "broken", Model: "m2", Priority: 2},
+					{Backend: "off", Model: "m3", Priority: 3},
+					{Backend: "spent", Model: "m4", Priority: 4},
+					{Backend: "missing", Model: "m5", Priority: 5},
+					{Backend: "healthy", Model: "m6", Priority: 6, Enabled: &disabled},
+					{Backend: "healthy", Model: "m7", Priority: 7},
+				},
+			},
+		},
+		CircuitBreaker: CircuitBreakerConfig{Enabled: true, FailureThreshold: 1},
+	}
+	cm := newTestConfigManager(cfg)
+	cd := NewCooldownManager()
+	router := NewRouter(cm, cd)
+
+	cd.SetCooldown(cd.Key("cooling", "m1"), time.Minute)
+	router.breaker.RecordFailure(cd.Key("broken", "m2"))
+	router.RecordUsage("spent", 100)
+
+	routes, trace := router.ResolveTrace("model-a")
+	if len(routes) != 1 || routes[0].Model != "m7" {
+		t.Fatalf("expected only healthy/m7, got %+v", routes)
+	}
+
+	want := map[string]string{
+		"m1": SkipCooldown,
+		"m2": SkipCircuitOpen,
+		"m3": SkipBackendDisabled,
+		"m4": SkipQuotaExhausted,
+		"m5": SkipBackendMissing,
+		"m6": SkipRouteDisabled,
+		"m7": "",
+	}
+	if len(trace.Candidates) != len(want) {
+		t.Fatalf("expected %d candidates, got %+v", len(want), trace.Candidates)
+	}
+	for _, c := range trace.Candidates {
+		if c.Skipped != want[c.Model] {
+			t.Errorf("%s/%s skipped=%q, want %q", c.Backend, c.Model, c.Skipped, want[c.Model])
+		}
+	}
+
+	selected, ok := trace.Selected()
+	if !ok || selected.Backend != "healthy" || selected.Weight != 1 {
+		t.Errorf("unexpected selection: %+v", selected)
+	}
+	summary := trace.String()
+	for _, s := range []string{"strategy=random", "selected=healthy/m7", "cooling/m1 alias=model-a p=1", "skipped=cooldown", "skipped=circuit_open"} {
+		if !strings.Contains(summary, s) {
+			t.Errorf("summary missing %q: %s", s, summary)
+		}
+	}
+}
+
+func TestRouter_ResolveTrace_IncludesFallbackAlias(t *testing.T) {
+	cfg := &Config{
+		Backends: []Backend{{Name: "b1", URL: "http://b1.com"}, {Name: "b2", URL: "http://b2.com"}},
+		Models: map[string]*ModelAlias{
+			"model-a": {Routes: []ModelRoute{{Backend: "b1", Model: "m1", Priority: 1}}},
+			"model-b": {Routes: []ModelRoute{{Backend: "b2", Model: "m2", Priority: 1}}},
+		},
+		Fallback: Fallback{AliasFallback: map[string][]FallbackTarget{"model-a": {{Alias: "model-b"}}}},
+	}
+	cd := NewCooldownManager()
+	router := NewRouter(newTestConfigManager(cfg), cd)
+	cd.SetCooldown(cd.Key("b1", "m1"), time.Minute)
+
+	_, trace := router.ResolveTrace("model-a")
+	if len(trace.Candidates) != 2 || trace.Candidates[1].Alias != "model-b" {
+		t.Fatalf("fallback alias candidates missing: %+v", trace.Candidates)
+	}
+	if selected, _ := trace.Selected(); selected.Backend != "b2" {
+		t.Errorf("expected fallback b2 selected, got %+v", selected)
+	}
+}
+
+func TestProxy_RouteDecisionHeader(t *testing.T) {
+	var upstreamAdminKey string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamAdminKey = r.Header.Get(adminKeyHeader)
+		w.Write([]byte(`{}`))
+	}))
+	defer backend.Close()
+
+	tests := []struct {
+		name       string
+		enabled    bool
+		adminKey   string
+		sentKey    string
+		wantHeader bool
+	}{
+		{"route_trace disabled", false, "sk-admin", "sk-admin", false},
+		{"admin caller", true, "sk-admin", "sk-admin", true},
+		{"ordinary caller", true, "sk-admin", "", false},
+		{"wrong admin key", true, "sk-admin", "sk-guess", false},
+		{"no admin key configured", true, "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstreamAdminKey = ""
+			proxy := newTestProxy(&Config{
+				AdminAPIKey: tt.adminKey,
+				Backends:    []Backend{{Name: "b1", URL: backend.URL}},
+				Models: map[string]*ModelAlias{
+					"model-a": {Routes: []ModelRoute{{Backend: "b1", Model: "m1", Priority: 1}}},
+				},
+				Logging: Logging{RouteTrace: tt.enabled},
+			})
+
+			req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"model-a"}`))
+			if tt.sentKey != "" {
+				req.Header.Set(adminKeyHeader, tt.sentKey)
+			}
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+
+			got := w.Header().Get("X-Route-Decision")
+			if tt.wantHeader && !strings.Contains(got, "selected=b1/m1") {
+				t.Errorf("header = %q, want the route decision", got)
+			}
+			if !tt.wantHeader && got != "" {
+				t.Errorf("unexpected header %q", got)
+			}
+			if upstreamAdminKey != "" {
+				t.Errorf("admin key forwarded upstream: %q", upstreamAdminKey)
+			}
+		})
+	}
+}
@@ -0,0 +1,462 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+// sseLineFilter 逐行改写 SSE 数据，返回 nil 表示丢弃该行
+type sseLineFilter func(line []byte) []byte
+
+// streamLines 按行转发 SSE 流，每个事件结束（空行）时刷新
+func streamLines(w http.ResponseWriter, body io.Reader, filter sseLineFilter) {
+	flusher, _ := w.(http.Flusher)
+	reader := bufio.NewReader(body)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			if out := filter(line); out != nil {
+				if _, werr := w.Write(out); werr != nil {
+					return
+				}
+			}
+			if flusher != nil && len(bytes.TrimSpace(line)) == 0 {
+				flusher.Flush()
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	if flusher != nil {
+		flusher.Flush()
+	}
+}
+
+func chainFilters(filters []sseLineFilter) sseLineFilter {
+	return func(line []byte) []byte {
+		for _, f := range filters {
+			if line = f(line); line == nil {
+				return nil
+			}
+		}
+		return line
+	}
+}
+
+// choiceSelector 只保留指定 index 的 choice 并将其重编号为 0
+type choiceSelector struct {
+	index int
+}
+
+func newChoiceSelector(index int) *choiceSelector {
+	return &choiceSelector{index: index}
+}
+
+func (c *choiceSelector) Filter(line []byte) []byte {
+	payload, ok := sseData(line)
+	if !ok {
+		return line
+	}
+	var chunk map[string]interface{}
+	if err := json.Unmarshal(payload, &chunk); err != nil {
+		return line
+	}
+	choices, ok := chunk["choices"].([]interface{})
+	if !ok || len(choices) == 0 {
+		return line
+	}
+	kept := filterChoices(choices, c.index)
+	if len(kept) == 0 {
+		return nil
+	}
+	chunk["choices"] = kept
+	return rewriteSSEData(line, chunk)
+}
+
+// selectChoice 对非流式响应只保留指定 index 的 choice，返回是否有改动
+func selectChoice(body []byte, index int) ([]byte, bool) {
+	var resp map[string]interface{}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return body, false
+	}
+	choices, ok := resp["choices"].([]interface{})
+	if !ok || len(choices) <= 1 && index == 0 {
+		return body, false
+	}
+	kept := filterChoices(choices, index)
+	if len(kept) == 0 {
+		kept = filterChoices(choices[:1], choiceIndex(choices[0], 0))
+	}
+	resp["choices"] = kept
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return body, false
+	}
+	return data, true
+}
+
+func filterChoices(choices []interface{}, index int) []interface{} {
+	var kept []interface{}
+	for i, c := range choices {
+		choice, ok := c.(map[string]interface{})
+		if !ok || choiceIndex(choice, i) != index {
+			continue
+		}
+		choice["index"] = 0
+		kept = append(kept, choice)
+	}
+	return kept
+}
+
+func choiceIndex(c interface{}, fallback int) int {
+	choice, _ := c.(map[string]interface{})
+	if idx, ok := choice["index"].(float64); ok {
+		return int(idx)
+	}
+	return fallback
+}
+
+// roleDeduper 只保留每个 choice 第一个 delta 中的 role 字段
+type roleDeduper struct {
+	seen map[float64]bool
+}
+
+func newRoleDeduper() *roleDeduper {
+	return &roleDeduper{seen: make(map[float64]bool)}
+}
+
+func (d *roleDeduper) Filter(line []byte) []byte {
+	payload, ok := sseData(line)
+	if !ok {
+		return line
+	}
+	var chunk map[string]interface{}
+	if err := json.Unmarshal(payload, &chunk); err != nil {
+		return line
+	}
+	choices, _ := chunk["choices"].([]interface{})
+	changed := false
+	for i, c := range choices {
+		choice, _ := c.(map[string]interface{})
+		delta, _ := choice["delta"].(map[string]interface{})
+		if delta == nil {
+			continue
+		}
+		if _, hasRole := delta["role"]; !hasRole {
+			continue
+		}
+		index, ok := choice["index"].(float64)
+		if !ok {
+			index = float64(i)
+		}
+		if d.seen[index] {
+			delete(delta, "role")
+			changed = true
+		}
+		d.seen[index] = true
+	}
+	if !changed {
+		return line
+	}
+	return rewriteSSEData(line, chunk)
+}
+
+// toolCallIndexer 将每个 choice 内 tool_calls 的 index 重编号为从 0 开始连续、且对同一工具调用稳定的值。
+// 带 id 的分片按 id 识别工具调用；不带 id 的参数分片沿用同一原始 index 最近一次对应的工具调用
+type toolCallIndexer struct {
+	choices map[int]*toolCallState
+}
+
+type toolCallState struct {
+	byID   map[string]int
+	byOrig map[int]int
+	last   int
+	next   int
+}
+
+func newToolCallIndexer() *toolCallIndexer {
+	return &toolCallIndexer{choices: make(map[int]*toolCallState)}
+}
+
+func (t *toolCallIndexer) Filter(line []byte) []byte {
+	payload, ok := sseData(line)
+	if !ok {
+		return line
+	}
+	var chunk map[string]interface{}
+	if err := json.Unmarshal(payload, &chunk); err != nil {
+		return line
+	}
+	choices, _ := chunk["choices"].([]interface{})
+	changed := false
+	for i, c := range choices {
+		choice, _ := c.(map[string]interface{})
+		delta, _ := choice["delta"].(map[string]interface{})
+		calls, _ := delta["tool_calls"].([]interface{})
+		if len(calls) == 0 {
+			continue
+		}
+		state := t.state(choiceIndex(choice, i))
+		for _, tc := range calls {
+			call, ok := tc.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			idx := state.assign(call)
+			if orig, ok := call["index"].(float64); !ok || int(orig) != idx {
+				call["index"] = idx
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		return line
+	}
+	return rewriteSSEData(line, chunk)
+}
+
+func (t *toolCallIndexer) state(choice int) *toolCallState {
+	s, exists := t.choices[choice]
+	if !exists {
+		s = &toolCallState{byID: make(map[string]int), byOrig: make(map[int]int), last: -1}
+		t.choices[choice] = s
+	}
+	return s
+}
+
+func (s *toolCallState) assign(call map[string]interface{}) int {
+	orig, hasOrig := call["index"].(float64)
+	id, _ := call["id"].(string)
+
+	idx, known := -1, false
+	switch {
+	case id != "":
+		idx, known = s.byID[id]
+	case hasOrig:
+		idx, known = s.byOrig[int(orig)]
+	case s.last >= 0:
+		idx, known = s.last, true
+	}
+	if !known {
+		idx = s.next
+		s.next++
+		if id != "" {
+			s.byID[id] = idx
+		}
+	}
+	if hasOrig {
+		s.byOrig[int(orig)] = idx
+	}
+	s.last = idx
+	return idx
+}
+
+// streamUsageCounter 旁路统计转发给客户端的流式响应：记录上游返回的 usage
+// （OpenAI 的 usage 分片，Anthropic 的 message_start/message_delta），并累计输出内容字符数用于估算
+type streamUsageCounter struct {
+	pending     []byte
+	usage       Usage
+	hasUsage    bool
+	outputChars int
+}
+
+func (c *streamUsageCounter) Write(p []byte) (int, error) {
+	c.pending = append(c.pending, p...)
+	for {
+		i := bytes.IndexByte(c.pending, '\n')
+		if i < 0 {
+			break
+		}
+		c.observe(c.pending[:i])
+		c.pending = c.pending[i+1:]
+	}
+	return len(p), nil
+}
+
+func (c *streamUsageCounter) observe(line []byte) {
+	payload, ok := sseData(line)
+	if !ok {
+		return
+	}
+	var event struct {
+		Usage   *Usage `json:"usage"`
+		Message struct {
+			Usage *Usage `json:"usage"`
+		} `json:"message"`
+		Delta struct {
+			Text        string `json:"text"`
+			PartialJSON string `json:"partial_json"`
+			Thinking    string `json:"thinking"`
+		} `json:"delta"`
+		Choices []struct {
+			Text  string `json:"text"`
+			Delta struct {
+				Content   string `json:"content"`
+				ToolCalls []struct {
+					Function struct {
+						Arguments string `json:"arguments"`
+					} `json:"function"`
+				} `json:"tool_calls"`
+			} `json:"delta"`
+		} `json:"choices"`
+	}
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return
+	}
+
+	for _, u := range []*Usage{event.Usage, event.Message.Usage} {
+		if u != nil {
+			c.mergeUsage(*u)
+		}
+	}
+	c.outputChars += len([]rune(event.Delta.Text + event.Delta.PartialJSON + event.Delta.Thinking))
+	for _, choice := range event.Choices {
+		c.outputChars += len([]rune(choice.Text + choice.Delta.Content))
+		for _, call := range choice.Delta.ToolCalls {
+			c.outputChars += len([]rune(call.Function.Arguments))
+		}
+	}
+}
+
+// mergeUsage 合并分多次到达的 usage，后到的非零字段覆盖之前的值
+func (c *streamUsageCounter) mergeUsage(u Usage) {
+	c.hasUsage = true
+	for _, f := range []struct{ dst, src *int64 }{
+		{&c.usage.PromptTokens, &u.PromptTokens},
+		{&c.usage.CompletionTokens, &u.CompletionTokens},
+		{&c.usage.TotalTokens, &u.TotalTokens},
+		{&c.usage.InputTokens, &u.InputTokens},
+		{&c.usage.OutputTokens, &u.OutputTokens},
+	} {
+		if *f.src > 0 {
+			*f.dst = *f.src
+		}
+	}
+}
+
+// Usage 返回流的 token 用量；上游没有返回 usage 时按输出字符数估算 completion tokens，estimated 为 true
+func (c *streamUsageCounter) Usage() (usage Usage, estimated bool) {
+	if c.hasUsage {
+		return c.usage.normalized(), false
+	}
+	tokens := int64((c.outputChars + charsPerToken - 1) / charsPerToken)
+	return Usage{CompletionTokens: tokens, TotalTokens: tokens}, true
+}
+
+// sseData 提取 "data:" 行中的 JSON 负载，[DONE] 与其他行返回 false
+func sseData(line []byte) ([]byte, bool) {
+	trimmed := bytes.TrimSpace(line)
+	if !bytes.HasPrefix(trimmed, []byte("data:")) {
+		return nil, false
+	}
+	payload := bytes.TrimSpace(bytes.TrimPrefix(trimmed, []byte("data:")))
+	if len(payload) == 0 || bytes.Equal(payload, []byte("[DONE]")) {
+		return nil, false
+	}
+	return payload, true
+}
+
+func rewriteSSEData(line []byte, chunk interface{}) []byte {
+	data, err := json.Marshal(chunk)
+	if err != nil {
+		return line
+	}
+	out := append([]byte("data: "), data...)
+	if bytes.HasSuffix(line, []byte("\r\n")) {
+		return append(out, '\r', '\n')
+	}
+	return append(out, '\n')
+}
+
+// anthropicUsageTrailer 保证 Anthropic 流在 message_stop 之前带有累计 usage 的 message_delta，
+// 后端未提供时按已输出字符数估算 output_tokens（约 4 字符/token）
+type anthropicUsageTrailer struct {
+	inputTokens  float64
+	outputTokens float64
+	outputChars  int
+	usageSent    bool
+}
+
+func newAnthropicUsageTrailer() *anthropicUsageTrailer {
+	return &anthropicUsageTrailer{}
+}
+
+func (a *anthropicUsageTrailer) Filter(line []byte) []byte {
+	trimmed := bytes.TrimSpace(line)
+	if bytes.Equal(trimmed, []byte("event: message_stop")) && !a.usageSent {
+		a.usageSent = true
+		return append(a.syntheticDelta(), line...)
+	}
+
+	payload, ok := sseData(line)
+	if !ok {
+		return line
+	}
+	var event map[string]interface{}
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return line
+	}
+
+	switch event["type"] {
+	case "message_start":
+		message, _ := event["message"].(map[string]interface{})
+		usage, _ := message["usage"].(map[string]interface{})
+		a.inputTokens, _ = usage["input_tokens"].(float64)
+	case "content_block_delta":
+		delta, _ := event["delta"].(map[string]interface{})
+		for _, field := range []string{"text", "partial_json", "thinking"} {
+			if s, ok := delta[field].(string); ok {
+				a.outputChars += len(s)
+			}
+		}
+	case "message_delta":
+		usage, hasUsage := event["usage"].(map[string]interface{})
+		if !hasUsage {
+			usage = make(map[string]interface{})
+			event["usage"] = usage
+		}
+		if out, ok := usage["output_tokens"].(float64); ok {
+			a.outputTokens = out
+		} else {
+			usage["output_tokens"] = a.estimatedOutput()
+		}
+		_, hasInput := usage["input_tokens"]
+		a.usageSent = true
+		if hasUsage && hasInput {
+			return line
+		}
+		if !hasInput {
+			usage["input_tokens"] = a.inputTokens
+		}
+		return rewriteSSEData(line, event)
+	case "message_stop":
+		if !a.usageSent {
+			a.usageSent = true
+			return append(a.syntheticDelta(), line...)
+		}
+	}
+	return line
+}
+
+func (a *anthropicUsageTrailer) estimatedOutput() float64 {
+	if a.outputTokens > 0 {
+		return a.outputTokens
+	}
+	return float64((a.outputChars + 3) / 4)
+}
+
+func (a *anthropicUsageTrailer) syntheticDelta() []byte {
+	data, _ := json.Marshal(map[string]interface{}{
+		"type":  "message_delta",
+		"delta": map[string]interface{}{"stop_reason": "end_turn", "stop_sequence": nil},
+		"usage": map[string]interface{}{
+			"input_tokens":  a.inputTokens,
+			"output_tokens": a.estimatedOutput(),
+		},
+	})
+	return []byte("event: message_delta\ndata: " + string(data) + "\n\n")
+}
@@ -0,0 +1,499 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const repeatedRoleStream = `data: {"id":"c1","choices":[{"index":0,"delta":{"role":"assistant","content":""}}]}
+
+data: {"id":"c1","choices":[{"index":0,"delta":{"role":"assistant","content":"Hel"}}]}
+
+data: {"id":"c1","choices":[{"index":0,"delta":{"role":"assistant","content":"lo"}}]}
+
+data: [DONE]
+
+`
+
+func TestRoleDeduper_Filter(t *testing.T) {
+	d := newRoleDeduper()
+	var out strings.Builder
+	for _, line := range strings.SplitAfter(repeatedRoleStream, "\n") {
+		if line == "" {
+			continue
+		}
+		out.Write(d.Filter([]byte(line)))
+	}
+
+	got := out.String()
+	if n := strings.Count(got, `"role"`); n != 1 {
+		t.Errorf("role should appear once, got %d:\n%s", n, got)
+	}
+	if !strings.Contains(got, `"content":"Hel"`) || !strings.Contains(got, `"content":"lo"`) {
+		t.Errorf("content should be preserved:\n%s", got)
+	}
+	if !strings.Contains(got, "data: [DONE]\n") {
+		t.Errorf("[DONE] should pass through:\n%s", got)
+	}
+}
+
+func TestRoleDeduper_PerChoice(t *testing.T) {
+	d := newRoleDeduper()
+	lines := []string{
+		`data: {"choices":[{"index":0,"delta":{"role":"assistant"}},{"index":1,"delta":{"role":"assistant"}}]}` + "\n",
+		`data: {"choices":[{"index":1,"delta":{"role":"assistant","content":"x"}}]}` + "\n",
+	}
+	first := string(d.Filter([]byte(lines[0])))
+	second := string(d.Filter([]byte(lines[1])))
+
+	if strings.Count(first, `"role"`) != 2 {
+		t.Errorf("first delta of each choice keeps role: %s", first)
+	}
+	if strings.Contains(second, `"role"`) {
+		t.Errorf("repeated role for choice 1 should be removed: %s", second)
+	}
+}
+
+func TestProxy_StreamDedupeRole(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(repeatedRoleStream))
+	}))
+	defer backend.Close()
+
+	tests := []struct {
+		name      string
+		dedupe    bool
+		wantRoles int
+	}{
+		{"enabled", true, 1},
+		{"disabled", false, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := newTestProxy(&Config{
+				Backends: []Backend{{Name: "b1", URL: backend.URL}},
+				Models: map[string]*ModelAlias{
+					"model-a": {Routes: []ModelRoute{{Backend: "b1", Model: "m1", Priority: 1}}},
+				},
+				Streaming: Streaming{DedupeRole: tt.dedupe},
+			})
+
+			req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"model-a","stream":true}`))
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+
+			if n := strings.Count(w.Body.String(), `"role"`); n != tt.wantRoles {
+				t.Errorf("expected %d role fields, got %d", tt.wantRoles, n)
+			}
+		})
+	}
+}
+
+func TestSelectChoice(t *testing.T) {
+	body := `{"id":"x","choices":[{"index":0,"message":{"content":"a"}},{"index":1,"message":{"content":"b"}},{"index":2,"message":{"content":"c"}}]}`
+
+	tests := []struct {
+		name    string
+		index   int
+		want    string
+		changed bool
+	}{
+		{"first choice", 0, "a", true},
+		{"configured index", 2, "c", true},
+		{"missing index falls back to first", 5, "a", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, changed := selectChoice([]byte(body), tt.index)
+			if changed != tt.changed {
+				t.Fatalf("changed = %v, want %v", changed, tt.changed)
+			}
+			var resp struct {
+				Choices []struct {
+					Index   int `json:"index"`
+					Message struct {
+						Content string `json:"content"`
+					} `json:"message"`
+				} `json:"choices"`
+			}
+			json.Unmarshal(out, &resp)
+			if len(resp.Choices) != 1 || resp.Choices[0].Index != 0 || resp.Choices[0].Message.Content != tt.want {
+				t.Errorf("got %+v, want single choice %q at index 0", resp.Choices, tt.want)
+			}
+		})
+	}
+
+	single := `{"choices":[{"index":0,"message":{"content":"a"}}]}`
+	if out, changed := selectChoice([]byte(single), 0); changed || string(out) != single {
+		t.Error("single-choice response should be untouched")
+	}
+}
+
+func TestChoiceSelector_Filter(t *testing.T) {
+	c := newChoiceSelector(1)
+	keep := c.Filter([]byte(`data: {"choices":[{"index":0,"delta":{"content":"a"}},{"index":1,"delta":{"content":"b"}}]}` + "\n"))
+	if !strings.Contains(string(keep), `"content":"b"`) || strings.Contains(string(keep), `"content":"a"`) || !strings.Contains(string(keep), `"index":0`) {
+		t.Errorf("should keep only choice 1 re-indexed to 0, got %s", keep)
+	}
+	if drop := c.Filter([]byte(`data: {"choices":[{"index":0,"delta":{"content":"a"}}]}` + "\n")); drop != nil {
+		t.Errorf("chunk with only other choices should be dropped, got %s", drop)
+	}
+	if done := c.Filter([]byte("data: [DONE]\n")); string(done) != "data: [DONE]\n" {
+		t.Errorf("[DONE] should pass through, got %q", done)
+	}
+}
+
+func TestProxy_KeepChoice(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"choices":[{"index":0,"message":{"content":"a"}},{"index":1,"message":{"content":"b"}}]}`))
+	}))
+	defer backend.Close()
+
+	keep := 1
+	proxy := newTestProxy(&Config{
+		Backends: []Backend{{Name: "b1", URL: backend.URL}},
+		Models: map[string]*ModelAlias{
+			"model-a": {KeepChoice: &keep, Routes: []ModelRoute{{Backend: "b1", Model: "m1", Priority: 1}}},
+		},
+	})
+
+	req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"model-a","n":2}`))
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+
+	if strings.Count(w.Body.String(), `"index"`) != 1 || !strings.Contains(w.Body.String(), `"content":"b"`) {
+		t.Errorf("expected only choice 1, got %s", w.Body.String())
+	}
+}
+
+func runFilter(filter sseLineFilter, stream string) string {
+	var out strings.Builder
+	for _, line := range strings.SplitAfter(stream, "\n") {
+		if line == "" {
+			continue
+		}
+		if b := filter([]byte(line)); b != nil {
+			out.Write(b)
+		}
+	}
+	return out.String()
+}
+
+func parseAnthropicUsage(t *testing.T, out string) (deltas int, input, output float64) {
+	t.Helper()
+	stopPos := strings.Index(out, "event: message_stop")
+	for _, line := range strings.Split(out, "\n") {
+		payload, ok := sseData([]byte(line))
+		if !ok {
+			continue
+		}
+		var event struct {
+			Type  string             `json:"type"`
+			Usage map[string]float64 `json:"usage"`
+		}
+		json.Unmarshal(payload, &event)
+		if event.Type != "message_delta" {
+			continue
+		}
+		deltas++
+		input, output = event.Usage["input_tokens"], event.Usage["output_tokens"]
+		if strings.Index(out, line) > stopPos {
+			t.Errorf("message_delta must precede message_stop:\n%s", out)
+		}
+	}
+	return
+}
+
+func TestAnthropicUsageTrailer_CompletesBackendUsage(t *testing.T) {
+	stream := `event: message_start
+data: {"type":"message_start","message":{"usage":{"input_tokens":25,"output_tokens":1}}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hello"}}
+
+event: message_delta
+data: {"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":12}}
+
+event: message_stop
+data: {"type":"message_stop"}
+
+`
+	out := runFilter(newAnthropicUsageTrailer().Filter, stream)
+	deltas, input, output := parseAnthropicUsage(t, out)
+
+	if deltas != 1 {
+		t.Fatalf("expected exactly one message_delta, got %d:\n%s", deltas, out)
+	}
+	if input != 25 || output != 12 {
+		t.Errorf("usage = input %v output %v, want 25/12", input, output)
+	}
+}
+
+func TestAnthropicUsageTrailer_SynthesizesMissingUsage(t *testing.T) {
+	stream := `event: message_start
+data: {"type":"message_start","message":{"usage":{"input_tokens":10}}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"0123456789"}}
+
+event: message_stop
+data: {"type":"message_stop"}
+
+`
+	out := runFilter(newAnthropicUsageTrailer().Filter, stream)
+	deltas, input, output := parseAnthropicUsage(t, out)
+
+	if deltas != 1 {
+		t.Fatalf("expected one synthesized message_delta, got %d:\n%s", deltas, out)
+	}
+	if input != 10 || output != 3 {
+		t.Errorf("usage = input %v output %v, want 10/3 (10 chars estimated)", input, output)
+	}
+	if strings.Count(out, "event: message_stop") != 1 {
+		t.Errorf("message_stop should appear once:\n%s", out)
+	}
+}
+
+func TestProxy_AnthropicUsageOnlyForMessagesPath(t *testing.T) {
+	stream := "event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n"
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(stream))
+	}))
+	defer backend.Close()
+
+	proxy := newTestProxy(&Config{
+		Backends: []Backend{{Name: "b1", URL: backend.URL}},
+		Models: map[string]*ModelAlias{
+			"claude": {Routes: []ModelRoute{{Backend: "b1", Model: "m1", Priority: 1}}},
+		},
+		Streaming: Streaming{AnthropicUsage: true},
+	})
+
+	for path, want := range map[string]bool{"/v1/messages": true, "/v1/chat/completions": false} {
+		req := httptest.NewRequest("POST", path, strings.NewReader(`{"model":"claude","stream":true}`))
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+		if got := strings.Contains(w.Body.String(), "message_delta"); got != want {
+			t.Errorf("%s: trailing usage present = %v, want %v", path, got, want)
+		}
+	}
+}
+
+// reassembleToolCalls 按客户端的方式用 index 拼接 tool_calls 参数
+func reassembleToolCalls(t *testing.T, stream string) map[int]string {
+	t.Helper()
+	calls := make(map[int]string)
+	names := make(map[int]string)
+	for _, line := range strings.Split(stream, "\n") {
+		payload, ok := sseData([]byte(line))
+		if !ok {
+			continue
+		}
+		var chunk struct {
+			Choices []struct {
+				Delta struct {
+					ToolCalls []struct {
+						Index    int `json:"index"`
+						Function struct {
+							Name      string `json:"name"`
+							Arguments string `json:"arguments"`
+						} `json:"function"`
+					} `json:"tool_calls"`
+				} `json:"delta"`
+			} `json:"choices"`
+		}
+		if err := json.Unmarshal(payload, &chunk); err != nil {
+			t.Fatalf("invalid chunk %s: %v", payload, err)
+		}
+		for _, c := range chunk.Choices {
+			for _, tc := range c.Delta.ToolCalls {
+				names[tc.Index] += tc.Function.Name
+				calls[tc.Index] += tc.Function.Arguments
+			}
+		}
+	}
+	result := make(map[int]string)
+	for idx, args := range calls {
+		result[idx] = names[idx] + args
+	}
+	return result
+}
+
+func TestToolCallIndexer_InterleavedWithGaps(t *testing.T) {
+	stream := `data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":1,"id":"call_a","type":"function","function":{"name":"weather","arguments":""}}]}}]}
+
+data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":3,"id":"call_b","type":"function","function":{"name":"time","arguments":""}}]}}]}
+
+data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":1,"function":{"arguments":"{\"city\":"}}]}}]}
+
+data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":3,"function":{"arguments":"{\"tz\":\"UTC\"}"}}]}}]}
+
+data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":1,"function":{"arguments":"\"Paris\"}"}}]}}]}
+
+data: [DONE]
+
+`
+	got := reassembleToolCalls(t, runFilter(newToolCallIndexer().Filter, stream))
+
+	want := map[int]string{
+		0: `weather{"city":"Paris"}`,
+		1: `time{"tz":"UTC"}`,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected contiguous indices 0..1, got %v", got)
+	}
+	for idx, args := range want {
+		if got[idx] != args {
+			t.Errorf("tool call %d = %q, want %q", idx, got[idx], args)
+		}
+	}
+}
+
+func TestToolCallIndexer_RepeatedZeroIndexAcrossChoices(t *testing.T) {
+	// 部分后端对每个工具调用都使用 index 0，仅靠 id 区分
+	stream := `data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"a1","function":{"name":"f","arguments":"{1"}}]}},{"index":1,"delta":{"tool_calls":[{"index":0,"id":"b1","function":{"name":"g","arguments":"{x"}}]}}]}
+
+data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"a2","function":{"name":"h","arguments":"{2"}}]}}]}
+
+data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"}"}}]}},{"index":1,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"}"}}]}}]}
+
+`
+	out := runFilter(newToolCallIndexer().Filter, stream)
+
+	if !strings.Contains(out, `{"function":{"arguments":"{2","name":"h"},"id":"a2","index":1}`) {
+		t.Errorf("second tool call of choice 0 should be renumbered to 1:\n%s", out)
+	}
+	if strings.Count(out, `"index":1`) < 3 {
+		t.Errorf("choice 1 and the trailing delta for a2 should keep stable indices:\n%s", out)
+	}
+	if strings.Contains(out, `"id":"b1","index":1`) {
+		t.Errorf("indices must be tracked per choice:\n%s", out)
+	}
+}
+
+func TestToolCallIndexer_PassThroughUnchanged(t *testing.T) {
+	stream := `data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"a","function":{"name":"f","arguments":""}}]}}]}
+
+data: {"choices":[{"index":0,"delta":{"content":"hi"}}]}
+
+data: [DONE]
+
+`
+	if got := runFilter(newToolCallIndexer().Filter, stream); got != stream {
+		t.Errorf("already contiguous stream should pass through unchanged:\n%s", got)
+	}
+}
+
+func TestStreamUsageCounter(t *testing.T) {
+	tests := []struct {
+		name          string
+		stream        string
+		want          Usage
+		wantEstimated bool
+	}{
+		{
+			name: "openai usage chunk",
+			stream: "data: {\"choices\":[{\"delta\":{\"content\":\"Hello there\"}}]}\n\n" +
+				"data: {\"choices\":[],\"usage\":{\"prompt_tokens\":12,\"completion_tokens\":3,\"total_tokens\":15}}\n\n" +
+				"data: [DONE]\n\n",
+			want: Usage{PromptTokens: 12, CompletionTokens: 3, TotalTokens: 15},
+		},
+		{
+			name: "anthropic usage events",
+			stream: "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"usage\":{\"input_tokens\":20,\"output_tokens\":1}}}\n\n" +
+				"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"delta\":{\"type\":\"text_delta\",\"text\":\"Hi\"}}\n\n" +
+				"event: message_delta\ndata: {\"type\":\"message_delta\",\"usage\":{\"output_tokens\":7}}\n\n",
+			want: Usage{PromptTokens: 20, CompletionTokens: 7, TotalTokens: 27, InputTokens: 20, OutputTokens: 7},
+		},
+		{
+			name: "no usage estimates from content",
+			stream: "data: {\"choices\":[{\"delta\":{\"content\":\"Hello \"}}]}\n\n" +
+				"data: {\"choices\":[{\"delta\":{\"content\":\"world!\"}}]}\n\n" +
+				"data: [DONE]\n\n",
+			want:          Usage{CompletionTokens: 3, TotalTokens: 3},
+			wantEstimated: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &streamUsageCounter{}
+			// 逐字节写入，确保跨分片的行也能正确解析
+			for i := range len(tt.stream) {
+				c.Write([]byte{tt.stream[i]})
+			}
+			got, estimated := c.Usage()
+			if got != tt.want || estimated != tt.wantEstimated {
+				t.Errorf("Usage() = %+v (estimated=%v), want %+v (estimated=%v)", got, estimated, tt.want, tt.wantEstimated)
+			}
+		})
+	}
+}
+
+func TestProxy_StreamUsageMetrics(t *testing.T) {
+	tests := []struct {
+		name           string
+		stream         string
+		estimated      string
+		wantPrompt     float64
+		wantCompletion float64
+	}{
+		{
+			name: "upstream usage",
+			stream: "data: {\"choices\":[{\"delta\":{\"content\":\"Hi\"}}]}\n\n" +
+				"data: {\"choices\":[],\"usage\":{\"prompt_tokens\":9,\"completion_tokens\":4,\"total_tokens\":13}}\n\ndata: [DONE]\n\n",
+			estimated:      "false",
+			wantPrompt:     9,
+			wantCompletion: 4,
+		},
+		{
+			name:           "estimated",
+			stream:         "data: {\"choices\":[{\"delta\":{\"content\":\"12345678\"}}]}\n\ndata: [DONE]\n\n",
+			estimated:      "true",
+			wantCompletion: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/event-stream")
+				w.Write([]byte(tt.stream))
+			}))
+			defer backend.Close()
+
+			proxy := newTestProxy(&Config{
+				Backends: []Backend{{Name: "usage-b1", URL: backend.URL}},
+				Models: map[string]*ModelAlias{
+					"usage-model": {Routes: []ModelRoute{{Backend: "usage-b1", Model: "m1", Priority: 1}}},
+				},
+			})
+
+			labels := func(kind string) []string {
+				return []string{"model", "usage-model", "backend", "usage-b1", "type", kind, "estimated", tt.estimated}
+			}
+			beforePrompt := metricsRegistry.CounterValue("llm_proxy_tokens_total", labels("prompt")...)
+			beforeCompletion := metricsRegistry.CounterValue("llm_proxy_tokens_total", labels("completion")...)
+
+			req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"usage-model","stream":true}`))
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+			if w.Body.String() != tt.stream {
+				t.Fatalf("stream not passed through unchanged: %q", w.Body.String())
+			}
+
+			if got := metricsRegistry.CounterValue("llm_proxy_tokens_total", labels("prompt")...) - beforePrompt; got != tt.wantPrompt {
+				t.Errorf("prompt tokens = %v, want %v", got, tt.wantPrompt)
+			}
+			if got := metricsRegistry.CounterValue("llm_proxy_tokens_total", labels("completion")...) - beforeCompletion; got != tt.wantCompletion {
+				t.Errorf("completion tokens = %v, want %v", got, tt.wantCompletion)
+			}
+		})
+	}
+}
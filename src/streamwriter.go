@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// heartbeatComment 是空闲时注入的 SSE 注释行，客户端解析时会忽略
+var heartbeatComment = []byte(": keep-alive\n\n")
+
+// terminalMarkers 标识流的终止事件：OpenAI 的 data: [DONE] 与 Anthropic 的 message_stop
+var terminalMarkers = [][]byte{[]byte("data: [DONE]"), []byte("message_stop")}
+
+// streamWriter 按 streaming.flush_mode 决定流式响应何时刷新到客户端。
+// batched 模式下 Flush 只在累计字节数达到上限时立即生效，否则由定时器在间隔到期后补刷；
+// 写入终止事件后恢复逐事件刷新，终止事件不等待定时器。
+// 配置了 heartbeat_interval_ms 时，上游超过该间隔没有数据且已写出的内容停在事件边界上，
+// 注入 keep-alive 注释，避免客户端或中间代理因流长时间无数据而断开。
+// 写入与刷新由 mu 串行化，定时器可在其他 goroutine 触发
+type streamWriter struct {
+	http.ResponseWriter
+	flusher   http.Flusher
+	batched   bool
+	interval  time.Duration
+	maxBytes  int
+	heartbeat time.Duration
+
+	mu        sync.Mutex
+	pending   int
+	timer     *time.Timer
+	idleTimer *time.Timer
+	lastWrite time.Time
+	tail      []byte
+	closed    bool
+}
+
+func newStreamWriter(w http.ResponseWriter, flusher http.Flusher, cfg *Streaming) *streamWriter {
+	s := &streamWriter{
+		ResponseWriter: w,
+		flusher:        flusher,
+		batched:        cfg.FlushMode == StreamFlushBatched,
+		interval:       cfg.GetFlushInterval(),
+		maxBytes:       cfg.GetFlushBytes(),
+		heartbeat:      cfg.GetHeartbeatInterval(),
+		lastWrite:      time.Now(),
+	}
+	if s.heartbeat > 0 {
+		s.idleTimer = time.AfterFunc(s.heartbeat, s.sendHeartbeat)
+	}
+	return s
+}
+
+func (s *streamWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, err := s.ResponseWriter.Write(p)
+	s.pending += n
+	s.lastWrite = time.Now()
+	s.tail = append(s.tail, p[:n]...)
+	if len(s.tail) > 3 {
+		s.tail = s.tail[len(s.tail)-3:]
+	}
+	if s.batched && isTerminalChunk(p) {
+		s.batched = false
+	}
+	return n, err
+}
+
+func (s *streamWriter) Flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pending == 0 {
+		return
+	}
+	if !s.batched || s.pending >= s.maxBytes {
+		s.flushLocked()
+		return
+	}
+	if s.timer == nil {
+		s.timer = time.AfterFunc(s.interval, s.flushTimer)
+	}
+}
+
+// Close 刷新剩余数据并停止定时器，之后定时器不再访问 ResponseWriter
+func (s *streamWriter) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.idleTimer != nil {
+		s.idleTimer.Stop()
+	}
+	if s.pending > 0 {
+		s.flushLocked()
+	}
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+}
+
+func (s *streamWriter) flushTimer() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.timer = nil
+	if !s.closed && s.pending > 0 {
+		s.flushLocked()
+	}
+}
+
+// sendHeartbeat 在上游空闲达到间隔时写出 keep-alive 注释，否则推迟到下一次到期；
+// 事件写到一半时不插入，等事件结束后的下一次到期
+func (s *streamWriter) sendHeartbeat() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	if idle := time.Since(s.lastWrite); idle < s.heartbeat {
+		s.idleTimer.Reset(s.heartbeat - idle)
+		return
+	}
+	if s.atEventBoundary() {
+		s.ResponseWriter.Write(heartbeatComment)
+		s.flushLocked()
+	}
+	s.idleTimer.Reset(s.heartbeat)
+}
+
+// atEventBoundary 判断已写出的内容是否以完整事件结尾（尚未写出任何内容也视为边界）
+func (s *streamWriter) atEventBoundary() bool {
+	return len(s.tail) == 0 || bytes.HasSuffix(s.tail, []byte("\n\n")) || bytes.HasSuffix(s.tail, []byte("\n\r\n"))
+}
+
+func (s *streamWriter) flushLocked() {
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	s.flusher.Flush()
+	s.pending = 0
+}
+
+func isTerminalChunk(p []byte) bool {
+	for _, marker := range terminalMarkers {
+		if bytes.Contains(p, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// streamPrelude 在等待上游首个事件期间按心跳间隔发送 keep-alive 注释。
+// 首次到期时先调用 commit 写出响应头，此后该请求无法再回退到其他后端
+type streamPrelude struct {
+	w        http.ResponseWriter
+	flusher  http.Flusher
+	interval time.Duration
+	commit   func()
+
+	mu        sync.Mutex
+	timer     *time.Timer
+	committed bool
+	stopped   bool
+}
+
+func startStreamPrelude(w http.ResponseWriter, interval time.Duration, commit func()) *streamPrelude {
+	p := &streamPrelude{w: w, interval: interval, commit: commit}
+	flusher, ok := w.(http.Flusher)
+	if interval <= 0 || !ok {
+		p.stopped = true
+		return p
+	}
+	p.flusher = flusher
+	p.mu.Lock()
+	p.timer = time.AfterFunc(interval, p.beat)
+	p.mu.Unlock()
+	return p
+}
+
+func (p *streamPrelude) beat() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopped {
+		return
+	}
+	if !p.committed {
+		p.commit()
+		p.committed = true
+	}
+	p.w.Write(heartbeatComment)
+	p.flusher.Flush()
+	p.timer.Reset(p.interval)
+}
+
+// Stop 停止心跳并返回响应头是否已经写出；返回后定时器不再访问 ResponseWriter
+func (p *streamPrelude) Stop() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stopped = true
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	return p.committed
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flushRecorder 记录每次刷新时已写出的内容
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+	mu      sync.Mutex
+	flushed []string
+}
+
+func newFlushRecorder() *flushRecorder {
+	return &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+}
+
+func (f *flushRecorder) Flush() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.flushed = append(f.flushed, f.Body.String())
+}
+
+func (f *flushRecorder) flushes() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.flushed...)
+}
+
+// chunkReader 每次 Read 返回一个事件，模拟逐个到达的 SSE 事件
+type chunkReader struct {
+	chunks []string
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	if len(c.chunks) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, c.chunks[0])
+	c.chunks = c.chunks[1:]
+	return n, nil
+}
+
+func chattyStream(events int) []string {
+	var chunks []string
+	for i := 0; i < events; i++ {
+		chunks = append(chunks, fmt.Sprintf("data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"t%d\"}}]}\n\n", i))
+	}
+	return append(chunks, "data: [DONE]\n\n")
+}
+
+func TestStreamResponse_FlushModes(t *testing.T) {
+	tests := []struct {
+		name        string
+		cfg         Streaming
+		filters     []sseLineFilter
+		wantFlushes int
+	}{
+		{name: "event", wantFlushes: 31},
+		{name: "event with filters", filters: []sseLineFilter{newRoleDeduper().Filter}, wantFlushes: 31},
+		{name: "batched by time", cfg: Streaming{FlushMode: StreamFlushBatched, FlushIntervalMs: 60000, FlushBytes: 1 << 20}, wantFlushes: 1},
+		{name: "batched by size", cfg: Streaming{FlushMode: StreamFlushBatched, FlushIntervalMs: 60000, FlushBytes: 500}, wantFlushes: 4},
+		{
+			name:        "batched with filters",
+			cfg:         Streaming{FlushMode: StreamFlushBatched, FlushIntervalMs: 60000, FlushBytes: 1 << 20},
+			filters:     []sseLineFilter{newRoleDeduper().Filter},
+			wantFlushes: 1,
+		},
+	}
+
+	want := strings.Join(chattyStream(30), "")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := newFlushRecorder()
+			(&Proxy{}).streamResponse(rec, &chunkReader{chunks: chattyStream(30)}, tt.filters, &tt.cfg)
+
+			if got := rec.Body.String(); got != want {
+				t.Fatalf("delivered body differs from upstream:\n%s", got)
+			}
+			flushes := rec.flushes()
+			if len(flushes) != tt.wantFlushes {
+				t.Errorf("flushes = %d, want %d", len(flushes), tt.wantFlushes)
+			}
+			if last := flushes[len(flushes)-1]; last != want {
+				t.Errorf("last flush should contain the whole stream, got %q", last)
+			}
+		})
+	}
+}
+
+func TestStreamWriter_TerminalEventNotDelayed(t *testing.T) {
+	for _, terminal := range []string{
+		"data: [DONE]\n\n",
+		"event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n",
+	} {
+		rec := newFlushRecorder()
+		pr, pw := io.Pipe()
+		done := make(chan struct{})
+		go func() {
+			(&Proxy{}).streamResponse(rec, pr, nil, &Streaming{FlushMode: StreamFlushBatched, FlushIntervalMs: 60000})
+			close(done)
+		}()
+
+		pw.Write([]byte("data: {\"choices\":[]}\n\n"))
+		pw.Write([]byte(terminal))
+		deadline := time.Now().Add(time.Second)
+		for {
+			if f := rec.flushes(); len(f) > 0 && strings.HasSuffix(f[len(f)-1], terminal) {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("terminal event %q was not flushed while the upstream stayed open", terminal)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		pw.Close()
+		<-done
+	}
+}
+
+func TestStreamWriter_BatchedIntervalFlushesIdleData(t *testing.T) {
+	rec := newFlushRecorder()
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		(&Proxy{}).streamResponse(rec, pr, nil, &Streaming{FlushMode: StreamFlushBatched, FlushIntervalMs: 20})
+		close(done)
+	}()
+	defer func() {
+		pw.Close()
+		<-done
+	}()
+
+	pw.Write([]byte("data: {\"choices\":[]}\n\n"))
+	if got := rec.flushes(); len(got) != 0 {
+		t.Fatalf("batched write flushed immediately: %q", got)
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(rec.flushes()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("pending data was not flushed after the interval")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestStreamWriter_Heartbeat(t *testing.T) {
+	run := func(intervalMs int, feed func(pw *io.PipeWriter)) string {
+		rec := newFlushRecorder()
+		pr, pw := io.Pipe()
+		done := make(chan struct{})
+		go func() {
+			(&Proxy{}).streamResponse(rec, pr, nil, &Streaming{HeartbeatIntervalMs: intervalMs})
+			close(done)
+		}()
+		feed(pw)
+		pw.Close()
+		<-done
+		return rec.Body.String()
+	}
+
+	t.Run("idle gap", func(t *testing.T) {
+		body := run(20, func(pw *io.PipeWriter) {
+			pw.Write([]byte("data: {\"choices\":[]}\n\n"))
+			time.Sleep(120 * time.Millisecond)
+			pw.Write([]byte("data: [DONE]\n\n"))
+		})
+		if n := strings.Count(body, ": keep-alive\n\n"); n < 2 {
+			t.Errorf("heartbeats during 120ms gap = %d, want at least 2:\n%q", n, body)
+		}
+		if !strings.HasPrefix(body, "data: {\"choices\":[]}\n\n: keep-alive\n\n") || !strings.HasSuffix(body, ": keep-alive\n\ndata: [DONE]\n\n") {
+			t.Errorf("heartbeats should sit between complete events:\n%q", body)
+		}
+	})
+
+	t.Run("data flowing", func(t *testing.T) {
+		body := run(200, func(pw *io.PipeWriter) {
+			for i := 0; i < 20; i++ {
+				pw.Write([]byte("data: {\"choices\":[]}\n\n"))
+				time.Sleep(5 * time.Millisecond)
+			}
+		})
+		if strings.Contains(body, "keep-alive") {
+			t.Errorf("no heartbeat expected while data flows:\n%q", body)
+		}
+	})
+
+	t.Run("mid-event gap", func(t *testing.T) {
+		body := run(20, func(pw *io.PipeWriter) {
+			pw.Write([]byte("event: content_block_delta\ndata: {\"type\":"))
+			time.Sleep(80 * time.Millisecond)
+			pw.Write([]byte("\"content_block_delta\"}\n\n"))
+		})
+		if want := "event: content_block_delta\ndata: {\"type\":\"content_block_delta\"}\n\n"; !strings.HasPrefix(body, want) {
+			t.Errorf("heartbeat must not split an event, got:\n%q", body)
+		}
+	})
+}
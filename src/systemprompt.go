@@ -0,0 +1,111 @@
+package main
+
+import (
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+type promptFile struct {
+	modTime time.Time
+	size    int64
+	content string
+}
+
+var (
+	promptFiles   = make(map[string]promptFile)
+	promptFilesMu sync.Mutex
+)
+
+// readPromptFile 读取系统提示词文件，按修改时间与大小缓存，文件变化后下次请求即使用新内容
+func readPromptFile(path string) (string, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	promptFilesMu.Lock()
+	defer promptFilesMu.Unlock()
+	if cached, ok := promptFiles[path]; ok && cached.modTime.Equal(stat.ModTime()) && cached.size == stat.Size() {
+		return cached.content, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	content := strings.TrimSpace(string(data))
+	promptFiles[path] = promptFile{modTime: stat.ModTime(), size: stat.Size(), content: content}
+	return content, nil
+}
+
+// aliasSystemPrompt 返回别名配置的系统提示词：内联的 system_prompt 或 system_prompt_file 的内容
+func aliasSystemPrompt(m *ModelAlias) (string, error) {
+	if m == nil {
+		return "", nil
+	}
+	if m.SystemPrompt != "" {
+		return m.SystemPrompt, nil
+	}
+	if m.SystemPromptFile != "" {
+		return readPromptFile(m.SystemPromptFile)
+	}
+	return "", nil
+}
+
+var promptVarPattern = regexp.MustCompile(`\{\{\s*([a-z_]+)\s*\}\}`)
+
+// expandPromptVars 替换系统提示词中的 {{date}}、{{model}}、{{client_ip}}，其他 {{...}} 原样保留
+func expandPromptVars(prompt string, tc *TransformContext, now time.Time) string {
+	if !strings.Contains(prompt, "{{") {
+		return prompt
+	}
+	return promptVarPattern.ReplaceAllStringFunc(prompt, func(token string) string {
+		switch promptVarPattern.FindStringSubmatch(token)[1] {
+		case "date":
+			return now.Format("2006-01-02")
+		case "model":
+			return tc.ModelAlias
+		case "client_ip":
+			return tc.ClientIP
+		}
+		return token
+	})
+}
+
+// overrideSystemPrompt 用 prompt 替换请求中的系统提示词，没有时补充，返回是否做了修改。
+// 与 ensureSystemPrompt 一样兼容顶层 system 字段与 system/developer 消息
+func overrideSystemPrompt(body map[string]interface{}, prompt string) bool {
+	if system, exists := body["system"]; exists {
+		if s, ok := system.(string); ok && s == prompt {
+			return false
+		}
+		body["system"] = prompt
+		return true
+	}
+
+	messages, ok := body["messages"].([]interface{})
+	if !ok {
+		return false
+	}
+	for i, m := range messages {
+		msg, _ := m.(map[string]interface{})
+		if msg == nil || (msg["role"] != "system" && msg["role"] != "developer") {
+			continue
+		}
+		if content, ok := msg["content"].(string); ok && content == prompt {
+			return false
+		}
+		replaced := make(map[string]interface{}, len(msg))
+		for k, v := range msg {
+			replaced[k] = v
+		}
+		replaced["content"] = prompt
+		updated := make([]interface{}, len(messages))
+		copy(updated, messages)
+		updated[i] = replaced
+		body["messages"] = updated
+		return true
+	}
+	return ensureSystemPrompt(body, prompt)
+}
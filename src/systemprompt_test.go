@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOverrideSystemPrompt(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		wantChanged bool
+		want        string
+	}{
+		{
+			name:        "replaces system message",
+			body:        `{"messages":[{"role":"system","content":"client"},{"role":"user","content":"hi"}]}`,
+			wantChanged: true,
+			want:        `{"messages":[{"content":"alias","role":"system"},{"content":"hi","role":"user"}]}`,
+		},
+		{
+			name:        "replaces anthropic system field",
+			body:        `{"system":"client","messages":[]}`,
+			wantChanged: true,
+			want:        `{"messages":[],"system":"alias"}`,
+		},
+		{
+			name:        "prepends when missing",
+			body:        `{"messages":[{"role":"user","content":"hi"}]}`,
+			wantChanged: true,
+			want:        `{"messages":[{"content":"alias","role":"system"},{"content":"hi","role":"user"}]}`,
+		},
+		{
+			name:        "already applied",
+			body:        `{"messages":[{"role":"system","content":"alias"}]}`,
+			wantChanged: false,
+			want:        `{"messages":[{"content":"alias","role":"system"}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body map[string]interface{}
+			json.Unmarshal([]byte(tt.body), &body)
+			if got := overrideSystemPrompt(body, "alias"); got != tt.wantChanged {
+				t.Errorf("changed = %v, want %v", got, tt.wantChanged)
+			}
+			if got, _ := json.Marshal(body); string(got) != tt.want {
+				t.Errorf("body = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpandPromptVars(t *testing.T) {
+	tc := &TransformContext{ModelAlias: "openai/gpt-4o", Model: "gpt-4o-2024-08-06", ClientIP: "203.0.113.7"}
+	now := time.Date(2026, 3, 9, 23, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		prompt string
+		want   string
+	}{
+		{"Today is {{date}}.", "Today is 2026-03-09."},
+		{"You are {{model}}.", "You are openai/gpt-4o."},
+		{"Client: {{ client_ip }}", "Client: 203.0.113.7"},
+		{"Keep {{unknown}} and {{Date}} and {date}", "Keep {{unknown}} and {{Date}} and {date}"},
+		{"{{model}} on {{date}}", "openai/gpt-4o on 2026-03-09"},
+		{"no variables", "no variables"},
+	}
+
+	for _, tt := range tests {
+		if got := expandPromptVars(tt.prompt, tc, now); got != tt.want {
+			t.Errorf("expandPromptVars(%q) = %q, want %q", tt.prompt, got, tt.want)
+		}
+	}
+}
+
+func TestReadPromptFile_PicksUpChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prompt.txt")
+	write := func(content string, mod time.Time) {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(path, mod, mod)
+	}
+	now := time.Now()
+	write("first prompt\n", now)
+	if got, err := readPromptFile(path); err != nil || got != "first prompt" {
+		t.Fatalf("readPromptFile = %q, %v", got, err)
+	}
+
+	write("second prompt\n", now.Add(time.Minute))
+	if got, _ := readPromptFile(path); got != "second prompt" {
+		t.Errorf("after change readPromptFile = %q, want second prompt", got)
+	}
+}
+
+func TestProxy_AliasSystemPrompt(t *testing.T) {
+	var gotSystem string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Messages []struct {
+				Role    string `json:"role"`
+				Content string `json:"content"`
+			} `json:"messages"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		gotSystem = ""
+		if len(body.Messages) > 0 && body.Messages[0].Role == "system" {
+			gotSystem = body.Messages[0].Content
+		}
+		w.Write([]byte(`{"choices":[]}`))
+	}))
+	defer backend.Close()
+
+	promptPath := filepath.Join(t.TempDir(), "prompt.txt")
+	os.WriteFile(promptPath, []byte("from file v1"), 0o644)
+
+	proxy := newTestProxy(&Config{
+		Backends: []Backend{{Name: "b1", URL: backend.URL, SystemPrompt: "backend default"}},
+		Models: map[string]*ModelAlias{
+			"inline":    {SystemPrompt: "inline alias", Routes: []ModelRoute{{Backend: "b1", Model: "m", Priority: 1}}},
+			"file":      {SystemPromptFile: promptPath, Routes: []ModelRoute{{Backend: "b1", Model: "m", Priority: 1}}},
+			"default":   {Routes: []ModelRoute{{Backend: "b1", Model: "m", Priority: 1}}},
+			"templated": {SystemPrompt: "{{model}} for {{client_ip}}", Routes: []ModelRoute{{Backend: "b1", Model: "m", Priority: 1}}},
+		},
+	})
+	send := func(model string) string {
+		body := `{"model":"` + model + `","messages":[{"role":"system","content":"client"},{"role":"user","content":"hi"}]}`
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d: %s", model, w.Code, w.Body.String())
+		}
+		return gotSystem
+	}
+
+	if got := send("inline"); got != "inline alias" {
+		t.Errorf("inline alias prompt = %q, want it to override the request", got)
+	}
+	if got := send("default"); got != "client" {
+		t.Errorf("without alias prompt = %q, want the request's own system message", got)
+	}
+	if got := send("templated"); got != "templated for 192.0.2.1" {
+		t.Errorf("templated prompt = %q", got)
+	}
+	if got := send("file"); got != "from file v1" {
+		t.Errorf("file prompt = %q", got)
+	}
+	os.WriteFile(promptPath, []byte("from file v2"), 0o644)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(promptPath, future, future)
+	if got := send("file"); got != "from file v2" {
+		t.Errorf("changed file prompt = %q, want from file v2", got)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// charsPerToken 提示词 token 估算系数：按约 4 个字符 1 个 token 的经验值估算，
+// 对英文较准确，对中日韩文本会低估，实际用量在响应返回后校正
+const charsPerToken = 4
+
+// promptFields 参与提示词 token 估算的请求体字段
+var promptFields = []string{"messages", "prompt", "input", "system", "tools"}
+
+// EstimatePromptTokens 按字符数估算请求体的提示词 token 数，至少为 1
+func EstimatePromptTokens(body map[string]interface{}) int64 {
+	chars := 0
+	for _, field := range promptFields {
+		chars += countChars(body[field])
+	}
+	tokens := int64((chars + charsPerToken - 1) / charsPerToken)
+	if tokens < 1 {
+		tokens = 1
+	}
+	return tokens
+}
+
+func countChars(v interface{}) int {
+	switch val := v.(type) {
+	case string:
+		return len([]rune(val))
+	case []interface{}:
+		n := 0
+		for _, item := range val {
+			n += countChars(item)
+		}
+		return n
+	case map[string]interface{}:
+		n := 0
+		for _, item := range val {
+			n += countChars(item)
+		}
+		return n
+	}
+	return 0
+}
+
+// TPMReservation 是一次请求预占的 token 额度
+type TPMReservation struct {
+	model    string
+	tpm      int64
+	reserved int64
+}
+
+// TPMLimiter 按模型别名维护每分钟 token 令牌桶：请求前按估算值预占，响应后按实际用量校正
+type TPMLimiter struct {
+	buckets map[string]*rateBucket
+	now     func() time.Time
+	mu      sync.Mutex
+}
+
+func NewTPMLimiter() *TPMLimiter {
+	return &TPMLimiter{buckets: make(map[string]*rateBucket), now: time.Now}
+}
+
+func (tl *TPMLimiter) bucket(model string, tpm int64) *TokenBucket {
+	return bucketFor(tl.buckets, model, float64(tpm)/60, int(tpm), tl.now())
+}
+
+// Reserve 为请求预占 estimate 个 token。模型未配置 TPM 时返回 nil 且放行；
+// 剩余额度不足时拒绝并返回需要等待的时长。单次估算超过整个 TPM 时按 TPM 预占，避免永远无法通过
+func (tl *TPMLimiter) Reserve(cfg *RateLimitConfig, model string, estimate int64) (*TPMReservation, bool, time.Duration) {
+	rule, ok := cfg.Models[model]
+	if !ok || rule.TPM <= 0 {
+		return nil, true, 0
+	}
+	if estimate > rule.TPM {
+		estimate = rule.TPM
+	}
+
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	if ok, wait := tl.bucket(model, rule.TPM).Take(float64(estimate), tl.now()); !ok {
+		return nil, false, wait
+	}
+	return &TPMReservation{model: model, tpm: rule.TPM, reserved: estimate}, true, 0
+}
+
+// Reconcile 用实际用量校正预占：少用的部分退回，多用的部分从令牌桶追加扣除（可透支，之后的请求需等待补回）
+func (tl *TPMLimiter) Reconcile(res *TPMReservation, actual int64) {
+	if res == nil || actual < 0 {
+		return
+	}
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	tl.bucket(res.model, res.tpm).Adjust(float64(actual-res.reserved), tl.now())
+	res.reserved = actual
+}
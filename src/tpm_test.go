@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEstimatePromptTokens(t *testing.T) {
+	tests := []struct {
+		name string
+		body map[string]interface{}
+		want int64
+	}{
+		{"empty", map[string]interface{}{}, 1},
+		{"messages", map[string]interface{}{
+			"model": "ignored-model-name",
+			"messages": []interface{}{
+				map[string]interface{}{"role": "user", "content": "hello world!"},
+			},
+		}, 4},
+		{"prompt string", map[string]interface{}{"prompt": "12345678"}, 2},
+		{"multibyte counted by rune", map[string]interface{}{"input": "你好世界"}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := EstimatePromptTokens(tt.body); got != tt.want {
+				t.Errorf("EstimatePromptTokens = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func newTestTPMLimiter() (*TPMLimiter, *time.Time) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	tl := NewTPMLimiter()
+	tl.now = func() time.Time { return now }
+	return tl, &now
+}
+
+func TestTPMLimiter_ReserveAndRefuse(t *testing.T) {
+	tl, now := newTestTPMLimiter()
+	cfg := &RateLimitConfig{Models: map[string]RateLimitRule{"model-a": {TPM: 600}}}
+
+	if res, ok, _ := tl.Reserve(cfg, "model-b", 10000); !ok || res != nil {
+		t.Fatal("model without TPM should be allowed without reservation")
+	}
+	if _, ok, _ := tl.Reserve(cfg, "model-a", 500); !ok {
+		t.Fatal("reservation within budget should succeed")
+	}
+	_, ok, wait := tl.Reserve(cfg, "model-a", 200)
+	if ok {
+		t.Fatal("reservation exceeding remaining budget should be refused")
+	}
+	if wait != 10*time.Second {
+		t.Errorf("wait = %v, want 10s (100 tokens at 10 tokens/s)", wait)
+	}
+
+	*now = now.Add(10 * time.Second)
+	if _, ok, _ := tl.Reserve(cfg, "model-a", 200); !ok {
+		t.Error("reservation should succeed after refill")
+	}
+}
+
+func TestTPMLimiter_Reconcile(t *testing.T) {
+	cfg := &RateLimitConfig{Models: map[string]RateLimitRule{"model-a": {TPM: 1000}}}
+
+	tests := []struct {
+		name        string
+		reserved    int64
+		actual      int64
+		nextRequest int64
+		wantAllowed bool
+	}{
+		{"overestimate refunds", 800, 100, 800, true},
+		{"exact keeps reservation", 800, 800, 300, false},
+		{"underestimate charges extra", 300, 900, 200, false},
+		{"underestimate within budget", 300, 500, 500, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tl, _ := newTestTPMLimiter()
+			res, ok, _ := tl.Reserve(cfg, "model-a", tt.reserved)
+			if !ok {
+				t.Fatal("initial reservation should succeed")
+			}
+			tl.Reconcile(res, tt.actual)
+			if _, ok, _ := tl.Reserve(cfg, "model-a", tt.nextRequest); ok != tt.wantAllowed {
+				t.Errorf("next reservation of %d allowed=%v, want %v", tt.nextRequest, ok, tt.wantAllowed)
+			}
+		})
+	}
+}
+
+func TestTPMLimiter_OversizedEstimateCapped(t *testing.T) {
+	tl, _ := newTestTPMLimiter()
+	cfg := &RateLimitConfig{Models: map[string]RateLimitRule{"model-a": {TPM: 100}}}
+
+	if _, ok, _ := tl.Reserve(cfg, "model-a", 5000); !ok {
+		t.Error("an estimate larger than the whole TPM should still pass on a full bucket")
+	}
+	if _, ok, _ := tl.Reserve(cfg, "model-a", 1); ok {
+		t.Error("bucket should be empty after a capped reservation")
+	}
+}
+
+func TestProxy_TPMLimit(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"choices":[],"usage":{"prompt_tokens":10,"completion_tokens":10,"total_tokens":20}}`))
+	}))
+	defer backend.Close()
+
+	proxy := newTestProxy(&Config{
+		Backends: []Backend{{Name: "b1", URL: backend.URL}},
+		Models: map[string]*ModelAlias{
+			"model-a": {Routes: []ModelRoute{{Backend: "b1", Model: "m1", Priority: 1}}},
+		},
+		RateLimit: RateLimitConfig{Models: map[string]RateLimitRule{"model-a": {TPM: 60}}},
+	})
+
+	send := func(content string) *httptest.ResponseRecorder {
+		body := `{"model":"model-a","messages":[{"role":"user","content":"` + content + `"}]}`
+		req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+		return w
+	}
+
+	// 估算约 50 token，实际用量 20，校正后剩余约 40
+	if w := send(strings.Repeat("a", 190)); w.Code != http.StatusOK {
+		t.Fatalf("first request: expected 200, got %d", w.Code)
+	}
+	if w := send(strings.Repeat("a", 120)); w.Code != http.StatusOK {
+		t.Fatalf("request within reconciled budget: expected 200, got %d", w.Code)
+	}
+	w := send(strings.Repeat("a", 120))
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request over budget: expected 429, got %d", w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("429 should carry Retry-After")
+	}
+}
+
+func TestProxy_TPMRefundOnFailure(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	tests := []struct {
+		name   string
+		url    string
+		cancel bool
+	}{
+		{"upstream 5xx", failing.URL, false},
+		{"connection error", closed.URL, false},
+		{"client cancelled", failing.URL, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Backends: []Backend{{Name: "b1", URL: tt.url}},
+				Models: map[string]*ModelAlias{
+					"model-a": {Routes: []ModelRoute{{Backend: "b1", Model: "m1", Priority: 1}}},
+				},
+				RateLimit: RateLimitConfig{Models: map[string]RateLimitRule{"model-a": {TPM: 60}}},
+			}
+			proxy := newTestProxy(cfg)
+
+			body := `{"model":"model-a","messages":[{"role":"user","content":"` + strings.Repeat("a", 190) + `"}]}`
+			req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+			if tt.cancel {
+				ctx, cancel := context.WithCancel(req.Context())
+				cancel()
+				req = req.WithContext(ctx)
+			}
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+			if w.Code >= 200 && w.Code < 300 && w.Body.Len() > 0 {
+				t.Fatalf("request should fail, got %d", w.Code)
+			}
+
+			if _, ok, _ := proxy.tpm.Reserve(&cfg.RateLimit, "model-a", 60); !ok {
+				t.Error("failed request should refund its TPM reservation")
+			}
+		})
+	}
+}
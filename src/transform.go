@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TransformContext 是转换器可见的单次尝试上下文
+type TransformContext struct {
+	ReqID      string
+	ModelAlias string
+	Backend    *Backend
+	Model      string
+	// Alias 为请求模型对应的别名配置，未找到时为 nil
+	Alias    *ModelAlias
+	ClientIP string
+}
+
+// Transformer 在请求发往后端前修改请求体，或在返回客户端前修改非流式响应体。
+// Request 返回非空字符串时表示做了修改，内容会写入请求日志。两个钩子均可为 nil。
+type Transformer struct {
+	Request  func(tc *TransformContext, body map[string]interface{}) string
+	Response func(tc *TransformContext, body []byte) []byte
+}
+
+var (
+	transformers   = make(map[string]Transformer)
+	transformersMu sync.RWMutex
+)
+
+// defaultTransforms 默认执行的转换器，路由配置了其他转换器时也会并入，见 withDefaultTransforms
+var defaultTransforms = []string{"system_prompt", "allowed_fields"}
+
+// RegisterTransformer 按名称注册转换器，通常在 init 中调用；重复注册会 panic
+func RegisterTransformer(name string, t Transformer) {
+	transformersMu.Lock()
+	defer transformersMu.Unlock()
+	if _, exists := transformers[name]; exists {
+		panic("转换器重复注册: " + name)
+	}
+	transformers[name] = t
+}
+
+func LookupTransformer(name string) (Transformer, bool) {
+	transformersMu.RLock()
+	defer transformersMu.RUnlock()
+	t, ok := transformers[name]
+	return t, ok
+}
+
+// TransformerNames 返回已注册的转换器名称（已排序）
+func TransformerNames() []string {
+	transformersMu.RLock()
+	defer transformersMu.RUnlock()
+	names := make([]string, 0, len(transformers))
+	for name := range transformers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// resolveTransforms 按配置顺序查找转换器，未知名称记录警告后跳过
+func resolveTransforms(names []string) []Transformer {
+	names = withDefaultTransforms(names)
+	var result []Transformer
+	for _, name := range names {
+		t, ok := LookupTransformer(name)
+		if !ok {
+			LogGeneral("WARN", "未知的转换器: %s", name)
+			continue
+		}
+		result = append(result, t)
+	}
+	return result
+}
+
+// withDefaultTransforms 将默认转换器并入路由配置：未配置时使用 defaultTransforms；
+// 非空列表中未出现的默认转换器排在列表之前，已列出的按列出的位置执行；显式空列表关闭全部转换器
+func withDefaultTransforms(names []string) []string {
+	if names == nil {
+		return defaultTransforms
+	}
+	if len(names) == 0 {
+		return names
+	}
+	var merged []string
+	for _, name := range defaultTransforms {
+		if !slices.Contains(names, name) {
+			merged = append(merged, name)
+		}
+	}
+	return append(merged, names...)
+}
+
+func applyRequestTransforms(ts []Transformer, tc *TransformContext, body map[string]interface{}) []string {
+	var notes []string
+	for _, t := range ts {
+		if t.Request == nil {
+			continue
+		}
+		if note := t.Request(tc, body); note != "" {
+			notes = append(notes, note)
+		}
+	}
+	return notes
+}
+
+func applyResponseTransforms(ts []Transformer, tc *TransformContext, body []byte) []byte {
+	for _, t := range ts {
+		if t.Response != nil {
+			body = t.Response(tc, body)
+		}
+	}
+	return body
+}
+
+func init() {
+	RegisterTransformer("system_prompt", Transformer{
+		Request: func(tc *TransformContext, body map[string]interface{}) string {
+			// 别名配置的提示词优先于请求自带的系统提示词，后端提示词只在请求没有时补充
+			prompt, err := aliasSystemPrompt(tc.Alias)
+			if err != nil {
+				LogGeneral("WARN", "[%s] 读取模型 %s 的系统提示词文件失败: %v", tc.ReqID, tc.ModelAlias, err)
+			}
+			if prompt != "" {
+				if overrideSystemPrompt(body, expandPromptVars(prompt, tc, time.Now())) {
+					return "使用模型别名配置的系统提示词"
+				}
+				return ""
+			}
+			if tc.Backend == nil || tc.Backend.SystemPrompt == "" {
+				return ""
+			}
+			if ensureSystemPrompt(body, expandPromptVars(tc.Backend.SystemPrompt, tc, time.Now())) {
+				return "补充后端默认系统提示词"
+			}
+			return ""
+		},
+	})
+	RegisterTransformer("allowed_fields", Transformer{
+		Request: func(tc *TransformContext, body map[string]interface{}) string {
+			if tc.Backend == nil || len(tc.Backend.AllowedFields) == 0 {
+				return ""
+			}
+			if dropped := filterBodyFields(body, tc.Backend.AllowedFields); len(dropped) > 0 {
+				return fmt.Sprintf("移除未允许字段: %s", strings.Join(dropped, ", "))
+			}
+			return ""
+		},
+	})
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func init() {
+	// 示例自定义转换器：为请求补充 user 字段，并在响应中标记经过代理
+	RegisterTransformer("test_tag_user", Transformer{
+		Request: func(tc *TransformContext, body map[string]interface{}) string {
+			body["user"] = "proxy-" + tc.Backend.Name
+			return "设置 user 字段"
+		},
+		Response: func(tc *TransformContext, body []byte) []byte {
+			return bytes.Replace(body, []byte(`"object":"chat.completion"`), []byte(`"object":"chat.completion","proxied":true`), 1)
+		},
+	})
+}
+
+func TestLookupTransformer(t *testing.T) {
+	tests := []struct {
+		name  string
+		found bool
+	}{
+		{"system_prompt", true},
+		{"allowed_fields", true},
+		{"test_tag_user", true},
+		{"missing", false},
+	}
+	for _, tt := range tests {
+		if _, ok := LookupTransformer(tt.name); ok != tt.found {
+			t.Errorf("LookupTransformer(%q) found=%v, want %v", tt.name, ok, tt.found)
+		}
+	}
+
+	names := strings.Join(TransformerNames(), ",")
+	if !strings.Contains(names, "allowed_fields,system_prompt") {
+		t.Errorf("TransformerNames() = %s, want sorted built-ins", names)
+	}
+}
+
+func TestRegisterTransformer_DuplicatePanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("duplicate registration should panic")
+		}
+	}()
+	RegisterTransformer("system_prompt", Transformer{})
+}
+
+func TestResolveTransforms(t *testing.T) {
+	tests := []struct {
+		name  string
+		names []string
+		want  int
+	}{
+		{"nil uses defaults", nil, len(defaultTransforms)},
+		{"empty disables all", []string{}, 0},
+		{"custom list keeps defaults", []string{"test_tag_user"}, len(defaultTransforms) + 1},
+		{"unknown skipped", []string{"missing", "test_tag_user"}, len(defaultTransforms) + 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := len(resolveTransforms(tt.names)); got != tt.want {
+				t.Errorf("resolved %d transformers, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithDefaultTransforms(t *testing.T) {
+	tests := []struct {
+		name  string
+		names []string
+		want  []string
+	}{
+		{"nil", nil, []string{"system_prompt", "allowed_fields"}},
+		{"empty", []string{}, []string{}},
+		{"defaults run first", []string{"test_tag_user"}, []string{"system_prompt", "allowed_fields", "test_tag_user"}},
+		{"listed default keeps its position", []string{"test_tag_user", "system_prompt"}, []string{"allowed_fields", "test_tag_user", "system_prompt"}},
+		{"all listed", []string{"allowed_fields", "system_prompt"}, []string{"allowed_fields", "system_prompt"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := withDefaultTransforms(tt.names); !slices.Equal(got, tt.want) {
+				t.Errorf("withDefaultTransforms(%v) = %v, want %v", tt.names, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestModelRoute_TransformsYAML(t *testing.T) {
+	var route ModelRoute
+	if err := yaml.Unmarshal([]byte("backend: b1\ntransforms: []\n"), &route); err != nil {
+		t.Fatal(err)
+	}
+	if route.Transforms == nil {
+		t.Error("explicit empty transforms should not fall back to defaults")
+	}
+}
+
+func TestProxy_CustomTransformerFromConfig(t *testing.T) {
+	var received map[string]interface{}
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &received)
+		w.Write([]byte(`{"object":"chat.completion","choices":[]}`))
+	}))
+	defer backend.Close()
+
+	proxy := newTestProxy(&Config{
+		Backends: []Backend{{Name: "b1", URL: backend.URL, SystemPrompt: "be brief"}},
+		Models: map[string]*ModelAlias{
+			"model-a": {Routes: []ModelRoute{
+				{Backend: "b1", Model: "m1", Priority: 1, Transforms: []string{"test_tag_user"}},
+			}},
+		},
+	})
+
+	req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"model-a","messages":[]}`))
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+
+	if received["user"] != "proxy-b1" {
+		t.Errorf("custom request transform not applied: %v", received)
+	}
+	if msgs, _ := received["messages"].([]interface{}); len(msgs) != 1 {
+		t.Errorf("default system_prompt should still run alongside custom transforms: %v", msgs)
+	}
+	if !strings.Contains(w.Body.String(), `"proxied":true`) {
+		t.Errorf("custom response transform not applied: %s", w.Body.String())
+	}
+}
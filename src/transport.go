@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// upstreamClients 为每个后端缓存独立的 HTTP 客户端与连接池，转发与健康检查共用。后端生效的连接池配置
+// （全局 connection_pool 与后端自身 connection_pool 合并后）或 TLS 配置变化时重建该后端的客户端，
+// 旧连接池中的空闲连接随之关闭，处理中的请求不受影响
+type upstreamClients struct {
+	mu      sync.Mutex
+	clients map[string]*upstreamClient
+}
+
+type upstreamClient struct {
+	pool   ConnectionPool
+	tls    BackendTLS
+	client *http.Client
+}
+
+// Get 返回后端当前配置对应的 HTTP 客户端，tlsCfg 为 nil 时使用默认 TLS 设置
+func (u *upstreamClients) Get(backend string, pool ConnectionPool, tlsCfg *BackendTLS) *http.Client {
+	var tlsOpts BackendTLS
+	if tlsCfg != nil {
+		tlsOpts = *tlsCfg
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.clients == nil {
+		u.clients = make(map[string]*upstreamClient)
+	}
+	cached, exists := u.clients[backend]
+	if exists && cached.pool == pool && reflect.DeepEqual(cached.tls, tlsOpts) {
+		return cached.client
+	}
+	if exists {
+		cached.client.CloseIdleConnections()
+		LogGeneral("INFO", "后端 %s 的连接配置已变化，重建 HTTP 客户端", backend)
+	}
+	if tlsOpts.InsecureSkipVerify {
+		LogGeneral("WARN", "后端 %s 已关闭 TLS 证书校验，仅应在开发环境使用", backend)
+	}
+	client := &http.Client{Transport: newUpstreamTransport(&pool, &tlsOpts)}
+	tlsOpts.PinnedSHA256 = slices.Clone(tlsOpts.PinnedSHA256)
+	u.clients[backend] = &upstreamClient{pool: pool, tls: tlsOpts, client: client}
+	return client
+}
+
+// newUpstreamTransport 以 http.DefaultTransport 为基础，按配置设置连接池大小、保活、超时与 TLS 参数
+func newUpstreamTransport(pool *ConnectionPool, tlsOpts *BackendTLS) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DialContext = (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: pool.GetKeepAlive(),
+	}).DialContext
+	t.MaxConnsPerHost = pool.MaxConnsPerHost
+	t.MaxIdleConns = pool.GetMaxIdleConns()
+	t.MaxIdleConnsPerHost = pool.GetMaxIdleConnsPerHost()
+	t.IdleConnTimeout = pool.GetIdleConnTimeout()
+	t.ResponseHeaderTimeout = time.Duration(pool.ResponseHeaderTimeoutSeconds) * time.Second
+	t.TLSClientConfig = tlsOpts.clientConfig()
+	switch pool.HTTPVersion {
+	case HTTPVersion1:
+		// 非 nil 的空 TLSNextProto 关闭 HTTP/2 协商
+		t.ForceAttemptHTTP2 = false
+		t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	case HTTPVersion2:
+		t.ForceAttemptHTTP2 = true
+		t.Protocols = new(http.Protocols)
+		t.Protocols.SetHTTP2(true)
+		t.Protocols.SetUnencryptedHTTP2(true)
+	}
+	return t
+}
+
+// clientConfig 按后端 TLS 配置生成 tls.Config：最低版本默认 TLS 1.2，
+// 配置了证书固定时在 VerifyPeerCertificate 中要求证书链中至少一张证书的公钥与之匹配
+func (b *BackendTLS) clientConfig() *tls.Config {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: b.InsecureSkipVerify}
+	if v, ok := tlsVersions[b.MinVersion]; ok {
+		cfg.MinVersion = v
+	}
+	if len(b.PinnedSHA256) == 0 {
+		return cfg
+	}
+
+	pins := make(map[string]bool, len(b.PinnedSHA256))
+	for _, pin := range b.PinnedSHA256 {
+		pins[strings.TrimPrefix(pin, "sha256/")] = true
+	}
+	cfg.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		for _, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return err
+			}
+			if pins[spkiPin(cert)] {
+				return nil
+			}
+		}
+		return errors.New("后端证书的公钥与 pinned_sha256 均不匹配")
+	}
+	return cfg
+}
+
+// spkiPin 返回证书公钥（SubjectPublicKeyInfo）SHA-256 的 base64 编码
+func spkiPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
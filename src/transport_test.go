@@ -0,0 +1,222 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewUpstreamTransport(t *testing.T) {
+	tests := []struct {
+		name                string
+		pool                ConnectionPool
+		maxConnsPerHost     int
+		maxIdleConns        int
+		maxIdleConnsPerHost int
+		idleConnTimeout     time.Duration
+	}{
+		{
+			name:                "defaults",
+			maxIdleConns:        100,
+			maxIdleConnsPerHost: http.DefaultMaxIdleConnsPerHost,
+			idleConnTimeout:     90 * time.Second,
+		},
+		{
+			name:                "configured",
+			pool:                ConnectionPool{MaxConnsPerHost: 64, MaxIdleConns: 500, MaxIdleConnsPerHost: 32, IdleConnTimeoutSeconds: 30},
+			maxConnsPerHost:     64,
+			maxIdleConns:        500,
+			maxIdleConnsPerHost: 32,
+			idleConnTimeout:     30 * time.Second,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := newUpstreamTransport(&tt.pool, &BackendTLS{})
+			if tr.MaxConnsPerHost != tt.maxConnsPerHost || tr.MaxIdleConns != tt.maxIdleConns ||
+				tr.MaxIdleConnsPerHost != tt.maxIdleConnsPerHost || tr.IdleConnTimeout != tt.idleConnTimeout {
+				t.Errorf("transport = {MaxConnsPerHost:%d MaxIdleConns:%d MaxIdleConnsPerHost:%d IdleConnTimeout:%v}",
+					tr.MaxConnsPerHost, tr.MaxIdleConns, tr.MaxIdleConnsPerHost, tr.IdleConnTimeout)
+			}
+			if tr.Proxy == nil {
+				t.Error("transport should keep the default proxy-from-environment setting")
+			}
+		})
+	}
+
+	if got := (&ConnectionPool{}).GetKeepAlive(); got != 30*time.Second {
+		t.Errorf("default keep-alive = %v, want 30s", got)
+	}
+	if got := (&ConnectionPool{KeepAliveSeconds: 5}).GetKeepAlive(); got != 5*time.Second {
+		t.Errorf("configured keep-alive = %v, want 5s", got)
+	}
+}
+
+func TestConnectionPool_For(t *testing.T) {
+	global := ConnectionPool{MaxIdleConns: 200, MaxIdleConnsPerHost: 8, ResponseHeaderTimeoutSeconds: 60}
+	tests := []struct {
+		name    string
+		backend *Backend
+		want    ConnectionPool
+	}{
+		{"nil backend", nil, global},
+		{"no override", &Backend{Name: "b1"}, global},
+		{
+			name:    "override non-zero fields",
+			backend: &Backend{Name: "b1", ConnectionPool: &ConnectionPool{MaxIdleConnsPerHost: 64, ResponseHeaderTimeoutSeconds: 5}},
+			want:    ConnectionPool{MaxIdleConns: 200, MaxIdleConnsPerHost: 64, ResponseHeaderTimeoutSeconds: 5},
+		},
+	}
+	for _, tt := range tests {
+		if got := global.For(tt.backend); got != tt.want {
+			t.Errorf("%s: For = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUpstreamClients_PerBackendAndReload(t *testing.T) {
+	var clients upstreamClients
+	global := ConnectionPool{ResponseHeaderTimeoutSeconds: 120}
+	fast := &Backend{Name: "local", ConnectionPool: &ConnectionPool{ResponseHeaderTimeoutSeconds: 5, MaxIdleConnsPerHost: 32}}
+	slow := &Backend{Name: "gemini"}
+
+	transportOf := func(c *http.Client) *http.Transport { return c.Transport.(*http.Transport) }
+	local := clients.Get(fast.Name, global.For(fast), nil)
+	remote := clients.Get(slow.Name, global.For(slow), nil)
+	if local == remote {
+		t.Fatal("backends should get distinct clients")
+	}
+	if got := transportOf(local).ResponseHeaderTimeout; got != 5*time.Second {
+		t.Errorf("local ResponseHeaderTimeout = %v, want 5s", got)
+	}
+	if got := transportOf(local).MaxIdleConnsPerHost; got != 32 {
+		t.Errorf("local MaxIdleConnsPerHost = %d, want 32", got)
+	}
+	if got := transportOf(remote).ResponseHeaderTimeout; got != 120*time.Second {
+		t.Errorf("gemini ResponseHeaderTimeout = %v, want 120s", got)
+	}
+
+	if clients.Get(fast.Name, global.For(fast), nil) != local {
+		t.Error("unchanged config should reuse the backend's client")
+	}
+	fast.ConnectionPool.ResponseHeaderTimeoutSeconds = 10
+	reloaded := clients.Get(fast.Name, global.For(fast), nil)
+	if reloaded == local || transportOf(reloaded).ResponseHeaderTimeout != 10*time.Second {
+		t.Errorf("changed config should rebuild the client, got ResponseHeaderTimeout %v", transportOf(reloaded).ResponseHeaderTimeout)
+	}
+	if clients.Get(slow.Name, global.For(slow), nil) != remote {
+		t.Error("reloading one backend should not rebuild another's client")
+	}
+}
+
+func TestNewUpstreamTransport_HTTPVersion(t *testing.T) {
+	tests := []struct {
+		version   string
+		forceH2   bool
+		disableH2 bool
+		protocols string
+		wantProto int
+		serverH2C bool
+	}{
+		{version: "", forceH2: true, wantProto: 2},
+		{version: HTTPVersion1, forceH2: false, disableH2: true, wantProto: 1},
+		{version: HTTPVersion2, forceH2: true, protocols: "{HTTP2,UnencryptedHTTP2}", wantProto: 2, serverH2C: true},
+	}
+
+	for _, tt := range tests {
+		t.Run("version="+tt.version, func(t *testing.T) {
+			tr := newUpstreamTransport(&ConnectionPool{HTTPVersion: tt.version}, &BackendTLS{})
+			if tr.ForceAttemptHTTP2 != tt.forceH2 {
+				t.Errorf("ForceAttemptHTTP2 = %v, want %v", tr.ForceAttemptHTTP2, tt.forceH2)
+			}
+			if disabled := tr.TLSNextProto != nil && len(tr.TLSNextProto) == 0; disabled != tt.disableH2 {
+				t.Errorf("TLSNextProto disables HTTP/2 = %v, want %v", disabled, tt.disableH2)
+			}
+			if tt.protocols != "" && (tr.Protocols == nil || tr.Protocols.String() != tt.protocols) {
+				t.Errorf("Protocols = %v, want %s", tr.Protocols, tt.protocols)
+			}
+
+			var gotProto int
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { gotProto = r.ProtoMajor })
+			server := httptest.NewUnstartedServer(handler)
+			if tt.serverH2C {
+				server.Config.Protocols = new(http.Protocols)
+				server.Config.Protocols.SetHTTP1(true)
+				server.Config.Protocols.SetUnencryptedHTTP2(true)
+				server.Start()
+			} else {
+				server.EnableHTTP2 = true
+				server.StartTLS()
+				tr.TLSClientConfig = server.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+			}
+			defer server.Close()
+
+			resp, err := (&http.Client{Transport: tr}).Get(server.URL)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			resp.Body.Close()
+			if gotProto != tt.wantProto {
+				t.Errorf("server saw HTTP/%d, want HTTP/%d", gotProto, tt.wantProto)
+			}
+		})
+	}
+}
+
+func TestBackendTLS_MinVersionAndPinning(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	server.StartTLS()
+	defer server.Close()
+	pin := spkiPin(server.Certificate())
+
+	tests := []struct {
+		name    string
+		tls     BackendTLS
+		wantErr string
+	}{
+		{name: "default accepts TLS 1.2", tls: BackendTLS{InsecureSkipVerify: true}},
+		{name: "min 1.3 rejects TLS 1.2 server", tls: BackendTLS{MinVersion: "1.3", InsecureSkipVerify: true}, wantErr: "protocol version"},
+		{name: "matching pin", tls: BackendTLS{PinnedSHA256: []string{"sha256/" + pin}, InsecureSkipVerify: true}},
+		{
+			name:    "pin mismatch",
+			tls:     BackendTLS{PinnedSHA256: []string{base64.StdEncoding.EncodeToString(make([]byte, 32))}, InsecureSkipVerify: true},
+			wantErr: "pinned_sha256",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := newUpstreamTransport(&ConnectionPool{}, &tt.tls)
+			defer tr.CloseIdleConnections()
+			resp, err := (&http.Client{Transport: tr}).Get(server.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("err = %v, want it to mention %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUpstreamClients_RebuildOnTLSChange(t *testing.T) {
+	var clients upstreamClients
+	tlsCfg := &BackendTLS{PinnedSHA256: []string{"a"}}
+	first := clients.Get("b1", ConnectionPool{}, tlsCfg)
+	if clients.Get("b1", ConnectionPool{}, &BackendTLS{PinnedSHA256: []string{"a"}}) != first {
+		t.Error("equal TLS config should reuse the client")
+	}
+	tlsCfg.PinnedSHA256[0] = "b"
+	if clients.Get("b1", ConnectionPool{}, tlsCfg) == first {
+		t.Error("changed pins should rebuild the client")
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+var validProtocols = map[string]bool{
+	ProtocolOpenAI:    true,
+	ProtocolAnthropic: true,
+	ProtocolGoogle:    true,
+	ProtocolAzure:     true,
+	ProtocolBedrock:   true,
+}
+
+var validHTTPVersions = map[string]bool{"": true, HTTPVersion1: true, HTTPVersion2: true}
+
+// Validate 检查配置的一致性：后端地址与协议、路由与回退引用的后端和别名是否存在、
+// 模式别名能否编译、数值字段是否为负。返回的错误包含全部问题，加载或重载时整体拒绝
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+	nonNegative := func(field string, v float64) {
+		if v < 0 {
+			fail("%s 不能为负数: %v", field, v)
+		}
+	}
+
+	backends := make(map[string]bool, len(c.Backends))
+	for i := range c.Backends {
+		b := &c.Backends[i]
+		if b.Name == "" {
+			fail("backends[%d] 缺少 name", i)
+		} else if backends[b.Name] {
+			fail("后端 %s 重复定义", b.Name)
+		}
+		backends[b.Name] = true
+
+		if !validProtocols[b.GetProtocol()] {
+			fail("后端 %s 的 protocol 无效: %s", b.Name, b.Protocol)
+		}
+		switch {
+		case b.URL == "" && b.GetProtocol() == ProtocolBedrock:
+			if b.AWS == nil || b.AWS.Region == "" {
+				fail("后端 %s 缺少 url 或 aws.region", b.Name)
+			}
+		case b.URL == "":
+			fail("后端 %s 缺少 url", b.Name)
+		default:
+			if u, err := url.Parse(b.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				fail("后端 %s 的 url 无效: %s", b.Name, b.URL)
+			}
+		}
+		nonNegative("后端 "+b.Name+" 的 key_cooldown_seconds", float64(b.KeyCooldownSeconds))
+		if b.TLS != nil {
+			if _, ok := tlsVersions[b.TLS.MinVersion]; b.TLS.MinVersion != "" && !ok {
+				fail("后端 %s 的 tls.min_version 无效: %s（可选 1.2、1.3）", b.Name, b.TLS.MinVersion)
+			}
+			for _, pin := range b.TLS.PinnedSHA256 {
+				if sum, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(pin, "sha256/")); err != nil || len(sum) != sha256.Size {
+					fail("后端 %s 的 tls.pinned_sha256 不是 base64 编码的 SHA-256: %s", b.Name, pin)
+				}
+			}
+		}
+		if b.ConnectionPool != nil && !validHTTPVersions[b.ConnectionPool.HTTPVersion] {
+			fail("后端 %s 的 connection_pool.http_version 无效: %s", b.Name, b.ConnectionPool.HTTPVersion)
+		}
+		if b.RateLimit != nil {
+			nonNegative("后端 "+b.Name+" 的 rate_limit.rps", b.RateLimit.RPS)
+			nonNegative("后端 "+b.Name+" 的 rate_limit.burst", float64(b.RateLimit.Burst))
+		}
+	}
+
+	aliases := make([]string, 0, len(c.Models))
+	for alias := range c.Models {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+	for _, alias := range aliases {
+		if isModelPattern(alias) {
+			if _, err := compileModelPattern(alias); err != nil {
+				fail("模型别名 %s 不是有效的模式: %w", alias, err)
+			}
+		}
+		m := c.Models[alias]
+		if m == nil {
+			continue
+		}
+		if m.SystemPrompt != "" && m.SystemPromptFile != "" {
+			fail("模型 %s 不能同时配置 system_prompt 与 system_prompt_file", alias)
+		}
+		for i, route := range m.Routes {
+			if !backends[route.Backend] {
+				fail("模型 %s 的第 %d 条路由引用了不存在的后端: %s", alias, i+1, route.Backend)
+			}
+			nonNegative(fmt.Sprintf("模型 %s 的第 %d 条路由的 weight", alias, i+1), route.Weight)
+		}
+		nonNegative("模型 "+alias+" 的 max_attempts", float64(m.MaxAttempts))
+	}
+
+	sources := make([]string, 0, len(c.Fallback.AliasFallback))
+	for alias := range c.Fallback.AliasFallback {
+		sources = append(sources, alias)
+	}
+	sort.Strings(sources)
+	for _, alias := range sources {
+		for _, target := range c.Fallback.AliasFallback[alias] {
+			if _, exists := c.LookupModel(target.Alias); !exists {
+				fail("模型 %s 的回退目标不存在: %s", alias, target.Alias)
+			}
+			nonNegative("模型 "+alias+" 的回退目标 "+target.Alias+" 的 weight", target.Weight)
+		}
+	}
+
+	for _, f := range []struct {
+		field string
+		value float64
+	}{
+		{"max_request_body_bytes", float64(c.MaxBodyBytes)},
+		{"max_json_depth", float64(c.MaxJSONDepth)},
+		{"max_messages", float64(c.MaxMessages)},
+		{"fallback.cooldown_seconds", float64(c.Fallback.CooldownSeconds)},
+		{"fallback.max_retries", float64(c.Fallback.MaxRetries)},
+		{"fallback.max_cooldown_seconds", float64(c.Fallback.MaxCooldownSeconds)},
+		{"fallback.cooldown_backoff.multiplier", c.Fallback.CooldownBackoff.Multiplier},
+		{"fallback.cooldown_backoff.jitter", c.Fallback.CooldownBackoff.Jitter},
+		{"circuit_breaker.failure_threshold", float64(c.CircuitBreaker.FailureThreshold)},
+		{"circuit_breaker.success_threshold", float64(c.CircuitBreaker.SuccessThreshold)},
+		{"circuit_breaker.open_timeout_seconds", float64(c.CircuitBreaker.OpenTimeoutSeconds)},
+		{"rate_limit.rps", c.RateLimit.RPS},
+		{"rate_limit.burst", float64(c.RateLimit.Burst)},
+		{"concurrency.max_in_flight", float64(c.Concurrency.MaxInFlight)},
+		{"connection_pool.max_conns_per_host", float64(c.ConnectionPool.MaxConnsPerHost)},
+		{"connection_pool.max_idle_conns", float64(c.ConnectionPool.MaxIdleConns)},
+		{"connection_pool.max_idle_conns_per_host", float64(c.ConnectionPool.MaxIdleConnsPerHost)},
+		{"connection_pool.idle_conn_timeout_seconds", float64(c.ConnectionPool.IdleConnTimeoutSeconds)},
+		{"connection_pool.keep_alive_seconds", float64(c.ConnectionPool.KeepAliveSeconds)},
+		{"connection_pool.response_header_timeout_seconds", float64(c.ConnectionPool.ResponseHeaderTimeoutSeconds)},
+		{"concurrency.queue_timeout_ms", float64(c.Concurrency.QueueTimeoutMs)},
+		{"timeout.total_seconds", float64(c.Timeout.TotalSeconds)},
+		{"timeout.min_override_seconds", float64(c.Timeout.MinOverrideSeconds)},
+		{"timeout.max_override_seconds", float64(c.Timeout.MaxOverrideSeconds)},
+		{"timeout.min_attempt_ms", float64(c.Timeout.MinAttemptMs)},
+		{"streaming.flush_interval_ms", float64(c.Streaming.FlushIntervalMs)},
+		{"streaming.flush_bytes", float64(c.Streaming.FlushBytes)},
+		{"streaming.heartbeat_interval_ms", float64(c.Streaming.HeartbeatIntervalMs)},
+		{"health_check.interval_seconds", float64(c.HealthCheck.IntervalSeconds)},
+		{"health_check.timeout_seconds", float64(c.HealthCheck.TimeoutSeconds)},
+		{"shutdown.drain_timeout_seconds", float64(c.Shutdown.DrainTimeoutSeconds)},
+		{"shutdown.ready_delay_seconds", float64(c.Shutdown.ReadyDelaySeconds)},
+	} {
+		nonNegative(f.field, f.value)
+	}
+
+	if !validHTTPVersions[c.ConnectionPool.HTTPVersion] {
+		fail("connection_pool.http_version 无效: %s", c.ConnectionPool.HTTPVersion)
+	}
+	switch c.LoadBalance.GetStrategy() {
+	case StrategyRandom, StrategyRoundRobin, StrategyLeastConnections, StrategyWeightedRandom, StrategyStickyHash, StrategyScoreWeighted:
+	default:
+		fail("load_balance.strategy 无效: %s（可选 random、round_robin、least_connections、weighted_random、sticky_hash、score_weighted）", c.LoadBalance.Strategy)
+	}
+	if m := c.Streaming.FlushMode; m != "" && m != StreamFlushEvent && m != StreamFlushBatched {
+		fail("streaming.flush_mode 无效: %s（可选 event、batched）", m)
+	}
+	if c.Fallback.CooldownBackoff.Jitter > 1 {
+		fail("fallback.cooldown_backoff.jitter 不能大于 1: %v", c.Fallback.CooldownBackoff.Jitter)
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	valid := func() *Config {
+		return &Config{
+			Backends: []Backend{
+				{Name: "b1", URL: "https://api.example.com/v1"},
+				{Name: "br", Protocol: ProtocolBedrock, AWS: &AWSCredentials{Region: "us-east-1"}},
+			},
+			Models: map[string]*ModelAlias{
+				"model-a":     {Routes: []ModelRoute{{Backend: "b1", Model: "m1", Priority: 1}}},
+				"ft:gpt-4o:*": {Routes: []ModelRoute{{Backend: "b1", Priority: 1}}},
+			},
+			Fallback: Fallback{AliasFallback: map[string][]FallbackTarget{
+				"model-a": {{Alias: "ft:gpt-4o:acme"}},
+			}},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		mutate  func(*Config)
+		wantErr string
+	}{
+		{
+			name:   "valid",
+			mutate: func(c *Config) {},
+		},
+		{
+			name:   "known load balance strategy",
+			mutate: func(c *Config) { c.LoadBalance.Strategy = StrategyScoreWeighted },
+		},
+		{
+			name:    "unknown load balance strategy",
+			mutate:  func(c *Config) { c.LoadBalance.Strategy = "round-robin" },
+			wantErr: "load_balance.strategy 无效: round-robin",
+		},
+		{
+			name:    "malformed url",
+			mutate:  func(c *Config) { c.Backends[0].URL = "api.example.com/v1" },
+			wantErr: "后端 b1 的 url 无效",
+		},
+		{
+			name:    "missing url",
+			mutate:  func(c *Config) { c.Backends[0].URL = "" },
+			wantErr: "后端 b1 缺少 url",
+		},
+		{
+			name:    "bedrock without region",
+			mutate:  func(c *Config) { c.Backends[1].AWS = nil },
+			wantErr: "后端 br 缺少 url 或 aws.region",
+		},
+		{
+			name:    "unknown protocol",
+			mutate:  func(c *Config) { c.Backends[0].Protocol = "grpc" },
+			wantErr: "protocol 无效: grpc",
+		},
+		{
+			name:    "duplicate backend",
+			mutate:  func(c *Config) { c.Backends = append(c.Backends, c.Backends[0]) },
+			wantErr: "后端 b1 重复定义",
+		},
+		{
+			name:    "route to unknown backend",
+			mutate:  func(c *Config) { c.Models["model-a"].Routes[0].Backend = "missing" },
+			wantErr: "不存在的后端: missing",
+		},
+		{
+			name: "fallback to unknown alias",
+			mutate: func(c *Config) {
+				c.Fallback.AliasFallback["model-a"] = []FallbackTarget{{Alias: "model-z"}}
+			},
+			wantErr: "回退目标不存在: model-z",
+		},
+		{
+			name:    "invalid pattern",
+			mutate:  func(c *Config) { c.Models["re:ft:(gpt"] = &ModelAlias{} },
+			wantErr: "re:ft:(gpt 不是有效的模式",
+		},
+		{
+			name:    "negative numeric field",
+			mutate:  func(c *Config) { c.Fallback.CooldownSeconds = -1 },
+			wantErr: "fallback.cooldown_seconds 不能为负数",
+		},
+		{
+			name:    "invalid tls min_version",
+			mutate:  func(c *Config) { c.Backends[0].TLS = &BackendTLS{MinVersion: "1.0"} },
+			wantErr: "后端 b1 的 tls.min_version 无效: 1.0",
+		},
+		{
+			name:    "malformed tls pin",
+			mutate:  func(c *Config) { c.Backends[0].TLS = &BackendTLS{PinnedSHA256: []string{"sha256/abc"}} },
+			wantErr: "tls.pinned_sha256 不是 base64 编码的 SHA-256: sha256/abc",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid()
+			tt.mutate(cfg)
+			err := cfg.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want mention of %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConfig_Validate_ReportsAllErrors(t *testing.T) {
+	cfg := &Config{
+		Backends: []Backend{{Name: "b1", URL: "ftp://x", Protocol: "grpc"}},
+		Models:   map[string]*ModelAlias{"m": {Routes: []ModelRoute{{Backend: "nope"}}}},
+	}
+	err := cfg.Validate()
+	for _, want := range []string{"url 无效", "protocol 无效", "不存在的后端: nope"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("error = %v, want mention of %q", err, want)
+		}
+	}
+}
+
+func TestParseConfig_ExampleIsValid(t *testing.T) {
+	data, err := os.ReadFile("config.example.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseConfig(data); err != nil {
+		t.Errorf("config.example.yaml: %v", err)
+	}
+}
+
+func TestConfigManager_RejectsInvalidReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(content string, mod time.Time) {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mod, mod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now()
+	write("backends:\n  - {name: b1, url: \"http://b1\"}\n", now)
+	cm, err := NewConfigManager(path)
+	if err != nil {
+		t.Fatalf("NewConfigManager: %v", err)
+	}
+
+	write("backends:\n  - {name: b1, url: \"http://b1\"}\n  - {name: b2, url: \"http://b2\"}\n", now.Add(time.Minute))
+	if got := len(cm.Get().Backends); got != 2 {
+		t.Fatalf("valid reload: %d backends, want 2", got)
+	}
+
+	write("backends:\n  - {name: b1, url: \"http://b1\"}\n  - {name: b3, url: \"not a url\"}\n", now.Add(2*time.Minute))
+	if got := cm.Get().Backends; len(got) != 2 || got[1].Name != "b2" {
+		t.Errorf("invalid reload should keep the previous config, got %+v", got)
+	}
+	if err := cm.Reload(); err == nil || !strings.Contains(err.Error(), "后端 b3 的 url 无效") {
+		t.Errorf("Reload error = %v, want url validation error", err)
+	}
+	if got := cm.Get().Backends; len(got) != 2 || got[1].Name != "b2" {
+		t.Errorf("after rejected Reload got %+v, want previous config", got)
+	}
+}